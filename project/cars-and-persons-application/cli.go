@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const appName = "cars-and-persons-application"
+
+// The exit codes of the application, so that scripts can tell why a command failed.
+const (
+	exitOK = 0
+	// exitTransactionFailed means the transaction was rejected by the chaincode or the
+	// network, e.g. because the car does not exist or the endorsement failed.
+	exitTransactionFailed = 1
+	// exitUsage means the command line was invalid.
+	exitUsage = 2
+	// exitSetupFailed means the config file, the organization or its crypto material
+	// could not be loaded, so no transaction was sent.
+	exitSetupFailed = 3
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// contractInvoker is the part of client.Contract the commands use, so that they can be
+// run against a stub contract in tests.
+type contractInvoker interface {
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+	SubmitTransaction(name string, args ...string) ([]byte, error)
+	SubmitWithTransient(name string, transient map[string][]byte, args ...string) ([]byte, error)
+	SubmitAsync(name string, args ...string) (*submittedTransaction, error)
+	CommitStatus(ctx context.Context, transactionID string) (*client.Status, error)
+	BlockHeight() (uint64, error)
+}
+
+// The keys of the transient data the chaincode reads the private details of persons from.
+const (
+	transientPersonDetailsKey   = "personDetails"
+	transientImportedPersonsKey = "importedPersons"
+	transientSaltKey            = "salt"
+)
+
+// newSalt returns 16 random bytes in hex. The chaincode salts the private details of
+// persons with them, so that their hash, which every peer stores, cannot be reversed by
+// trying out likely values.
+func newSalt() (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a salt: %w", err)
+	}
+
+	return hex.EncodeToString(salt), nil
+}
+
+type globalOptions struct {
+	org        string
+	identity   string
+	configPath string
+	output     string
+	// async submits transactions without waiting for them to be committed.
+	async bool
+}
+
+// usageError is returned for invalid command lines.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// setupError is returned when the application cannot connect to the network.
+type setupError struct {
+	err error
+}
+
+func (e *setupError) Error() string {
+	return e.err.Error()
+}
+
+func (e *setupError) Unwrap() error {
+	return e.err
+}
+
+// command is a subcommand such as "cars transfer". setup registers the flags of the
+// command on its flag set and returns the function that runs the command once the
+// flags are parsed.
+type command struct {
+	group   string
+	name    string
+	summary string
+	// args describes the positional arguments of the command, which takes none without it.
+	args  string
+	setup func(c *cli, flags *flag.FlagSet) func() error
+}
+
+// cli holds the state of a single run of the application.
+type cli struct {
+	options    globalOptions
+	stdout     io.Writer
+	stderr     io.Writer
+	appConfig  *AppConfig
+	wallet     *wallet
+	connection *gatewayConnection
+	// contract is set by open, or up front by tests.
+	contract contractInvoker
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	c := &cli{stdout: stdout, stderr: stderr}
+	defer c.close()
+
+	return c.run(args)
+}
+
+// run runs the command given by args and returns the exit code. Without a command the
+// interactive shell is started, as before subcommands were introduced.
+func (c *cli) run(args []string) int {
+	flags := c.newFlagSet(appName)
+	flags.Usage = func() { c.printUsage() }
+
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{"shell"}
+	}
+	if args[0] == "help" {
+		c.printUsage()
+		return exitOK
+	}
+
+	cmd, args, err := findCommand(args)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "Error: %v\nRun \"%s help\" to list the commands.\n", err, appName)
+		return exitUsage
+	}
+
+	return c.exitCode(c.runCommand(cmd, args))
+}
+
+func (c *cli) runCommand(cmd *command, args []string) error {
+	name := cmd.group
+	if cmd.name != "" {
+		name += " " + cmd.name
+	}
+
+	flags := c.newFlagSet(name)
+	action := cmd.setup(c, flags)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: %s [global flags] %s\n\n%s\n\nFlags:\n", appName, strings.TrimSpace(name+" [flags] "+cmd.args), cmd.summary)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return &usageError{message: ""}
+	}
+	if flags.NArg() > 0 && cmd.args == "" {
+		return newUsageError("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	if c.options.output != outputTable && c.options.output != outputJSON {
+		return newUsageError("the output format must be %s or %s, not %q", outputTable, outputJSON, c.options.output)
+	}
+
+	return action()
+}
+
+// exitCode reports the error of a command and maps it to an exit code.
+func (c *cli) exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		// An empty message means the flag package has already reported the problem.
+		if usageErr.message != "" {
+			fmt.Fprintf(c.stderr, "Error: %v\n", err)
+		}
+		return exitUsage
+	}
+
+	fmt.Fprintf(c.stderr, "Error: %v\n", err)
+
+	var setupErr *setupError
+	if errors.As(err, &setupErr) {
+		return exitSetupFailed
+	}
+
+	return exitTransactionFailed
+}
+
+// newFlagSet returns a flag set with the global flags, so that they can be given both
+// before and after the command name.
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	if c.options.configPath == "" {
+		c.options.configPath = "app_config.json"
+	}
+	if c.options.output == "" {
+		c.options.output = outputTable
+	}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.StringVar(&c.options.org, "org", c.options.org, "organization from the config file to act for (default org1)")
+	flags.StringVar(&c.options.identity, "identity", c.options.identity, "identity from the wallet to sign with, e.g. Admin or org2/User1 (default the organization's defaultIdentity)")
+	flags.StringVar(&c.options.configPath, "config", c.options.configPath, "path to the application config file")
+	flags.StringVar(&c.options.output, "output", c.options.output, "output format, table or json")
+	flags.BoolVar(&c.options.async, "async", c.options.async, "print the transaction ID as soon as the orderer accepted a transaction, instead of waiting for it to be committed")
+
+	return flags
+}
+
+func (c *cli) printUsage() {
+	fmt.Fprintf(c.stderr, "Usage: %s [global flags] <command> [flags]\n\n", appName)
+	fmt.Fprintln(c.stderr, "Commands:")
+	for _, cmd := range commands {
+		name := strings.TrimSpace(cmd.group + " " + cmd.name)
+		fmt.Fprintf(c.stderr, "  %-26s %s\n", name, cmd.summary)
+	}
+	fmt.Fprintf(c.stderr, "\nGlobal flags:\n")
+	c.newFlagSet(appName).PrintDefaults()
+	fmt.Fprintf(c.stderr, "\nRun \"%s <command> -h\" for the flags of a command. Exit codes: %d success, %d transaction failed, %d invalid usage, %d config or connection problem.\n",
+		appName, exitOK, exitTransactionFailed, exitUsage, exitSetupFailed)
+}
+
+// findCommand looks up the command named by the first one or two arguments and returns
+// the remaining arguments.
+func findCommand(args []string) (*command, []string, error) {
+	var groupCommands []string
+	for i := range commands {
+		cmd := &commands[i]
+		if cmd.group != args[0] {
+			continue
+		}
+		if cmd.name == "" {
+			return cmd, args[1:], nil
+		}
+		if len(args) > 1 && cmd.name == args[1] {
+			return cmd, args[2:], nil
+		}
+		groupCommands = append(groupCommands, cmd.name)
+	}
+
+	if len(groupCommands) == 0 {
+		return nil, nil, fmt.Errorf("unknown command %q", args[0])
+	}
+
+	sort.Strings(groupCommands)
+	if len(args) == 1 {
+		return nil, nil, fmt.Errorf("%s needs one of the commands: %s", args[0], strings.Join(groupCommands, ", "))
+	}
+
+	return nil, nil, fmt.Errorf("unknown command \"%s %s\", %s has the commands: %s", args[0], args[1], args[0], strings.Join(groupCommands, ", "))
+}
+
+// orgName returns the organization selected with --org.
+func (c *cli) orgName() string {
+	if c.options.org == "" {
+		return "org1"
+	}
+
+	return c.options.org
+}
+
+// loadWallet loads the config file and the wallet of the identities it configures.
+func (c *cli) loadWallet() (*wallet, error) {
+	if c.wallet != nil {
+		return c.wallet, nil
+	}
+
+	appConfig, err := loadAppConfig(c.options.configPath)
+	if err != nil {
+		return nil, &setupError{err: err}
+	}
+
+	c.appConfig = appConfig
+	c.wallet = newWallet(appConfig)
+
+	return c.wallet, nil
+}
+
+// currentIdentity returns the identity selected with --org and --identity.
+func (c *cli) currentIdentity() (walletIdentity, error) {
+	if c.connection != nil {
+		return c.connection.identity, nil
+	}
+
+	wallet, err := c.loadWallet()
+	if err != nil {
+		return walletIdentity{}, err
+	}
+
+	if _, ok := c.appConfig.Orgs[c.orgName()]; !ok {
+		return walletIdentity{}, &setupError{err: fmt.Errorf("the organization %s is not in %s, choose one of: %s", c.orgName(), c.options.configPath, strings.Join(wallet.orgNames(), ", "))}
+	}
+
+	identity, err := wallet.find(c.orgName(), c.options.identity)
+	if err != nil {
+		return walletIdentity{}, &setupError{err: err}
+	}
+
+	return identity, nil
+}
+
+// open connects to the network with the selected identity, unless a contract has
+// already been set.
+func (c *cli) open() (contractInvoker, error) {
+	if c.contract != nil {
+		return c.contract, nil
+	}
+
+	identity, err := c.currentIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := connect(c.appConfig, identity)
+	if err != nil {
+		return nil, &setupError{err: fmt.Errorf("failed to connect as %s: %w", identity.label(), err)}
+	}
+
+	c.connection = connection
+	c.contract = connection.contract
+
+	return c.contract, nil
+}
+
+func (c *cli) close() {
+	if c.connection != nil {
+		c.connection.Close()
+	}
+}
+
+// requireFlags reports the flags that were left empty.
+func requireFlags(flags *flag.FlagSet, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if flags.Lookup(name).Value.String() == "" {
+			missing = append(missing, "--"+name)
+		}
+	}
+	if len(missing) > 0 {
+		return newUsageError("%s %s required", strings.Join(missing, ", "), pluralize(len(missing), "is", "are"))
+	}
+
+	return nil
+}
+
+func pluralize(count int, singular string, plural string) string {
+	if count == 1 {
+		return singular
+	}
+
+	return plural
+}
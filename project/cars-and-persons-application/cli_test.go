@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// stubContract records the transactions it is asked to run and answers them with the
+// result or error configured for the transaction name.
+type stubContract struct {
+	calls   []string
+	results map[string][][]byte
+	errs    map[string]error
+	// statuses are the commit statuses by transaction ID, the other transactions stay
+	// pending.
+	statuses map[string]*client.Status
+	// height is the block height of the channel.
+	height uint64
+}
+
+func newStubContract() *stubContract {
+	return &stubContract{results: map[string][][]byte{}, errs: map[string]error{}, statuses: map[string]*client.Status{}}
+}
+
+func (s *stubContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return s.invoke("evaluate", name, args)
+}
+
+func (s *stubContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return s.invoke("submit", name, args)
+}
+
+// SubmitWithTransient records the transient data after the arguments, by key.
+func (s *stubContract) SubmitWithTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	var keys []string
+	for key := range transient {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	transientArgs := append([]string{}, args...)
+	for _, key := range keys {
+		transientArgs = append(transientArgs, key+"="+string(transient[key]))
+	}
+
+	return s.invoke("submit", name, transientArgs)
+}
+
+// SubmitAsync numbers the submitted transactions tx1, tx2 and so on.
+func (s *stubContract) SubmitAsync(name string, args ...string) (*submittedTransaction, error) {
+	result, err := s.invoke("submitAsync", name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionID := fmt.Sprintf("tx%d", len(s.calls))
+	status := s.statuses[transactionID]
+	return &submittedTransaction{transactionID: transactionID, result: result, status: func(ctx context.Context) (*client.Status, error) {
+		if status == nil {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return status, nil
+	}}, nil
+}
+
+func (s *stubContract) CommitStatus(ctx context.Context, transactionID string) (*client.Status, error) {
+	if err := s.errs[transactionID]; err != nil {
+		return nil, err
+	}
+
+	status := s.statuses[transactionID]
+	if status == nil {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return status, nil
+}
+
+func (s *stubContract) BlockHeight() (uint64, error) {
+	return s.height, nil
+}
+
+// invoke returns the configured results of the transaction one after another.
+func (s *stubContract) invoke(kind string, name string, args []string) ([]byte, error) {
+	s.calls = append(s.calls, kind+" "+name+"("+strings.Join(args, ", ")+")")
+	if err := s.errs[name]; err != nil {
+		return nil, err
+	}
+
+	results := s.results[name]
+	if len(results) == 0 {
+		return nil, nil
+	}
+	s.results[name] = results[1:]
+
+	return results[0], nil
+}
+
+func runWithContract(contract *stubContract, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	c := &cli{stdout: &stdout, stderr: &stderr, contract: contract}
+
+	return c.run(args), stdout.String(), stderr.String()
+}
+
+func TestTransferCommand(t *testing.T) {
+	contract := newStubContract()
+
+	code, stdout, stderr := runWithContract(contract, "cars", "transfer", "--car", "car1", "--to", "person2", "--accept-malfunction")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if got, want := strings.Join(contract.calls, "; "), "submit TransferCarAsset(car1, person2, true)"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if stdout != "Transaction committed successfully\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+
+	code, stdout, _ = runWithContract(contract, "--output", "json", "--org", "org2", "cars", "recolor", "--car", "car1", "--color", "red")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d", exitOK, code)
+	}
+	if stdout != "{\n  \"status\": \"committed\"\n}\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+}
+
+func TestSubmitWithPersonDetails(t *testing.T) {
+	detailsPath := filepath.Join(t.TempDir(), "person2.json")
+	writeFile(t, detailsPath, `{"ID":"person2","EmailAddress":"marko@pdasp.rs","AmountOfMoneyOwned":{"Amount":820040,"Currency":"EUR"},"Salt":"e5d1a4f1c0b94a7e9d2f6c3b8a1e0f47"}`)
+	contract := newStubContract()
+
+	code, _, stderr := runWithContract(contract, "escrows", "lock", "--escrow", "escrow1", "--offer", "offer1", "--buyer", "person2", "--details", detailsPath)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	want := `submit LockEscrow(escrow1, offer1, person2, false, 3600, personDetails=[{"AmountOfMoneyOwned":{"Amount":820040,"Currency":"EUR"},"EmailAddress":"marko@pdasp.rs","ID":"person2","Salt":"e5d1a4f1c0b94a7e9d2f6c3b8a1e0f47"}])`
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	contract = newStubContract()
+	code, _, stderr = runWithContract(contract, "--org", "org2", "offers", "accept", "--offer", "offer1", "--buyer", "person2", "--details", detailsPath)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	want = `submit AcceptSaleOffer(offer1, person2, false, personDetails=[{"AmountOfMoneyOwned":{"Amount":820040,"Currency":"EUR"},"EmailAddress":"marko@pdasp.rs","ID":"person2","Salt":"e5d1a4f1c0b94a7e9d2f6c3b8a1e0f47"}])`
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	contract = newStubContract()
+	code, _, stderr = runWithContract(contract, "--org", "org2", "cars", "transfer", "--car", "car5", "--to", "person2", "--details", detailsPath)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	want = `submit TransferCarAsset(car5, person2, false, personDetails=[{"AmountOfMoneyOwned":{"Amount":820040,"Currency":"EUR"},"EmailAddress":"marko@pdasp.rs","ID":"person2","Salt":"e5d1a4f1c0b94a7e9d2f6c3b8a1e0f47"}])`
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	for _, args := range [][]string{
+		{"--async", "escrows", "lock", "--escrow", "escrow1", "--offer", "offer1", "--buyer", "person2", "--details", detailsPath},
+		{"escrows", "lock", "--escrow", "escrow1", "--offer", "offer1", "--buyer", "person2", "--details", filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		code, _, _ = runWithContract(contract, args...)
+		if code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestLedgerCommandsPassSalt(t *testing.T) {
+	salts := map[string]bool{}
+	for _, command := range []string{"init", "migrate-keys", "migrate-person-details"} {
+		contract := newStubContract()
+
+		code, _, stderr := runWithContract(contract, "ledger", command)
+		if code != exitOK {
+			t.Fatalf("%s: expected exit code %d, got %d: %s", command, exitOK, code, stderr)
+		}
+		call := regexp.MustCompile(`^submit \w+\(salt=([0-9a-f]{32})\)$`).FindStringSubmatch(strings.Join(contract.calls, "; "))
+		if call == nil {
+			t.Fatalf("%s: expected a transaction with a random salt, got %v", command, contract.calls)
+		}
+		salts[call[1]] = true
+	}
+	if len(salts) != 3 {
+		t.Errorf("expected a new salt for every transaction, got %v", salts)
+	}
+
+	code, _, _ := runWithContract(newStubContract(), "--async", "ledger", "init")
+	if code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCarsEndorsementCommand(t *testing.T) {
+	contract := newStubContract()
+	contract.results["GetCarEndorsementPolicy"] = [][]byte{[]byte(`{"CarID":"car5","OwnerID":"person2","Organizations":["Org2MSP","Org3MSP"]}`)}
+
+	code, stdout, stderr := runWithContract(contract, "cars", "endorsement", "--car", "car5")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if got, want := strings.Join(contract.calls, "; "), "evaluate GetCarEndorsementPolicy(car5)"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if !strings.Contains(stdout, "Org2MSP, Org3MSP") {
+		t.Errorf("expected the organizations, got %q", stdout)
+	}
+}
+
+func TestReservationCommands(t *testing.T) {
+	contract := newStubContract()
+
+	for _, args := range [][]string{
+		{"cars", "reserve", "--offer", "offer1", "--holder", "person2"},
+		{"cars", "reservation", "--car", "car5"},
+		{"cars", "release-reservation", "--car", "car5"},
+	} {
+		code, _, stderr := runWithContract(contract, args...)
+		if code != exitOK {
+			t.Fatalf("%v: expected exit code %d, got %d: %s", args, exitOK, code, stderr)
+		}
+	}
+
+	want := "submit ReserveCar(offer1, person2, 600); evaluate ReadCarReservation(car5); submit ReleaseReservation(car5)"
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestVehicleCommands(t *testing.T) {
+	contract := newStubContract()
+
+	for _, args := range [][]string{
+		{"cars", "read", "--vin", "WDD1760410J123456"},
+		{"cars", "read", "--plate", "NI-345-JK"},
+		{"cars", "odometer", "--car", "car5", "--reading", "36500"},
+		{"cars", "identity", "--car", "car7", "--vin", "ZFA18800105123456", "--plate", "BG-555-ZZ", "--first-registration", "2005-07-01"},
+	} {
+		code, _, stderr := runWithContract(contract, args...)
+		if code != exitOK {
+			t.Fatalf("%v: expected exit code %d, got %d: %s", args, exitOK, code, stderr)
+		}
+	}
+
+	want := "evaluate GetCarByVIN(WDD1760410J123456); evaluate GetCarByPlate(NI-345-JK); submit UpdateOdometer(car5, 36500); submit SetCarIdentity(car7, ZFA18800105123456, BG-555-ZZ, 2005-07-01)"
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	for _, args := range [][]string{
+		{"cars", "read"},
+		{"cars", "read", "--car", "car5", "--vin", "WDD1760410J123456"},
+		{"cars", "odometer", "--car", "car5"},
+		{"cars", "identity", "--car", "car7"},
+	} {
+		code, _, _ := runWithContract(contract, args...)
+		if code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestEscrowCommands(t *testing.T) {
+	detailsPath := filepath.Join(t.TempDir(), "person3.json")
+	writeFile(t, detailsPath, `{"ID":"person3","EmailAddress":"jovana@pdasp.rs","AmountOfMoneyOwned":{"Amount":143022,"Currency":"EUR"}}`)
+	contract := newStubContract()
+
+	for _, args := range [][]string{
+		{"escrows", "lock", "--escrow", "escrow1", "--offer", "offer1", "--buyer", "person2", "--timeout", "600"},
+		{"escrows", "transfer", "--escrow", "escrow1"},
+		{"escrows", "release", "--escrow", "escrow1", "--details", detailsPath},
+		{"escrows", "read", "--escrow", "escrow1"},
+	} {
+		code, _, stderr := runWithContract(contract, args...)
+		if code != exitOK {
+			t.Fatalf("%v: expected exit code %d, got %d: %s", args, exitOK, code, stderr)
+		}
+	}
+
+	want := []string{
+		"submit LockEscrow(escrow1, offer1, person2, false, 600)",
+		"submit TransferEscrowedCar(escrow1)",
+		`submit ReleaseEscrow(escrow1, personDetails=[{"AmountOfMoneyOwned":{"Amount":143022,"Currency":"EUR"},"EmailAddress":"jovana@pdasp.rs","ID":"person3"}])`,
+		"evaluate ReadEscrow(escrow1)",
+	}
+	if got := strings.Join(contract.calls, "; "); got != strings.Join(want, "; ") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	code, _, _ := runWithContract(contract, "escrows", "refund")
+	if code != exitUsage {
+		t.Errorf("expected exit code %d without the escrow, got %d", exitUsage, code)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"offers", "accept", "--offer", "offer1"},
+		{"offers", "accept", "--offer", "offer1", "--buyer", "person2", "extra"},
+		{"cars", "transfer", "--car", "car1"},
+		{"cars", "fly"},
+		{"cars"},
+		{"cars", "read", "--car", "car1", "--output", "yaml"},
+		{"cars", "query", "--color", "red", "--brand", "Audi"},
+		{"cars", "query", "--has-malfunctions", "maybe"},
+		{"cars", "read", "--unknown"},
+	} {
+		contract := newStubContract()
+
+		code, _, stderr := runWithContract(contract, args...)
+		if code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+		if stderr == "" {
+			t.Errorf("%v: expected the problem to be reported", args)
+		}
+		if len(contract.calls) > 0 {
+			t.Errorf("%v: expected no transactions, got %v", args, contract.calls)
+		}
+	}
+}
+
+func TestTransactionFailure(t *testing.T) {
+	contract := newStubContract()
+	contract.errs["ReadCarAsset"] = errors.New("the car asset car9 does not exist")
+
+	code, stdout, stderr := runWithContract(contract, "cars", "read", "--car", "car9")
+	if code != exitTransactionFailed {
+		t.Fatalf("expected exit code %d, got %d", exitTransactionFailed, code)
+	}
+	if stdout != "" {
+		t.Errorf("expected no output, got %q", stdout)
+	}
+	if !strings.Contains(stderr, "the car asset car9 does not exist") {
+		t.Errorf("expected the chaincode error, got %q", stderr)
+	}
+}
+
+func TestSetupFailure(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "app_config.json")
+	err := os.WriteFile(configPath, []byte(`{"orgs":{"org1":{"mspID":"Org1MSP"}},"channelName":"mychannel","chaincodeName":"basic"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"--config", filepath.Join(t.TempDir(), "missing.json"), "cars", "read", "--car", "car1"},
+		{"--config", configPath, "--org", "org4", "cars", "read", "--car", "car1"},
+		{"--config", configPath, "cars", "read", "--car", "car1"},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := run(args, &stdout, &stderr)
+		if code != exitSetupFailed {
+			t.Errorf("%v: expected exit code %d, got %d: %s", args, exitSetupFailed, code, stderr.String())
+		}
+	}
+}
+
+func TestQueryCommand(t *testing.T) {
+	contract := newStubContract()
+	contract.results["GetCarsByColorAndOwnerWithPagination"] = [][]byte{
+		[]byte(`{"records":[{"ID":"car1","Color":"red","OwnerID":"person2","Price":{"Amount":250000,"Currency":"EUR"}},{"ID":"car2"},{"ID":"car3"},{"ID":"car4"},{"ID":"car5"}],"fetchedRecordsCount":5,"bookmark":"car6"}`),
+		[]byte(`{"records":[{"ID":"car6","MalfunctionList":[{"ID":"1"}]}],"fetchedRecordsCount":1,"bookmark":""}`),
+	}
+
+	code, stdout, stderr := runWithContract(contract, "cars", "query", "--color", "red", "--owner", "person2")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	want := []string{
+		"evaluate GetCarsByColorAndOwnerWithPagination(red, person2, 5, )",
+		"evaluate GetCarsByColorAndOwnerWithPagination(red, person2, 5, car6)",
+	}
+	if got := strings.Join(contract.calls, "; "); got != strings.Join(want, "; ") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("expected a header and 6 rows, got %q", stdout)
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "ID Color OwnerID Price MalfunctionList" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "car1 red person2 2500.00 EUR" {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[6], "1 item") {
+		t.Errorf("unexpected row %q", lines[6])
+	}
+
+	contract = newStubContract()
+	contract.results["QueryCars"] = [][]byte{[]byte(`{"records":[],"fetchedRecordsCount":0,"bookmark":""}`)}
+	code, stdout, _ = runWithContract(contract, "cars", "query", "--brand", "Audi", "--min-year", "2015", "--has-malfunctions", "false")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d", exitOK, code)
+	}
+	if got, want := contract.calls[0], `evaluate QueryCars({"brand":"Audi","hasMalfunctions":false,"minYear":2015}, 5, )`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if stdout != "No results\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var output bytes.Buffer
+	err := writeTable(&output, []byte(`{"ID":"person1","AmountOfMoneyOwned":{"Amount":-5,"Currency":"EUR"},"Tags":["a","b"],"ClientID":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ID                  person1\nAmountOfMoneyOwned  -0.05 EUR\nTags                a, b\nClientID            \n"
+	if output.String() != want {
+		t.Errorf("expected %q, got %q", want, output.String())
+	}
+
+	output.Reset()
+	err = writeTable(&output, []byte("x509::CN=user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "x509::CN=user1\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+}
@@ -0,0 +1,1047 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// commands are the subcommands of the application, in the order they are listed in
+// the usage.
+var commands = []command{
+	{group: "shell", summary: "Start the interactive menu (the default without a command)", setup: setupShell},
+
+	{group: "ledger", name: "init", summary: "Initialize the ledger with the sample cars and persons", setup: submitWithSalt("InitLedger")},
+	{group: "ledger", name: "migrate-money", summary: "Convert float prices and balances to minor units", setup: submitWithoutArgs("MigrateMoneyToMinorUnits")},
+	{group: "ledger", name: "migrate-keys", summary: "Move cars and persons from bare IDs to typed keys", setup: submitWithSalt("MigrateToTypedKeys")},
+	{group: "ledger", name: "migrate-person-details", summary: "Move the email addresses and balances of persons to private data", setup: submitWithSalt("MigratePersonDetailsToPrivateData")},
+	{group: "ledger", name: "migrate-owner-index", summary: "Add cars stored by earlier chaincode versions to the owner~ID index", setup: submitWithoutArgs("MigrateOwnerIndex")},
+	{group: "ledger", name: "migrate-car-endorsement", summary: "Give cars without a key-level endorsement policy one for their owner's organization", setup: submitWithoutArgs("MigrateCarEndorsementPolicies")},
+
+	{group: "whoami", summary: "Show the wallet identity commands are signed with", setup: setupWhoami},
+	{group: "identity", name: "list", summary: "List the identities in the wallet", setup: setupIdentityList},
+	{group: "identity", name: "show", summary: "Show the client identity the chaincode sees for the selected identity", setup: setupIdentityShow},
+
+	{group: "persons", name: "read", summary: "Read a person", setup: setupPersonsRead},
+	{group: "persons", name: "details", summary: "Read the email address and balance of a person", setup: setupPersonsDetails},
+	{group: "persons", name: "link", summary: "Link a person to a client identity (registry only)", setup: setupPersonsLink},
+
+	{group: "cars", name: "read", summary: "Read a car by its ID, VIN or registration plate", setup: setupCarsRead},
+	{group: "cars", name: "query", summary: "List the cars matching the given filters", setup: setupCarsQuery},
+	{group: "cars", name: "history", summary: "Show every version of a car", setup: setupCarsHistory},
+	{group: "cars", name: "endorsement", summary: "Show the organizations that have to endorse changes of a car (registry only)", setup: setupCarsEndorsement},
+	{group: "cars", name: "transfer", summary: "Buy a car through an open sale offer of its owner", setup: setupCarsTransfer},
+	{group: "cars", name: "reserve", summary: "Reserve the car of an open sale offer for a buyer for a while", setup: setupCarsReserve},
+	{group: "cars", name: "release-reservation", summary: "Release the reservation of a car", setup: setupCarsReleaseReservation},
+	{group: "cars", name: "reservation", summary: "Read the active reservation of a car", setup: setupCarsReservation},
+	{group: "cars", name: "odometer", summary: "Record a new odometer reading of a car", setup: setupCarsOdometer},
+	{group: "cars", name: "identity", summary: "Set the VIN, registration plate and first registration of a car (registry only)", setup: setupCarsIdentity},
+	{group: "cars", name: "recolor", summary: "Change the color of a car", setup: setupCarsRecolor},
+	{group: "cars", name: "add-malfunction", summary: "Report a malfunction of a car", setup: setupCarsAddMalfunction},
+	{group: "cars", name: "malfunctions", summary: "List the open malfunctions of a car", setup: setupCarsMalfunctions},
+	{group: "cars", name: "repair", summary: "Repair all malfunctions of a car", setup: setupCarsRepair},
+	{group: "cars", name: "repair-malfunction", summary: "Repair a single malfunction of a car", setup: setupCarsRepairMalfunction},
+	{group: "cars", name: "written-off", summary: "List the written-off cars of an owner", setup: setupCarsWrittenOff},
+	{group: "cars", name: "settle-payout", summary: "Record the payout of a written-off car (insurer only)", setup: setupCarsSettlePayout},
+
+	{group: "offers", name: "create", summary: "Offer a car for sale", setup: setupOffersCreate},
+	{group: "offers", name: "accept", summary: "Accept a sale offer and pay its price as the buyer", setup: setupOffersAccept},
+	{group: "offers", name: "settle", summary: "Hand over the car of an accepted sale offer and collect its price as the seller", setup: setupOfferStep("SettleSaleOffer")},
+	{group: "offers", name: "cancel", summary: "Cancel an open sale offer as the seller, or an accepted one as the buyer", setup: setupOfferStep("CancelSaleOffer")},
+	{group: "offers", name: "read", summary: "Read a sale offer", setup: setupOffersRead},
+
+	{group: "escrows", name: "lock", summary: "Accept a sale offer into an escrow as the buyer", setup: setupEscrowsLock},
+	{group: "escrows", name: "transfer", summary: "Transfer the car of a locked escrow to the buyer", setup: setupEscrowStep("TransferEscrowedCar")},
+	{group: "escrows", name: "release", summary: "Pay the price of a transferred car to the seller", setup: setupEscrowStep("ReleaseEscrow")},
+	{group: "escrows", name: "refund", summary: "Return the price of a car that was not transferred to the buyer", setup: setupEscrowStep("RefundEscrow")},
+	{group: "escrows", name: "read", summary: "Read an escrow", setup: setupEscrowsRead},
+
+	{group: "import", summary: "Import cars and persons from a CSV or JSON file in batches (registry only)", setup: setupImport},
+
+	{group: "export", summary: "Write a snapshot of all cars, persons and malfunctions as JSON Lines, or verify one", setup: setupExport},
+
+	{group: "status", summary: "Show the commit status of submitted transactions", args: "<transaction ID>...", setup: setupStatus},
+
+	{group: "events", name: "listen", summary: "Print chaincode events until interrupted", setup: setupEventsListen},
+
+	{group: "serve", summary: "Serve the contract as a REST API until interrupted", setup: setupServe},
+}
+
+func setupShell(c *cli, flags *flag.FlagSet) func() error {
+	return func() error {
+		if c.options.org == "" {
+			c.options.org = chooseOrganization()
+		}
+
+		_, err := c.open()
+		if err != nil {
+			return err
+		}
+		if c.connection == nil {
+			return &setupError{err: fmt.Errorf("the shell needs a connection to the network")}
+		}
+
+		runShell(c.connection, c.wallet, c.appConfig.ChaincodeName)
+
+		return nil
+	}
+}
+
+func submitWithoutArgs(transactionName string) func(c *cli, flags *flag.FlagSet) func() error {
+	return func(c *cli, flags *flag.FlagSet) func() error {
+		return func() error {
+			return c.submit(transactionName)
+		}
+	}
+}
+
+// submitWithSalt sets up the commands of transactions that store the private details of
+// persons without taking them from the client, which pass a new random salt for them.
+func submitWithSalt(transactionName string) func(c *cli, flags *flag.FlagSet) func() error {
+	return func(c *cli, flags *flag.FlagSet) func() error {
+		return func() error {
+			salt, err := newSalt()
+			if err != nil {
+				return err
+			}
+
+			return c.submitWithTransient(transactionName, map[string][]byte{transientSaltKey: []byte(salt)})
+		}
+	}
+}
+
+func setupWhoami(c *cli, flags *flag.FlagSet) func() error {
+	return func() error {
+		identity, err := c.currentIdentity()
+		if err != nil {
+			return err
+		}
+
+		description, err := identity.describe()
+		if err != nil {
+			return &setupError{err: fmt.Errorf("failed to read identity %s: %w", identity.label(), err)}
+		}
+
+		return c.printValue(description)
+	}
+}
+
+func setupIdentityList(c *cli, flags *flag.FlagSet) func() error {
+	return func() error {
+		wallet, err := c.loadWallet()
+		if err != nil {
+			return err
+		}
+
+		type listedIdentity struct {
+			Identity string
+			MSPID    string
+			Default  bool
+		}
+
+		identities := []listedIdentity{}
+		for _, identity := range wallet.list() {
+			defaultIdentity, err := wallet.defaultIdentity(identity.org)
+			isDefault := err == nil && defaultIdentity.name == identity.name
+			identities = append(identities, listedIdentity{identity.label(), identity.mspID, isDefault})
+		}
+
+		return c.printValue(identities)
+	}
+}
+
+func setupIdentityShow(c *cli, flags *flag.FlagSet) func() error {
+	return func() error {
+		return c.evaluate("GetSubmittingClientIdentity")
+	}
+}
+
+func setupPersonsRead(c *cli, flags *flag.FlagSet) func() error {
+	personID := flags.String("person", "", "ID of the person")
+
+	return func() error {
+		err := requireFlags(flags, "person")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("ReadPersonAsset", *personID)
+	}
+}
+
+func setupPersonsDetails(c *cli, flags *flag.FlagSet) func() error {
+	personID := flags.String("person", "", "ID of the person")
+
+	return func() error {
+		err := requireFlags(flags, "person")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("ReadPersonPrivateDetails", *personID)
+	}
+}
+
+func setupPersonsLink(c *cli, flags *flag.FlagSet) func() error {
+	personID := flags.String("person", "", "ID of the person")
+	clientID := flags.String("client-id", "", "client identity, as shown by \"identity show\"")
+	mspID := flags.String("msp-id", "", "MSP ID of the client")
+
+	return func() error {
+		err := requireFlags(flags, "person", "client-id", "msp-id")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("LinkPersonIdentity", *personID, *clientID, *mspID)
+	}
+}
+
+func setupCarsRead(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	vin := flags.String("vin", "", "vehicle identification number of the car, instead of --car")
+	plate := flags.String("plate", "", "registration plate of the car, instead of --car")
+
+	return func() error {
+		switch {
+		case *carID != "" && *vin == "" && *plate == "":
+			return c.evaluate("ReadCarAsset", *carID)
+		case *carID == "" && *vin != "" && *plate == "":
+			return c.evaluate("GetCarByVIN", *vin)
+		case *carID == "" && *vin == "" && *plate != "":
+			return c.evaluate("GetCarByPlate", *plate)
+		default:
+			return newUsageError("exactly one of --car, --vin and --plate is required")
+		}
+	}
+}
+
+func setupCarsQuery(c *cli, flags *flag.FlagSet) func() error {
+	var query carQuery
+	flags.StringVar(&query.color, "color", "", "color of the cars, can only be combined with --owner")
+	flags.StringVar(&query.ownerID, "owner", "", "ID of the owner")
+	flags.StringVar(&query.brand, "brand", "", "brand of the cars")
+	flags.StringVar(&query.model, "model", "", "model of the cars")
+	flags.IntVar(&query.minYear, "min-year", 0, "earliest year of production")
+	flags.IntVar(&query.maxYear, "max-year", 0, "latest year of production")
+	flags.StringVar(&query.minPrice, "min-price", "", "lowest price, e.g. 1000.00 EUR")
+	flags.StringVar(&query.maxPrice, "max-price", "", "highest price, e.g. 5000.00 EUR")
+	flags.StringVar(&query.status, "status", "", "status of the cars, active or writtenOff")
+	flags.StringVar(&query.hasMalfunctions, "has-malfunctions", "", "true or false to select cars with or without open malfunctions")
+
+	return func() error {
+		transactionName, args, err := query.transaction()
+		if err != nil {
+			return &usageError{message: err.Error()}
+		}
+
+		return c.evaluateAllPages(transactionName, args...)
+	}
+}
+
+// carQuery holds the filters of a car listing, given on the command line or in the
+// query string of GET /cars.
+type carQuery struct {
+	color           string
+	ownerID         string
+	brand           string
+	model           string
+	minYear         int
+	maxYear         int
+	minPrice        string
+	maxPrice        string
+	status          string
+	hasMalfunctions string
+}
+
+// transaction returns the paginated transaction that answers the query, and its
+// arguments without the page size and bookmark. Cars selected by color, with or without
+// an owner, are read from the color index, and any other filter is passed to QueryCars,
+// which needs CouchDB.
+func (q carQuery) transaction() (string, []string, error) {
+	filter := map[string]interface{}{}
+	for key, value := range map[string]string{"brand": q.brand, "model": q.model, "minPrice": q.minPrice, "maxPrice": q.maxPrice, "status": q.status} {
+		if value != "" {
+			filter[key] = value
+		}
+	}
+	for key, value := range map[string]int{"minYear": q.minYear, "maxYear": q.maxYear} {
+		if value != 0 {
+			filter[key] = value
+		}
+	}
+	if q.hasMalfunctions != "" {
+		value, err := strconv.ParseBool(q.hasMalfunctions)
+		if err != nil {
+			return "", nil, fmt.Errorf("has-malfunctions must be true or false, not %q", q.hasMalfunctions)
+		}
+		filter["hasMalfunctions"] = value
+	}
+
+	if q.color != "" {
+		if len(filter) > 0 {
+			return "", nil, fmt.Errorf("color can only be combined with owner, QueryCars does not filter by color")
+		}
+		if q.ownerID != "" {
+			return "GetCarsByColorAndOwnerWithPagination", []string{q.color, q.ownerID}, nil
+		}
+		return "GetCarsByColorWithPagination", []string{q.color}, nil
+	}
+
+	if q.ownerID != "" {
+		filter["ownerID"] = q.ownerID
+	}
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "QueryCars", []string{string(filterJSON)}, nil
+}
+
+func setupCarsHistory(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("GetCarHistory", *carID)
+	}
+}
+
+func setupCarsEndorsement(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("GetCarEndorsementPolicy", *carID)
+	}
+}
+
+func setupCarsTransfer(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	newOwnerID := flags.String("to", "", "ID of the new owner")
+	acceptMalfunction := flags.Bool("accept-malfunction", false, "the new owner accepts a malfunctioned car with a price compensation")
+	detailsPath := flags.String("details", "", "JSON file with the private details of the buyer or the seller, as printed by \"persons details\", for peers outside their organization")
+
+	return func() error {
+		err := requireFlags(flags, "car", "to")
+		if err != nil {
+			return err
+		}
+
+		return c.submitWithDetails("TransferCarAsset", *detailsPath, *carID, *newOwnerID, strconv.FormatBool(*acceptMalfunction))
+	}
+}
+
+// readPersonDetailsFile reads the private details of one person, or a JSON array of
+// them, and returns them as the array the chaincode expects.
+func readPersonDetailsFile(path string) ([]byte, error) {
+	detailsJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var detailsList []json.RawMessage
+	if json.Unmarshal(detailsJSON, &detailsList) == nil {
+		return detailsJSON, nil
+	}
+
+	var details map[string]json.RawMessage
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, fmt.Errorf("the details file %s must hold a JSON object or array: %v", path, err)
+	}
+
+	return json.Marshal([]map[string]json.RawMessage{details})
+}
+
+func setupCarsReserve(c *cli, flags *flag.FlagSet) func() error {
+	offerID := flags.String("offer", "", "ID of the open sale offer of the car")
+	holderID := flags.String("holder", "", "ID of the buyer to reserve the car for")
+	durationSeconds := flags.Int("duration", 600, "number of seconds the reservation lasts, at most a day and no longer than the offer")
+
+	return func() error {
+		err := requireFlags(flags, "offer", "holder")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("ReserveCar", *offerID, *holderID, strconv.Itoa(*durationSeconds))
+	}
+}
+
+func setupCarsReleaseReservation(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("ReleaseReservation", *carID)
+	}
+}
+
+func setupCarsReservation(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("ReadCarReservation", *carID)
+	}
+}
+
+func setupCarsOdometer(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	reading := flags.Int("reading", -1, "odometer reading in kilometers, at least the current one")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+		if *reading < 0 {
+			return newUsageError("--reading is required and must not be negative")
+		}
+
+		return c.submit("UpdateOdometer", *carID, strconv.Itoa(*reading))
+	}
+}
+
+func setupCarsIdentity(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	vin := flags.String("vin", "", "vehicle identification number of the car")
+	plate := flags.String("plate", "", "registration plate of the car, empty if it is not registered")
+	firstRegistration := flags.String("first-registration", "", "date of the first registration, such as 2018-04-11")
+
+	return func() error {
+		err := requireFlags(flags, "car", "vin")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("SetCarIdentity", *carID, *vin, *plate, *firstRegistration)
+	}
+}
+
+func setupCarsRecolor(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	color := flags.String("color", "", "new color of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car", "color")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("ChangeCarColor", *carID, *color)
+	}
+}
+
+func setupCarsAddMalfunction(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	description := flags.String("description", "", "description of the malfunction")
+	repairPrice := flags.String("repair-price", "", "repair price, e.g. 49.99 EUR")
+	severity := flags.String("severity", "", "severity of the malfunction, minor, major or critical")
+
+	return func() error {
+		err := requireFlags(flags, "car", "description", "repair-price", "severity")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("AddCarMalfunction", *carID, *description, *repairPrice, *severity)
+	}
+}
+
+func setupCarsMalfunctions(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("ListOpenMalfunctions", *carID)
+	}
+}
+
+func setupCarsRepair(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+
+	return func() error {
+		err := requireFlags(flags, "car")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("RepairCar", *carID)
+	}
+}
+
+func setupCarsRepairMalfunction(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the car")
+	malfunctionID := flags.String("malfunction", "", "ID of the malfunction")
+
+	return func() error {
+		err := requireFlags(flags, "car", "malfunction")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("RepairMalfunction", *carID, *malfunctionID)
+	}
+}
+
+func setupCarsWrittenOff(c *cli, flags *flag.FlagSet) func() error {
+	ownerID := flags.String("owner", "", "ID of the owner")
+
+	return func() error {
+		err := requireFlags(flags, "owner")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("GetWrittenOffCars", *ownerID)
+	}
+}
+
+func setupCarsSettlePayout(c *cli, flags *flag.FlagSet) func() error {
+	carID := flags.String("car", "", "ID of the written-off car")
+	payout := flags.String("payout", "", "payout, e.g. 1500.00 EUR")
+
+	return func() error {
+		err := requireFlags(flags, "car", "payout")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("SettleWriteOffPayout", *carID, *payout)
+	}
+}
+
+func setupOffersCreate(c *cli, flags *flag.FlagSet) func() error {
+	offerID := flags.String("offer", "", "ID of the new offer")
+	carID := flags.String("car", "", "ID of the car")
+	buyerID := flags.String("buyer", "", "ID of the buyer, leave empty to offer the car to anyone")
+	askingPrice := flags.String("price", "", "asking price, e.g. 2500.00 EUR")
+	validForSeconds := flags.Int("valid-for", 3600, "number of seconds the offer is valid for")
+
+	return func() error {
+		err := requireFlags(flags, "offer", "car", "price")
+		if err != nil {
+			return err
+		}
+
+		return c.submit("CreateSaleOffer", *offerID, *carID, *buyerID, *askingPrice, strconv.Itoa(*validForSeconds))
+	}
+}
+
+func setupOffersAccept(c *cli, flags *flag.FlagSet) func() error {
+	offerID := flags.String("offer", "", "ID of the offer")
+	buyerID := flags.String("buyer", "", "ID of the buyer")
+	acceptMalfunction := flags.Bool("accept-malfunction", false, "the buyer accepts a malfunctioned car with a price compensation")
+	detailsPath := flags.String("details", "", "JSON file with the private details of the buyer, as printed by \"persons details\", for peers outside their organization")
+
+	return func() error {
+		err := requireFlags(flags, "offer", "buyer")
+		if err != nil {
+			return err
+		}
+
+		return c.submitWithDetails("AcceptSaleOffer", *detailsPath, *offerID, *buyerID, strconv.FormatBool(*acceptMalfunction))
+	}
+}
+
+// setupOfferStep sets up the offer commands that only take the offer ID, i.e.
+// SettleSaleOffer and CancelSaleOffer.
+func setupOfferStep(transactionName string) func(c *cli, flags *flag.FlagSet) func() error {
+	return func(c *cli, flags *flag.FlagSet) func() error {
+		offerID := flags.String("offer", "", "ID of the offer")
+		detailsPath := flags.String("details", "", "JSON file with the private details of the person who is paid, as printed by \"persons details\", for peers outside their organization")
+
+		return func() error {
+			err := requireFlags(flags, "offer")
+			if err != nil {
+				return err
+			}
+
+			return c.submitWithDetails(transactionName, *detailsPath, *offerID)
+		}
+	}
+}
+
+func setupOffersRead(c *cli, flags *flag.FlagSet) func() error {
+	offerID := flags.String("offer", "", "ID of the offer")
+
+	return func() error {
+		err := requireFlags(flags, "offer")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("ReadSaleOffer", *offerID)
+	}
+}
+
+func setupEscrowsLock(c *cli, flags *flag.FlagSet) func() error {
+	escrowID := flags.String("escrow", "", "ID of the new escrow")
+	offerID := flags.String("offer", "", "ID of the sale offer to accept into the escrow")
+	buyerID := flags.String("buyer", "", "ID of the buyer")
+	acceptMalfunction := flags.Bool("accept-malfunction", false, "the buyer accepts a malfunctioned car with a price compensation")
+	timeoutSeconds := flags.Int("timeout", 3600, "number of seconds the seller has to transfer the car, and the buyer to confirm the receipt")
+	detailsPath := flags.String("details", "", "JSON file with the private details of the buyer, as printed by \"persons details\", for peers outside their organization")
+
+	return func() error {
+		err := requireFlags(flags, "escrow", "offer", "buyer")
+		if err != nil {
+			return err
+		}
+
+		return c.submitWithDetails("LockEscrow", *detailsPath, *escrowID, *offerID, *buyerID, strconv.FormatBool(*acceptMalfunction), strconv.Itoa(*timeoutSeconds))
+	}
+}
+
+// setupEscrowStep sets up the escrow commands that only take the escrow ID, i.e.
+// TransferEscrowedCar, ReleaseEscrow and RefundEscrow.
+func setupEscrowStep(transactionName string) func(c *cli, flags *flag.FlagSet) func() error {
+	return func(c *cli, flags *flag.FlagSet) func() error {
+		escrowID := flags.String("escrow", "", "ID of the escrow")
+		detailsPath := flags.String("details", "", "JSON file with the private details of the person who is paid, as printed by \"persons details\", for peers outside their organization")
+
+		return func() error {
+			err := requireFlags(flags, "escrow")
+			if err != nil {
+				return err
+			}
+
+			return c.submitWithDetails(transactionName, *detailsPath, *escrowID)
+		}
+	}
+}
+
+func setupEscrowsRead(c *cli, flags *flag.FlagSet) func() error {
+	escrowID := flags.String("escrow", "", "ID of the escrow")
+
+	return func() error {
+		err := requireFlags(flags, "escrow")
+		if err != nil {
+			return err
+		}
+
+		return c.evaluate("ReadEscrow", *escrowID)
+	}
+}
+
+func setupEventsListen(c *cli, flags *flag.FlagSet) func() error {
+	startBlock := flags.String("start-block", "", "block number to replay the events from, by default only new events are printed")
+
+	return func() error {
+		var options []client.ChaincodeEventsOption
+		if *startBlock != "" {
+			blockNumber, err := strconv.ParseUint(*startBlock, 10, 64)
+			if err != nil {
+				return newUsageError("--start-block must be a block number, not %q", *startBlock)
+			}
+			options = append(options, client.WithStartBlock(blockNumber))
+		}
+
+		return c.listenForEvents(options)
+	}
+}
+
+func setupImport(c *cli, flags *flag.FlagSet) func() error {
+	file := flags.String("file", "", "CSV or JSON file with the cars and persons to import")
+	batchSize := flags.Int("batch-size", 100, fmt.Sprintf("most records submitted in one transaction, at most %d", maxImportBatchRecords))
+	allOrNothing := flags.Bool("all-or-nothing", false, "import nothing of a batch that has an invalid record")
+
+	return func() error {
+		err := requireFlags(flags, "file")
+		if err != nil {
+			return err
+		}
+		if *batchSize < 1 || *batchSize > maxImportBatchRecords {
+			return newUsageError("--batch-size must be between 1 and %d, not %d", maxImportBatchRecords, *batchSize)
+		}
+
+		records, err := readImportFile(*file)
+		if err != nil {
+			return &usageError{message: err.Error()}
+		}
+
+		contract, err := c.open()
+		if err != nil {
+			return err
+		}
+
+		report := importRecords(contract, records, *batchSize, *allOrNothing, func(batch int, batches int) {
+			fmt.Fprintf(c.stderr, "Submitting batch %d of %d\n", batch, batches)
+		})
+
+		err = c.printImportReport(report)
+		if err != nil {
+			return err
+		}
+		if len(report.Failures) > 0 {
+			return fmt.Errorf("%d of the %d records were not imported", len(report.Failures), report.Records)
+		}
+
+		return nil
+	}
+}
+
+func setupExport(c *cli, flags *flag.FlagSet) func() error {
+	file := flags.String("file", "", "file to write the snapshot to, by default it is written to the standard output")
+	verify := flags.String("verify", "", "snapshot to compare with the ledger instead of exporting one")
+
+	return func() error {
+		if *verify != "" {
+			return c.verifySnapshot(*verify)
+		}
+
+		contract, err := c.open()
+		if err != nil {
+			return err
+		}
+
+		header := snapshotHeader{ExportedAt: time.Now().UTC()}
+		if c.appConfig != nil {
+			header.ChannelName, header.ChaincodeName = c.appConfig.ChannelName, c.appConfig.ChaincodeName
+		}
+		header.BlockHeight, err = contract.BlockHeight()
+		if err != nil {
+			return newTransactionError("evaluate", "GetChainInfo", err)
+		}
+
+		records, err := readLedgerRecords(contract)
+		if err != nil {
+			return err
+		}
+
+		// The pages are read in separate transactions, so blocks committed meanwhile may
+		// show in the later pages.
+		heightAfter, err := contract.BlockHeight()
+		if err == nil && heightAfter != header.BlockHeight {
+			fmt.Fprintf(c.stderr, "Warning: the block height changed from %d to %d during the export, the snapshot may mix both states\n", header.BlockHeight, heightAfter)
+		}
+
+		output := c.stdout
+		if *file != "" {
+			snapshotFile, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer snapshotFile.Close()
+			output = snapshotFile
+		}
+
+		err = writeSnapshot(output, header, records)
+		if err != nil {
+			return fmt.Errorf("failed to write the snapshot: %w", err)
+		}
+		if *file != "" {
+			fmt.Fprintf(c.stderr, "Exported %d records at block height %d to %s\n", len(records), header.BlockHeight, *file)
+		}
+
+		return nil
+	}
+}
+
+func setupStatus(c *cli, flags *flag.FlagSet) func() error {
+	wait := flags.Duration("wait", statusLookupTimeout, "how long to wait for transactions that are not committed yet")
+
+	return func() error {
+		transactionIDs := flags.Args()
+		if len(transactionIDs) == 0 {
+			return newUsageError("status needs at least one transaction ID")
+		}
+
+		contract, err := c.open()
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *wait)
+		defer cancel()
+
+		// The transactions are waited for together, so that a batch of pending
+		// transactions takes no longer than --wait.
+		statuses := make([]transactionStatus, len(transactionIDs))
+		var group sync.WaitGroup
+		for i, transactionID := range transactionIDs {
+			group.Add(1)
+			go func(i int, transactionID string) {
+				defer group.Done()
+				status, err := contract.CommitStatus(ctx, transactionID)
+				statuses[i] = newTransactionStatus(transactionID, status, err)
+			}(i, transactionID)
+		}
+		group.Wait()
+
+		err = c.printValue(statuses)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.Status == statusFailed {
+				return fmt.Errorf("failed to get the commit status of transaction %s: %s", status.TransactionID, status.Error)
+			}
+		}
+
+		return nil
+	}
+}
+
+func setupServe(c *cli, flags *flag.FlagSet) func() error {
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on, only the local host by default")
+	tokenFile := flags.String("token-file", "", "file holding the bearer token clients have to send, a random token is generated and printed without it")
+
+	return func() error {
+		var token string
+		if *tokenFile != "" {
+			tokenBytes, err := os.ReadFile(*tokenFile)
+			if err != nil {
+				return &usageError{message: err.Error()}
+			}
+			token = strings.TrimSpace(string(tokenBytes))
+			if token == "" {
+				return newUsageError("the token file %s is empty", *tokenFile)
+			}
+		}
+
+		contract, err := c.open()
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return serveAPI(ctx, *addr, contract, token, c.stderr)
+	}
+}
+
+// verifySnapshot compares a snapshot with the ledger and fails if any record drifted.
+func (c *cli) verifySnapshot(path string) error {
+	snapshotFile, err := os.Open(path)
+	if err != nil {
+		return &usageError{message: err.Error()}
+	}
+	defer snapshotFile.Close()
+
+	header, snapshot, err := readSnapshot(snapshotFile)
+	if err != nil {
+		return &usageError{message: fmt.Sprintf("the snapshot %s is not valid: %v", path, err)}
+	}
+
+	contract, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	height, err := contract.BlockHeight()
+	if err != nil {
+		return newTransactionError("evaluate", "GetChainInfo", err)
+	}
+
+	records, err := readLedgerRecords(contract)
+	if err != nil {
+		return err
+	}
+
+	drift, err := compareSnapshot(snapshot, records)
+	if err != nil {
+		return err
+	}
+
+	err = c.printVerification(snapshotVerification{SnapshotBlockHeight: header.BlockHeight, BlockHeight: height, Records: len(snapshot), Drift: drift})
+	if err != nil {
+		return err
+	}
+	if len(drift) > 0 {
+		return fmt.Errorf("%d %s drifted since the snapshot at block height %d", len(drift), pluralize(len(drift), "record", "records"), header.BlockHeight)
+	}
+
+	return nil
+}
+
+func (c *cli) evaluate(transactionName string, args ...string) error {
+	contract, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	evaluateResult, err := contract.EvaluateTransaction(transactionName, args...)
+	if err != nil {
+		return newTransactionError("evaluate", transactionName, err)
+	}
+
+	return c.printResult(evaluateResult)
+}
+
+func (c *cli) submit(transactionName string, args ...string) error {
+	contract, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	if c.options.async {
+		submitted, err := contract.SubmitAsync(transactionName, args...)
+		if err != nil {
+			return newTransactionError("submit", transactionName, err)
+		}
+
+		return c.printSubmitted(submitted)
+	}
+
+	submitResult, err := contract.SubmitTransaction(transactionName, args...)
+	if err != nil {
+		return newTransactionError("submit", transactionName, err)
+	}
+
+	return c.printCommitted(submitResult)
+}
+
+// submitWithTransient submits a transaction with transient data and waits for it to be
+// committed. Transactions with transient data cannot be submitted with --async.
+func (c *cli) submitWithTransient(transactionName string, transient map[string][]byte, args ...string) error {
+	if c.options.async {
+		return newUsageError("%s cannot be submitted with --async", transactionName)
+	}
+
+	contract, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	submitResult, err := contract.SubmitWithTransient(transactionName, transient, args...)
+	if err != nil {
+		return newTransactionError("submit", transactionName, err)
+	}
+
+	return c.printCommitted(submitResult)
+}
+
+// submitWithDetails submits the transaction with the private details read from
+// detailsPath as transient data, or as usual when no file is given.
+func (c *cli) submitWithDetails(transactionName string, detailsPath string, args ...string) error {
+	if detailsPath == "" {
+		return c.submit(transactionName, args...)
+	}
+
+	details, err := readPersonDetailsFile(detailsPath)
+	if err != nil {
+		return &usageError{message: err.Error()}
+	}
+
+	return c.submitWithTransient(transactionName, map[string][]byte{transientPersonDetailsKey: details}, args...)
+}
+
+// evaluateAllPages evaluates a paginated query page by page, passing the page size and
+// bookmark after args, and prints the records of all pages together.
+func (c *cli) evaluateAllPages(transactionName string, args ...string) error {
+	contract, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	records := []json.RawMessage{}
+	bookmark := ""
+	for {
+		pageArgs := append(append([]string{}, args...), strconv.Itoa(pageSize), bookmark)
+		evaluateResult, err := contract.EvaluateTransaction(transactionName, pageArgs...)
+		if err != nil {
+			return newTransactionError("evaluate", transactionName, err)
+		}
+
+		var page struct {
+			Records             []json.RawMessage `json:"records"`
+			FetchedRecordsCount int32             `json:"fetchedRecordsCount"`
+			Bookmark            string            `json:"bookmark"`
+		}
+		err = json.Unmarshal(evaluateResult, &page)
+		if err != nil {
+			return fmt.Errorf("failed to parse page: %w", err)
+		}
+
+		records = append(records, page.Records...)
+		if page.FetchedRecordsCount < pageSize || page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	recordsJSON, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return c.printResult(recordsJSON)
+}
+
+// listenForEvents prints chaincode events until the application is interrupted, and
+// then the block to resume from, like option 25 of the shell.
+func (c *cli) listenForEvents(options []client.ChaincodeEventsOption) error {
+	_, err := c.open()
+	if err != nil {
+		return err
+	}
+	if c.connection == nil {
+		return &setupError{err: fmt.Errorf("listening for events needs a connection to the network")}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	events, err := c.connection.network.ChaincodeEvents(ctx, c.appConfig.ChaincodeName, options...)
+	if err != nil {
+		return fmt.Errorf("failed to start chaincode event listening: %w", err)
+	}
+
+	fmt.Fprintln(c.stderr, "Listening for chaincode events, press Ctrl+C to stop")
+
+	var lastBlock uint64
+	received := false
+	for event := range events {
+		lastBlock = event.BlockNumber
+		received = true
+
+		err = c.printEvent(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	if received {
+		fmt.Fprintf(c.stderr, "Stopped listening, resume from block %d to continue; the events of that block are received again\n", lastBlock)
+	} else {
+		fmt.Fprintln(c.stderr, "Stopped listening, no events were received")
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"google.golang.org/grpc/codes"
+)
+
+// The states of a submitted transaction.
+const (
+	statusPending   = "pending"
+	statusCommitted = "committed"
+	// statusInvalid means the transaction is in a block but was marked invalid, e.g.
+	// because of an MVCC read conflict, so it did not change the ledger.
+	statusInvalid = "invalid"
+	// statusFailed means the commit status could not be obtained.
+	statusFailed = "failed"
+)
+
+const (
+	// commitStatusTimeout limits how long the commit status of a submitted transaction is
+	// waited for in the background.
+	commitStatusTimeout = 2 * time.Minute
+	// statusLookupTimeout limits how long a transaction that was not submitted in this
+	// session is waited for.
+	statusLookupTimeout = 5 * time.Second
+)
+
+// submittedTransaction is a transaction that has been sent to the orderer but may not
+// have been committed yet.
+type submittedTransaction struct {
+	transactionID string
+	result        []byte
+	// status waits until the transaction is committed and returns its commit status.
+	status func(ctx context.Context) (*client.Status, error)
+}
+
+// gatewayContract adds the operations of the application that are not plain calls of
+// client.Contract methods.
+type gatewayContract struct {
+	*client.Contract
+	gateway     *client.Gateway
+	channelName string
+}
+
+// SubmitWithTransient submits a transaction with transient data, which the endorsing
+// peers see but which is not recorded on the ledger.
+func (c *gatewayContract) SubmitWithTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return c.Contract.Submit(name, client.WithArguments(args...), client.WithTransient(transient))
+}
+
+// SubmitAsync sends the transaction to the orderer and returns as soon as the orderer
+// accepted it, like transferAssetAsync in the asset-transfer-basic application.
+func (c *gatewayContract) SubmitAsync(name string, args ...string) (*submittedTransaction, error) {
+	result, commit, err := c.Contract.SubmitAsync(name, client.WithArguments(args...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &submittedTransaction{transactionID: commit.TransactionID(), result: result, status: commit.StatusWithContext}, nil
+}
+
+// CommitStatus waits for the commit status of any transaction on the channel, including
+// those submitted by other runs of the application. It builds the same commit status
+// request the Gateway client builds for the transactions it submits itself.
+func (c *gatewayContract) CommitStatus(ctx context.Context, transactionID string) (*client.Status, error) {
+	id := c.gateway.Identity()
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: id.MspID(), IdBytes: id.Credentials()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize identity: %w", err)
+	}
+
+	request, err := proto.Marshal(&gateway.CommitStatusRequest{ChannelId: c.channelName, TransactionId: transactionID, Identity: creator})
+	if err != nil {
+		return nil, err
+	}
+	signedRequest, err := proto.Marshal(&gateway.SignedCommitStatusRequest{Request: request})
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a signature the request is signed with the identity of the gateway.
+	commit, err := c.gateway.NewSignedCommit(signedRequest, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.StatusWithContext(ctx)
+}
+
+// BlockHeight returns the number of blocks on the channel, as reported by the query
+// system chaincode of the gateway peer.
+func (c *gatewayContract) BlockHeight() (uint64, error) {
+	qscc := c.gateway.GetNetwork(c.channelName).GetContract("qscc")
+	chainInfoBytes, err := qscc.EvaluateTransaction("GetChainInfo", c.channelName)
+	if err != nil {
+		return 0, err
+	}
+
+	var chainInfo common.BlockchainInfo
+	err = proto.Unmarshal(chainInfoBytes, &chainInfo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the chain info: %w", err)
+	}
+
+	return chainInfo.Height, nil
+}
+
+// transactionStatus is what the status command shows about a transaction.
+type transactionStatus struct {
+	TransactionID string
+	Transaction   string `json:",omitempty"`
+	Status        string
+	// Code is the validation code of the transaction, e.g. MVCC_READ_CONFLICT.
+	Code        string `json:",omitempty"`
+	BlockNumber uint64 `json:",omitempty"`
+	Error       string `json:",omitempty"`
+}
+
+// newTransactionStatus describes the result of waiting for a commit status. Waiting
+// until the context is done leaves the transaction pending: it has not been committed
+// yet, or it is not known to the network at all.
+func newTransactionStatus(transactionID string, status *client.Status, err error) transactionStatus {
+	switch {
+	case err == nil && status.Successful:
+		return transactionStatus{TransactionID: transactionID, Status: statusCommitted, Code: status.Code.String(), BlockNumber: status.BlockNumber}
+	case err == nil:
+		return transactionStatus{TransactionID: transactionID, Status: statusInvalid, Code: status.Code.String(), BlockNumber: status.BlockNumber}
+	case errors.Is(err, context.DeadlineExceeded) || grpcCode(err) == codes.DeadlineExceeded:
+		return transactionStatus{TransactionID: transactionID, Status: statusPending}
+	default:
+		return transactionStatus{TransactionID: transactionID, Status: statusFailed, Error: describeFailure(err)}
+	}
+}
+
+// commitTracker waits for the commit status of submitted transactions in the
+// background, so that the user can carry on and check on them later.
+type commitTracker struct {
+	mutex        sync.Mutex
+	transactions map[string]*transactionStatus
+	// order holds the IDs of the transactions in the order they were submitted.
+	order   []string
+	pending sync.WaitGroup
+	// timeout limits how long the tracker waits for a commit status, after which the
+	// transaction is left pending.
+	timeout time.Duration
+	// notify is called when the commit status of a transaction is known.
+	notify func(transactionStatus)
+}
+
+func newCommitTracker(timeout time.Duration, notify func(transactionStatus)) *commitTracker {
+	return &commitTracker{transactions: map[string]*transactionStatus{}, timeout: timeout, notify: notify}
+}
+
+// track waits for the commit status of the transaction in the background.
+func (t *commitTracker) track(transactionName string, submitted *submittedTransaction) {
+	t.mutex.Lock()
+	t.transactions[submitted.transactionID] = &transactionStatus{TransactionID: submitted.transactionID, Transaction: transactionName, Status: statusPending}
+	t.order = append(t.order, submitted.transactionID)
+	t.mutex.Unlock()
+
+	t.pending.Add(1)
+	go func() {
+		defer t.pending.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		defer cancel()
+
+		status, err := submitted.status(ctx)
+		result := newTransactionStatus(submitted.transactionID, status, err)
+		result.Transaction = transactionName
+
+		t.mutex.Lock()
+		t.transactions[submitted.transactionID] = &result
+		t.mutex.Unlock()
+
+		if t.notify != nil {
+			t.notify(result)
+		}
+	}()
+}
+
+// get returns the status of a tracked transaction.
+func (t *commitTracker) get(transactionID string) (transactionStatus, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	status, ok := t.transactions[transactionID]
+	if !ok {
+		return transactionStatus{}, false
+	}
+
+	return *status, true
+}
+
+// list returns the status of all tracked transactions in the order they were submitted.
+func (t *commitTracker) list() []transactionStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	statuses := make([]transactionStatus, len(t.order))
+	for i, transactionID := range t.order {
+		statuses[i] = *t.transactions[transactionID]
+	}
+
+	return statuses
+}
+
+// countPending returns the number of transactions whose commit status is not known yet.
+func (t *commitTracker) countPending() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	count := 0
+	for _, status := range t.transactions {
+		if status.Status == statusPending {
+			count++
+		}
+	}
+
+	return count
+}
+
+// wait waits until the commit status of all tracked transactions is known.
+func (t *commitTracker) wait() {
+	t.pending.Wait()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCommitTracker(t *testing.T) {
+	contract := newStubContract()
+	contract.statuses["tx1"] = &client.Status{TransactionID: "tx1", Code: peer.TxValidationCode_VALID, Successful: true, BlockNumber: 7}
+	contract.statuses["tx2"] = &client.Status{TransactionID: "tx2", Code: peer.TxValidationCode_MVCC_READ_CONFLICT, BlockNumber: 8}
+
+	notified := make(chan transactionStatus, 3)
+	tracker := newCommitTracker(50*time.Millisecond, func(status transactionStatus) {
+		notified <- status
+	})
+
+	for _, carID := range []string{"car1", "car2", "car3"} {
+		submitted, err := contract.SubmitAsync("TransferCarAsset", carID, "person2", "true")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracker.track("TransferCarAsset", submitted)
+	}
+	tracker.wait()
+
+	if len(notified) != 3 {
+		t.Errorf("expected 3 notifications, got %d", len(notified))
+	}
+	if pending := tracker.countPending(); pending != 1 {
+		t.Errorf("expected the transaction without a commit status to stay pending, got %d pending", pending)
+	}
+
+	want := []transactionStatus{
+		{TransactionID: "tx1", Transaction: "TransferCarAsset", Status: statusCommitted, Code: "VALID", BlockNumber: 7},
+		{TransactionID: "tx2", Transaction: "TransferCarAsset", Status: statusInvalid, Code: "MVCC_READ_CONFLICT", BlockNumber: 8},
+		{TransactionID: "tx3", Transaction: "TransferCarAsset", Status: statusPending},
+	}
+	for i, status := range tracker.list() {
+		if status != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], status)
+		}
+	}
+
+	if _, ok := tracker.get("tx4"); ok {
+		t.Error("expected tx4 not to be tracked")
+	}
+}
+
+func TestAsyncSubmitAndStatusCommand(t *testing.T) {
+	contract := newStubContract()
+
+	code, stdout, stderr := runWithContract(contract, "--async", "cars", "transfer", "--car", "car1", "--to", "person2")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if got, want := strings.Join(contract.calls, "; "), "submitAsync TransferCarAsset(car1, person2, false)"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if !strings.HasPrefix(stdout, "Transaction tx1 submitted") {
+		t.Errorf("unexpected output %q", stdout)
+	}
+
+	contract.statuses["tx1"] = &client.Status{TransactionID: "tx1", Code: peer.TxValidationCode_VALID, Successful: true, BlockNumber: 12}
+	code, stdout, stderr = runWithContract(contract, "--output", "json", "status", "--wait", "10ms", "tx1", "tx2")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	var statuses []transactionStatus
+	err := json.Unmarshal([]byte(stdout), &statuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []transactionStatus{
+		{TransactionID: "tx1", Status: statusCommitted, Code: "VALID", BlockNumber: 12},
+		{TransactionID: "tx2", Status: statusPending},
+	}
+	if len(statuses) != len(want) || statuses[0] != want[0] || statuses[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, statuses)
+	}
+
+	contract.errs["tx3"] = status.Error(codes.PermissionDenied, "access denied")
+	code, _, stderr = runWithContract(contract, "status", "tx3")
+	if code != exitTransactionFailed || !strings.Contains(stderr, "access denied") {
+		t.Errorf("expected exit code %d with the failure, got %d: %s", exitTransactionFailed, code, stderr)
+	}
+
+	code, _, _ = runWithContract(contract, "status")
+	if code != exitUsage {
+		t.Errorf("expected exit code %d without a transaction ID, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetryConfig configures how transactions that failed for a transient reason, such as
+// an MVCC read conflict or an unavailable peer, are retried.
+type RetryConfig struct {
+	// MaxAttempts is the number of times a transaction is tried, 1 disables retries.
+	MaxAttempts int `json:"maxAttempts"`
+	// InitialBackoff is the wait before the first retry, e.g. "500ms". The wait doubles
+	// with every retry, up to MaxBackoff.
+	InitialBackoff string `json:"initialBackoff"`
+	MaxBackoff     string `json:"maxBackoff"`
+}
+
+// defaultRetryConfig is used for config files without a retry section.
+var defaultRetryConfig = RetryConfig{MaxAttempts: 3, InitialBackoff: "500ms", MaxBackoff: "5s"}
+
+// validate reports all problems of the config file at once, so that they can be fixed
+// in one go.
+func (c *AppConfig) validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.ChannelName == "" {
+		addProblem("channelName is required")
+	}
+	if c.ChaincodeName == "" {
+		addProblem("chaincodeName is required")
+	}
+	if len(c.Orgs) == 0 {
+		addProblem("orgs must configure at least one organization")
+	}
+
+	var orgs []string
+	for org := range c.Orgs {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+
+	for _, org := range orgs {
+		orgConfig := c.Orgs[org]
+		prefix := "orgs." + org
+
+		for _, field := range []struct{ name, value string }{
+			{"mspID", orgConfig.MspID},
+			{"tlsCertPath", orgConfig.TlsCertPath},
+			{"peerEndpoint", orgConfig.PeerEndpoint},
+			{"gatewayPeer", orgConfig.GatewayPeer},
+		} {
+			if field.value == "" {
+				addProblem("%s.%s is required", prefix, field.name)
+			}
+		}
+		if orgConfig.PeerEndpoint != "" {
+			if _, _, err := net.SplitHostPort(orgConfig.PeerEndpoint); err != nil {
+				addProblem("%s.peerEndpoint must be a host and port such as localhost:7051, not %q", prefix, orgConfig.PeerEndpoint)
+			}
+		}
+
+		if len(orgConfig.Identities) == 0 {
+			if orgConfig.CertPath == "" || orgConfig.KeyPath == "" {
+				addProblem("%s needs identities, or a certPath and keyPath", prefix)
+			}
+			continue
+		}
+		if orgConfig.CertPath != "" || orgConfig.KeyPath != "" {
+			addProblem("%s.certPath and keyPath are ignored when identities are given, move them to an identity", prefix)
+		}
+
+		var names []string
+		for name := range orgConfig.Identities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			identityConfig := orgConfig.Identities[name]
+			if name == "" || strings.Contains(name, "/") {
+				addProblem("%s.identities has the invalid name %q, names must not be empty or contain /", prefix, name)
+			}
+			if identityConfig.CertPath == "" {
+				addProblem("%s.identities.%s.certPath is required", prefix, name)
+			}
+			if identityConfig.KeyPath == "" {
+				addProblem("%s.identities.%s.keyPath is required", prefix, name)
+			}
+		}
+		if _, ok := orgConfig.Identities[orgConfig.DefaultIdentity]; orgConfig.DefaultIdentity != "" && !ok {
+			addProblem("%s.defaultIdentity %s is not one of its identities: %s", prefix, orgConfig.DefaultIdentity, strings.Join(names, ", "))
+		}
+	}
+
+	if c.Retry != nil {
+		if c.Retry.MaxAttempts < 1 {
+			addProblem("retry.maxAttempts must be at least 1, not %d", c.Retry.MaxAttempts)
+		}
+
+		initialBackoff, err := parseBackoff(c.Retry.InitialBackoff)
+		if err != nil {
+			addProblem("retry.initialBackoff %v", err)
+		}
+		maxBackoff, err := parseBackoff(c.Retry.MaxBackoff)
+		if err != nil {
+			addProblem("retry.maxBackoff %v", err)
+		}
+		if initialBackoff > maxBackoff && maxBackoff > 0 {
+			addProblem("retry.initialBackoff must not be longer than retry.maxBackoff")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d %s:\n  - %s", len(problems), pluralize(len(problems), "problem", "problems"), strings.Join(problems, "\n  - "))
+	}
+
+	return nil
+}
+
+// retryPolicy returns the retry policy of the config file, or the default one.
+func (c *AppConfig) retryPolicy() retryPolicy {
+	retryConfig := defaultRetryConfig
+	if c.Retry != nil {
+		retryConfig = *c.Retry
+	}
+
+	// The durations have been validated when the config file was loaded.
+	initialBackoff, _ := parseBackoff(retryConfig.InitialBackoff)
+	maxBackoff, _ := parseBackoff(retryConfig.MaxBackoff)
+
+	return retryPolicy{maxAttempts: retryConfig.MaxAttempts, initialBackoff: initialBackoff, maxBackoff: maxBackoff}
+}
+
+func parseBackoff(value string) (time.Duration, error) {
+	backoff, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("must be a duration such as 500ms or 2s, not %q", value)
+	}
+	if backoff <= 0 {
+		return 0, fmt.Errorf("must be positive, not %s", value)
+	}
+
+	return backoff, nil
+}
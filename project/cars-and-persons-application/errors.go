@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// transactionError is a failed evaluation or submission of a transaction. Its message
+// tells in which step the transaction failed, as exampleErrorHandling in the
+// asset-transfer-basic application does, followed by the errors the peers and orderers
+// reported.
+type transactionError struct {
+	action          string
+	transactionName string
+	err             error
+}
+
+func newTransactionError(action string, transactionName string, err error) error {
+	return &transactionError{action: action, transactionName: transactionName, err: err}
+}
+
+func (e *transactionError) Error() string {
+	message := fmt.Sprintf("failed to %s transaction %s: %s", e.action, e.transactionName, describeFailure(e.err))
+	for _, detail := range errorDetails(e.err) {
+		message += fmt.Sprintf("\n  %s (%s): %s", detail.Address, detail.MspId, detail.Message)
+	}
+
+	return message
+}
+
+func (e *transactionError) Unwrap() error {
+	return e.err
+}
+
+// describeFailure describes in which step a transaction failed.
+func describeFailure(err error) string {
+	var endorseErr *client.EndorseError
+	var submitErr *client.SubmitError
+	var commitStatusErr *client.CommitStatusError
+	var commitErr *client.CommitError
+
+	switch {
+	case errors.As(err, &endorseErr):
+		return fmt.Sprintf("endorsement of transaction %s failed with gRPC status %v: %s", endorseErr.TransactionID, grpcCode(err), grpcMessage(err))
+	case errors.As(err, &submitErr):
+		return fmt.Sprintf("submitting transaction %s to the orderer failed with gRPC status %v: %s", submitErr.TransactionID, grpcCode(err), grpcMessage(err))
+	case errors.As(err, &commitStatusErr):
+		if errors.Is(err, context.DeadlineExceeded) || grpcCode(err) == codes.DeadlineExceeded {
+			return fmt.Sprintf("timed out waiting for the commit status of transaction %s, it may still be committed", commitStatusErr.TransactionID)
+		}
+		return fmt.Sprintf("obtaining the commit status of transaction %s failed with gRPC status %v: %s", commitStatusErr.TransactionID, grpcCode(err), grpcMessage(err))
+	case errors.As(err, &commitErr):
+		return fmt.Sprintf("transaction %s was not committed, it was marked invalid with status %s (%d)", commitErr.TransactionID, commitErr.Code, int32(commitErr.Code))
+	}
+
+	if code := grpcCode(err); code != codes.Unknown {
+		return fmt.Sprintf("gRPC status %v: %s", code, grpcMessage(err))
+	}
+
+	return err.Error()
+}
+
+// grpcStatus returns the gRPC status of an error, which the errors of the Gateway client
+// carry, or nil for other errors.
+func grpcStatus(err error) *status.Status {
+	var statusErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &statusErr) {
+		return statusErr.GRPCStatus()
+	}
+
+	return nil
+}
+
+func grpcCode(err error) codes.Code {
+	if grpcStatus := grpcStatus(err); grpcStatus != nil {
+		return grpcStatus.Code()
+	}
+
+	return codes.Unknown
+}
+
+func grpcMessage(err error) string {
+	if grpcStatus := grpcStatus(err); grpcStatus != nil {
+		return grpcStatus.Message()
+	}
+
+	return err.Error()
+}
+
+// errorDetails returns the errors the peers and orderers outside the gateway reported,
+// which are embedded in the gRPC status.
+func errorDetails(err error) []*gateway.ErrorDetail {
+	grpcStatus := grpcStatus(err)
+	if grpcStatus == nil {
+		return nil
+	}
+
+	var details []*gateway.ErrorDetail
+	for _, detail := range grpcStatus.Details() {
+		if errorDetail, ok := detail.(*gateway.ErrorDetail); ok {
+			details = append(details, errorDetail)
+		}
+	}
+
+	return details
+}
+
+// failureReason returns the error the chaincode reported for a failed transaction, or
+// in which step the transaction failed when it did not get to the chaincode.
+func failureReason(err error) string {
+	if details := errorDetails(err); len(details) > 0 {
+		return chaincodeMessage(details[0].Message)
+	}
+
+	return describeFailure(err)
+}
+
+// chaincodeMessage strips the prefix peers add to the error messages of the chaincode,
+// e.g. "chaincode response 500, the car asset car9 does not exist".
+func chaincodeMessage(message string) string {
+	const prefix = "chaincode response 500, "
+	if i := strings.Index(message, prefix); i >= 0 {
+		return message[i+len(prefix):]
+	}
+
+	return message
+}
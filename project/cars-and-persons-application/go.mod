@@ -3,13 +3,13 @@ module cars-and-persons-application
 go 1.17
 
 require (
+	github.com/golang/protobuf v1.5.2
 	github.com/hyperledger/fabric-gateway v1.0.1
+	github.com/hyperledger/fabric-protos-go v0.0.0-20211118165945-23d738fc3553
 	google.golang.org/grpc v1.44.0
 )
 
 require (
-	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/hyperledger/fabric-protos-go v0.0.0-20211118165945-23d738fc3553 // indirect
 	github.com/miekg/pkcs11 v1.1.1 // indirect
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxImportBatchRecords is the most records the BulkImport transaction accepts.
+	maxImportBatchRecords = 500
+	// maxImportBatchBytes bounds the JSON of a batch, so that a batch of large records
+	// stays well below the message size limits of the gateway and the orderer.
+	maxImportBatchBytes = 256 << 10
+)
+
+// privateImportFields are the fields of person records that BulkImport takes from the
+// transient data, so that they are not recorded on the ledger. Persons without a salt
+// get a random one.
+var privateImportFields = []string{"emailAddress", "amountOfMoneyOwned", "salt"}
+
+// importRecord is a record of an import file, passed to BulkImport as it is, except for
+// the private fields of persons. Type and ID are only read to report the records of a
+// batch that failed as a whole, and to pass the private fields by person ID.
+type importRecord struct {
+	// number is the position of the record in the file, starting with 1.
+	number int
+	json   json.RawMessage
+	// private holds the private fields of a person record.
+	private json.RawMessage
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+}
+
+// separatePrivateFields moves the private fields of a person record from json to private.
+func (r *importRecord) separatePrivateFields() error {
+	var fields map[string]json.RawMessage
+	if r.Type != "person" || json.Unmarshal(r.json, &fields) != nil {
+		return nil
+	}
+
+	private := map[string]json.RawMessage{}
+	for _, name := range privateImportFields {
+		if value, ok := fields[name]; ok {
+			private[name] = value
+			delete(fields, name)
+		}
+	}
+	if len(private) == 0 {
+		return nil
+	}
+	if _, ok := private["salt"]; !ok {
+		salt, err := newSalt()
+		if err != nil {
+			return err
+		}
+		private["salt"], _ = json.Marshal(salt)
+	}
+
+	var err error
+	r.json, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	r.private, err = json.Marshal(private)
+
+	return err
+}
+
+// size is the number of bytes the record adds to a batch.
+func (r *importRecord) size() int {
+	return len(r.json) + len(r.private) + 1
+}
+
+// importFailure is a record that was not imported, as reported by the import command.
+type importFailure struct {
+	Record int
+	Type   string
+	ID     string
+	Reason string
+}
+
+// importReport is the result of the import command.
+type importReport struct {
+	Records  int
+	Batches  int
+	Imported int
+	Failures []importFailure
+}
+
+// readImportFile reads the records of a JSON file, holding an array of records, or of
+// a CSV file, whose header names the fields of the records, e.g.
+// type,id,brand,model,year,color,ownerID,price,vin,registrationPlate,odometer. Empty CSV
+// cells are left out.
+func readImportFile(path string) ([]importRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []importRecord
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		records, err = readJSONRecords(file)
+	case ".csv":
+		records, err = readCSVRecords(file)
+	default:
+		return nil, fmt.Errorf("the import file %s must be a .csv or .json file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the import file %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the import file %s has no records", path)
+	}
+
+	for i := range records {
+		err = records[i].separatePrivateFields()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+func readJSONRecords(r io.Reader) ([]importRecord, error) {
+	var rawRecords []json.RawMessage
+	err := json.NewDecoder(r).Decode(&rawRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]importRecord, len(rawRecords))
+	for i, rawRecord := range rawRecords {
+		records[i] = importRecord{number: i + 1, json: rawRecord}
+		// A record that is not an object is passed on for the chaincode to reject.
+		_ = json.Unmarshal(rawRecord, &records[i])
+	}
+
+	return records, nil
+}
+
+func readCSVRecords(r io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []importRecord
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fields := map[string]interface{}{}
+		for i, value := range row {
+			switch {
+			case value == "":
+			case header[i] == "year" || header[i] == "odometer":
+				// The year and the odometer reading are the only numbers of a record.
+				number, err := strconv.Atoi(value)
+				if err != nil {
+					line, _ := reader.FieldPos(i)
+					return nil, fmt.Errorf("line %d: the %s %q is not a number", line, header[i], value)
+				}
+				fields[header[i]] = number
+			default:
+				fields[header[i]] = value
+			}
+		}
+
+		recordJSON, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+
+		record := importRecord{number: len(records) + 1, json: recordJSON}
+		record.Type, _ = fields["type"].(string)
+		record.ID, _ = fields["id"].(string)
+		records = append(records, record)
+	}
+}
+
+// importBatches splits the records into batches of at most batchSize records and
+// maxImportBatchBytes of JSON, counting the private fields.
+func importBatches(records []importRecord, batchSize int) [][]importRecord {
+	var batches [][]importRecord
+	var batch []importRecord
+	batchBytes := 0
+	for _, record := range records {
+		if len(batch) > 0 && (len(batch) == batchSize || batchBytes+record.size() > maxImportBatchBytes) {
+			batches = append(batches, batch)
+			batch, batchBytes = nil, 0
+		}
+		batch = append(batch, record)
+		batchBytes += record.size()
+	}
+
+	return append(batches, batch)
+}
+
+// importRecords submits the records to BulkImport batch by batch. A batch that fails as
+// a whole, e.g. because its endorsement failed or an all-or-nothing batch had an invalid
+// record, is reported with each of its records, and the remaining batches are still
+// submitted.
+func importRecords(contract contractInvoker, records []importRecord, batchSize int, allOrNothing bool, progress func(batch int, batches int)) importReport {
+	batches := importBatches(records, batchSize)
+	report := importReport{Records: len(records), Batches: len(batches), Failures: []importFailure{}}
+
+	for i, batch := range batches {
+		progress(i+1, len(batches))
+
+		rawRecords := make([]json.RawMessage, len(batch))
+		importedPersons := map[string]json.RawMessage{}
+		for j, record := range batch {
+			rawRecords[j] = record.json
+			if record.private != nil {
+				importedPersons[record.ID] = record.private
+			}
+		}
+		batchJSON, _ := json.Marshal(rawRecords)
+
+		var submitResult []byte
+		var err error
+		if len(importedPersons) == 0 {
+			submitResult, err = contract.SubmitTransaction("BulkImport", string(batchJSON), strconv.FormatBool(allOrNothing))
+		} else {
+			importedPersonsJSON, _ := json.Marshal(importedPersons)
+			submitResult, err = contract.SubmitWithTransient("BulkImport", map[string][]byte{transientImportedPersonsKey: importedPersonsJSON}, string(batchJSON), strconv.FormatBool(allOrNothing))
+		}
+		if err != nil {
+			reason := failureReason(err)
+			for _, record := range batch {
+				report.Failures = append(report.Failures, importFailure{Record: record.number, Type: record.Type, ID: record.ID, Reason: reason})
+			}
+			continue
+		}
+
+		var result struct {
+			CarIDs    []string `json:"carIDs"`
+			PersonIDs []string `json:"personIDs"`
+			Failures  []struct {
+				Index  int    `json:"index"`
+				Reason string `json:"reason"`
+			} `json:"failures"`
+		}
+		err = json.Unmarshal(submitResult, &result)
+		if err != nil {
+			for _, record := range batch {
+				report.Failures = append(report.Failures, importFailure{Record: record.number, Type: record.Type, ID: record.ID, Reason: "the result of the batch could not be read: " + err.Error()})
+			}
+			continue
+		}
+
+		report.Imported += len(result.CarIDs) + len(result.PersonIDs)
+		for _, failure := range result.Failures {
+			if failure.Index < 0 || failure.Index >= len(batch) {
+				continue
+			}
+			record := batch[failure.Index]
+			report.Failures = append(report.Failures, importFailure{Record: record.number, Type: record.Type, ID: record.ID, Reason: failure.Reason})
+		}
+	}
+
+	return report
+}
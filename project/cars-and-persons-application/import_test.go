@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestImportCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "import.csv")
+	writeFile(t, file, "type,id,firstName,lastName,emailAddress,amountOfMoneyOwned,brand,model,year,color,ownerID,price,vin,odometer\n"+
+		"person,person4,Ana,Anic,ana@pdasp.rs,1000.00 EUR,,,,,,,,\n"+
+		"car,car7,,,,,Fiat,Punto,2010,red,person4,1500.00 EUR,ZFA19900300A12345,98000\n"+
+		"car,car1,,,,,Opel,Astra,2012,blue,person1,2000.00 EUR,,\n")
+
+	contract := newStubContract()
+	contract.results["BulkImport"] = [][]byte{
+		[]byte(`{"carIDs":["car7"],"personIDs":["person4"],"failures":[]}`),
+		[]byte(`{"carIDs":[],"personIDs":[],"failures":[{"index":0,"type":"car","id":"car1","reason":"the car asset car1 already exists"}]}`),
+	}
+
+	code, stdout, stderr := runWithContract(contract, "import", "--file", file, "--batch-size", "2", "--all-or-nothing")
+	if code != exitTransactionFailed {
+		t.Fatalf("expected exit code %d, got %d: %s", exitTransactionFailed, code, stderr)
+	}
+	if !strings.Contains(stderr, "1 of the 3 records were not imported") {
+		t.Errorf("unexpected errors %q", stderr)
+	}
+
+	wantCalls := []string{
+		`submit BulkImport([{"firstName":"Ana","id":"person4","lastName":"Anic","type":"person"},` +
+			`{"brand":"Fiat","color":"red","id":"car7","model":"Punto","odometer":98000,"ownerID":"person4","price":"1500.00 EUR","type":"car","vin":"ZFA19900300A12345","year":2010}], true, ` +
+			`importedPersons={"person4":{"amountOfMoneyOwned":"1000.00 EUR","emailAddress":"ana@pdasp.rs","salt":"<salt>"}})`,
+		`submit BulkImport([{"brand":"Opel","color":"blue","id":"car1","model":"Astra","ownerID":"person1","price":"2000.00 EUR","type":"car","year":2012}], true)`,
+	}
+	// Persons without a salt in the file get a random one.
+	got := regexp.MustCompile(`"salt":"[0-9a-f]{32}"`).ReplaceAllString(strings.Join(contract.calls, "\n"), `"salt":"<salt>"`)
+	if want := strings.Join(wantCalls, "\n"); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 4 || lines[0] != "Imported 2 of 3 records in 2 batches" || strings.Join(strings.Fields(lines[3]), " ") != "3 car car1 the car asset car1 already exists" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+}
+
+func TestImportFailedBatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "import.json")
+	writeFile(t, file, `[{"type":"person","id":"person4"},{"type":"car","id":"car7"}]`)
+
+	contract := newStubContract()
+	contract.errs["BulkImport"] = errors.New("the client is not authorized to import")
+
+	code, stdout, _ := runWithContract(contract, "--output", "json", "import", "--file", file)
+	if code != exitTransactionFailed {
+		t.Fatalf("expected exit code %d, got %d", exitTransactionFailed, code)
+	}
+
+	var report importReport
+	err := json.Unmarshal([]byte(stdout), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 0 || len(report.Failures) != 2 || report.Failures[1] != (importFailure{Record: 2, Type: "car", ID: "car7", Reason: "the client is not authorized to import"}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	for _, args := range [][]string{
+		{"import", "--file", filepath.Join(t.TempDir(), "import.xml")},
+		{"import", "--file", file, "--batch-size", "501"},
+	} {
+		code, _, _ = runWithContract(contract, args...)
+		if code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestImportBatches(t *testing.T) {
+	largeRecord := importRecord{json: json.RawMessage(`"` + strings.Repeat("x", maxImportBatchBytes/2) + `"`)}
+	smallRecord := importRecord{json: json.RawMessage(`{}`)}
+
+	var sizes []int
+	for _, batch := range importBatches([]importRecord{smallRecord, smallRecord, smallRecord, largeRecord, largeRecord, smallRecord}, 3) {
+		sizes = append(sizes, len(batch))
+	}
+	if got, want := sizes, []int{3, 1, 2}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("expected batches of %v records, got %v", want, got)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// printResult writes the result of a transaction in the selected output format.
+func (c *cli) printResult(result []byte) error {
+	if c.options.output == outputJSON {
+		return writeJSON(c.stdout, result)
+	}
+
+	return writeTable(c.stdout, result)
+}
+
+// printValue writes a value of the application itself, rather than a transaction
+// result, in the selected output format.
+func (c *cli) printValue(value interface{}) error {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return c.printResult(valueJSON)
+}
+
+// printCommitted writes the result of a submitted transaction, or a confirmation for
+// transactions that do not return anything.
+func (c *cli) printCommitted(result []byte) error {
+	if len(bytes.TrimSpace(result)) > 0 {
+		return c.printResult(result)
+	}
+
+	if c.options.output == outputJSON {
+		return writeJSON(c.stdout, []byte(`{"status":"committed"}`))
+	}
+
+	_, err := fmt.Fprintln(c.stdout, "Transaction committed successfully")
+	return err
+}
+
+// printSubmitted writes the ID of a transaction that was submitted with --async, so that
+// its commit status can be checked with the status command.
+func (c *cli) printSubmitted(submitted *submittedTransaction) error {
+	if c.options.output == outputJSON {
+		return c.printValue(transactionStatus{TransactionID: submitted.transactionID, Status: statusPending})
+	}
+
+	_, err := fmt.Fprintf(c.stdout, "Transaction %s submitted, run \"%s status %s\" for its commit status\n", submitted.transactionID, appName, submitted.transactionID)
+	return err
+}
+
+// printImportReport writes the result of the import command. The table output is a
+// summary followed by a table of the records that failed.
+func (c *cli) printImportReport(report importReport) error {
+	if c.options.output == outputJSON {
+		return c.printValue(report)
+	}
+
+	fmt.Fprintf(c.stdout, "Imported %d of %d records in %d %s\n", report.Imported, report.Records, report.Batches, pluralize(report.Batches, "batch", "batches"))
+	if len(report.Failures) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout)
+	return c.printValue(report.Failures)
+}
+
+// printVerification writes the result of verifying a snapshot. The table output is a
+// summary followed by a table of the drifted records.
+func (c *cli) printVerification(verification snapshotVerification) error {
+	if c.options.output == outputJSON {
+		return c.printValue(verification)
+	}
+
+	fmt.Fprintf(c.stdout, "Compared %d records of the snapshot at block height %d with the ledger at block height %d: %d drifted\n",
+		verification.Records, verification.SnapshotBlockHeight, verification.BlockHeight, len(verification.Drift))
+	if len(verification.Drift) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout)
+	return c.printValue(verification.Drift)
+}
+
+// printEvent writes a chaincode event, as a JSON object on a single line in the JSON
+// output format, so that the events can be processed as JSON Lines.
+func (c *cli) printEvent(event *client.ChaincodeEvent) error {
+	payload := event.Payload
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(string(payload))
+	}
+
+	if c.options.output == outputJSON {
+		eventJSON, err := json.Marshal(struct {
+			BlockNumber   uint64          `json:"blockNumber"`
+			TransactionID string          `json:"transactionID"`
+			EventName     string          `json:"eventName"`
+			Payload       json.RawMessage `json:"payload"`
+		}{event.BlockNumber, event.TransactionID, event.EventName, payload})
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(c.stdout, string(eventJSON))
+		return err
+	}
+
+	_, err := fmt.Fprintf(c.stdout, "block %d\ttransaction %s\t%s\t%s\n", event.BlockNumber, event.TransactionID, event.EventName, formatCell(payload))
+	return err
+}
+
+// writeJSON writes an indented JSON result. Results that are not JSON, such as the
+// plain strings some transactions return, are written as JSON strings.
+func writeJSON(w io.Writer, result []byte) error {
+	result = bytes.TrimSpace(result)
+	if len(result) == 0 {
+		result = []byte("null")
+	}
+	if !json.Valid(result) {
+		result, _ = json.Marshal(string(result))
+	}
+
+	var prettyJSON bytes.Buffer
+	err := json.Indent(&prettyJSON, result, "", "  ")
+	if err != nil {
+		return err
+	}
+	prettyJSON.WriteByte('\n')
+
+	_, err = w.Write(prettyJSON.Bytes())
+	return err
+}
+
+// writeTable writes a list of objects as a table with a column per field, a single
+// object as a table of its fields and anything else as a line of text.
+func writeTable(w io.Writer, result []byte) error {
+	result = bytes.TrimSpace(result)
+	if len(result) == 0 {
+		return nil
+	}
+	if !json.Valid(result) {
+		_, err := fmt.Fprintln(w, string(result))
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	switch result[0] {
+	case '[':
+		var rows []json.RawMessage
+		err := json.Unmarshal(result, &rows)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			_, err = fmt.Fprintln(w, "No results")
+			return err
+		}
+		if !isObject(rows[0]) {
+			for _, row := range rows {
+				fmt.Fprintln(table, formatCell(row))
+			}
+			break
+		}
+
+		var columns []string
+		seen := map[string]bool{}
+		for _, row := range rows {
+			for _, key := range objectKeys(row) {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+
+		fmt.Fprintln(table, strings.Join(columns, "\t"))
+		for _, row := range rows {
+			var fields map[string]json.RawMessage
+			err := json.Unmarshal(row, &fields)
+			if err != nil {
+				return err
+			}
+
+			cells := make([]string, len(columns))
+			for i, column := range columns {
+				cells[i] = formatCell(fields[column])
+			}
+			fmt.Fprintln(table, strings.Join(cells, "\t"))
+		}
+
+	case '{':
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(result, &fields)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range objectKeys(result) {
+			fmt.Fprintf(table, "%s\t%s\n", key, formatCell(fields[key]))
+		}
+
+	default:
+		fmt.Fprintln(table, formatCell(result))
+	}
+
+	return table.Flush()
+}
+
+// formatCell formats a JSON value for a table cell. Money objects are written as
+// amounts, lists of values are joined, lists of objects are counted and other objects
+// are written as compact JSON.
+func formatCell(value json.RawMessage) string {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 || string(value) == "null" {
+		return ""
+	}
+
+	switch value[0] {
+	case '"':
+		var text string
+		if json.Unmarshal(value, &text) == nil {
+			return text
+		}
+
+	case '{':
+		if money, ok := formatMoney(value); ok {
+			return money
+		}
+
+	case '[':
+		var items []json.RawMessage
+		if json.Unmarshal(value, &items) == nil {
+			if len(items) > 0 && isObject(items[0]) {
+				return fmt.Sprintf("%d %s", len(items), pluralize(len(items), "item", "items"))
+			}
+
+			cells := make([]string, len(items))
+			for i, item := range items {
+				cells[i] = formatCell(item)
+			}
+			return strings.Join(cells, ", ")
+		}
+	}
+
+	var compactJSON bytes.Buffer
+	if json.Compact(&compactJSON, value) != nil {
+		return string(value)
+	}
+
+	return compactJSON.String()
+}
+
+// formatMoney formats the Money objects of the chaincode, which hold the amount in
+// minor units, e.g. {"Amount":250000,"Currency":"EUR"} as 2500.00 EUR.
+func formatMoney(value json.RawMessage) (string, bool) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(value, &fields) != nil || len(fields) != 2 {
+		return "", false
+	}
+
+	var money struct {
+		Amount   *int64
+		Currency string
+	}
+	if json.Unmarshal(value, &money) != nil || money.Amount == nil || money.Currency == "" {
+		return "", false
+	}
+
+	amount := *money.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, money.Currency), true
+}
+
+func isObject(value json.RawMessage) bool {
+	value = bytes.TrimSpace(value)
+	return len(value) > 0 && value[0] == '{'
+}
+
+// objectKeys returns the keys of a JSON object in the order they appear in.
+func objectKeys(object json.RawMessage) []string {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return keys
+		}
+		keys = append(keys, token.(string))
+
+		var value json.RawMessage
+		if decoder.Decode(&value) != nil {
+			return keys
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc/codes"
+)
+
+// retryPolicy is the parsed RetryConfig.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// backoff returns the wait before the given retry, starting with 1. The wait doubles with
+// every retry and is jittered, so that clients that conflicted on the same key do not
+// retry in lockstep.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < retry && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// retryingContract retries the transactions of a contract that failed for a transient
+// reason. Submitted transactions are only retried when they are known not to have been
+// applied: when the endorsement failed, so the transaction was never sent to the
+// orderer, or when it was committed as invalid because of a read conflict.
+type retryingContract struct {
+	contract contractInvoker
+	policy   retryPolicy
+	// sleep waits between the attempts and logf reports the retries, tests replace them.
+	sleep func(time.Duration)
+	logf  func(format string, args ...interface{})
+}
+
+func newRetryingContract(contract contractInvoker, policy retryPolicy) *retryingContract {
+	return &retryingContract{contract: contract, policy: policy, sleep: time.Sleep, logf: log.Printf}
+}
+
+func (c *retryingContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return c.retry(name, func() ([]byte, error) {
+		return c.contract.EvaluateTransaction(name, args...)
+	})
+}
+
+func (c *retryingContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	return c.retry(name, func() ([]byte, error) {
+		return c.contract.SubmitTransaction(name, args...)
+	})
+}
+
+func (c *retryingContract) SubmitWithTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return c.retry(name, func() ([]byte, error) {
+		return c.contract.SubmitWithTransient(name, transient, args...)
+	})
+}
+
+// SubmitAsync retries the transaction when it failed before it was sent to the orderer.
+// Read conflicts are not retried, since the transaction is not waited for.
+func (c *retryingContract) SubmitAsync(name string, args ...string) (*submittedTransaction, error) {
+	var submitted *submittedTransaction
+	_, err := c.retry(name, func() ([]byte, error) {
+		var err error
+		submitted, err = c.contract.SubmitAsync(name, args...)
+		return nil, err
+	})
+
+	return submitted, err
+}
+
+func (c *retryingContract) CommitStatus(ctx context.Context, transactionID string) (*client.Status, error) {
+	return c.contract.CommitStatus(ctx, transactionID)
+}
+
+func (c *retryingContract) BlockHeight() (uint64, error) {
+	var height uint64
+	_, err := c.retry("GetChainInfo", func() ([]byte, error) {
+		var err error
+		height, err = c.contract.BlockHeight()
+		return nil, err
+	})
+
+	return height, err
+}
+
+func (c *retryingContract) retry(name string, attempt func() ([]byte, error)) ([]byte, error) {
+	for i := 1; ; i++ {
+		result, err := attempt()
+		if err == nil || i >= c.policy.maxAttempts || !isTransient(err) {
+			return result, err
+		}
+
+		backoff := c.policy.backoff(i)
+		c.logf("Transaction %s failed (%s), retrying in %s, attempt %d of %d", name, describeFailure(err), backoff.Round(time.Millisecond), i+1, c.policy.maxAttempts)
+		c.sleep(backoff)
+	}
+}
+
+// isTransient reports whether a failed transaction can safely be tried again.
+func isTransient(err error) bool {
+	var submitErr *client.SubmitError
+	var commitStatusErr *client.CommitStatusError
+	var commitErr *client.CommitError
+
+	switch {
+	case errors.As(err, &commitErr):
+		return commitErr.Code == peer.TxValidationCode_MVCC_READ_CONFLICT || commitErr.Code == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+	case errors.As(err, &submitErr), errors.As(err, &commitStatusErr):
+		// The transaction may have reached the orderer, so trying it again could apply it twice.
+		return false
+	}
+
+	switch grpcCode(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
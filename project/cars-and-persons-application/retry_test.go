@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyContract fails with the given errors, one per attempt, before it succeeds.
+type flakyContract struct {
+	errs     []error
+	attempts int
+}
+
+func (c *flakyContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	return c.SubmitTransaction(name, args...)
+}
+
+func (c *flakyContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	c.attempts++
+	if c.attempts <= len(c.errs) {
+		return nil, c.errs[c.attempts-1]
+	}
+
+	return []byte("ok"), nil
+}
+
+func (c *flakyContract) SubmitWithTransient(name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return c.SubmitTransaction(name, args...)
+}
+
+func (c *flakyContract) SubmitAsync(name string, args ...string) (*submittedTransaction, error) {
+	result, err := c.SubmitTransaction(name, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &submittedTransaction{transactionID: "tx1", result: result}, nil
+}
+
+func (c *flakyContract) CommitStatus(ctx context.Context, transactionID string) (*client.Status, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *flakyContract) BlockHeight() (uint64, error) {
+	_, err := c.SubmitTransaction("GetChainInfo")
+	return uint64(c.attempts), err
+}
+
+func newTestRetryingContract(t *testing.T, contract contractInvoker, maxAttempts int) (*retryingContract, *[]time.Duration) {
+	var backoffs []time.Duration
+	retrying := newRetryingContract(contract, retryPolicy{maxAttempts: maxAttempts, initialBackoff: 100 * time.Millisecond, maxBackoff: 300 * time.Millisecond})
+	retrying.sleep = func(backoff time.Duration) {
+		backoffs = append(backoffs, backoff)
+	}
+	retrying.logf = t.Logf
+
+	return retrying, &backoffs
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	mvccConflict := &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}
+	contract := &flakyContract{errs: []error{
+		mvccConflict,
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.DeadlineExceeded, "timeout"),
+	}}
+	retrying, backoffs := newTestRetryingContract(t, contract, 4)
+
+	result, err := retrying.SubmitTransaction("TransferCarAsset", "car1", "person2", "true")
+	if err != nil || string(result) != "ok" {
+		t.Fatalf("expected the fourth attempt to succeed, got %q, %v", result, err)
+	}
+	if contract.attempts != 4 {
+		t.Errorf("expected 4 attempts, got %d", contract.attempts)
+	}
+
+	// The backoff doubles up to the maximum and is jittered down to half of it.
+	for i, maxBackoff := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond} {
+		if backoff := (*backoffs)[i]; backoff < maxBackoff/2 || backoff > maxBackoff {
+			t.Errorf("retry %d: expected a backoff between %s and %s, got %s", i+1, maxBackoff/2, maxBackoff, backoff)
+		}
+	}
+
+	contract = &flakyContract{errs: []error{mvccConflict, mvccConflict, mvccConflict}}
+	retrying, _ = newTestRetryingContract(t, contract, 3)
+
+	_, err = retrying.SubmitTransaction("TransferCarAsset")
+	if err != mvccConflict || contract.attempts != 3 {
+		t.Errorf("expected the last conflict after 3 attempts, got %v after %d", err, contract.attempts)
+	}
+}
+
+func TestNoRetryForPermanentFailures(t *testing.T) {
+	for _, err := range []error{
+		errors.New("the car asset car9 does not exist"),
+		status.Error(codes.Aborted, "failed to endorse transaction"),
+		&client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE},
+	} {
+		contract := &flakyContract{errs: []error{err}}
+		retrying, _ := newTestRetryingContract(t, contract, 3)
+
+		_, got := retrying.SubmitTransaction("RepairCar", "car1")
+		if got != err || contract.attempts != 1 {
+			t.Errorf("%v: expected a single attempt, got %v after %d", err, got, contract.attempts)
+		}
+	}
+}
+
+func TestTransactionErrorMessage(t *testing.T) {
+	endorseStatus, err := status.New(codes.Aborted, "failed to endorse transaction, see attached details for more info").WithDetails(
+		&gateway.ErrorDetail{Address: "peer0.org1.example.com:7051", MspId: "Org1MSP", Message: "chaincode response 500, the car asset car9 does not exist"},
+		&gateway.ErrorDetail{Address: "peer0.org2.example.com:9051", MspId: "Org2MSP", Message: "chaincode response 500, the car asset car9 does not exist"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{
+			endorseStatus.Err(),
+			"failed to submit transaction RepairCar: gRPC status Aborted: failed to endorse transaction, see attached details for more info\n" +
+				"  peer0.org1.example.com:7051 (Org1MSP): chaincode response 500, the car asset car9 does not exist\n" +
+				"  peer0.org2.example.com:9051 (Org2MSP): chaincode response 500, the car asset car9 does not exist",
+		},
+		{
+			&client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT},
+			"failed to submit transaction RepairCar: transaction tx1 was not committed, it was marked invalid with status MVCC_READ_CONFLICT (11)",
+		},
+		{
+			errors.New("the car asset car9 does not exist"),
+			"failed to submit transaction RepairCar: the car asset car9 does not exist",
+		},
+	} {
+		if got := newTransactionError("submit", "RepairCar", test.err).Error(); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "app_config.json")
+
+	for _, test := range []struct {
+		config   string
+		problems []string
+	}{
+		{
+			`{"channelName":"mychannel","chaincodeName":"basic","orgs":{"org1":{"mspID":"Org1MSP","peerEndpont":"localhost:7051"}}}`,
+			[]string{`json: unknown field "peerEndpont"`},
+		},
+		{
+			`{"channelName":"mychannel","orgs":{"org1":{"mspID":"Org1MSP","tlsCertPath":"ca.crt","peerEndpoint":"localhost","gatewayPeer":"peer0",
+				"defaultIdentity":"User2","identities":{"User1":{"certPath":"cert.pem"}}}},"retry":{"maxAttempts":0,"initialBackoff":"1s","maxBackoff":"soon"}}`,
+			[]string{
+				"6 problems:",
+				"chaincodeName is required",
+				`orgs.org1.peerEndpoint must be a host and port such as localhost:7051, not "localhost"`,
+				"orgs.org1.identities.User1.keyPath is required",
+				"orgs.org1.defaultIdentity User2 is not one of its identities: User1",
+				"retry.maxAttempts must be at least 1, not 0",
+				`retry.maxBackoff must be a duration such as 500ms or 2s, not "soon"`,
+			},
+		},
+	} {
+		err := os.WriteFile(configPath, []byte(test.config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = loadAppConfig(configPath)
+		if err == nil {
+			t.Errorf("%s: expected the config to be rejected", test.config)
+			continue
+		}
+		for _, problem := range test.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("expected %q to report %q", err, problem)
+			}
+		}
+	}
+
+	appConfig, err := loadAppConfig("app_config.json")
+	if err != nil {
+		t.Fatalf("expected the shipped config to be valid: %v", err)
+	}
+	if policy := appConfig.retryPolicy(); policy.maxAttempts != 3 || policy.initialBackoff != 500*time.Millisecond {
+		t.Errorf("expected the default retry policy, got %+v", policy)
+	}
+}
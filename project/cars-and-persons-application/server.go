@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// defaultServerPageSize is the number of cars returned by GET /cars when the
+	// request does not give a page size.
+	defaultServerPageSize = 20
+	// maxRequestBodyBytes bounds the request bodies, which only hold a few fields.
+	maxRequestBodyBytes = 64 << 10
+)
+
+// openAPIDocument describes the REST API and is served at /openapi.yaml.
+//
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+// apiServer exposes the contract as REST resources. Every request is evaluated or
+// submitted as the client of the organization the server was started for, so only
+// requests with the bearer token of the server are served.
+type apiServer struct {
+	contract contractInvoker
+	token    string
+}
+
+// apiError is the body of every error response, wrapped in an "error" object.
+type apiError struct {
+	status  int
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+func newAPIError(status int, code string, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func newAPIServer(contract contractInvoker, token string) http.Handler {
+	s := &apiServer{contract: contract, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi.yaml", s.handleOpenAPI)
+	mux.HandleFunc("/cars", s.handleCars)
+	mux.HandleFunc("/cars/", s.handleCar)
+	mux.HandleFunc("/persons/", s.handlePerson)
+	mux.HandleFunc("/offers", s.handleOffers)
+	mux.HandleFunc("/offers/", s.handleOffer)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		respondError(w, newAPIError(http.StatusNotFound, "not_found", "no resource at %s", r.URL.Path))
+	})
+
+	return s.authorize(mux)
+}
+
+// authorize only passes on requests with the bearer token of the server, except for the
+// OpenAPI document, and bounds the size of their bodies.
+func (s *apiServer) authorize(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openapi.yaml" {
+			authorization := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(authorization, "Bearer ")
+			if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cars-and-persons"`)
+				respondError(w, newAPIError(http.StatusUnauthorized, "unauthorized", "a valid bearer token is required"))
+				return
+			}
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newAPIToken generates a random bearer token for a server started without one.
+func newAPIToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a bearer token: %w", err)
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// serveAPI serves the REST API on addr until ctx is cancelled, and then waits for the
+// requests in progress to finish. Without a token, a random one is generated and logged.
+func serveAPI(ctx context.Context, addr string, contract contractInvoker, token string, logOutput io.Writer) error {
+	logger := log.New(logOutput, "", log.LstdFlags)
+	if token == "" {
+		var err error
+		token, err = newAPIToken()
+		if err != nil {
+			return &setupError{err: err}
+		}
+		logger.Printf("Clients have to send the header \"Authorization: Bearer %s\"", token)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           logRequests(logger, newAPIServer(contract, token)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	logger.Printf("Serving the REST API on %s, the OpenAPI document is at /openapi.yaml", addr)
+
+	select {
+	case err := <-serveErr:
+		return &setupError{err: fmt.Errorf("failed to serve the REST API: %w", err)}
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
+
+func (s *apiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPIDocument)
+}
+
+// handleCars serves GET /cars, which returns a page of the cars matching the filters
+// in the query string.
+func (s *apiServer) handleCars(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	params := r.URL.Query()
+	query := carQuery{
+		color:           params.Get("color"),
+		ownerID:         params.Get("owner"),
+		brand:           params.Get("brand"),
+		model:           params.Get("model"),
+		minPrice:        params.Get("minPrice"),
+		maxPrice:        params.Get("maxPrice"),
+		status:          params.Get("status"),
+		hasMalfunctions: params.Get("hasMalfunctions"),
+	}
+
+	var apiErr *apiError
+	query.minYear, apiErr = intParam(params.Get("minYear"), "minYear", 0)
+	if apiErr != nil {
+		respondError(w, apiErr)
+		return
+	}
+	query.maxYear, apiErr = intParam(params.Get("maxYear"), "maxYear", 0)
+	if apiErr != nil {
+		respondError(w, apiErr)
+		return
+	}
+	pageSize, apiErr := intParam(params.Get("pageSize"), "pageSize", defaultServerPageSize)
+	if apiErr != nil {
+		respondError(w, apiErr)
+		return
+	}
+	if pageSize <= 0 {
+		respondError(w, newAPIError(http.StatusBadRequest, "invalid_request", "pageSize must be positive"))
+		return
+	}
+
+	transactionName, args, err := query.transaction()
+	if err != nil {
+		respondError(w, newAPIError(http.StatusBadRequest, "invalid_request", "%v", err))
+		return
+	}
+
+	args = append(args, strconv.Itoa(pageSize), params.Get("bookmark"))
+	evaluateResult, err := s.contract.EvaluateTransaction(transactionName, args...)
+	if err != nil {
+		respondError(w, transactionAPIError(err))
+		return
+	}
+
+	// Empty pages are returned with an empty list of records rather than null.
+	var page struct {
+		Records             []json.RawMessage `json:"records"`
+		FetchedRecordsCount int32             `json:"fetchedRecordsCount"`
+		Bookmark            string            `json:"bookmark"`
+	}
+	err = json.Unmarshal(evaluateResult, &page)
+	if err != nil {
+		respondError(w, newAPIError(http.StatusBadGateway, "invalid_response", "failed to parse page: %v", err))
+		return
+	}
+	if page.Records == nil {
+		page.Records = []json.RawMessage{}
+	}
+
+	respondJSON(w, http.StatusOK, page)
+}
+
+// handleCar serves the resources of a single car under /cars/{id}.
+func (s *apiServer) handleCar(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/cars/")
+	if len(segments) == 0 {
+		respondError(w, newAPIError(http.StatusNotFound, "not_found", "no resource at %s", r.URL.Path))
+		return
+	}
+	carID := segments[0]
+
+	switch {
+	case len(segments) == 1:
+		if allowMethods(w, r, http.MethodGet) {
+			s.evaluate(w, "ReadCarAsset", carID)
+		}
+
+	case len(segments) == 2 && segments[1] == "history":
+		if allowMethods(w, r, http.MethodGet) {
+			s.evaluate(w, "GetCarHistory", carID)
+		}
+
+	case len(segments) == 2 && segments[1] == "malfunctions":
+		if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			s.evaluate(w, "ListOpenMalfunctions", carID)
+			return
+		}
+
+		var request struct {
+			Description string `json:"description"`
+			RepairPrice string `json:"repairPrice"`
+			Severity    string `json:"severity"`
+		}
+		if !decodeRequest(w, r, &request) || !requireFields(w, map[string]string{"description": request.Description, "repairPrice": request.RepairPrice, "severity": request.Severity}) {
+			return
+		}
+		s.submitCarChange(w, http.StatusCreated, nil, carID, "AddCarMalfunction", carID, request.Description, request.RepairPrice, request.Severity)
+
+	case len(segments) == 4 && segments[1] == "malfunctions" && segments[3] == "repair":
+		var request personDetailsRequest
+		if allowMethods(w, r, http.MethodPost) && decodeOptionalRequest(w, r, &request) {
+			s.submitCarChange(w, http.StatusOK, request.PersonDetails, carID, "RepairMalfunction", carID, segments[2])
+		}
+
+	case len(segments) == 2 && segments[1] == "transfer":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+
+		var request struct {
+			NewOwnerID        string            `json:"newOwnerID"`
+			AcceptMalfunction bool              `json:"acceptMalfunction"`
+			PersonDetails     []json.RawMessage `json:"personDetails"`
+		}
+		if !decodeRequest(w, r, &request) || !requireFields(w, map[string]string{"newOwnerID": request.NewOwnerID}) {
+			return
+		}
+		s.submitCarChange(w, http.StatusOK, request.PersonDetails, carID, "TransferCarAsset", carID, request.NewOwnerID, strconv.FormatBool(request.AcceptMalfunction))
+
+	case len(segments) == 2 && segments[1] == "repair":
+		var request personDetailsRequest
+		if allowMethods(w, r, http.MethodPost) && decodeOptionalRequest(w, r, &request) {
+			s.submitCarChange(w, http.StatusOK, request.PersonDetails, carID, "RepairCar", carID)
+		}
+
+	case len(segments) == 2 && segments[1] == "color":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+
+		var request struct {
+			Color string `json:"color"`
+		}
+		if !decodeRequest(w, r, &request) || !requireFields(w, map[string]string{"color": request.Color}) {
+			return
+		}
+		s.submitCarChange(w, http.StatusOK, nil, carID, "ChangeCarColor", carID, request.Color)
+
+	default:
+		respondError(w, newAPIError(http.StatusNotFound, "not_found", "no resource at %s", r.URL.Path))
+	}
+}
+
+// handlePerson serves the resources of a single person under /persons/{id}.
+func (s *apiServer) handlePerson(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/persons/")
+
+	switch {
+	case len(segments) == 1:
+		if allowMethods(w, r, http.MethodGet) {
+			s.evaluate(w, "ReadPersonAsset", segments[0])
+		}
+
+	case len(segments) == 2 && segments[1] == "written-off-cars":
+		if allowMethods(w, r, http.MethodGet) {
+			s.evaluate(w, "GetWrittenOffCars", segments[0])
+		}
+
+	default:
+		respondError(w, newAPIError(http.StatusNotFound, "not_found", "no resource at %s", r.URL.Path))
+	}
+}
+
+// handleOffers serves POST /offers, which creates a sale offer.
+func (s *apiServer) handleOffers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var request struct {
+		OfferID         string `json:"offerID"`
+		CarID           string `json:"carID"`
+		BuyerID         string `json:"buyerID"`
+		AskingPrice     string `json:"askingPrice"`
+		ValidForSeconds int    `json:"validForSeconds"`
+	}
+	if !decodeRequest(w, r, &request) || !requireFields(w, map[string]string{"offerID": request.OfferID, "carID": request.CarID, "askingPrice": request.AskingPrice}) {
+		return
+	}
+
+	s.submit(w, http.StatusCreated, nil, "CreateSaleOffer", request.OfferID, request.CarID, request.BuyerID, request.AskingPrice, strconv.Itoa(request.ValidForSeconds))
+}
+
+// handleOffer serves the resources of a single sale offer under /offers/{id}.
+func (s *apiServer) handleOffer(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/offers/")
+
+	switch {
+	case len(segments) == 1:
+		if allowMethods(w, r, http.MethodGet) {
+			s.evaluate(w, "ReadSaleOffer", segments[0])
+		}
+
+	case len(segments) == 2 && segments[1] == "accept":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+
+		var request struct {
+			BuyerID           string            `json:"buyerID"`
+			AcceptMalfunction bool              `json:"acceptMalfunction"`
+			PersonDetails     []json.RawMessage `json:"personDetails"`
+		}
+		if !decodeRequest(w, r, &request) || !requireFields(w, map[string]string{"buyerID": request.BuyerID}) {
+			return
+		}
+		s.submit(w, http.StatusOK, request.PersonDetails, "AcceptSaleOffer", segments[0], request.BuyerID, strconv.FormatBool(request.AcceptMalfunction))
+
+	case len(segments) == 2 && segments[1] == "settle":
+		var request personDetailsRequest
+		if allowMethods(w, r, http.MethodPost) && decodeOptionalRequest(w, r, &request) {
+			s.submit(w, http.StatusOK, request.PersonDetails, "SettleSaleOffer", segments[0])
+		}
+
+	case len(segments) == 2 && segments[1] == "cancel":
+		var request personDetailsRequest
+		if allowMethods(w, r, http.MethodPost) && decodeOptionalRequest(w, r, &request) {
+			s.submit(w, http.StatusOK, request.PersonDetails, "CancelSaleOffer", segments[0])
+		}
+
+	default:
+		respondError(w, newAPIError(http.StatusNotFound, "not_found", "no resource at %s", r.URL.Path))
+	}
+}
+
+// evaluate responds with the result of an evaluated transaction.
+func (s *apiServer) evaluate(w http.ResponseWriter, transactionName string, args ...string) {
+	evaluateResult, err := s.contract.EvaluateTransaction(transactionName, args...)
+	if err != nil {
+		respondError(w, transactionAPIError(err))
+		return
+	}
+
+	respondRawJSON(w, http.StatusOK, evaluateResult)
+}
+
+// submit responds with the result of a submitted transaction.
+func (s *apiServer) submit(w http.ResponseWriter, statusCode int, personDetails []json.RawMessage, transactionName string, args ...string) {
+	submitResult, err := s.submitTransaction(personDetails, transactionName, args...)
+	if err != nil {
+		respondError(w, transactionAPIError(err))
+		return
+	}
+
+	respondRawJSON(w, statusCode, submitResult)
+}
+
+// submitCarChange submits a transaction that changes a car and responds with the car
+// as it is after the transaction was committed.
+func (s *apiServer) submitCarChange(w http.ResponseWriter, statusCode int, personDetails []json.RawMessage, carID string, transactionName string, args ...string) {
+	_, err := s.submitTransaction(personDetails, transactionName, args...)
+	if err != nil {
+		respondError(w, transactionAPIError(err))
+		return
+	}
+
+	evaluateResult, err := s.contract.EvaluateTransaction("ReadCarAsset", carID)
+	if err != nil {
+		respondError(w, transactionAPIError(err))
+		return
+	}
+
+	respondRawJSON(w, statusCode, evaluateResult)
+}
+
+// submitTransaction submits a transaction, passing the private details of persons, if
+// any, to the peers under the "personDetails" transient key, like "--details" does.
+func (s *apiServer) submitTransaction(personDetails []json.RawMessage, transactionName string, args ...string) ([]byte, error) {
+	if len(personDetails) == 0 {
+		return s.contract.SubmitTransaction(transactionName, args...)
+	}
+
+	details, err := json.Marshal(personDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.contract.SubmitWithTransient(transactionName, map[string][]byte{transientPersonDetailsKey: details}, args...)
+}
+
+// transactionAPIError maps a failed transaction to an error response. The message of
+// the chaincode is preferred to the gRPC status message, which for submitted
+// transactions only says that the endorsement failed.
+func transactionAPIError(err error) *apiError {
+	var commitErr *client.CommitError
+
+	code := grpcCode(err)
+	message := err.Error()
+	if grpcStatus := grpcStatus(err); grpcStatus != nil {
+		message = chaincodeMessage(grpcStatus.Message())
+	}
+
+	var details []string
+	for _, detail := range errorDetails(err) {
+		details = append(details, fmt.Sprintf("%s (%s): %s", detail.Address, detail.MspId, detail.Message))
+		message = chaincodeMessage(detail.Message)
+	}
+
+	apiErr := &apiError{Message: message, Details: details}
+
+	switch {
+	case errors.As(err, &commitErr):
+		apiErr.status, apiErr.Code = http.StatusConflict, "commit_failed"
+	case code == codes.Unavailable:
+		apiErr.status, apiErr.Code = http.StatusServiceUnavailable, "unavailable"
+	case code == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded):
+		apiErr.status, apiErr.Code = http.StatusGatewayTimeout, "timeout"
+	case strings.Contains(message, "not authorized") || strings.Contains(message, "only the owner can"):
+		apiErr.status, apiErr.Code = http.StatusForbidden, "forbidden"
+	case strings.Contains(message, "does not exist"):
+		apiErr.status, apiErr.Code = http.StatusNotFound, "not_found"
+	default:
+		apiErr.status, apiErr.Code = http.StatusUnprocessableEntity, "transaction_rejected"
+	}
+
+	return apiErr
+}
+
+// decodeRequest decodes a JSON request body, rejecting unknown fields. It responds with
+// an error and returns false when the body is invalid.
+func decodeRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(request)
+	if err != nil {
+		respondError(w, newAPIError(http.StatusBadRequest, "invalid_request", "invalid request body: %v", err))
+		return false
+	}
+
+	return true
+}
+
+// personDetailsRequest is the optional body of the requests that only change the balance
+// of a person. The details of the person, as returned by ReadPersonPrivateDetails, have to
+// be passed for peers outside the person's organization.
+type personDetailsRequest struct {
+	PersonDetails []json.RawMessage `json:"personDetails"`
+}
+
+// decodeOptionalRequest decodes a JSON request body like decodeRequest, but accepts an
+// empty body as well.
+func decodeOptionalRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(request)
+	if err != nil && err != io.EOF {
+		respondError(w, newAPIError(http.StatusBadRequest, "invalid_request", "invalid request body: %v", err))
+		return false
+	}
+
+	return true
+}
+
+// requireFields responds with an error and returns false when any of the given request
+// fields is empty.
+func requireFields(w http.ResponseWriter, fields map[string]string) bool {
+	var missing []string
+	for name, value := range fields {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		respondError(w, newAPIError(http.StatusBadRequest, "invalid_request", "missing required %s: %s", pluralize(len(missing), "field", "fields"), strings.Join(missing, ", ")))
+		return false
+	}
+
+	return true
+}
+
+func intParam(value string, name string, defaultValue int) (int, *apiError) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, newAPIError(http.StatusBadRequest, "invalid_request", "%s must be a number, not %q", name, value)
+	}
+
+	return number, nil
+}
+
+// pathSegments returns the non-empty segments of the path after the prefix.
+func pathSegments(path string, prefix string) []string {
+	var segments []string
+	for _, segment := range strings.Split(strings.TrimPrefix(path, prefix), "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
+
+// allowMethods responds with 405 Method Not Allowed and returns false when the request
+// method is not one of the given ones.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	respondError(w, newAPIError(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed on %s", r.Method, r.URL.Path))
+
+	return false
+}
+
+func respondJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondRawJSON(w, statusCode, bodyJSON)
+}
+
+// respondRawJSON responds with a transaction result. Results that are not JSON, such as
+// the plain strings some transactions return, are sent as JSON strings.
+func respondRawJSON(w http.ResponseWriter, statusCode int, body []byte) {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		body = []byte(`{"status":"committed"}`)
+	}
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+func respondError(w http.ResponseWriter, apiErr *apiError) {
+	respondJSON(w, apiErr.status, struct {
+		Error *apiError `json:"error"`
+	}{apiErr})
+}
+
+// logRequests logs the method, path, status and duration of every request.
+func logRequests(logger *log.Logger, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		logger.Printf("%s %s %d %s", r.Method, r.URL.RequestURI(), recorder.status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
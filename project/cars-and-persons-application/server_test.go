@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testAPIToken is the bearer token of the REST API in the tests.
+const testAPIToken = "test-token"
+
+// serve sends a request with the bearer token to the REST API backed by the contract
+// and returns the recorded response.
+func serve(contract *stubContract, method string, target string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+testAPIToken)
+	response := httptest.NewRecorder()
+	newAPIServer(contract, testAPIToken).ServeHTTP(response, request)
+
+	return response
+}
+
+// errorCode returns the code of an error response.
+func errorCode(t *testing.T, response *httptest.ResponseRecorder) string {
+	var body struct {
+		Error struct {
+			Code    string
+			Message string
+		}
+	}
+	err := json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("expected an error response, got %q", response.Body.String())
+	}
+
+	return body.Error.Code
+}
+
+func TestServerRequiresToken(t *testing.T) {
+	contract := newStubContract()
+
+	for _, authorization := range []string{"", "Bearer wrong-token", testAPIToken} {
+		request := httptest.NewRequest(http.MethodGet, "/cars/car1", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		response := httptest.NewRecorder()
+		newAPIServer(contract, testAPIToken).ServeHTTP(response, request)
+
+		if response.Code != http.StatusUnauthorized || errorCode(t, response) != "unauthorized" {
+			t.Errorf("%q: expected status %d, got %d: %s", authorization, http.StatusUnauthorized, response.Code, response.Body)
+		}
+	}
+	if len(contract.calls) != 0 {
+		t.Errorf("expected no transactions, got %v", contract.calls)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil)
+	response := httptest.NewRecorder()
+	newAPIServer(contract, testAPIToken).ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("expected the OpenAPI document without a token, got status %d", response.Code)
+	}
+}
+
+func TestServerLimitsRequestBody(t *testing.T) {
+	contract := newStubContract()
+
+	body := `{"description":"` + strings.Repeat("x", maxRequestBodyBytes) + `","repairPrice":"50.00 EUR","severity":"minor"}`
+	response := serve(contract, http.MethodPost, "/cars/car1/malfunctions", body)
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "request body too large") {
+		t.Errorf("expected a too large request body error, got %d: %s", response.Code, response.Body)
+	}
+	if len(contract.calls) != 0 {
+		t.Errorf("expected no transactions, got %v", contract.calls)
+	}
+}
+
+func TestServerReadCar(t *testing.T) {
+	contract := newStubContract()
+	contract.results["ReadCarAsset"] = [][]byte{[]byte(`{"ID":"car1","Color":"blue"}`)}
+
+	response := serve(contract, http.MethodGet, "/cars/car1", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if response.Body.String() != `{"ID":"car1","Color":"blue"}` {
+		t.Errorf("unexpected body %q", response.Body)
+	}
+	if got := response.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected content type %q", got)
+	}
+
+	contract = newStubContract()
+	contract.errs["ReadCarAsset"] = status.Error(codes.Unknown, "evaluate call to endorser returned error: chaincode response 500, the car asset car9 does not exist")
+
+	response = serve(contract, http.MethodGet, "/cars/car9", "")
+	if response.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+	want := `{"error":{"code":"not_found","message":"the car asset car9 does not exist"}}`
+	if response.Body.String() != want {
+		t.Errorf("expected %s, got %s", want, response.Body)
+	}
+}
+
+func TestServerQueryCars(t *testing.T) {
+	contract := newStubContract()
+	contract.results["GetCarsByColorAndOwnerWithPagination"] = [][]byte{[]byte(`{"records":null,"fetchedRecordsCount":0,"bookmark":""}`)}
+
+	response := serve(contract, http.MethodGet, "/cars?color=red&owner=person2&pageSize=2&bookmark=car3", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if got, want := strings.Join(contract.calls, "; "), "evaluate GetCarsByColorAndOwnerWithPagination(red, person2, 2, car3)"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if response.Body.String() != `{"records":[],"fetchedRecordsCount":0,"bookmark":""}` {
+		t.Errorf("unexpected body %q", response.Body)
+	}
+
+	for _, target := range []string{"/cars?color=red&brand=Audi", "/cars?minYear=new", "/cars?pageSize=0", "/cars?hasMalfunctions=maybe"} {
+		contract := newStubContract()
+
+		response := serve(contract, http.MethodGet, target, "")
+		if response.Code != http.StatusBadRequest || errorCode(t, response) != "invalid_request" {
+			t.Errorf("%s: expected an invalid request, got %d: %s", target, response.Code, response.Body)
+		}
+		if len(contract.calls) > 0 {
+			t.Errorf("%s: expected no transactions, got %v", target, contract.calls)
+		}
+	}
+}
+
+func TestServerChangeCarColor(t *testing.T) {
+	contract := newStubContract()
+	contract.results["ReadCarAsset"] = [][]byte{[]byte(`{"ID":"car1","Color":"red"}`)}
+
+	response := serve(contract, http.MethodPost, "/cars/car1/color", `{"color":"red"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+
+	want := "submit ChangeCarColor(car1, red); evaluate ReadCarAsset(car1)"
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if response.Body.String() != `{"ID":"car1","Color":"red"}` {
+		t.Errorf("unexpected body %q", response.Body)
+	}
+
+	for _, body := range []string{`{}`, `{"color":"red","price":"1.00 EUR"}`, `not json`} {
+		contract := newStubContract()
+
+		response := serve(contract, http.MethodPost, "/cars/car1/color", body)
+		if response.Code != http.StatusBadRequest || errorCode(t, response) != "invalid_request" {
+			t.Errorf("%s: expected an invalid request, got %d: %s", body, response.Code, response.Body)
+		}
+		if len(contract.calls) > 0 {
+			t.Errorf("%s: expected no transactions, got %v", body, contract.calls)
+		}
+	}
+}
+
+func TestServerAddMalfunction(t *testing.T) {
+	contract := newStubContract()
+
+	response := serve(contract, http.MethodPost, "/cars/car2/malfunctions", `{"description":"broken mirror","repairPrice":"50.00 EUR","severity":"minor"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if got, want := contract.calls[0], "submit AddCarMalfunction(car2, broken mirror, 50.00 EUR, minor)"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestServerTransferCar(t *testing.T) {
+	contract := newStubContract()
+	contract.results["ReadCarAsset"] = [][]byte{[]byte(`{"ID":"car5","OwnerID":"person2"}`)}
+
+	response := serve(contract, http.MethodPost, "/cars/car5/transfer", `{"newOwnerID":"person2","acceptMalfunction":true}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+
+	want := "submit TransferCarAsset(car5, person2, true); evaluate ReadCarAsset(car5)"
+	if got := strings.Join(contract.calls, "; "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if response.Body.String() != `{"ID":"car5","OwnerID":"person2"}` {
+		t.Errorf("unexpected body %q", response.Body)
+	}
+
+	response = serve(newStubContract(), http.MethodPost, "/cars/car5/transfer", `{"acceptMalfunction":true}`)
+	if response.Code != http.StatusBadRequest || errorCode(t, response) != "invalid_request" {
+		t.Errorf("expected an invalid request, got %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestServerPassesPersonDetails(t *testing.T) {
+	details := `{"ID":"person2","EmailAddress":"marko@pdasp.rs","AmountOfMoneyOwned":{"Amount":820040,"Currency":"EUR"},"Salt":"e5d1a4f1c0b94a7e9d2f6c3b8a1e0f47"}`
+	transient := `personDetails=[` + details + `]`
+
+	for _, test := range []struct {
+		target string
+		body   string
+		want   string
+	}{
+		{"/offers/offer1/accept", `{"buyerID":"person2","personDetails":[` + details + `]}`, "submit AcceptSaleOffer(offer1, person2, false, " + transient + ")"},
+		{"/offers/offer1/settle", `{"personDetails":[` + details + `]}`, "submit SettleSaleOffer(offer1, " + transient + ")"},
+		{"/offers/offer1/settle", "", "submit SettleSaleOffer(offer1)"},
+		{"/offers/offer1/cancel", `{"personDetails":[` + details + `]}`, "submit CancelSaleOffer(offer1, " + transient + ")"},
+		{"/cars/car5/transfer", `{"newOwnerID":"person2","personDetails":[` + details + `]}`, "submit TransferCarAsset(car5, person2, false, " + transient + "); evaluate ReadCarAsset(car5)"},
+		{"/cars/car5/repair", `{"personDetails":[` + details + `]}`, "submit RepairCar(car5, " + transient + "); evaluate ReadCarAsset(car5)"},
+	} {
+		contract := newStubContract()
+
+		response := serve(contract, http.MethodPost, test.target, test.body)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.target, http.StatusOK, response.Code, response.Body)
+		}
+		if got := strings.Join(contract.calls, "; "); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.target, test.want, got)
+		}
+	}
+
+	for _, body := range []string{`{"personDetails":` + details + `}`, `{"personDetails":[]`, `{"details":[]}`} {
+		contract := newStubContract()
+
+		response := serve(contract, http.MethodPost, "/offers/offer1/settle", body)
+		if response.Code != http.StatusBadRequest || errorCode(t, response) != "invalid_request" {
+			t.Errorf("%s: expected an invalid request, got %d: %s", body, response.Code, response.Body)
+		}
+		if len(contract.calls) > 0 {
+			t.Errorf("%s: expected no transactions, got %v", body, contract.calls)
+		}
+	}
+}
+
+func TestServerTransactionErrors(t *testing.T) {
+	endorseStatus, err := status.New(codes.Aborted, "failed to endorse transaction, see attached details for more info").WithDetails(&gateway.ErrorDetail{
+		Address: "peer0.org1.example.com:7051",
+		MspId:   "Org1MSP",
+		Message: "chaincode response 500, client is not authorized to act on behalf of person1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		err        error
+		statusCode int
+		code       string
+	}{
+		{endorseStatus.Err(), http.StatusForbidden, "forbidden"},
+		{status.Error(codes.Unknown, "chaincode response 500, the car asset car1 is written off"), http.StatusUnprocessableEntity, "transaction_rejected"},
+		{status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable, "unavailable"},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"), http.StatusGatewayTimeout, "timeout"},
+	} {
+		contract := newStubContract()
+		contract.errs["RepairCar"] = test.err
+
+		response := serve(contract, http.MethodPost, "/cars/car1/repair", "")
+		if response.Code != test.statusCode || errorCode(t, response) != test.code {
+			t.Errorf("%v: expected %d %s, got %d: %s", test.err, test.statusCode, test.code, response.Code, response.Body)
+		}
+	}
+
+	contract := newStubContract()
+	contract.errs["RepairCar"] = endorseStatus.Err()
+	response := serve(contract, http.MethodPost, "/cars/car1/repair", "")
+
+	var body struct {
+		Error apiError
+	}
+	err = json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Message != "client is not authorized to act on behalf of person1" {
+		t.Errorf("unexpected message %q", body.Error.Message)
+	}
+	if len(body.Error.Details) != 1 || !strings.HasPrefix(body.Error.Details[0], "peer0.org1.example.com:7051 (Org1MSP): ") {
+		t.Errorf("unexpected details %q", body.Error.Details)
+	}
+}
+
+func TestServerRoutes(t *testing.T) {
+	response := serve(newStubContract(), http.MethodGet, "/openapi.yaml", "")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/yaml" {
+		t.Errorf("expected the OpenAPI document, got %d %q", response.Code, response.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(response.Body.String(), "openapi: 3.0.3") {
+		t.Errorf("unexpected OpenAPI document %q", response.Body.String()[:20])
+	}
+
+	response = serve(newStubContract(), http.MethodDelete, "/cars/car1", "")
+	if response.Code != http.StatusMethodNotAllowed || errorCode(t, response) != "method_not_allowed" {
+		t.Errorf("expected method not allowed, got %d: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Allow"); got != http.MethodGet {
+		t.Errorf("unexpected Allow header %q", got)
+	}
+
+	for _, target := range []string{"/", "/cars/car1/wheels", "/cars/car1/sell", "/persons/", "/offers/offer1/reject"} {
+		response := serve(newStubContract(), http.MethodGet, target, "")
+		if response.Code != http.StatusNotFound || errorCode(t, response) != "not_found" {
+			t.Errorf("%s: expected not found, got %d: %s", target, response.Code, response.Body)
+		}
+	}
+}
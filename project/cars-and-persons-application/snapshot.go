@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// snapshotPageSize is the number of records read per page when taking a snapshot.
+const snapshotPageSize = 100
+
+// The types of the lines of a snapshot.
+const (
+	snapshotTypeHeader      = "snapshot"
+	snapshotTypePerson      = "person"
+	snapshotTypeCar         = "car"
+	snapshotTypeMalfunction = "malfunction"
+)
+
+// snapshotHeader is the first line of a snapshot. BlockHeight is the height of the
+// channel when the export started.
+type snapshotHeader struct {
+	Type          string    `json:"type"`
+	ChannelName   string    `json:"channelName,omitempty"`
+	ChaincodeName string    `json:"chaincodeName,omitempty"`
+	BlockHeight   uint64    `json:"blockHeight"`
+	ExportedAt    time.Time `json:"exportedAt"`
+}
+
+// snapshotRecord is a line of a snapshot with a person, a car or a malfunction of a car,
+// as the chaincode returns it. The malfunctions of a car are written as records of
+// their own, with IDs such as car1/m1, and are left out of the record of the car.
+type snapshotRecord struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	CarID  string          `json:"carID,omitempty"`
+	Record json.RawMessage `json:"record"`
+}
+
+func (r snapshotRecord) key() string {
+	return r.Type + " " + r.ID
+}
+
+// snapshotDrift is a record that differs between a snapshot and the ledger.
+type snapshotDrift struct {
+	Type   string
+	ID     string
+	Change string
+	// Fields are the fields of a changed record that differ.
+	Fields []string `json:",omitempty"`
+}
+
+// The changes of drifted records.
+const (
+	driftAdded   = "added"
+	driftRemoved = "removed"
+	driftChanged = "changed"
+)
+
+// snapshotVerification is the result of verifying a snapshot against the ledger.
+type snapshotVerification struct {
+	SnapshotBlockHeight uint64
+	BlockHeight         uint64
+	Records             int
+	Drift               []snapshotDrift
+}
+
+// readLedgerRecords pages through all persons and cars of the ledger.
+func readLedgerRecords(contract contractInvoker) ([]snapshotRecord, error) {
+	var records []snapshotRecord
+
+	err := forEachPage(contract, "GetAllPersonsWithPagination", func(personJSON json.RawMessage) error {
+		var person struct{ ID string }
+		err := json.Unmarshal(personJSON, &person)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, snapshotRecord{Type: snapshotTypePerson, ID: person.ID, Record: personJSON})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachPage(contract, "GetAllCarsWithPagination", func(carJSON json.RawMessage) error {
+		var car map[string]json.RawMessage
+		err := json.Unmarshal(carJSON, &car)
+		if err != nil {
+			return err
+		}
+
+		var carID string
+		var malfunctions []json.RawMessage
+		err = json.Unmarshal(car["ID"], &carID)
+		if err != nil {
+			return err
+		}
+		if car["MalfunctionList"] != nil {
+			err = json.Unmarshal(car["MalfunctionList"], &malfunctions)
+			if err != nil {
+				return err
+			}
+		}
+		delete(car, "MalfunctionList")
+
+		carJSON, err = json.Marshal(car)
+		if err != nil {
+			return err
+		}
+		records = append(records, snapshotRecord{Type: snapshotTypeCar, ID: carID, Record: carJSON})
+
+		for _, malfunctionJSON := range malfunctions {
+			var malfunction struct{ ID string }
+			err := json.Unmarshal(malfunctionJSON, &malfunction)
+			if err != nil {
+				return err
+			}
+			records = append(records, snapshotRecord{Type: snapshotTypeMalfunction, ID: carID + "/" + malfunction.ID, CarID: carID, Record: malfunctionJSON})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// forEachPage evaluates a paginated query that only takes the page size and the bookmark
+// page by page, and calls fn with every record.
+func forEachPage(contract contractInvoker, transactionName string, fn func(record json.RawMessage) error) error {
+	bookmark := ""
+	for {
+		evaluateResult, err := contract.EvaluateTransaction(transactionName, strconv.Itoa(snapshotPageSize), bookmark)
+		if err != nil {
+			return newTransactionError("evaluate", transactionName, err)
+		}
+
+		var page struct {
+			Records             []json.RawMessage `json:"records"`
+			FetchedRecordsCount int32             `json:"fetchedRecordsCount"`
+			Bookmark            string            `json:"bookmark"`
+		}
+		err = json.Unmarshal(evaluateResult, &page)
+		if err != nil {
+			return fmt.Errorf("failed to parse page: %w", err)
+		}
+
+		for _, record := range page.Records {
+			err = fn(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse a record of %s: %w", transactionName, err)
+			}
+		}
+
+		if page.FetchedRecordsCount < snapshotPageSize || page.Bookmark == "" {
+			return nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// writeSnapshot writes the header and the records as JSON Lines.
+func writeSnapshot(w io.Writer, header snapshotHeader, records []snapshotRecord) error {
+	encoder := json.NewEncoder(w)
+
+	header.Type = snapshotTypeHeader
+	err := encoder.Encode(header)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSnapshot reads a snapshot written by writeSnapshot.
+func readSnapshot(r io.Reader) (snapshotHeader, []snapshotRecord, error) {
+	scanner := bufio.NewScanner(r)
+	// A car with a long history of repairs can make for long lines.
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	var header snapshotHeader
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return header, nil, scanner.Err()
+		}
+		return header, nil, fmt.Errorf("the snapshot is empty")
+	}
+	err := json.Unmarshal(scanner.Bytes(), &header)
+	if err != nil || header.Type != snapshotTypeHeader {
+		return header, nil, fmt.Errorf("line 1 is not a snapshot header")
+	}
+
+	var records []snapshotRecord
+	for line := 2; scanner.Scan(); line++ {
+		var record snapshotRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return header, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return header, records, scanner.Err()
+}
+
+// compareSnapshot reports the records that were added, removed or changed since the
+// snapshot was taken, ordered by type and ID.
+func compareSnapshot(snapshot []snapshotRecord, ledger []snapshotRecord) ([]snapshotDrift, error) {
+	current := map[string]snapshotRecord{}
+	for _, record := range ledger {
+		current[record.key()] = record
+	}
+
+	drift := []snapshotDrift{}
+	seen := map[string]bool{}
+	for _, record := range snapshot {
+		seen[record.key()] = true
+
+		currentRecord, ok := current[record.key()]
+		if !ok {
+			drift = append(drift, snapshotDrift{Type: record.Type, ID: record.ID, Change: driftRemoved})
+			continue
+		}
+
+		fields, err := changedFields(record.Record, currentRecord.Record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s %s: %w", record.Type, record.ID, err)
+		}
+		if len(fields) > 0 {
+			drift = append(drift, snapshotDrift{Type: record.Type, ID: record.ID, Change: driftChanged, Fields: fields})
+		}
+	}
+
+	for _, record := range ledger {
+		if !seen[record.key()] {
+			drift = append(drift, snapshotDrift{Type: record.Type, ID: record.ID, Change: driftAdded})
+		}
+	}
+
+	sort.SliceStable(drift, func(i, j int) bool {
+		if drift[i].Type != drift[j].Type {
+			return drift[i].Type < drift[j].Type
+		}
+		return drift[i].ID < drift[j].ID
+	})
+
+	return drift, nil
+}
+
+// changedFields returns the names of the top-level fields that differ between two
+// versions of a record.
+func changedFields(oldRecord json.RawMessage, newRecord json.RawMessage) ([]string, error) {
+	var oldFields, newFields map[string]interface{}
+	err := json.Unmarshal(oldRecord, &oldFields)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(newRecord, &newFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for name, oldValue := range oldFields {
+		newValue, ok := newFields[name]
+		if !ok || !reflect.DeepEqual(oldValue, newValue) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields, nil
+}
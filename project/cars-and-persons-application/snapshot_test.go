@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setLedger makes the stub contract return the given persons and cars as a single page.
+func setLedger(contract *stubContract, persons string, cars string) {
+	contract.results["GetAllPersonsWithPagination"] = [][]byte{[]byte(`{"records":` + persons + `,"fetchedRecordsCount":1}`)}
+	contract.results["GetAllCarsWithPagination"] = [][]byte{[]byte(`{"records":` + cars + `,"fetchedRecordsCount":1}`)}
+}
+
+func TestExportAndVerifySnapshot(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	contract := newStubContract()
+	contract.height = 42
+	setLedger(contract,
+		`[{"ID":"person1","FirstName":"Petar","AmountOfMoneyOwned":{"Amount":540054,"Currency":"EUR"}}]`,
+		`[{"ID":"car1","Color":"blue","OwnerID":"person1","MalfunctionList":[{"ID":"m1","Status":"open"},{"ID":"m2","Status":"open"}]}]`)
+
+	code, _, stderr := runWithContract(contract, "export", "--file", snapshotPath)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	snapshot, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(snapshot)), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], `{"type":"snapshot","blockHeight":42,`) {
+		t.Fatalf("unexpected snapshot %s", snapshot)
+	}
+	for i, want := range []string{
+		`{"type":"person","id":"person1","record":{"ID":"person1","FirstName":"Petar","AmountOfMoneyOwned":{"Amount":540054,"Currency":"EUR"}}}`,
+		`{"type":"car","id":"car1","record":{"Color":"blue","ID":"car1","OwnerID":"person1"}}`,
+		`{"type":"malfunction","id":"car1/m1","carID":"car1","record":{"ID":"m1","Status":"open"}}`,
+		`{"type":"malfunction","id":"car1/m2","carID":"car1","record":{"ID":"m2","Status":"open"}}`,
+	} {
+		if lines[i+1] != want {
+			t.Errorf("line %d: expected %s, got %s", i+2, want, lines[i+1])
+		}
+	}
+
+	setLedger(contract,
+		`[{"ID":"person1","AmountOfMoneyOwned":{"Amount":540054,"Currency":"EUR"},"FirstName":"Petar"}]`,
+		`[{"ID":"car1","Color":"blue","OwnerID":"person1","MalfunctionList":[{"ID":"m1","Status":"open"},{"ID":"m2","Status":"open"}]}]`)
+	code, stdout, stderr := runWithContract(contract, "export", "--verify", snapshotPath)
+	if code != exitOK {
+		t.Fatalf("expected no drift, got exit code %d: %s%s", code, stdout, stderr)
+	}
+
+	contract.height = 45
+	setLedger(contract,
+		`[{"ID":"person1","FirstName":"Petar","AmountOfMoneyOwned":{"Amount":290054,"Currency":"EUR"}},{"ID":"person4","FirstName":"Ana"}]`,
+		`[{"ID":"car1","Color":"red","OwnerID":"person1","MalfunctionList":[{"ID":"m1","Status":"repaired"}]}]`)
+	code, stdout, stderr = runWithContract(contract, "--output", "json", "export", "--verify", snapshotPath)
+	if code != exitTransactionFailed || !strings.Contains(stderr, "5 records drifted since the snapshot at block height 42") {
+		t.Fatalf("expected exit code %d with the drift, got %d: %s", exitTransactionFailed, code, stderr)
+	}
+
+	var verification snapshotVerification
+	err = json.Unmarshal([]byte(stdout), &verification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.SnapshotBlockHeight != 42 || verification.BlockHeight != 45 || verification.Records != 4 {
+		t.Errorf("unexpected verification %+v", verification)
+	}
+
+	var drift []string
+	for _, record := range verification.Drift {
+		drift = append(drift, strings.TrimSpace(record.Type+" "+record.ID+" "+record.Change+" "+strings.Join(record.Fields, ",")))
+	}
+	want := []string{
+		"car car1 changed Color",
+		"malfunction car1/m1 changed Status",
+		"malfunction car1/m2 removed",
+		"person person1 changed AmountOfMoneyOwned",
+		"person person4 added",
+	}
+	if strings.Join(drift, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected drift\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(drift, "\n"))
+	}
+}
@@ -9,14 +9,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-gateway/pkg/client"
@@ -41,36 +42,33 @@ type AppConfig struct {
 	Orgs          map[string]OrgConfig `json:"orgs"`
 	ChannelName   string               `json:"channelName"`
 	ChaincodeName string               `json:"chaincodeName"`
+	// Retry is optional, defaultRetryConfig is used without it.
+	Retry *RetryConfig `json:"retry"`
 }
 
 type OrgConfig struct {
-	MspID        string `json:"mspID"`
-	CertPath     string `json:"certPath"`
-	KeyPath      string `json:"keyPath"`
-	TlsCertPath  string `json:"tlsCertPath"`
-	PeerEndpoint string `json:"peerEndpoint"`
-	GatewayPeer  string `json:"gatewayPeer"`
+	MspID string `json:"mspID"`
+	// Identities are the enrolled identities of the organization by name, e.g. User1
+	// and Admin. CertPath and KeyPath configure a single identity named "default" in
+	// older config files.
+	Identities      map[string]IdentityConfig `json:"identities"`
+	DefaultIdentity string                    `json:"defaultIdentity"`
+	CertPath        string                    `json:"certPath"`
+	KeyPath         string                    `json:"keyPath"`
+	TlsCertPath     string                    `json:"tlsCertPath"`
+	PeerEndpoint    string                    `json:"peerEndpoint"`
+	GatewayPeer     string                    `json:"gatewayPeer"`
 }
 
+// pageSize is the number of records fetched per page by the paginated queries.
+const pageSize = 5
+
 var now = time.Now()
 var assetId = fmt.Sprintf("asset%d", now.Unix()*1e3+int64(now.Nanosecond())/1e6)
 
-func main() {
-	log.Println("============ application-golang starts ============")
-
-	configJSON, err := os.Open("app_config.json")
-	if err != nil {
-		panic(fmt.Errorf("could not open the config file"))
-	}
-	defer configJSON.Close()
-
-	byteConfig, _ := ioutil.ReadAll(configJSON)
-	var appConfig AppConfig
-	json.Unmarshal(byteConfig, &appConfig)
-	orgsConfig := appConfig.Orgs
-
-	var orgConfig OrgConfig
-
+// chooseOrganization asks which organization the shell should act for, as the
+// application did before it could be started with --org.
+func chooseOrganization() string {
 	fmt.Println("Choose your organization by entering a number:")
 	fmt.Println("1 - org1\n2 - org2\n3 - org3")
 	fmt.Println("Anything else defaults to 1")
@@ -79,41 +77,23 @@ func main() {
 
 	switch orgOption {
 	case 2:
-		orgConfig = orgsConfig["org2"]
+		return "org2"
 	case 3:
-		orgConfig = orgsConfig["org3"]
+		return "org3"
 	default:
-		orgConfig = orgsConfig["org1"]
+		return "org1"
 	}
+}
 
-	channelName := appConfig.ChannelName
-	chaincodeName := appConfig.ChaincodeName
-
-	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection(orgConfig.TlsCertPath, orgConfig.GatewayPeer, orgConfig.PeerEndpoint)
-	defer clientConnection.Close()
-
-	id := newIdentity(orgConfig.CertPath, orgConfig.MspID)
-	sign := newSign(orgConfig.KeyPath)
-
-	// Create a Gateway connection for a specific client identity
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
-	if err != nil {
-		panic(err)
-	}
-	defer gateway.Close()
+// runShell runs the interactive menu until the user chooses to exit. The identity of the
+// connection can be switched from the menu, so that a single session can act as the
+// buyer and the seller.
+func runShell(connection *gatewayConnection, wallet *wallet, chaincodeName string) {
+	log.Println("============ application-golang starts ============")
 
-	network := gateway.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	// Submitted transactions are not waited for, their commit status is printed when it
+	// arrives and can be looked up with option 28.
+	tracker := newCommitTracker(commitStatusTimeout, printCommitStatus)
 
 	var option int
 
@@ -130,8 +110,28 @@ loop:
 		fmt.Println("7 - Change car color")
 		fmt.Println("8 - Repair car")
 		fmt.Println("9 - Exit")
+		fmt.Println("10 - Migrate money fields to minor units")
+		fmt.Println("11 - Migrate assets to typed keys")
+		fmt.Println("12 - Show my client identity")
+		fmt.Println("13 - Link person to client identity")
+		fmt.Println("14 - Offer car for sale")
+		fmt.Println("15 - Accept sale offer")
+		fmt.Println("16 - Settle sale offer")
+		fmt.Println("17 - Cancel sale offer")
+		fmt.Println("18 - Read sale offer")
+		fmt.Println("19 - Get car history")
+		fmt.Println("20 - Query cars (CouchDB only)")
+		fmt.Println("21 - Repair a single malfunction")
+		fmt.Println("22 - List open malfunctions")
+		fmt.Println("23 - Get written-off cars of an owner")
+		fmt.Println("24 - Settle write-off payout (insurer only)")
+		fmt.Println("25 - Listen for chaincode events")
+		fmt.Println("26 - Who am I")
+		fmt.Println("27 - Switch identity")
+		fmt.Println("28 - Transaction status")
 
 		fmt.Scanf("%d", &option)
+		contract, network := connection.contract, connection.network
 
 		switch option {
 		case 0:
@@ -154,7 +154,7 @@ loop:
 			fmt.Printf("Enter car color: ")
 			var color string
 			fmt.Scanf("%s", &color)
-			getCarsByColor(contract, color)
+			getCarsByColor(contract, color, bufio.NewScanner(os.Stdin))
 
 		case 4:
 			fmt.Printf("Enter car color: ")
@@ -164,7 +164,7 @@ loop:
 			fmt.Printf("Enter car owner: ")
 			var ownerID string
 			fmt.Scanf("%s", &ownerID)
-			getCarsByColorAndOwner(contract, color, ownerID)
+			getCarsByColorAndOwner(contract, color, ownerID, bufio.NewScanner(os.Stdin))
 
 		case 5:
 			fmt.Printf("Enter car ID: ")
@@ -185,7 +185,7 @@ loop:
 				acceptMalfunctionedBool = true
 			}
 
-			transferCarAsset(contract, carID, newOwnerID, acceptMalfunctionedBool)
+			transferCarAsset(contract, tracker, carID, newOwnerID, acceptMalfunctionedBool)
 
 		case 6:
 			fmt.Printf("Enter car ID: ")
@@ -199,10 +199,17 @@ loop:
 				description = scanner.Text()
 			}
 
-			fmt.Printf("Enter malfunction repair price: ")
-			var repairPrice float32
-			fmt.Scanf("%f", &repairPrice)
-			addCarMalfunction(contract, carID, description, repairPrice)
+			fmt.Printf("Enter malfunction repair price (e.g. 49.99 EUR): ")
+			var repairPrice string
+			if scanner.Scan() {
+				repairPrice = scanner.Text()
+			}
+			fmt.Printf("Enter malfunction severity (minor/major/critical): ")
+			var severity string
+			if scanner.Scan() {
+				severity = strings.TrimSpace(scanner.Text())
+			}
+			addCarMalfunction(contract, tracker, carID, description, repairPrice, severity)
 
 		case 7:
 			fmt.Printf("Enter car ID: ")
@@ -212,20 +219,221 @@ loop:
 			fmt.Printf("Enter new car color: ")
 			var newColor string
 			fmt.Scanf("%s", &newColor)
-			changeCarColor(contract, carID, newColor)
+			changeCarColor(contract, tracker, carID, newColor)
 
 		case 8:
 			fmt.Printf("Enter car ID: ")
 			var carID string
 			fmt.Scanf("%s", &carID)
-			repairCar(contract, carID)
+			repairCar(contract, tracker, carID)
 
 		case 9:
+			if pending := tracker.countPending(); pending > 0 {
+				fmt.Printf("Waiting for the commit status of %d submitted transactions...\n", pending)
+				tracker.wait()
+			}
 			fmt.Printf("Exiting...")
 			break loop
 
+		case 10:
+			fmt.Println("Migrating money fields...")
+			migrateMoneyToMinorUnits(contract, tracker)
+
+		case 11:
+			fmt.Println("Migrating asset keys...")
+			migrateToTypedKeys(contract)
+
+		case 12:
+			getSubmittingClientIdentity(contract)
+
+		case 13:
+			fmt.Printf("Enter person ID: ")
+			var personID string
+			fmt.Scanf("%s", &personID)
+
+			fmt.Println("Enter client identity (as shown by option 12):")
+			var clientID string
+			scanner := bufio.NewScanner(os.Stdin)
+			if scanner.Scan() {
+				clientID = scanner.Text()
+			}
+
+			fmt.Printf("Enter client MSP ID: ")
+			var mspID string
+			if scanner.Scan() {
+				mspID = scanner.Text()
+			}
+			linkPersonIdentity(contract, tracker, personID, clientID, mspID)
+
+		case 14:
+			fmt.Printf("Enter offer ID: ")
+			var offerID string
+			fmt.Scanf("%s", &offerID)
+
+			fmt.Printf("Enter car ID: ")
+			var carID string
+			fmt.Scanf("%s", &carID)
+
+			fmt.Printf("Enter buyer ID (leave empty to offer the car to anyone): ")
+			var buyerID string
+			scanner := bufio.NewScanner(os.Stdin)
+			if scanner.Scan() {
+				buyerID = scanner.Text()
+			}
+
+			fmt.Printf("Enter asking price (e.g. 2500.00 EUR): ")
+			var askingPrice string
+			if scanner.Scan() {
+				askingPrice = scanner.Text()
+			}
+
+			fmt.Printf("Enter how many seconds the offer is valid for: ")
+			var validForSeconds int
+			if scanner.Scan() {
+				validForSeconds, _ = strconv.Atoi(scanner.Text())
+			}
+			createSaleOffer(contract, tracker, offerID, carID, buyerID, askingPrice, validForSeconds)
+
+		case 15:
+			fmt.Printf("Enter offer ID: ")
+			var offerID string
+			fmt.Scanf("%s", &offerID)
+
+			fmt.Printf("Enter buyer ID: ")
+			var buyerID string
+			fmt.Scanf("%s", &buyerID)
+
+			fmt.Printf("Does the buyer accept malfunctioned car, with a price compensation? (Y/n): ")
+			var acceptMalfunctionedStr string
+			fmt.Scanf("%s", &acceptMalfunctionedStr)
+			acceptSaleOffer(contract, tracker, offerID, buyerID, acceptMalfunctionedStr != "n")
+
+		case 16:
+			fmt.Printf("Enter offer ID: ")
+			var offerID string
+			fmt.Scanf("%s", &offerID)
+			submitSaleOfferStep(contract, tracker, "SettleSaleOffer", offerID)
+
+		case 17:
+			fmt.Printf("Enter offer ID: ")
+			var offerID string
+			fmt.Scanf("%s", &offerID)
+			submitSaleOfferStep(contract, tracker, "CancelSaleOffer", offerID)
+
+		case 18:
+			fmt.Printf("Enter offer ID: ")
+			var offerID string
+			fmt.Scanf("%s", &offerID)
+			readSaleOffer(contract, offerID)
+
+		case 19:
+			fmt.Printf("Enter car ID: ")
+			var carID string
+			fmt.Scanf("%s", &carID)
+			getCarHistory(contract, carID)
+
+		case 20:
+			fmt.Println("Enter the filter values, or leave them empty to match any car.")
+			scanner := bufio.NewScanner(os.Stdin)
+			prompt := func(label string) string {
+				fmt.Printf("%s: ", label)
+				if scanner.Scan() {
+					return strings.TrimSpace(scanner.Text())
+				}
+				return ""
+			}
+
+			filter := map[string]interface{}{}
+			for _, field := range []struct{ key, label string }{
+				{"brand", "Brand"},
+				{"model", "Model"},
+				{"ownerID", "Owner ID"},
+				{"minPrice", "Minimum price (e.g. 1000.00 EUR)"},
+				{"maxPrice", "Maximum price (e.g. 5000.00 EUR)"},
+				{"status", "Status (active or writtenOff)"},
+			} {
+				if value := prompt(field.label); value != "" {
+					filter[field.key] = value
+				}
+			}
+			for _, field := range []struct{ key, label string }{
+				{"minYear", "Minimum year"},
+				{"maxYear", "Maximum year"},
+			} {
+				if value, err := strconv.Atoi(prompt(field.label)); err == nil {
+					filter[field.key] = value
+				}
+			}
+			switch prompt("Has malfunctions? (y/n)") {
+			case "y":
+				filter["hasMalfunctions"] = true
+			case "n":
+				filter["hasMalfunctions"] = false
+			}
+
+			filterJSON, _ := json.Marshal(filter)
+			queryCars(contract, string(filterJSON), scanner)
+
+		case 21:
+			fmt.Printf("Enter car ID: ")
+			var carID string
+			fmt.Scanf("%s", &carID)
+
+			fmt.Printf("Enter malfunction ID: ")
+			var malfunctionID string
+			fmt.Scanf("%s", &malfunctionID)
+			repairMalfunction(contract, tracker, carID, malfunctionID)
+
+		case 22:
+			fmt.Printf("Enter car ID: ")
+			var carID string
+			fmt.Scanf("%s", &carID)
+			listOpenMalfunctions(contract, carID)
+
+		case 23:
+			fmt.Printf("Enter owner ID: ")
+			var ownerID string
+			fmt.Scanf("%s", &ownerID)
+			getWrittenOffCars(contract, ownerID)
+
+		case 24:
+			fmt.Printf("Enter car ID: ")
+			var carID string
+			fmt.Scanf("%s", &carID)
+
+			fmt.Printf("Enter payout (e.g. 1500.00 EUR): ")
+			var payout string
+			fmt.Scanf("%s", &payout)
+			settleWriteOffPayout(contract, tracker, carID, payout)
+
+		case 25:
+			scanner := bufio.NewScanner(os.Stdin)
+			fmt.Printf("Enter the block number to resume from, or leave it empty to listen for new events only: ")
+			scanner.Scan()
+			startBlock := strings.TrimSpace(scanner.Text())
+			listenForChaincodeEvents(network, chaincodeName, startBlock, scanner)
+
+		case 26:
+			whoAmI(connection.identity)
+
+		case 27:
+			fmt.Println("Identities in the wallet:")
+			for _, identity := range wallet.list() {
+				fmt.Printf("  %s (%s)\n", identity.label(), identity.mspID)
+			}
+			fmt.Printf("Enter the identity to switch to, e.g. org2/User1: ")
+			var reference string
+			fmt.Scanf("%s", &reference)
+			switchIdentity(connection, wallet, reference)
+
+		case 28:
+			fmt.Printf("Enter transaction ID, or leave it empty to list the transactions submitted in this session: ")
+			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Scan()
+			showTransactionStatus(contract, tracker, strings.TrimSpace(scanner.Text()))
+
 		default:
-			fmt.Printf("Invalid input! Please enter a number in the range [1, 9]!")
+			fmt.Printf("Invalid input! Please enter a number in the range [0, 28]!")
 		}
 
 		fmt.Printf("\n\n")
@@ -234,11 +442,127 @@ loop:
 	log.Println("============ application-golang ends ============")
 }
 
+// loadAppConfig reads the application configuration from the given JSON file. Unknown
+// fields are rejected, so that misspelled settings are not silently ignored.
+func loadAppConfig(configPath string) (*AppConfig, error) {
+	byteConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the config file: %w", err)
+	}
+
+	var appConfig AppConfig
+	decoder := json.NewDecoder(bytes.NewReader(byteConfig))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&appConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the config file %s: %w", configPath, err)
+	}
+
+	err = appConfig.validate()
+	if err != nil {
+		return nil, fmt.Errorf("the config file %s is invalid, %w", configPath, err)
+	}
+
+	return &appConfig, nil
+}
+
+// gatewayConnection is a Gateway connection of one client identity, together with the
+// network and contract the application uses. The identity can be switched without
+// restarting the application.
+type gatewayConnection struct {
+	appConfig *AppConfig
+	// clientConnections are the gRPC connections to the gateway peers by organization,
+	// shared by all identities of the organization.
+	clientConnections map[string]*grpc.ClientConn
+	identity          walletIdentity
+	gateway           *client.Gateway
+	network           *client.Network
+	// contract retries transactions that failed for a transient reason.
+	contract contractInvoker
+}
+
+// connect creates a Gateway connection for the given identity to the peer of its
+// organization.
+func connect(appConfig *AppConfig, identity walletIdentity) (*gatewayConnection, error) {
+	connection := &gatewayConnection{appConfig: appConfig, clientConnections: map[string]*grpc.ClientConn{}}
+
+	err := connection.switchIdentity(identity)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	return connection, nil
+}
+
+// switchIdentity replaces the Gateway connection with one for the given identity. The
+// current connection is kept if the new one cannot be created.
+func (c *gatewayConnection) switchIdentity(walletID walletIdentity) error {
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, ok := c.clientConnections[walletID.org]
+	if !ok {
+		orgConfig := c.appConfig.Orgs[walletID.org]
+
+		var err error
+		clientConnection, err = newGrpcConnection(orgConfig.TlsCertPath, orgConfig.GatewayPeer, orgConfig.PeerEndpoint)
+		if err != nil {
+			return err
+		}
+		c.clientConnections[walletID.org] = clientConnection
+	}
+
+	id, err := newIdentity(walletID.certPath, walletID.mspID)
+	if err != nil {
+		return err
+	}
+
+	sign, err := newSign(walletID.certPath, walletID.keyPath)
+	if err != nil {
+		return err
+	}
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(clientConnection),
+		// Default timeouts for different gRPC calls
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(1*time.Minute),
+	)
+	if err != nil {
+		return err
+	}
+
+	if c.gateway != nil {
+		c.gateway.Close()
+	}
+
+	c.identity = walletID
+	c.gateway = gateway
+	c.network = gateway.GetNetwork(c.appConfig.ChannelName)
+	contract := &gatewayContract{Contract: c.network.GetContract(c.appConfig.ChaincodeName), gateway: gateway, channelName: c.appConfig.ChannelName}
+	c.contract = newRetryingContract(contract, c.appConfig.retryPolicy())
+
+	return nil
+}
+
+func (c *gatewayConnection) Close() {
+	if c.gateway != nil {
+		c.gateway.Close()
+	}
+	for _, clientConnection := range c.clientConnections {
+		clientConnection.Close()
+	}
+}
+
 // newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection(tlsCertPath string, gatewayPeer string, peerEndpoint string) *grpc.ClientConn {
+func newGrpcConnection(tlsCertPath string, gatewayPeer string, peerEndpoint string) (*grpc.ClientConn, error) {
 	certificate, err := loadCertificate(tlsCertPath)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	certPool := x509.NewCertPool()
@@ -247,25 +571,20 @@ func newGrpcConnection(tlsCertPath string, gatewayPeer string, peerEndpoint stri
 
 	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
 	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+		return nil, fmt.Errorf("failed to create gRPC connection: %w", err)
 	}
 
-	return connection
+	return connection, nil
 }
 
 // newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity(certPath string, mspID string) *identity.X509Identity {
+func newIdentity(certPath string, mspID string) (*identity.X509Identity, error) {
 	certificate, err := loadCertificate(certPath)
 	if err != nil {
-		panic(err)
-	}
-
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return id
+	return identity.NewX509Identity(mspID, certificate)
 }
 
 func loadCertificate(filename string) (*x509.Certificate, error) {
@@ -277,52 +596,50 @@ func loadCertificate(filename string) (*x509.Certificate, error) {
 }
 
 // newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign(keyPath string) identity.Sign {
-	files, err := ioutil.ReadDir(keyPath)
+func newSign(certPath string, keyPath string) (identity.Sign, error) {
+	certificate, err := loadCertificate(certPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read private key directory: %w", err))
+		return nil, err
 	}
-	privateKeyPEM, err := ioutil.ReadFile(path.Join(keyPath, files[0].Name()))
 
+	keyFile, err := privateKeyFile(keyPath, certificate)
 	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
+		return nil, err
 	}
+	privateKeyPEM, err := ioutil.ReadFile(keyFile)
 
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
 	}
 
-	sign, err := identity.NewPrivateKeySign(privateKey)
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return sign
+	return identity.NewPrivateKeySign(privateKey)
 }
 
 /*
  This type of transaction would typically only be run once by an application the first time it was started after its
  initial deployment. A new version of the chaincode deployed later would likely not need to run an "init" function.
 */
-func initLedger(contract *client.Contract) {
+func initLedger(contract contractInvoker) {
 	fmt.Printf("Submit Transaction: InitLedger, function creates the initial set of assets on the ledger \n")
 
-	_, err := contract.SubmitTransaction("InitLedger")
+	_, err := submitSalted(contract, "InitLedger")
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to submit transaction: %w", err))
+		fmt.Println(newTransactionError("submit", "InitLedger", err))
 		return
 	}
-
-	fmt.Printf("*** Transaction committed successfully\n")
 }
 
-func readPersonAsset(contract *client.Contract, id string) {
+func readPersonAsset(contract contractInvoker, id string) {
 	fmt.Printf("Evaluate Transaction: ReadPersonAsset, function returns person asset attributes\n")
 
 	evaluateResult, err := contract.EvaluateTransaction("ReadPersonAsset", id)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to evaluate transaction: %w", err))
+		fmt.Println(newTransactionError("evaluate", "ReadPersonAsset", err))
 		return
 	}
 	result := formatJSON(evaluateResult)
@@ -330,12 +647,12 @@ func readPersonAsset(contract *client.Contract, id string) {
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func readCarAsset(contract *client.Contract, id string) {
+func readCarAsset(contract contractInvoker, id string) {
 	fmt.Printf("Evaluate Transaction: ReadCarAsset, function returns car asset attributes\n")
 
 	evaluateResult, err := contract.EvaluateTransaction("ReadCarAsset", id)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to evaluate transaction: %w", err))
+		fmt.Println(newTransactionError("evaluate", "ReadCarAsset", err))
 		return
 	}
 	result := formatJSON(evaluateResult)
@@ -343,12 +660,252 @@ func readCarAsset(contract *client.Contract, id string) {
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func getCarsByColor(contract *client.Contract, color string) {
-	fmt.Println("Evaluate Transaction: GetCarsByColor, function returns all the cars with the given color")
+func getCarsByColor(contract contractInvoker, color string, scanner *bufio.Scanner) {
+	fmt.Println("Evaluate Transaction: GetCarsByColorWithPagination, function returns the cars with the given color page by page")
+
+	pageThrough(scanner, "GetCarsByColorWithPagination", func(bookmark string) ([]byte, error) {
+		return contract.EvaluateTransaction("GetCarsByColorWithPagination", color, strconv.Itoa(pageSize), bookmark)
+	})
+}
+
+func getCarsByColorAndOwner(contract contractInvoker, color string, ownerID string, scanner *bufio.Scanner) {
+	fmt.Println("Evaluate Transaction: GetCarsByColorAndOwnerWithPagination, function returns the cars with the given color and owner page by page")
+
+	pageThrough(scanner, "GetCarsByColorAndOwnerWithPagination", func(bookmark string) ([]byte, error) {
+		return contract.EvaluateTransaction("GetCarsByColorAndOwnerWithPagination", color, ownerID, strconv.Itoa(pageSize), bookmark)
+	})
+}
+
+func transferCarAsset(contract contractInvoker, tracker *commitTracker, id string, newOwner string, acceptMalfunction bool) {
+	fmt.Printf("Submit Transaction: TransferCarAsset, change car owner \n")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetCarsByColor", color)
+	_, err := submitInBackground(contract, tracker, "TransferCarAsset", id, newOwner, strconv.FormatBool(acceptMalfunction))
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to evaluate transaction: %w", err))
+		fmt.Println(newTransactionError("submit", "TransferCarAsset", err))
+		return
+	}
+}
+
+func addCarMalfunction(contract contractInvoker, tracker *commitTracker, id string, description string, repairPrice string, severity string) {
+	fmt.Printf("Submit Transaction: AddCarMalfunction, record a new car malfunction \n")
+
+	_, err := submitInBackground(contract, tracker, "AddCarMalfunction", id, description, repairPrice, severity)
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "AddCarMalfunction", err))
+		return
+	}
+}
+
+func changeCarColor(contract contractInvoker, tracker *commitTracker, id string, newColor string) {
+	fmt.Printf("Submit Transaction: ChangeCarColor, change the color of a car \n")
+
+	_, err := submitInBackground(contract, tracker, "ChangeCarColor", id, newColor)
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "ChangeCarColor", err))
+		return
+	}
+}
+
+func repairCar(contract contractInvoker, tracker *commitTracker, id string) {
+	fmt.Printf("Submit Transaction: RepairCar, fix all of the car's malfunctions \n")
+
+	_, err := submitInBackground(contract, tracker, "RepairCar", id)
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "RepairCar", err))
+		return
+	}
+}
+
+func migrateMoneyToMinorUnits(contract contractInvoker, tracker *commitTracker) {
+	fmt.Printf("Submit Transaction: MigrateMoneyToMinorUnits, convert float prices and balances to minor units \n")
+
+	submitResult, err := submitInBackground(contract, tracker, "MigrateMoneyToMinorUnits")
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "MigrateMoneyToMinorUnits", err))
+		return
+	}
+
+	fmt.Printf("*** %s records are migrated once the transaction is committed\n", string(submitResult))
+}
+
+func migrateToTypedKeys(contract contractInvoker) {
+	fmt.Printf("Submit Transaction: MigrateToTypedKeys, move cars and persons from bare IDs to typed keys \n")
+
+	submitResult, err := submitSalted(contract, "MigrateToTypedKeys")
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "MigrateToTypedKeys", err))
+		return
+	}
+
+	fmt.Printf("*** %s records are migrated\n", string(submitResult))
+}
+
+// submitSalted submits a transaction that stores the private details of persons with a
+// new random salt for them. It waits for the commit, as the transient data the salt is
+// passed in cannot be submitted in the background.
+func submitSalted(contract contractInvoker, transactionName string) ([]byte, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	return contract.SubmitWithTransient(transactionName, map[string][]byte{transientSaltKey: []byte(salt)})
+}
+
+func getSubmittingClientIdentity(contract contractInvoker) {
+	fmt.Printf("Evaluate Transaction: GetSubmittingClientIdentity, function returns the identity of this client\n")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetSubmittingClientIdentity")
+	if err != nil {
+		fmt.Println(newTransactionError("evaluate", "GetSubmittingClientIdentity", err))
+		return
+	}
+
+	fmt.Printf("*** Result:%s\n", string(evaluateResult))
+}
+
+func whoAmI(identity walletIdentity) {
+	description, err := identity.describe()
+	if err != nil {
+		fmt.Println(fmt.Errorf("failed to read identity %s: %w", identity.label(), err))
+		return
+	}
+
+	fmt.Printf("*** Identity: %s\n", description.Identity)
+	fmt.Printf("*** MSP ID: %s\n", description.MSPID)
+	fmt.Printf("*** Subject: %s\n", description.Subject)
+	fmt.Printf("*** Certificate: %s\n", description.CertPath)
+	fmt.Printf("*** Private key: %s\n", description.KeyPath)
+}
+
+func switchIdentity(connection *gatewayConnection, wallet *wallet, reference string) {
+	identity, err := wallet.find(connection.identity.org, reference)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = connection.switchIdentity(identity)
+	if err != nil {
+		fmt.Println(fmt.Errorf("failed to switch to %s: %w", identity.label(), err))
+		return
+	}
+
+	fmt.Printf("*** Now acting as %s\n", identity.label())
+}
+
+// submitInBackground submits the transaction without waiting for it to be committed, like
+// transferAssetAsync in the asset-transfer-basic application. The result of the
+// endorsement is returned straight away and the commit status is printed when it arrives.
+func submitInBackground(contract contractInvoker, tracker *commitTracker, transactionName string, args ...string) ([]byte, error) {
+	submitted, err := contract.SubmitAsync(transactionName, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("*** Submitted transaction %s, waiting for its commit status in the background (option 28)\n", submitted.transactionID)
+	tracker.track(transactionName, submitted)
+
+	return submitted.result, nil
+}
+
+// printCommitStatus is called by the commit tracker of the shell when the commit status
+// of a transaction is known.
+func printCommitStatus(status transactionStatus) {
+	switch status.Status {
+	case statusCommitted:
+		fmt.Printf("\n<-- Transaction %s (%s) committed in block %d\n", status.TransactionID, status.Transaction, status.BlockNumber)
+	case statusInvalid:
+		fmt.Printf("\n<-- Transaction %s (%s) was marked invalid in block %d with status %s, it did not change the ledger\n", status.TransactionID, status.Transaction, status.BlockNumber, status.Code)
+	case statusPending:
+		fmt.Printf("\n<-- Transaction %s (%s) is not committed after %s, check it again with option 28\n", status.TransactionID, status.Transaction, commitStatusTimeout)
+	default:
+		fmt.Printf("\n<-- Failed to get the commit status of transaction %s (%s): %s\n", status.TransactionID, status.Transaction, status.Error)
+	}
+}
+
+// showTransactionStatus prints the status of the transactions submitted in this session,
+// or of the given transaction, which may also have been submitted by another client.
+func showTransactionStatus(contract contractInvoker, tracker *commitTracker, transactionID string) {
+	var statuses []transactionStatus
+	if transactionID == "" {
+		statuses = tracker.list()
+	} else if status, ok := tracker.get(transactionID); ok {
+		statuses = []transactionStatus{status}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), statusLookupTimeout)
+		defer cancel()
+
+		status, err := contract.CommitStatus(ctx, transactionID)
+		statuses = []transactionStatus{newTransactionStatus(transactionID, status, err)}
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("*** No transactions were submitted in this session")
+		return
+	}
+
+	result, _ := json.Marshal(statuses)
+	err := writeTable(os.Stdout, result)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func linkPersonIdentity(contract contractInvoker, tracker *commitTracker, personID string, clientID string, mspID string) {
+	fmt.Printf("Submit Transaction: LinkPersonIdentity, link a person to a client identity \n")
+
+	_, err := submitInBackground(contract, tracker, "LinkPersonIdentity", personID, clientID, mspID)
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "LinkPersonIdentity", err))
+		return
+	}
+}
+
+func createSaleOffer(contract contractInvoker, tracker *commitTracker, offerID string, carID string, buyerID string, askingPrice string, validForSeconds int) {
+	fmt.Printf("Submit Transaction: CreateSaleOffer, offer a car for sale \n")
+
+	submitResult, err := submitInBackground(contract, tracker, "CreateSaleOffer", offerID, carID, buyerID, askingPrice, strconv.Itoa(validForSeconds))
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "CreateSaleOffer", err))
+		return
+	}
+
+	fmt.Printf("*** Result:%s\n", formatJSON(submitResult))
+}
+
+func acceptSaleOffer(contract contractInvoker, tracker *commitTracker, offerID string, buyerID string, acceptMalfunction bool) {
+	fmt.Printf("Submit Transaction: AcceptSaleOffer, accept an offer as the buyer \n")
+
+	submitResult, err := submitInBackground(contract, tracker, "AcceptSaleOffer", offerID, buyerID, strconv.FormatBool(acceptMalfunction))
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "AcceptSaleOffer", err))
+		return
+	}
+
+	fmt.Printf("*** Result:%s\n", formatJSON(submitResult))
+}
+
+// submitSaleOfferStep submits the offer transactions that only take the offer ID,
+// i.e. SettleSaleOffer and CancelSaleOffer.
+func submitSaleOfferStep(contract contractInvoker, tracker *commitTracker, transactionName string, offerID string) {
+	fmt.Printf("Submit Transaction: %s\n", transactionName)
+
+	submitResult, err := submitInBackground(contract, tracker, transactionName, offerID)
+	if err != nil {
+		fmt.Println(newTransactionError("submit", transactionName, err))
+		return
+	}
+
+	fmt.Printf("*** Result:%s\n", formatJSON(submitResult))
+}
+
+func readSaleOffer(contract contractInvoker, offerID string) {
+	fmt.Printf("Evaluate Transaction: ReadSaleOffer, function returns sale offer attributes\n")
+
+	evaluateResult, err := contract.EvaluateTransaction("ReadSaleOffer", offerID)
+	if err != nil {
+		fmt.Println(newTransactionError("evaluate", "ReadSaleOffer", err))
 		return
 	}
 	result := formatJSON(evaluateResult)
@@ -356,12 +913,12 @@ func getCarsByColor(contract *client.Contract, color string) {
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func getCarsByColorAndOwner(contract *client.Contract, color string, ownerID string) {
-	fmt.Println("Evaluate Transaction: GetCarsByColorAndOwner, function returns all the cars with the given color and owner")
+func getCarHistory(contract contractInvoker, id string) {
+	fmt.Printf("Evaluate Transaction: GetCarHistory, function returns every version of the car with its owner, color and malfunction changes\n")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetCarsByColorAndOwner", color, ownerID)
+	evaluateResult, err := contract.EvaluateTransaction("GetCarHistory", id)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to evaluate transaction: %w", err))
+		fmt.Println(newTransactionError("evaluate", "GetCarHistory", err))
 		return
 	}
 	result := formatJSON(evaluateResult)
@@ -369,59 +926,154 @@ func getCarsByColorAndOwner(contract *client.Contract, color string, ownerID str
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func transferCarAsset(contract *client.Contract, id string, newOwner string, acceptMalfunction bool) {
-	fmt.Printf("Submit Transaction: TransferCarAsset, change car owner \n")
+func queryCars(contract contractInvoker, filterJSON string, scanner *bufio.Scanner) {
+	fmt.Printf("Evaluate Transaction: QueryCars, function returns the cars matching the filter %s\n", filterJSON)
+
+	pageThrough(scanner, "QueryCars", func(bookmark string) ([]byte, error) {
+		return contract.EvaluateTransaction("QueryCars", filterJSON, strconv.Itoa(pageSize), bookmark)
+	})
+}
+
+// pageThrough evaluates a paginated query one page at a time, asking before fetching
+// each following page.
+func pageThrough(scanner *bufio.Scanner, transactionName string, evaluatePage func(bookmark string) ([]byte, error)) {
+	bookmark := ""
+	for {
+		evaluateResult, err := evaluatePage(bookmark)
+		if err != nil {
+			fmt.Println(newTransactionError("evaluate", transactionName, err))
+			return
+		}
+
+		var page struct {
+			FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+			Bookmark            string `json:"bookmark"`
+		}
+		if err := json.Unmarshal(evaluateResult, &page); err != nil {
+			fmt.Println(fmt.Errorf("failed to parse page: %w", err))
+			return
+		}
+
+		fmt.Printf("*** Result:%s\n", formatJSON(evaluateResult))
 
-	_, err := contract.SubmitTransaction("TransferCarAsset", id, newOwner, strconv.FormatBool(acceptMalfunction))
+		if page.FetchedRecordsCount < pageSize || page.Bookmark == "" {
+			return
+		}
+
+		fmt.Printf("Show the next page? (y/N): ")
+		if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "y" {
+			return
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+func repairMalfunction(contract contractInvoker, tracker *commitTracker, carID string, malfunctionID string) {
+	fmt.Printf("Submit Transaction: RepairMalfunction, fix a single malfunction of the car \n")
+
+	_, err := submitInBackground(contract, tracker, "RepairMalfunction", carID, malfunctionID)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to submit transaction: %w", err))
+		fmt.Println(newTransactionError("submit", "RepairMalfunction", err))
 		return
 	}
-
-	fmt.Printf("*** Transaction committed successfully\n")
 }
 
-func addCarMalfunction(contract *client.Contract, id string, description string, repairPrice float32) {
-	fmt.Printf("Submit Transaction: AddCarMalfunction, record a new car malfunction \n")
+func listOpenMalfunctions(contract contractInvoker, carID string) {
+	fmt.Printf("Evaluate Transaction: ListOpenMalfunctions, function returns the malfunctions of the car that are not repaired yet\n")
 
-	_, err := contract.SubmitTransaction("AddCarMalfunction", id, description, fmt.Sprintf("%f", repairPrice))
+	evaluateResult, err := contract.EvaluateTransaction("ListOpenMalfunctions", carID)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to submit transaction: %w", err))
+		fmt.Println(newTransactionError("evaluate", "ListOpenMalfunctions", err))
 		return
 	}
+	result := formatJSON(evaluateResult)
 
-	fmt.Printf("*** Transaction committed successfully\n")
+	fmt.Printf("*** Result:%s\n", result)
 }
 
-func changeCarColor(contract *client.Contract, id string, newColor string) {
-	fmt.Printf("Submit Transaction: ChangeCarColor, change the color of a car \n")
+func getWrittenOffCars(contract contractInvoker, ownerID string) {
+	fmt.Printf("Evaluate Transaction: GetWrittenOffCars, function returns the written-off cars of the owner\n")
 
-	_, err := contract.SubmitTransaction("ChangeCarColor", id, newColor)
+	evaluateResult, err := contract.EvaluateTransaction("GetWrittenOffCars", ownerID)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to submit transaction: %w", err))
+		fmt.Println(newTransactionError("evaluate", "GetWrittenOffCars", err))
 		return
 	}
+	result := formatJSON(evaluateResult)
 
-	fmt.Printf("*** Transaction committed successfully\n")
+	fmt.Printf("*** Result:%s\n", result)
 }
 
-func repairCar(contract *client.Contract, id string) {
-	fmt.Printf("Submit Transaction: RepairCar, fix all of the car's malfunctions \n")
+func settleWriteOffPayout(contract contractInvoker, tracker *commitTracker, carID string, payout string) {
+	fmt.Printf("Submit Transaction: SettleWriteOffPayout, record the insurance payout of a written-off car \n")
+
+	_, err := submitInBackground(contract, tracker, "SettleWriteOffPayout", carID, payout)
+	if err != nil {
+		fmt.Println(newTransactionError("submit", "SettleWriteOffPayout", err))
+		return
+	}
+}
 
-	_, err := contract.SubmitTransaction("RepairCar", id)
+// listenForChaincodeEvents prints the events emitted by the chaincode until Enter is pressed,
+// in the same way as startChaincodeEventListening in asset-transfer-events. Events are
+// replayed from startBlock when it is given, and the last block an event was received
+// from is printed at the end, so that listening can be resumed from it.
+func listenForChaincodeEvents(network *client.Network, chaincodeName string, startBlock string, scanner *bufio.Scanner) {
+	var options []client.ChaincodeEventsOption
+	if startBlock != "" {
+		blockNumber, err := strconv.ParseUint(startBlock, 10, 64)
+		if err != nil {
+			fmt.Println(fmt.Errorf("invalid block number: %w", err))
+			return
+		}
+		options = append(options, client.WithStartBlock(blockNumber))
+	}
+
+	fmt.Println("\n*** Start chaincode event listening, press Enter to stop")
+
+	// Context used for event listening
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := network.ChaincodeEvents(ctx, chaincodeName, options...)
 	if err != nil {
-		fmt.Println(fmt.Errorf("failed to submit transaction: %w", err))
+		fmt.Println(fmt.Errorf("failed to start chaincode event listening: %w", err))
 		return
 	}
 
-	fmt.Printf("*** Transaction committed successfully\n")
+	lastBlock := make(chan uint64, 1)
+	go func() {
+		var blockNumber uint64
+		received := false
+		for event := range events {
+			blockNumber = event.BlockNumber
+			received = true
+			payload := formatJSON(event.Payload)
+			fmt.Printf("\n<-- Chaincode event received from block %d, transaction %s: %s - %s\n", event.BlockNumber, event.TransactionID, event.EventName, payload)
+		}
+		if received {
+			lastBlock <- blockNumber
+		}
+		close(lastBlock)
+	}()
+
+	scanner.Scan()
+	cancel()
+
+	if blockNumber, ok := <-lastBlock; ok {
+		fmt.Printf("*** Stopped listening, resume from block %d to continue; the events of that block are received again\n", blockNumber)
+	} else {
+		fmt.Println("*** Stopped listening, no events were received")
+	}
 }
 
 //Format JSON data
+// Results that are not JSON, such as the plain strings some transactions return, are
+// returned as they are.
 func formatJSON(data []byte) string {
 	var prettyJSON bytes.Buffer
 	if err := json.Indent(&prettyJSON, data, " ", ""); err != nil {
-		panic(fmt.Errorf("failed to parse JSON: %w", err))
+		return string(data)
 	}
 	return prettyJSON.String()
 }
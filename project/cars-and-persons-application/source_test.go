@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestPageThrough(t *testing.T) {
+	fullPage := []byte(`{"records":[{"ID":"car1"},{"ID":"car2"},{"ID":"car3"},{"ID":"car4"},{"ID":"car5"}],"fetchedRecordsCount":5,"bookmark":"car5"}`)
+	lastPage := []byte(`{"records":[{"ID":"car6"}],"fetchedRecordsCount":1,"bookmark":""}`)
+
+	for _, test := range []struct {
+		name      string
+		pages     [][]byte
+		answer    string
+		bookmarks []string
+	}{
+		{name: "next page", pages: [][]byte{fullPage, lastPage}, answer: "y\n", bookmarks: []string{"", "car5"}},
+		{name: "stop", pages: [][]byte{fullPage, lastPage}, answer: "\n", bookmarks: []string{""}},
+		{name: "last page", pages: [][]byte{lastPage}, bookmarks: []string{""}},
+	} {
+		var bookmarks []string
+		pageThrough(bufio.NewScanner(strings.NewReader(test.answer)), "GetCarsByColorWithPagination", func(bookmark string) ([]byte, error) {
+			page := test.pages[len(bookmarks)]
+			bookmarks = append(bookmarks, bookmark)
+			return page, nil
+		})
+
+		if strings.Join(bookmarks, ",") != strings.Join(test.bookmarks, ",") {
+			t.Errorf("%s: expected the bookmarks %q, got %q", test.name, test.bookmarks, bookmarks)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// legacyIdentityName is the name of the identity configured by the certPath and keyPath
+// of an organization, as in config files written before organizations could have
+// several identities.
+const legacyIdentityName = "default"
+
+// IdentityConfig is an enrolled client identity of an organization.
+type IdentityConfig struct {
+	CertPath string `json:"certPath"`
+	// KeyPath is the private key file, or a keystore directory that holds the key of the
+	// certificate.
+	KeyPath string `json:"keyPath"`
+}
+
+// walletIdentity is an identity the application can sign transactions with.
+type walletIdentity struct {
+	org      string
+	name     string
+	mspID    string
+	certPath string
+	keyPath  string
+}
+
+// label names the identity together with its organization, e.g. org2/User1.
+func (i walletIdentity) label() string {
+	return i.org + "/" + i.name
+}
+
+// identityDescription is what whoami shows about an identity.
+type identityDescription struct {
+	Identity string
+	MSPID    string
+	Subject  string
+	CertPath string
+	KeyPath  string
+}
+
+// describe reads the certificate of the identity and resolves its private key file.
+func (i walletIdentity) describe() (identityDescription, error) {
+	certificate, err := loadCertificate(i.certPath)
+	if err != nil {
+		return identityDescription{}, err
+	}
+
+	keyFile, err := privateKeyFile(i.keyPath, certificate)
+	if err != nil {
+		return identityDescription{}, err
+	}
+
+	return identityDescription{
+		Identity: i.label(),
+		MSPID:    i.mspID,
+		Subject:  certificate.Subject.String(),
+		CertPath: i.certPath,
+		KeyPath:  keyFile,
+	}, nil
+}
+
+// wallet holds the enrolled identities of all organizations in the config file.
+type wallet struct {
+	orgs map[string]OrgConfig
+	// identities are keyed by their label.
+	identities map[string]walletIdentity
+}
+
+func newWallet(appConfig *AppConfig) *wallet {
+	w := &wallet{orgs: appConfig.Orgs, identities: map[string]walletIdentity{}}
+
+	for org, orgConfig := range appConfig.Orgs {
+		identities := orgConfig.Identities
+		if len(identities) == 0 && orgConfig.CertPath != "" {
+			identities = map[string]IdentityConfig{legacyIdentityName: {CertPath: orgConfig.CertPath, KeyPath: orgConfig.KeyPath}}
+		}
+
+		for name, identityConfig := range identities {
+			identity := walletIdentity{
+				org:      org,
+				name:     name,
+				mspID:    orgConfig.MspID,
+				certPath: identityConfig.CertPath,
+				keyPath:  identityConfig.KeyPath,
+			}
+			w.identities[identity.label()] = identity
+		}
+	}
+
+	return w
+}
+
+// list returns the identities sorted by their label.
+func (w *wallet) list() []walletIdentity {
+	labels := make([]string, 0, len(w.identities))
+	for label := range w.identities {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	identities := make([]walletIdentity, len(labels))
+	for i, label := range labels {
+		identities[i] = w.identities[label]
+	}
+
+	return identities
+}
+
+// find looks up an identity. The reference is either a label such as org2/Admin, the
+// name of an identity of the current organization, the name of an organization for
+// its default identity, or empty for the default identity of the current organization.
+func (w *wallet) find(currentOrg string, reference string) (walletIdentity, error) {
+	org, name := currentOrg, reference
+	if i := strings.Index(reference, "/"); i >= 0 {
+		org, name = reference[:i], reference[i+1:]
+	} else if _, ok := w.identities[currentOrg+"/"+reference]; !ok {
+		if _, ok := w.orgs[reference]; ok {
+			org, name = reference, ""
+		}
+	}
+
+	if _, ok := w.orgs[org]; !ok {
+		return walletIdentity{}, fmt.Errorf("the organization %s is not in the config file, choose one of: %s", org, strings.Join(w.orgNames(), ", "))
+	}
+
+	if name == "" {
+		return w.defaultIdentity(org)
+	}
+
+	identity, ok := w.identities[org+"/"+name]
+	if !ok {
+		return walletIdentity{}, fmt.Errorf("%s has no identity named %s, choose one of: %s", org, name, strings.Join(w.identityNames(org), ", "))
+	}
+
+	return identity, nil
+}
+
+// defaultIdentity returns the identity set as defaultIdentity of the organization, or
+// its only identity.
+func (w *wallet) defaultIdentity(org string) (walletIdentity, error) {
+	names := w.identityNames(org)
+	defaultName := w.orgs[org].DefaultIdentity
+
+	switch {
+	case defaultName != "":
+		identity, ok := w.identities[org+"/"+defaultName]
+		if !ok {
+			return walletIdentity{}, fmt.Errorf("the default identity %s of %s is not one of its identities: %s", defaultName, org, strings.Join(names, ", "))
+		}
+		return identity, nil
+
+	case len(names) == 0:
+		return walletIdentity{}, fmt.Errorf("%s has no identities, add them to its identities in the config file", org)
+
+	case len(names) > 1:
+		return walletIdentity{}, fmt.Errorf("%s has several identities (%s), choose one or set its defaultIdentity", org, strings.Join(names, ", "))
+	}
+
+	return w.identities[org+"/"+names[0]], nil
+}
+
+func (w *wallet) orgNames() []string {
+	var orgs []string
+	for org := range w.orgs {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+
+	return orgs
+}
+
+func (w *wallet) identityNames(org string) []string {
+	var names []string
+	for _, identity := range w.list() {
+		if identity.org == org {
+			names = append(names, identity.name)
+		}
+	}
+
+	return names
+}
+
+// privateKeyFile returns the private key file at keyPath. In a keystore directory, such
+// as the ones the Fabric CA client enrolls into, it picks the file that holds the key of
+// the certificate, so that a stale or foreign key in the directory is never used.
+func privateKeyFile(keyPath string, certificate *x509.Certificate) (string, error) {
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read private key: %w", err)
+	}
+	if !info.IsDir() {
+		return keyPath, nil
+	}
+
+	files, err := ioutil.ReadDir(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read private key directory: %w", err)
+	}
+
+	var names []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		names = append(names, file.Name())
+
+		keyFile := filepath.Join(keyPath, file.Name())
+		privateKeyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read private key file: %w", err)
+		}
+		if keyMatchesCertificate(privateKeyPEM, certificate) {
+			return keyFile, nil
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("the private key directory %s is empty", keyPath)
+	}
+
+	return "", fmt.Errorf("the private key directory %s holds no key of the certificate %s (%s)", keyPath, certificate.Subject, strings.Join(names, ", "))
+}
+
+// keyMatchesCertificate reports whether privateKeyPEM holds the private key of the
+// public key in the certificate. Files that are not private keys do not match.
+func keyMatchesCertificate(privateKeyPEM []byte, certificate *x509.Certificate) bool {
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+	if err != nil {
+		return false
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	publicKey, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+
+	return ok && publicKey.Equal(certificate.PublicKey)
+}
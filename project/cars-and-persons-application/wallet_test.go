@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testWallet() *wallet {
+	return newWallet(&AppConfig{Orgs: map[string]OrgConfig{
+		"org1": {
+			MspID: "Org1MSP",
+			Identities: map[string]IdentityConfig{
+				"User1": {CertPath: "user1.pem", KeyPath: "user1_sk"},
+				"Admin": {CertPath: "admin.pem", KeyPath: "admin_sk"},
+			},
+			DefaultIdentity: "User1",
+		},
+		"org2": {MspID: "Org2MSP", CertPath: "cert.pem", KeyPath: "keystore"},
+		"org3": {
+			MspID: "Org3MSP",
+			Identities: map[string]IdentityConfig{
+				"User1": {CertPath: "user1.pem", KeyPath: "user1_sk"},
+				"User2": {CertPath: "user2.pem", KeyPath: "user2_sk"},
+			},
+		},
+	}})
+}
+
+func TestWalletFind(t *testing.T) {
+	w := testWallet()
+
+	for _, test := range []struct {
+		currentOrg string
+		reference  string
+		label      string
+	}{
+		{"org1", "", "org1/User1"},
+		{"org1", "Admin", "org1/Admin"},
+		{"org1", "org3/User2", "org3/User2"},
+		{"org1", "org2", "org2/default"},
+		{"org2", "", "org2/default"},
+	} {
+		identity, err := w.find(test.currentOrg, test.reference)
+		if err != nil {
+			t.Errorf("%s %q: %v", test.currentOrg, test.reference, err)
+			continue
+		}
+		if identity.label() != test.label {
+			t.Errorf("%s %q: expected %s, got %s", test.currentOrg, test.reference, test.label, identity.label())
+		}
+	}
+
+	identity, _ := w.find("org1", "Admin")
+	if identity.mspID != "Org1MSP" || identity.keyPath != "admin_sk" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	for _, test := range []struct {
+		currentOrg string
+		reference  string
+		message    string
+	}{
+		{"org1", "Driver", "org1 has no identity named Driver, choose one of: Admin, User1"},
+		{"org1", "org4/User1", "the organization org4 is not in the config file, choose one of: org1, org2, org3"},
+		{"org3", "", "org3 has several identities (User1, User2), choose one or set its defaultIdentity"},
+	} {
+		_, err := w.find(test.currentOrg, test.reference)
+		if err == nil || err.Error() != test.message {
+			t.Errorf("%s %q: expected %q, got %v", test.currentOrg, test.reference, test.message, err)
+		}
+	}
+}
+
+func TestPrivateKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	certificate := writeTestCertificate(t, filepath.Join(dir, "user1.pem"), filepath.Join(dir, "user1_sk"), "User1@org1.example.com")
+	writeTestCertificate(t, filepath.Join(dir, "admin.pem"), filepath.Join(dir, "admin_sk"), "Admin@org1.example.com")
+	keystore := filepath.Join(dir, "keystore")
+	writeFile(t, filepath.Join(keystore, "config.yaml"), "not a key")
+
+	_, err := privateKeyFile(t.TempDir(), certificate)
+	if err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("expected an empty keystore error, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(keystore, "abc_sk"), readFile(t, filepath.Join(dir, "admin_sk")))
+
+	_, err = privateKeyFile(keystore, certificate)
+	if err == nil || !strings.Contains(err.Error(), "holds no key of the certificate CN=User1@org1.example.com (abc_sk, config.yaml)") {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+
+	keyFile := filepath.Join(keystore, "def_sk")
+	writeFile(t, keyFile, readFile(t, filepath.Join(dir, "user1_sk")))
+
+	file, err := privateKeyFile(keystore, certificate)
+	if err != nil || file != keyFile {
+		t.Errorf("expected the key of the certificate %s, got %s, %v", keyFile, file, err)
+	}
+
+	file, err = privateKeyFile(filepath.Join(dir, "admin_sk"), certificate)
+	if err != nil || file != filepath.Join(dir, "admin_sk") {
+		t.Errorf("expected the configured key file, got %s, %v", file, err)
+	}
+}
+
+func TestWhoamiCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCertificate(t, filepath.Join(dir, "admin.pem"), filepath.Join(dir, "keystore", "priv_sk"), "Admin@org1.example.com")
+	writeFile(t, filepath.Join(dir, "user1.pem"), "not used")
+
+	configPath := filepath.Join(dir, "app_config.json")
+	writeFile(t, configPath, `{"channelName":"mychannel","chaincodeName":"basic","orgs":{"org1":{"mspID":"Org1MSP",
+		"tlsCertPath":"ca.crt","peerEndpoint":"localhost:7051","gatewayPeer":"peer0.org1.example.com","defaultIdentity":"User1","identities":{
+		"User1":{"certPath":"`+filepath.Join(dir, "user1.pem")+`","keyPath":"`+filepath.Join(dir, "keystore")+`"},
+		"Admin":{"certPath":"`+filepath.Join(dir, "admin.pem")+`","keyPath":"`+filepath.Join(dir, "keystore")+`"}}}}}`)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"--config", configPath, "--identity", "Admin", "--output", "json", "whoami"}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+
+	var description identityDescription
+	err := json.Unmarshal(stdout.Bytes(), &description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := identityDescription{
+		Identity: "org1/Admin",
+		MSPID:    "Org1MSP",
+		Subject:  "CN=Admin@org1.example.com",
+		CertPath: filepath.Join(dir, "admin.pem"),
+		KeyPath:  filepath.Join(dir, "keystore", "priv_sk"),
+	}
+	if description != want {
+		t.Errorf("expected %+v, got %+v", want, description)
+	}
+
+	stdout.Reset()
+	code = run([]string{"--config", configPath, "identity", "list"}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[2]), " ") != "org1/User1 Org1MSP true" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	code = run([]string{"--config", configPath, "--identity", "Driver", "whoami"}, &stdout, &stderr)
+	if code != exitSetupFailed {
+		t.Errorf("expected exit code %d, got %d", exitSetupFailed, code)
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) {
+	err := os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(name, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate with the given common name and
+// its private key, and returns the certificate.
+func writeTestCertificate(t *testing.T, name string, keyName string, commonName string) *x509.Certificate {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, name, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER})))
+
+	privateKeyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, keyName, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyDER})))
+
+	certificate, err := x509.ParseCertificate(certificateDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func readFile(t *testing.T, name string) string {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// registryMSPID is the organization that administers the ledger: it seeds it,
+	// registers persons and cars, and links persons to client identities.
+	registryMSPID = "Org1MSP"
+	// insurerMSPID is the organization that settles payouts for written-off cars.
+	insurerMSPID = "Org3MSP"
+)
+
+// GetSubmittingClientIdentity returns the decoded X.509 identity of the caller, which
+// the registry needs in order to link a person to that client with LinkPersonIdentity.
+func (s *SmartContract) GetSubmittingClientIdentity(ctx contractapi.TransactionContextInterface) (string, error) {
+	clientID, _, err := getSubmittingClient(ctx)
+	return clientID, err
+}
+
+// LinkPersonIdentity links a person to the client that acts on their behalf. When the
+// person moves to another organization, their private details move to the collection
+// of that organization, and their cars require the endorsement of that organization
+// alone.
+func (s *SmartContract) LinkPersonIdentity(ctx contractapi.TransactionContextInterface, personID string, clientID string, mspID string) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
+
+	if clientID == "" || mspID == "" {
+		return fmt.Errorf("the client ID and MSP ID must not be empty")
+	}
+	if _, ok := personCollections[mspID]; !ok {
+		return fmt.Errorf("the organization %s has no private data collection", mspID)
+	}
+
+	personAsset, err := s.ReadPersonAsset(ctx, personID)
+	if err != nil {
+		return err
+	}
+
+	oldCollection, err := personCollection(personAsset)
+	if err != nil {
+		return err
+	}
+
+	var details *PersonPrivateDetails
+	if oldCollection != personCollections[mspID] {
+		details, err = readPersonDetails(ctx, personAsset)
+		if err != nil {
+			return err
+		}
+
+		err = deletePersonDetails(ctx, personAsset)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldOrganization := personOrganization(personAsset)
+	personAsset.ClientID = clientID
+	personAsset.MSPID = mspID
+
+	err = putPersonAsset(ctx, personAsset)
+	if err != nil {
+		return err
+	}
+
+	if oldOrganization != mspID {
+		carIDs, err := ownedCarIDs(ctx, personID)
+		if err != nil {
+			return err
+		}
+
+		for _, carID := range carIDs {
+			err = setCarEndorsement(ctx, carID, mspID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if details == nil {
+		return nil
+	}
+
+	return putPersonDetails(ctx, personAsset, details)
+}
+
+func getSubmittingClient(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read client ID: %v", err)
+	}
+	decodedID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to base64 decode client ID: %v", err)
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+
+	return string(decodedID), mspID, nil
+}
+
+func requireRegistry(ctx contractapi.TransactionContextInterface) error {
+	return requireOrganization(ctx, registryMSPID, "registry")
+}
+
+func requireInsurer(ctx contractapi.TransactionContextInterface) error {
+	return requireOrganization(ctx, insurerMSPID, "insurer")
+}
+
+func requireOrganization(ctx contractapi.TransactionContextInterface, expectedMSPID string, role string) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+	if mspID != expectedMSPID {
+		return fmt.Errorf("client from %s is not authorized to perform %s operations", mspID, role)
+	}
+
+	return nil
+}
+
+// requirePerson checks that the transaction was submitted by the client identity
+// linked to the given person.
+func requirePerson(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset) error {
+	if personAsset.ClientID == "" {
+		return fmt.Errorf("the person %s is not linked to a client identity", personAsset.ID)
+	}
+
+	clientID, mspID, err := getSubmittingClient(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID != personAsset.ClientID || mspID != personAsset.MSPID {
+		return fmt.Errorf("client is not authorized to act on behalf of %s", personAsset.ID)
+	}
+
+	return nil
+}
+
+// requireEitherPerson checks that the transaction was submitted by the client linked
+// to one of the two persons, e.g. the holder of a reservation or the owner of the car.
+func requireEitherPerson(ctx contractapi.TransactionContextInterface, first *PersonAsset, second *PersonAsset) error {
+	if requirePerson(ctx, first) == nil {
+		return nil
+	}
+
+	err := requirePerson(ctx, second)
+	if err != nil {
+		return fmt.Errorf("client is not authorized to act on behalf of %s or %s", first.ID, second.ID)
+	}
+
+	return nil
+}
+
+func (s *SmartContract) requireCarOwner(ctx contractapi.TransactionContextInterface, carAsset *CarAsset) (*PersonAsset, error) {
+	ownerAsset, err := s.ReadPersonAsset(ctx, carAsset.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requirePerson(ctx, ownerAsset)
+	if err != nil {
+		return nil, fmt.Errorf("only the owner can modify the car %s: %v", carAsset.ID, err)
+	}
+
+	return ownerAsset, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"cars-and-persons-chaincodes/mocks"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSubmittingClientIdentity(t *testing.T) {
+	transactionContext, _, _ := prepMocks(org2MSP, person2ClientID)
+	carsAndPersons := SmartContract{}
+
+	clientID, err := carsAndPersons.GetSubmittingClientIdentity(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, person2ClientID, clientID)
+
+	clientIdentity := &mocks.ClientIdentity{}
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+
+	clientIdentity.GetIDReturns("not base64!", nil)
+	_, err = carsAndPersons.GetSubmittingClientIdentity(transactionContext)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to base64 decode client ID")
+
+	clientIdentity.GetIDReturns("", fmt.Errorf("no certificate"))
+	_, err = carsAndPersons.GetSubmittingClientIdentity(transactionContext)
+	require.EqualError(t, err, "failed to read client ID: no certificate")
+}
+
+func TestLinkPersonIdentity(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.LinkPersonIdentity(transactionContext, "person3", person1ClientID, org1MSP)
+	require.NoError(t, err)
+	personAsset, err := carsAndPersons.ReadPersonAsset(transactionContext, "person3")
+	require.NoError(t, err)
+	require.Equal(t, person1ClientID, personAsset.ClientID)
+	require.Equal(t, org1MSP, personAsset.MSPID)
+
+	// The private details follow the person to the collection of the new organization.
+	require.Equal(t, newMoney(1430_22), personBalance(t, transactionContext, "person3"))
+	key, err := personKey(transactionContext, "person3")
+	require.NoError(t, err)
+	detailsJSON, err := chaincodeStub.GetPrivateData("Org3MSPPrivateCollection", key)
+	require.NoError(t, err)
+	require.Nil(t, detailsJSON)
+
+	err = carsAndPersons.LinkPersonIdentity(transactionContext, "person3", person1ClientID, "Org4MSP")
+	require.EqualError(t, err, "the organization Org4MSP has no private data collection")
+
+	err = carsAndPersons.LinkPersonIdentity(transactionContext, "person3", "", org1MSP)
+	require.EqualError(t, err, "the client ID and MSP ID must not be empty")
+
+	err = carsAndPersons.LinkPersonIdentity(transactionContext, "person9", person1ClientID, org1MSP)
+	require.EqualError(t, err, "the person asset person9 does not exist")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	err = carsAndPersons.LinkPersonIdentity(transactionContext, "person2", person3ClientID, org3MSP)
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestRequirePerson(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setPersonInput(t, chaincodeStub, "ana@pdasp.rs", "100")
+	err := carsAndPersons.CreatePersonAsset(transactionContext, "person4", "Ana", "Anic")
+	require.NoError(t, err)
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car7", "Fiat", "Punto", 2005, "grey", "person4", "1000", "ZFA18800105123456", "", "", 0)
+	require.NoError(t, err)
+
+	// Nobody can act for a person that is not linked to an identity yet.
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car7", "white")
+	require.EqualError(t, err, "only the owner can modify the car car7: the person person4 is not linked to a client identity")
+
+	// The same certificate subject from another organization is a different client.
+	setClient(transactionContext, org2MSP, person1ClientID)
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car1", "white")
+	require.EqualError(t, err, "only the owner can modify the car car1: client is not authorized to act on behalf of person1")
+}
+
+func TestRelinkPersonIdentity(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	// person1 got a new certificate, e.g. after the old one was revoked.
+	const renewedClientID = "x509::CN=petar2,OU=client::CN=ca.org1.example.com"
+	err := carsAndPersons.LinkPersonIdentity(transactionContext, "person1", renewedClientID, org1MSP)
+	require.NoError(t, err)
+
+	// The registry administers the ledger, but does not act for the persons.
+	err = carsAndPersons.RepairCar(transactionContext, "car1")
+	require.EqualError(t, err, "only the owner can modify the car car1: client is not authorized to act on behalf of person1")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car1", "white")
+	require.EqualError(t, err, "only the owner can modify the car car1: client is not authorized to act on behalf of person1")
+
+	setClient(transactionContext, org1MSP, renewedClientID)
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car1", "white")
+	require.NoError(t, err)
+	err = carsAndPersons.RepairCar(transactionContext, "car1")
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "white", carAsset.Color)
+	require.Len(t, carAsset.RepairList, 2)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CarEndorsementPolicy is the key-level endorsement policy of a car. A car is created
+// with a policy that requires the peers of its owner's organization, and every change of
+// owner requires the organizations of both the old and the new owner.
+type CarEndorsementPolicy struct {
+	CarID   string
+	OwnerID string
+	// Organizations are the MSP IDs of the organizations whose peers have to endorse
+	// changes of the car. It is empty when the car has no key-level policy, in which
+	// case the endorsement policy of the chaincode applies.
+	Organizations []string
+}
+
+// GetCarEndorsementPolicy returns the key-level endorsement policy of a car. Only the
+// registry may inspect it.
+func (s *SmartContract) GetCarEndorsementPolicy(ctx contractapi.TransactionContextInterface, id string) (*CarEndorsementPolicy, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsset, err := s.ReadCarAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := carKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := ctx.GetStub().GetStateValidationParameter(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the validation parameter of car %s: %v", id, err)
+	}
+
+	organizations := []string{}
+	if len(policy) > 0 {
+		endorsementPolicy, err := statebased.NewStateEP(policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the endorsement policy of car %s: %v", id, err)
+		}
+		organizations = endorsementPolicy.ListOrgs()
+		sort.Strings(organizations)
+	}
+
+	return &CarEndorsementPolicy{CarID: id, OwnerID: carAsset.OwnerID, Organizations: organizations}, nil
+}
+
+// personOrganization is the organization that endorses for a person: the one the person
+// is linked to, or the registry until the person is linked.
+func personOrganization(personAsset *PersonAsset) string {
+	if personAsset.MSPID == "" {
+		return registryMSPID
+	}
+
+	return personAsset.MSPID
+}
+
+// ownerOrganization reads the owner of a car and returns their organization.
+func (s *SmartContract) ownerOrganization(ctx contractapi.TransactionContextInterface, ownerID string) (string, error) {
+	owner, err := s.ReadPersonAsset(ctx, ownerID)
+	if err != nil {
+		return "", err
+	}
+
+	return personOrganization(owner), nil
+}
+
+// setCarEndorsement replaces the key-level endorsement policy of a car with one that
+// requires the peers of all the given organizations.
+func setCarEndorsement(ctx contractapi.TransactionContextInterface, carID string, orgsToEndorse ...string) error {
+	key, err := carKey(ctx, carID)
+	if err != nil {
+		return err
+	}
+
+	err = setStateBasedEndorsement(ctx, key, orgsToEndorse...)
+	if err != nil {
+		return fmt.Errorf("failed to set the endorsement policy of car %s: %v", carID, err)
+	}
+
+	return nil
+}
+
+// setStateBasedEndorsement requires the peers of all the given organizations to endorse
+// every later change of the key, whatever the endorsement policy of the chaincode is.
+func setStateBasedEndorsement(ctx contractapi.TransactionContextInterface, key string, orgsToEndorse ...string) error {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgsToEndorse...)
+	if err != nil {
+		return fmt.Errorf("failed to add orgs to endorsement policy: %v", err)
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return fmt.Errorf("failed to create endorsement policy bytes from orgs: %v", err)
+	}
+
+	return ctx.GetStub().SetStateValidationParameter(key, policy)
+}
+
+// forEachCar calls fn with every car on the ledger, written-off cars included.
+func forEachCar(ctx contractapi.TransactionContextInterface, fn func(carAsset *CarAsset) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(carKeyIndex, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var carAsset CarAsset
+		err = json.Unmarshal(queryResponse.Value, &carAsset)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&carAsset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarEndorsementPolicy(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepMocks(org1MSP, registryClientID)
+	carsAndPersons := SmartContract{}
+
+	setSaltSeed(chaincodeStub)
+	err := carsAndPersons.InitLedger(transactionContext)
+	require.NoError(t, err)
+
+	policy, err := carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, &CarEndorsementPolicy{CarID: "car5", OwnerID: "person3", Organizations: []string{org1MSP}}, policy, "the registry endorses for persons that are not linked")
+
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person2", person2ClientID, org2MSP))
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person3", person3ClientID, org3MSP))
+	policy, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, []string{org3MSP}, policy.Organizations)
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car7", "Fiat", "Punto", 2010, "red", "person2", "1500", "ZFA19900300A12345", "", "", 0)
+	require.NoError(t, err)
+	policy, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, []string{org2MSP}, policy.Organizations)
+
+	sellCar(t, transactionContext, "offer1", "car5", "person2", "5300")
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car5")
+	require.EqualError(t, err, "client from Org3MSP is not authorized to perform registry operations")
+
+	setClient(transactionContext, org1MSP, registryClientID)
+	policy, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, &CarEndorsementPolicy{CarID: "car5", OwnerID: "person2", Organizations: []string{org2MSP, org3MSP}}, policy)
+
+	_, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car9")
+	require.EqualError(t, err, "the car asset car9 does not exist")
+
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person1", person1ClientID, org1MSP))
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+	setClient(transactionContext, org1MSP, registryClientID)
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person1", person1ClientID, org2MSP))
+	for _, carID := range []string{"car1", "car3", "car4"} {
+		policy, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, carID)
+		require.NoError(t, err)
+		require.Equal(t, []string{org2MSP}, policy.Organizations, "relinking updates the owned and written-off cars")
+	}
+}
+
+func TestCarEndorsementPolicyOnFakeLedger(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	registry := ledger.as(org1MSP, registryClientID)
+	buyer := ledger.as(org2MSP, person2ClientID)
+	seller := ledger.as(org3MSP, person3ClientID)
+
+	ledger.submit(func() error {
+		_, err := carsAndPersons.CreateSaleOffer(seller, "offer1", "car5", "person2", "4000", 3600)
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.AcceptSaleOffer(buyer, "offer1", "person2", false)
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.SettleSaleOffer(seller, "offer1")
+		return err
+	})
+
+	policy, err := carsAndPersons.GetCarEndorsementPolicy(registry, "car5")
+	require.NoError(t, err)
+	require.Equal(t, []string{org2MSP, org3MSP}, policy.Organizations)
+
+	ledger.submit(func() error {
+		return carsAndPersons.LinkPersonIdentity(registry, "person2", person2ClientID, org1MSP)
+	})
+	policy, err = carsAndPersons.GetCarEndorsementPolicy(registry, "car5")
+	require.NoError(t, err)
+	require.Equal(t, []string{org1MSP}, policy.Organizations, "linking a person to another organization resets the policies of their cars")
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	escrowObjectType = "escrow"
+	escrowKeyIndex   = "escrow~ID"
+
+	escrowStatusLocked      = "locked"
+	escrowStatusTransferred = "transferred"
+	escrowStatusReleased    = "released"
+	escrowStatusRefunded    = "refunded"
+)
+
+// Escrow holds the price of a car between a buyer and a seller of different
+// organizations, so that neither has to trust the other. It is built on a sale offer
+// of the seller: the buyer accepts the offer into the escrow, which takes the price
+// from the buyer's balance, the seller transfers the car, and the buyer confirms the
+// receipt, which releases the price to the seller and settles the offer. If the seller
+// does not transfer the car before ExpiresAt, the price is refunded to the buyer and
+// the offer is cancelled; if the buyer does not confirm before ExpiresAt, the seller
+// can release it.
+//
+// Every step changes the balance of one party at most, so the peers of the other
+// organizations only have to be passed the details of that party. The key of the
+// escrow requires the endorsement of the peers of both organizations.
+type Escrow struct {
+	ObjectType string `json:"objectType"`
+	ID         string
+	OfferID    string
+	CarID      string
+	SellerID   string
+	BuyerID    string
+	Price      Money
+	Status     string
+	// TimeoutSeconds is the time the seller has to transfer the car once the price is
+	// locked, and the buyer has to confirm the receipt once the car is transferred.
+	TimeoutSeconds int
+	LockedAt       time.Time
+	ExpiresAt      time.Time
+}
+
+// LockEscrow is submitted by the buyer to accept a sale offer into a new escrow. Like
+// AcceptSaleOffer, it moves the price of the offer from the buyer's balance, but the
+// offer is then settled or cancelled through the escrow alone.
+func (s *SmartContract) LockEscrow(ctx contractapi.TransactionContextInterface, escrowID string, offerID string, buyerID string, acceptMalfunction bool, timeoutSeconds int) (*Escrow, error) {
+	if escrowID == "" {
+		return nil, fmt.Errorf("the escrow ID must not be empty")
+	}
+	if timeoutSeconds <= 0 {
+		return nil, fmt.Errorf("the escrow timeout must be a positive number of seconds")
+	}
+
+	exists, err := s.EscrowExists(ctx, escrowID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("the escrow %s already exists", escrowID)
+	}
+
+	offer, err := s.ReadSaleOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := s.ReadPersonAsset(ctx, offer.SellerID)
+	if err != nil {
+		return nil, err
+	}
+	if seller.MSPID == "" {
+		return nil, fmt.Errorf("the seller %s is not linked to an organization", seller.ID)
+	}
+
+	offer.EscrowID = escrowID
+	err = s.acceptSaleOffer(ctx, offer, buyerID, acceptMalfunction)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := s.ReadPersonAsset(ctx, buyerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	escrow := Escrow{
+		ID:             escrowID,
+		OfferID:        offerID,
+		CarID:          offer.CarID,
+		SellerID:       seller.ID,
+		BuyerID:        buyerID,
+		Price:          offer.Price,
+		Status:         escrowStatusLocked,
+		TimeoutSeconds: timeoutSeconds,
+		LockedAt:       now,
+		ExpiresAt:      now.Add(time.Duration(timeoutSeconds) * time.Second),
+	}
+
+	err = putEscrow(ctx, &escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEscrowStateBasedEndorsement(ctx, escrowID, buyer.MSPID, seller.MSPID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventEscrowLocked, EscrowLockedEvent{
+		EscrowID:  escrowID,
+		OfferID:   offerID,
+		CarID:     offer.CarID,
+		SellerID:  seller.ID,
+		BuyerID:   buyerID,
+		Price:     offer.Price,
+		ExpiresAt: escrow.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &escrow, nil
+}
+
+// TransferEscrowedCar is submitted by the seller to hand the car over to the buyer of
+// a locked escrow. The buyer then has another TimeoutSeconds to confirm the receipt.
+func (s *SmartContract) TransferEscrowedCar(ctx contractapi.TransactionContextInterface, escrowID string) (*Escrow, error) {
+	escrow, err := s.ReadEscrow(ctx, escrowID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkEscrowStatus(escrow, escrowStatusLocked)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now.After(escrow.ExpiresAt) {
+		return nil, fmt.Errorf("the escrow %s expired at %s", escrowID, escrow.ExpiresAt.Format(time.RFC3339))
+	}
+
+	seller, err := s.ReadPersonAsset(ctx, escrow.SellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requirePerson(ctx, seller)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := s.ReadPersonAsset(ctx, escrow.BuyerID)
+	if err != nil {
+		return nil, err
+	}
+
+	offer, err := s.ReadSaleOffer(ctx, escrow.OfferID)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsset, err := s.readOfferedCar(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = handOverCar(ctx, carAsset, seller, buyer, escrow.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	escrow.Status = escrowStatusTransferred
+	escrow.ExpiresAt = now.Add(time.Duration(escrow.TimeoutSeconds) * time.Second)
+
+	err = putEscrow(ctx, escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	return escrow, nil
+}
+
+// ReleaseEscrow pays the price of a transferred car to the seller. The buyer submits it
+// to confirm the receipt of the car, and the seller can submit it once the buyer has
+// let the escrow expire.
+func (s *SmartContract) ReleaseEscrow(ctx contractapi.TransactionContextInterface, escrowID string) (*Escrow, error) {
+	escrow, err := s.ReadEscrow(ctx, escrowID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkEscrowStatus(escrow, escrowStatusTransferred)
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := s.ReadPersonAsset(ctx, escrow.SellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := s.ReadPersonAsset(ctx, escrow.BuyerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if requirePerson(ctx, buyer) != nil {
+		err = requirePerson(ctx, seller)
+		if err != nil {
+			return nil, err
+		}
+
+		err = requireEscrowExpired(ctx, escrow, "the buyer may confirm it")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = creditPerson(ctx, seller, escrow.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.closeEscrowedOffer(ctx, escrow, offerStatusSettled)
+	if err != nil {
+		return nil, err
+	}
+
+	escrow.Status = escrowStatusReleased
+
+	err = putEscrow(ctx, escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventEscrowReleased, EscrowReleasedEvent{
+		EscrowID: escrowID,
+		CarID:    escrow.CarID,
+		SellerID: escrow.SellerID,
+		Price:    escrow.Price,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return escrow, nil
+}
+
+// RefundEscrow returns the price to the buyer when the car has not been transferred.
+// The seller submits it to decline the sale, and the buyer can submit it once the
+// seller has let the escrow expire.
+func (s *SmartContract) RefundEscrow(ctx contractapi.TransactionContextInterface, escrowID string) (*Escrow, error) {
+	escrow, err := s.ReadEscrow(ctx, escrowID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkEscrowStatus(escrow, escrowStatusLocked)
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := s.ReadPersonAsset(ctx, escrow.SellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := s.ReadPersonAsset(ctx, escrow.BuyerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if requirePerson(ctx, seller) != nil {
+		err = requirePerson(ctx, buyer)
+		if err != nil {
+			return nil, err
+		}
+
+		err = requireEscrowExpired(ctx, escrow, "the seller may transfer the car")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = creditPerson(ctx, buyer, escrow.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.closeEscrowedOffer(ctx, escrow, offerStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	escrow.Status = escrowStatusRefunded
+
+	err = putEscrow(ctx, escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventEscrowRefunded, EscrowRefundedEvent{
+		EscrowID: escrowID,
+		CarID:    escrow.CarID,
+		BuyerID:  escrow.BuyerID,
+		Price:    escrow.Price,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return escrow, nil
+}
+
+func (s *SmartContract) ReadEscrow(ctx contractapi.TransactionContextInterface, escrowID string) (*Escrow, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(escrowKeyIndex, []string{escrowID})
+	if err != nil {
+		return nil, err
+	}
+
+	escrowJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read escrow from world state: %v", err)
+	}
+	if escrowJSON == nil {
+		return nil, fmt.Errorf("the escrow %s does not exist", escrowID)
+	}
+
+	var escrow Escrow
+	err = json.Unmarshal(escrowJSON, &escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkObjectType(escrowID, escrow.ObjectType, escrowObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &escrow, nil
+}
+
+func (s *SmartContract) EscrowExists(ctx contractapi.TransactionContextInterface, escrowID string) (bool, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(escrowKeyIndex, []string{escrowID})
+	if err != nil {
+		return false, err
+	}
+
+	escrowJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read escrow from world state: %v", err)
+	}
+
+	return escrowJSON != nil, nil
+}
+
+// closeEscrowedOffer settles or cancels the sale offer of an escrow that is released
+// or refunded. A cancelled offer also ends the reservation of the car.
+func (s *SmartContract) closeEscrowedOffer(ctx contractapi.TransactionContextInterface, escrow *Escrow, status string) error {
+	offer, err := s.ReadSaleOffer(ctx, escrow.OfferID)
+	if err != nil {
+		return err
+	}
+
+	if status == offerStatusCancelled {
+		err = releaseOfferReservation(ctx, offer)
+		if err != nil {
+			return err
+		}
+	}
+
+	offer.Status = status
+
+	err = putSaleOffer(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	return deleteCarOfferIndex(ctx, offer.CarID, offer.ID)
+}
+
+func checkEscrowStatus(escrow *Escrow, expectedStatus string) error {
+	if escrow.Status != expectedStatus {
+		return fmt.Errorf("the escrow %s is %s, not %s", escrow.ID, escrow.Status, expectedStatus)
+	}
+
+	return nil
+}
+
+// requireEscrowExpired stops the other party from closing an escrow while the party
+// whose turn it is still may act.
+func requireEscrowExpired(ctx contractapi.TransactionContextInterface, escrow *Escrow, until string) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.After(escrow.ExpiresAt) {
+		return fmt.Errorf("the escrow %s has not expired, %s until %s", escrow.ID, until, escrow.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// creditPerson pays a price held in an escrow or by an accepted sale offer to the
+// seller or back to the buyer.
+func creditPerson(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset, price Money) error {
+	details, err := readPersonDetails(ctx, personAsset)
+	if err != nil {
+		return err
+	}
+
+	details.AmountOfMoneyOwned, err = details.AmountOfMoneyOwned.Add(price)
+	if err != nil {
+		return err
+	}
+
+	return putPersonDetails(ctx, personAsset, details)
+}
+
+func putEscrow(ctx contractapi.TransactionContextInterface, escrow *Escrow) error {
+	key, err := ctx.GetStub().CreateCompositeKey(escrowKeyIndex, []string{escrow.ID})
+	if err != nil {
+		return err
+	}
+
+	escrow.ObjectType = escrowObjectType
+	escrowJSON, err := json.Marshal(escrow)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, escrowJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put escrow %s to world state: %v", escrow.ID, err)
+	}
+
+	return nil
+}
+
+// setEscrowStateBasedEndorsement requires the peers of the buyer's and the seller's
+// organizations to endorse every later change of the escrow.
+func setEscrowStateBasedEndorsement(ctx contractapi.TransactionContextInterface, escrowID string, orgsToEndorse ...string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(escrowKeyIndex, []string{escrowID})
+	if err != nil {
+		return err
+	}
+
+	err = setStateBasedEndorsement(ctx, key, orgsToEndorse...)
+	if err != nil {
+		return fmt.Errorf("failed to set validation parameter on escrow %s: %v", escrowID, err)
+	}
+
+	return nil
+}
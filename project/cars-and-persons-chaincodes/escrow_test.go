@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"cars-and-persons-chaincodes/mocks"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/stretchr/testify/require"
+)
+
+// setTxTime makes the following transactions look as if they were submitted at t.
+func setTxTime(t *testing.T, chaincodeStub *mocks.ChaincodeStub, txTime time.Time) {
+	timestamp, err := ptypes.TimestampProto(txTime)
+	require.NoError(t, err)
+	chaincodeStub.GetTxTimestampReturns(timestamp, nil)
+}
+
+// endorsingOrgs returns the organizations a key-level endorsement policy requires.
+func endorsingOrgs(t *testing.T, policy []byte) []string {
+	endorsementPolicy, err := statebased.NewStateEP(policy)
+	require.NoError(t, err)
+
+	orgs := endorsementPolicy.ListOrgs()
+	sort.Strings(orgs)
+
+	return orgs
+}
+
+func TestLockEscrow(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "", "200", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer3", "car3", "", "3400", 3600)
+	require.NoError(t, err)
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	escrow, err := carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer1", "person2", false, 3600)
+	require.NoError(t, err)
+	require.Equal(t, &Escrow{
+		ObjectType:     escrowObjectType,
+		ID:             "escrow1",
+		OfferID:        "offer1",
+		CarID:          "car5",
+		SellerID:       "person3",
+		BuyerID:        "person2",
+		Price:          newMoney(5300_00),
+		Status:         escrowStatusLocked,
+		TimeoutSeconds: 3600,
+		LockedAt:       testTime,
+		ExpiresAt:      testTime.Add(time.Hour),
+	}, escrow)
+	require.Equal(t, newMoney(2900_40), personBalance(t, transactionContext, "person2"))
+
+	offer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusAccepted, offer.Status)
+	require.Equal(t, "person2", offer.BuyerID)
+	require.Equal(t, "escrow1", offer.EscrowID)
+
+	storedEscrow, err := carsAndPersons.ReadEscrow(transactionContext, "escrow1")
+	require.NoError(t, err)
+	require.Equal(t, escrow, storedEscrow)
+
+	escrowKey, err := chaincodeStub.CreateCompositeKey(escrowKeyIndex, []string{"escrow1"})
+	require.NoError(t, err)
+	policy, err := chaincodeStub.GetStateValidationParameter(escrowKey)
+	require.NoError(t, err)
+	require.Equal(t, []string{org2MSP, org3MSP}, endorsingOrgs(t, policy))
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventEscrowLocked, eventName)
+	var locked EscrowLockedEvent
+	require.NoError(t, json.Unmarshal(payload, &locked))
+	require.Equal(t, EscrowLockedEvent{EscrowID: "escrow1", OfferID: "offer1", CarID: "car5", SellerID: "person3", BuyerID: "person2", Price: newMoney(5300_00), ExpiresAt: testTime.Add(time.Hour)}, locked)
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer2", "person2", true, 3600)
+	require.EqualError(t, err, "the escrow escrow1 already exists")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "", "offer2", "person2", true, 3600)
+	require.EqualError(t, err, "the escrow ID must not be empty")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer2", "person2", true, 0)
+	require.EqualError(t, err, "the escrow timeout must be a positive number of seconds")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer1", "person2", false, 3600)
+	require.EqualError(t, err, "the sale offer offer1 is accepted, not open")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer9", "person2", false, 3600)
+	require.EqualError(t, err, "the sale offer offer9 does not exist")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer2", "person3", true, 3600)
+	require.EqualError(t, err, "client is not authorized to act on behalf of person3")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer2", "person1", true, 3600)
+	require.EqualError(t, err, "Person person1 is already the owner of the car!")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer2", "person2", false, 3600)
+	require.EqualError(t, err, "the buyer will not accept a malfunctioned car")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer3", "person2", true, 3600)
+	require.EqualError(t, err, "the buyer does not own enough money to purchase the car")
+	require.Equal(t, newMoney(2900_40), personBalance(t, transactionContext, "person2"))
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 is held in the escrow escrow1")
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 is held in the escrow escrow1", "the buyer has to wait for the escrow to expire")
+}
+
+func TestReleaseEscrow(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer1", "person2", false, 3600)
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.ReleaseEscrow(transactionContext, "escrow1")
+	require.EqualError(t, err, "the escrow escrow1 is locked, not transferred")
+
+	_, err = carsAndPersons.TransferEscrowedCar(transactionContext, "escrow1")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person3")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	setTxTime(t, chaincodeStub, testTime.Add(30*time.Minute))
+	escrow, err := carsAndPersons.TransferEscrowedCar(transactionContext, "escrow1")
+	require.NoError(t, err)
+	require.Equal(t, escrowStatusTransferred, escrow.Status)
+	require.Equal(t, testTime.Add(90*time.Minute), escrow.ExpiresAt, "the buyer gets the whole timeout to confirm")
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person2", carAsset.OwnerID)
+	require.Contains(t, state.indexEntries(t, colorOwnerIndex), "black/person2/car5")
+	require.Equal(t, newMoney(1430_22), personBalance(t, transactionContext, "person3"), "the seller is paid on release")
+
+	eventName, _ := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarTransferred, eventName)
+
+	_, err = carsAndPersons.ReleaseEscrow(transactionContext, "escrow1")
+	require.EqualError(t, err, "the escrow escrow1 has not expired, the buyer may confirm it until 2022-01-15T13:30:00Z")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	escrow, err = carsAndPersons.ReleaseEscrow(transactionContext, "escrow1")
+	require.NoError(t, err)
+	require.Equal(t, escrowStatusReleased, escrow.Status)
+	require.Equal(t, newMoney(6730_22), personBalance(t, transactionContext, "person3"))
+	require.Equal(t, newMoney(2900_40), personBalance(t, transactionContext, "person2"))
+
+	offer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusSettled, offer.Status)
+	require.Empty(t, state.indexEntries(t, carOfferIndex))
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventEscrowReleased, eventName)
+	var released EscrowReleasedEvent
+	require.NoError(t, json.Unmarshal(payload, &released))
+	require.Equal(t, EscrowReleasedEvent{EscrowID: "escrow1", CarID: "car5", SellerID: "person3", Price: newMoney(5300_00)}, released)
+
+	_, err = carsAndPersons.ReleaseEscrow(transactionContext, "escrow1")
+	require.EqualError(t, err, "the escrow escrow1 is released, not transferred")
+	_, err = carsAndPersons.RefundEscrow(transactionContext, "escrow1")
+	require.EqualError(t, err, "the escrow escrow1 is released, not locked")
+}
+
+func TestReleaseExpiredEscrow(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer1", "person2", false, 60)
+	require.NoError(t, err)
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.TransferEscrowedCar(transactionContext, "escrow1")
+	require.NoError(t, err)
+
+	setTxTime(t, chaincodeStub, testTime.Add(2*time.Minute))
+	escrow, err := carsAndPersons.ReleaseEscrow(transactionContext, "escrow1")
+	require.NoError(t, err, "the seller can release the price once the buyer let the escrow expire")
+	require.Equal(t, escrowStatusReleased, escrow.Status)
+	require.Equal(t, newMoney(6730_22), personBalance(t, transactionContext, "person3"))
+}
+
+func TestRefundEscrow(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer1", "person2", false, 3600)
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.RefundEscrow(transactionContext, "escrow1")
+	require.EqualError(t, err, "the escrow escrow1 has not expired, the seller may transfer the car until 2022-01-15T13:00:00Z")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.RefundEscrow(transactionContext, "escrow1")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person2")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	setTxTime(t, chaincodeStub, testTime.Add(2*time.Hour))
+	_, err = carsAndPersons.TransferEscrowedCar(transactionContext, "escrow1")
+	require.EqualError(t, err, "the escrow escrow1 expired at 2022-01-15T13:00:00Z")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	escrow, err := carsAndPersons.RefundEscrow(transactionContext, "escrow1")
+	require.NoError(t, err)
+	require.Equal(t, escrowStatusRefunded, escrow.Status)
+	require.Equal(t, newMoney(8200_40), personBalance(t, transactionContext, "person2"))
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventEscrowRefunded, eventName)
+	var refunded EscrowRefundedEvent
+	require.NoError(t, json.Unmarshal(payload, &refunded))
+	require.Equal(t, EscrowRefundedEvent{EscrowID: "escrow1", CarID: "car5", BuyerID: "person2", Price: newMoney(5300_00)}, refunded)
+
+	offer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusCancelled, offer.Status)
+
+	// The seller can decline the sale before the escrow expires.
+	setClient(transactionContext, org3MSP, person3ClientID)
+	setTxTime(t, chaincodeStub, testTime)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car5", "person2", "5300", 3600)
+	require.NoError(t, err)
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow2", "offer2", "person2", false, 3600)
+	require.NoError(t, err)
+	setClient(transactionContext, org3MSP, person3ClientID)
+	escrow, err = carsAndPersons.RefundEscrow(transactionContext, "escrow2")
+	require.NoError(t, err)
+	require.Equal(t, escrowStatusRefunded, escrow.Status)
+	require.Equal(t, newMoney(8200_40), personBalance(t, transactionContext, "person2"))
+}
+
+func TestTransferEscrowedCarChecksTheCar(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer1", "person2", false, 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Scratched door", "20", "minor")
+	require.NoError(t, err)
+	_, err = carsAndPersons.TransferEscrowedCar(transactionContext, "escrow1")
+	require.EqualError(t, err, "the malfunctions of the car car5 changed since the offer was made")
+
+	err = carsAndPersons.RepairCar(transactionContext, "car5")
+	require.NoError(t, err)
+	sellCar(t, transactionContext, "offer2", "car5", "person1", "5300")
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.TransferEscrowedCar(transactionContext, "escrow1")
+	require.EqualError(t, err, "the car car5 is no longer owned by person3")
+}
+
+func TestEscrowOnFakeLedger(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	seller := ledger.as(org3MSP, person3ClientID)
+	buyer := ledger.as(org2MSP, person2ClientID)
+
+	ledger.submit(func() error {
+		_, err := carsAndPersons.CreateSaleOffer(seller, "offer1", "car5", "person2", "5300", 3600)
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.LockEscrow(buyer, "escrow1", "offer1", "person2", false, 3600)
+		return err
+	})
+
+	escrowKey, err := ledger.stub.CreateCompositeKey(escrowKeyIndex, []string{"escrow1"})
+	require.NoError(t, err)
+	policy, err := ledger.stub.GetStateValidationParameter(escrowKey)
+	require.NoError(t, err)
+	require.Equal(t, []string{org2MSP, org3MSP}, endorsingOrgs(t, policy))
+
+	ledger.submit(func() error {
+		_, err := carsAndPersons.TransferEscrowedCar(seller, "escrow1")
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.ReleaseEscrow(buyer, "escrow1")
+		return err
+	})
+
+	carAsset, err := carsAndPersons.ReadCarAsset(buyer, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person2", carAsset.OwnerID)
+	require.Equal(t, newMoney(2900_40), personBalance(t, buyer, "person2"))
+	require.Equal(t, newMoney(6730_22), personBalance(t, seller, "person3"))
+	require.Equal(t, eventEscrowReleased, ledger.stub.LastEvent().EventName)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The names of the chaincode events. Every event carries a JSON payload described by
+// the type named after it, e.g. CarTransferred carries a CarTransferredEvent. Amounts
+// are Money objects in minor units, e.g. {"Amount":250000,"Currency":"EUR"}.
+const (
+	eventLedgerInitialized     = "LedgerInitialized"
+	eventCarTransferred        = "CarTransferred"
+	eventMalfunctionReported   = "MalfunctionReported"
+	eventCarRepaired           = "CarRepaired"
+	eventCarRecolored          = "CarRecolored"
+	eventCarWrittenOff         = "CarWrittenOff"
+	eventWriteOffPayoutSettled = "WriteOffPayoutSettled"
+	eventAssetsImported        = "AssetsImported"
+	eventEscrowLocked          = "EscrowLocked"
+	eventEscrowReleased        = "EscrowReleased"
+	eventEscrowRefunded        = "EscrowRefunded"
+	eventCarReserved           = "CarReserved"
+	eventReservationReleased   = "ReservationReleased"
+	eventOdometerUpdated       = "OdometerUpdated"
+	eventCarIdentitySet        = "CarIdentitySet"
+)
+
+// LedgerInitializedEvent is the payload of the LedgerInitialized event, emitted by
+// InitLedger with the IDs of the seeded assets.
+type LedgerInitializedEvent struct {
+	CarIDs    []string `json:"carIDs"`
+	PersonIDs []string `json:"personIDs"`
+}
+
+// AssetsImportedEvent is the payload of the AssetsImported event, emitted by BulkImport
+// with the IDs of the imported assets.
+type AssetsImportedEvent struct {
+	CarIDs    []string `json:"carIDs"`
+	PersonIDs []string `json:"personIDs"`
+}
+
+// CarTransferredEvent is the payload of the CarTransferred event, emitted when a car
+// changes hands through TransferCarAsset, SettleSaleOffer or TransferEscrowedCar.
+type CarTransferredEvent struct {
+	CarID      string `json:"carID"`
+	OldOwnerID string `json:"oldOwnerID"`
+	NewOwnerID string `json:"newOwnerID"`
+	Price      Money  `json:"price"`
+}
+
+// MalfunctionReportedEvent is the payload of the MalfunctionReported event, emitted by
+// AddCarMalfunction unless the new malfunction gets the car written off.
+type MalfunctionReportedEvent struct {
+	CarID           string         `json:"carID"`
+	OwnerID         string         `json:"ownerID"`
+	Malfunction     CarMalfunction `json:"malfunction"`
+	OpenRepairPrice Money          `json:"openRepairPrice"`
+}
+
+// CarRepairedEvent is the payload of the CarRepaired event, emitted by RepairCar and
+// RepairMalfunction with one repair per fixed malfunction.
+type CarRepairedEvent struct {
+	CarID   string      `json:"carID"`
+	OwnerID string      `json:"ownerID"`
+	Repairs []CarRepair `json:"repairs"`
+}
+
+// CarRecoloredEvent is the payload of the CarRecolored event, emitted by ChangeCarColor
+// and by UpdateCarAsset when the color changes.
+type CarRecoloredEvent struct {
+	CarID    string `json:"carID"`
+	OwnerID  string `json:"ownerID"`
+	OldColor string `json:"oldColor"`
+	NewColor string `json:"newColor"`
+}
+
+// CarWrittenOffEvent is the payload of the CarWrittenOff event, emitted when the
+// open repair price of a car exceeds its price.
+type CarWrittenOffEvent struct {
+	CarID        string    `json:"carID"`
+	OwnerID      string    `json:"ownerID"`
+	Price        Money     `json:"price"`
+	RepairPrice  Money     `json:"repairPrice"`
+	WrittenOffAt time.Time `json:"writtenOffAt"`
+}
+
+// WriteOffPayoutSettledEvent is the payload of the WriteOffPayoutSettled event.
+type WriteOffPayoutSettledEvent struct {
+	CarID     string    `json:"carID"`
+	OwnerID   string    `json:"ownerID"`
+	Payout    Money     `json:"payout"`
+	InsurerID string    `json:"insurerID"`
+	SettledAt time.Time `json:"settledAt"`
+}
+
+// EscrowLockedEvent is the payload of the EscrowLocked event, emitted by LockEscrow.
+type EscrowLockedEvent struct {
+	EscrowID  string    `json:"escrowID"`
+	OfferID   string    `json:"offerID"`
+	CarID     string    `json:"carID"`
+	SellerID  string    `json:"sellerID"`
+	BuyerID   string    `json:"buyerID"`
+	Price     Money     `json:"price"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// EscrowReleasedEvent is the payload of the EscrowReleased event, emitted by
+// ReleaseEscrow when the price is paid to the seller.
+type EscrowReleasedEvent struct {
+	EscrowID string `json:"escrowID"`
+	CarID    string `json:"carID"`
+	SellerID string `json:"sellerID"`
+	Price    Money  `json:"price"`
+}
+
+// EscrowRefundedEvent is the payload of the EscrowRefunded event, emitted by
+// RefundEscrow when the price is returned to the buyer.
+type EscrowRefundedEvent struct {
+	EscrowID string `json:"escrowID"`
+	CarID    string `json:"carID"`
+	BuyerID  string `json:"buyerID"`
+	Price    Money  `json:"price"`
+}
+
+// CarReservedEvent is the payload of the CarReserved event, emitted by ReserveCar.
+type CarReservedEvent struct {
+	CarID     string    `json:"carID"`
+	OfferID   string    `json:"offerID"`
+	HolderID  string    `json:"holderID"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ReservationReleasedEvent is the payload of the ReservationReleased event, emitted by
+// ReleaseReservation. Reservations that expire, end with a transfer or end with the
+// cancellation of their offer emit no event.
+type ReservationReleasedEvent struct {
+	CarID    string `json:"carID"`
+	HolderID string `json:"holderID"`
+}
+
+// OdometerUpdatedEvent is the payload of the OdometerUpdated event, emitted by
+// UpdateOdometer. Readings are in kilometers.
+type OdometerUpdatedEvent struct {
+	CarID      string `json:"carID"`
+	OwnerID    string `json:"ownerID"`
+	OldReading int    `json:"oldReading"`
+	NewReading int    `json:"newReading"`
+}
+
+// CarIdentitySetEvent is the payload of the CarIdentitySet event, emitted by
+// SetCarIdentity with the old and the new VIN and registration plate of the car.
+type CarIdentitySetEvent struct {
+	CarID                string `json:"carID"`
+	OldVIN               string `json:"oldVIN"`
+	VIN                  string `json:"vin"`
+	OldRegistrationPlate string `json:"oldRegistrationPlate"`
+	RegistrationPlate    string `json:"registrationPlate"`
+}
+
+// emitEvent sets the chaincode event of the transaction. Fabric keeps only one event
+// per transaction, so a transaction that calls it twice publishes the last payload.
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().SetEvent(name, payloadJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set event %s: %v", name, err)
+	}
+
+	return nil
+}
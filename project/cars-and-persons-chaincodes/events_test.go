@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	// payloadFields are the sorted top-level fields of the payload of an event, which
+	// must match the schema documented in the README.
+	payloadFields := func(payload []byte) []string {
+		var fields map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(payload, &fields))
+
+		var names []string
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventLedgerInitialized, eventName)
+	require.Equal(t, []string{"carIDs", "personIDs"}, payloadFields(payload))
+
+	for _, step := range []struct {
+		name     string
+		mspID    string
+		clientID string
+		fn       func() error
+		event    string
+		fields   []string
+	}{
+		{
+			name:     "ChangeCarColor",
+			mspID:    org1MSP,
+			clientID: person1ClientID,
+			fn: func() error {
+				_, err := carsAndPersons.ChangeCarColor(transactionContext, "car3", "purple")
+				return err
+			},
+			event:  eventCarRecolored,
+			fields: []string{"carID", "newColor", "oldColor", "ownerID"},
+		},
+		{
+			name:     "UpdateCarAsset",
+			mspID:    org1MSP,
+			clientID: person1ClientID,
+			fn: func() error {
+				return carsAndPersons.UpdateCarAsset(transactionContext, "car3", "Volvo", "V60", 2014, "green", "3400")
+			},
+			event:  eventCarRecolored,
+			fields: []string{"carID", "newColor", "oldColor", "ownerID"},
+		},
+		{
+			name:     "AddCarMalfunction",
+			mspID:    org1MSP,
+			clientID: person1ClientID,
+			fn: func() error {
+				return carsAndPersons.AddCarMalfunction(transactionContext, "car1", "Flat tyre", "20", severityMinor)
+			},
+			event:  eventMalfunctionReported,
+			fields: []string{"carID", "malfunction", "openRepairPrice", "ownerID"},
+		},
+		{
+			name:     "RepairMalfunction",
+			mspID:    org1MSP,
+			clientID: person1ClientID,
+			fn:       func() error { return carsAndPersons.RepairMalfunction(transactionContext, "car1", "m3") },
+			event:    eventCarRepaired,
+			fields:   []string{"carID", "ownerID", "repairs"},
+		},
+		{
+			name:     "RepairCar",
+			mspID:    org1MSP,
+			clientID: person1ClientID,
+			fn:       func() error { return carsAndPersons.RepairCar(transactionContext, "car1") },
+			event:    eventCarRepaired,
+			fields:   []string{"carID", "ownerID", "repairs"},
+		},
+		{
+			name:     "AddCarMalfunction writing the car off",
+			mspID:    org1MSP,
+			clientID: person1ClientID,
+			fn: func() error {
+				return carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+			},
+			event:  eventCarWrittenOff,
+			fields: []string{"carID", "ownerID", "price", "repairPrice", "writtenOffAt"},
+		},
+		{
+			name:     "SettleWriteOffPayout",
+			mspID:    org3MSP,
+			clientID: insurerClientID,
+			fn: func() error {
+				_, err := carsAndPersons.SettleWriteOffPayout(transactionContext, "car4", "150")
+				return err
+			},
+			event:  eventWriteOffPayoutSettled,
+			fields: []string{"carID", "insurerID", "ownerID", "payout", "settledAt"},
+		},
+		{
+			name:     "CreateSaleOffer",
+			mspID:    org3MSP,
+			clientID: person3ClientID,
+			fn: func() error {
+				_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "person2", "4000", 3600)
+				return err
+			},
+		},
+		{
+			name:     "AcceptSaleOffer",
+			mspID:    org2MSP,
+			clientID: person2ClientID,
+			fn: func() error {
+				_, err := carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person2", false)
+				return err
+			},
+		},
+		{
+			name:     "SettleSaleOffer",
+			mspID:    org3MSP,
+			clientID: person3ClientID,
+			fn: func() error {
+				_, err := carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+				return err
+			},
+			event:  eventCarTransferred,
+			fields: []string{"carID", "newOwnerID", "oldOwnerID", "price"},
+		},
+		{
+			name:     "UpdateCarAsset keeping the color",
+			mspID:    org2MSP,
+			clientID: person2ClientID,
+			fn: func() error {
+				return carsAndPersons.UpdateCarAsset(transactionContext, "car5", "Mercedes-Benz", "A-class", 2018, "black", "5000")
+			},
+		},
+		{
+			name:     "LinkPersonIdentity",
+			mspID:    org1MSP,
+			clientID: registryClientID,
+			fn: func() error {
+				return carsAndPersons.LinkPersonIdentity(transactionContext, "person3", person3ClientID, org3MSP)
+			},
+		},
+	} {
+		setClient(transactionContext, step.mspID, step.clientID)
+		eventCount := chaincodeStub.SetEventCallCount()
+		require.NoError(t, step.fn(), step.name)
+
+		if step.event == "" {
+			require.Equal(t, eventCount, chaincodeStub.SetEventCallCount(), step.name)
+			continue
+		}
+		require.Equal(t, eventCount+1, chaincodeStub.SetEventCallCount(), step.name)
+
+		eventName, payload := lastEvent(t, chaincodeStub)
+		require.Equal(t, step.event, eventName, step.name)
+		require.Equal(t, step.fields, payloadFields(payload), step.name)
+	}
+}
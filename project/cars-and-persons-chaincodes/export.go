@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// PersonPaginatedQueryResult is a page of persons, with the bookmark to pass in to get
+// the next page.
+type PersonPaginatedQueryResult struct {
+	Records             []*PersonAsset `json:"records"`
+	FetchedRecordsCount int32          `json:"fetchedRecordsCount"`
+	Bookmark            string         `json:"bookmark"`
+}
+
+// GetAllCarsWithPagination returns a page of all cars, active and written off, in the
+// order of their IDs. Together with GetAllPersonsWithPagination it lets clients take a
+// snapshot of the ledger. Paginated queries are only valid for read only transactions.
+func (s *SmartContract) GetAllCarsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	carIter, responseMetadata, err := getAllWithPagination(ctx, carKeyIndex, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	defer carIter.Close()
+
+	carAssets := make([]*CarAsset, 0)
+	for carIter.HasNext() {
+		responseRange, err := carIter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var carAsset CarAsset
+		err = json.Unmarshal(responseRange.Value, &carAsset)
+		if err != nil {
+			return nil, err
+		}
+
+		normalizeMalfunctions(&carAsset)
+		normalizeCarStatus(&carAsset)
+		carAssets = append(carAssets, &carAsset)
+	}
+
+	return &PaginatedQueryResult{
+		Records:             carAssets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// GetAllPersonsWithPagination returns a page of all persons in the order of their IDs.
+// Paginated queries are only valid for read only transactions.
+func (s *SmartContract) GetAllPersonsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*PersonPaginatedQueryResult, error) {
+	personIter, responseMetadata, err := getAllWithPagination(ctx, personKeyIndex, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	defer personIter.Close()
+
+	personAssets := make([]*PersonAsset, 0)
+	for personIter.HasNext() {
+		responseRange, err := personIter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var personAsset PersonAsset
+		err = json.Unmarshal(responseRange.Value, &personAsset)
+		if err != nil {
+			return nil, err
+		}
+		personAssets = append(personAssets, &personAsset)
+	}
+
+	return &PersonPaginatedQueryResult{
+		Records:             personAssets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// getAllWithPagination pages through the typed keys of one kind of asset.
+func getAllWithPagination(ctx contractapi.TransactionContextInterface, keyIndex string, pageSize int, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("the page size must be positive")
+	}
+
+	return ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(keyIndex, []string{}, int32(pageSize), bookmark)
+}
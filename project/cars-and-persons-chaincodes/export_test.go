@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAllAssetsWithPagination(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	registry := ledger.as(org1MSP, registryClientID)
+
+	var carIDs []string
+	bookmark := ""
+	for {
+		page, err := carsAndPersons.GetAllCarsWithPagination(registry, 4, bookmark)
+		require.NoError(t, err)
+		for _, carAsset := range page.Records {
+			carIDs = append(carIDs, carAsset.ID)
+		}
+		if page.Bookmark == "" || page.FetchedRecordsCount < 4 {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	require.Equal(t, []string{"car1", "car2", "car3", "car4", "car5", "car6"}, carIDs)
+
+	page, err := carsAndPersons.GetAllCarsWithPagination(registry, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, "m1", page.Records[0].MalfunctionList[0].ID)
+
+	persons, err := carsAndPersons.GetAllPersonsWithPagination(registry, 10, "")
+	require.NoError(t, err)
+	require.Len(t, persons.Records, 3)
+	require.Equal(t, "person1", persons.Records[0].ID)
+	require.Equal(t, org1MSP, persons.Records[0].MSPID)
+
+	_, err = carsAndPersons.GetAllPersonsWithPagination(registry, 0, "")
+	require.EqualError(t, err, "the page size must be positive")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CarHistoryRecord is one version of a car, together with what changed compared to
+// the version before it.
+type CarHistoryRecord struct {
+	Record    *CarAsset   `json:"record"`
+	TxId      string      `json:"txId"`
+	Timestamp time.Time   `json:"timestamp"`
+	IsDelete  bool        `json:"isDelete"`
+	Changes   *CarChanges `json:"changes,omitempty"`
+}
+
+// CarChanges describes the difference in owner, color, malfunctions and status between
+// two consecutive versions of a car. Unchanged owners and colors are left empty.
+type CarChanges struct {
+	OldOwnerID           string           `json:"oldOwnerID,omitempty"`
+	NewOwnerID           string           `json:"newOwnerID,omitempty"`
+	OldColor             string           `json:"oldColor,omitempty"`
+	NewColor             string           `json:"newColor,omitempty"`
+	AddedMalfunctions    []CarMalfunction `json:"addedMalfunctions,omitempty"`
+	RepairedMalfunctions []CarMalfunction `json:"repairedMalfunctions,omitempty"`
+	RemovedMalfunctions  []CarMalfunction `json:"removedMalfunctions,omitempty"`
+	WrittenOff           bool             `json:"writtenOff,omitempty"`
+}
+
+// GetCarHistory returns every version of a car in chronological order. Versions
+// stored under the bare car ID, before MigrateToTypedKeys was run, are included too.
+func (s *SmartContract) GetCarHistory(ctx contractapi.TransactionContextInterface, id string) ([]CarHistoryRecord, error) {
+	key, err := carKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyRecords, err := getCarHistoryForKey(ctx, id, id)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := getCarHistoryForKey(ctx, key, id)
+	if err != nil {
+		return nil, err
+	}
+
+	records = append(legacyRecords, records...)
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the car asset %s has no history", id)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	var previous *CarAsset
+	for i := range records {
+		if records[i].IsDelete {
+			previous = nil
+			continue
+		}
+
+		records[i].Changes = diffCarVersions(previous, records[i].Record)
+		previous = records[i].Record
+	}
+
+	return records, nil
+}
+
+func getCarHistoryForKey(ctx contractapi.TransactionContextInterface, key string, id string) ([]CarHistoryRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var records []CarHistoryRecord
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		carAsset := &CarAsset{ID: id}
+		if len(response.Value) > 0 {
+			carAsset, err = decodeCarVersion(response.Value)
+			if err != nil {
+				return nil, err
+			}
+			if carAsset == nil {
+				continue
+			}
+		}
+
+		timestamp, err := ptypes.Timestamp(response.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		record := CarHistoryRecord{
+			TxId:      response.TxId,
+			Timestamp: timestamp,
+			Record:    carAsset,
+			IsDelete:  response.IsDelete,
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// decodeCarVersion decodes a historic car value, converting float prices written by
+// earlier chaincode versions. It returns nil for values that are not cars, since a
+// bare ID may also have held a person before typed keys were introduced.
+func decodeCarVersion(value []byte) (*CarAsset, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(value, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	objectType := legacyObjectType(fields)
+	if rawObjectType, ok := fields["objectType"]; ok {
+		err = json.Unmarshal(rawObjectType, &objectType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if objectType != carObjectType {
+		return nil, nil
+	}
+
+	_, carAssetJSON, err := migrateCarMoney(fields)
+	if err != nil {
+		return nil, err
+	}
+	if carAssetJSON == nil {
+		carAssetJSON = value
+	}
+
+	var carAsset CarAsset
+	err = json.Unmarshal(carAssetJSON, &carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeMalfunctions(&carAsset)
+	normalizeCarStatus(&carAsset)
+
+	return &carAsset, nil
+}
+
+func diffCarVersions(previous *CarAsset, current *CarAsset) *CarChanges {
+	changes := &CarChanges{}
+	var previousMalfunctions []CarMalfunction
+
+	if previous == nil {
+		changes.NewOwnerID = current.OwnerID
+		changes.NewColor = current.Color
+	} else {
+		if previous.OwnerID != current.OwnerID {
+			changes.OldOwnerID = previous.OwnerID
+			changes.NewOwnerID = current.OwnerID
+		}
+		if previous.Color != current.Color {
+			changes.OldColor = previous.Color
+			changes.NewColor = current.Color
+		}
+		previousMalfunctions = previous.MalfunctionList
+	}
+
+	changes.WrittenOff = current.Status == carStatusWrittenOff && (previous == nil || previous.Status != carStatusWrittenOff)
+
+	previousByKey := make(map[string]CarMalfunction)
+	for _, malfunction := range previousMalfunctions {
+		previousByKey[malfunctionKey(malfunction)] = malfunction
+	}
+
+	currentKeys := make(map[string]bool)
+	for _, malfunction := range current.MalfunctionList {
+		currentKeys[malfunctionKey(malfunction)] = true
+
+		previousMalfunction, existed := previousByKey[malfunctionKey(malfunction)]
+		if !existed {
+			changes.AddedMalfunctions = append(changes.AddedMalfunctions, malfunction)
+		} else if previousMalfunction.Status != malfunctionStatusRepaired && malfunction.Status == malfunctionStatusRepaired {
+			changes.RepairedMalfunctions = append(changes.RepairedMalfunctions, malfunction)
+		}
+	}
+
+	// Versions written before malfunctions were kept after repair dropped them from the list.
+	for _, malfunction := range previousMalfunctions {
+		if !currentKeys[malfunctionKey(malfunction)] {
+			changes.RemovedMalfunctions = append(changes.RemovedMalfunctions, malfunction)
+		}
+	}
+
+	return changes
+}
+
+// malfunctionKey identifies a malfunction across versions. The description is part of
+// the key because IDs given to legacy malfunctions by position were reused once an
+// old-style repair had emptied the list.
+func malfunctionKey(malfunction CarMalfunction) string {
+	return malfunction.ID + "\x00" + malfunction.Description
+}
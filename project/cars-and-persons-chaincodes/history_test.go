@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"cars-and-persons-chaincodes/mocks"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/stretchr/testify/require"
+)
+
+func historyIterator(modifications []*queryresult.KeyModification) *mocks.HistoryQueryIterator {
+	iterator := &mocks.HistoryQueryIterator{}
+	iterator.HasNextStub = func() bool {
+		return len(modifications) > 0
+	}
+	iterator.NextStub = func() (*queryresult.KeyModification, error) {
+		modification := modifications[0]
+		modifications = modifications[1:]
+		return modification, nil
+	}
+
+	return iterator
+}
+
+func keyModification(t *testing.T, txID string, at time.Time, value []byte) *queryresult.KeyModification {
+	timestamp, err := ptypes.TimestampProto(at)
+	require.NoError(t, err)
+
+	return &queryresult.KeyModification{TxId: txID, Value: value, Timestamp: timestamp, IsDelete: value == nil}
+}
+
+func TestGetCarHistory(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepMocks(org1MSP, registryClientID)
+	carsAndPersons := SmartContract{}
+
+	carAsset := CarAsset{ObjectType: carObjectType, ID: "car1", Brand: "Opel", Model: "Cascada", Year: 2013, Color: "blue", OwnerID: "person1",
+		Price: newMoney(2500_00), MalfunctionList: []CarMalfunction{{ID: "m1", Description: "Oil leaking", RepairPrice: newMoney(75_00), Status: malfunctionStatusOpen}}}
+	migratedJSON, err := json.Marshal(carAsset)
+	require.NoError(t, err)
+
+	carAsset.OwnerID = "person2"
+	carAsset.Color = "red"
+	transferredJSON, err := json.Marshal(carAsset)
+	require.NoError(t, err)
+
+	carAsset.MalfunctionList[0].Status = malfunctionStatusRepaired
+	repairedJSON, err := json.Marshal(carAsset)
+	require.NoError(t, err)
+
+	typedKey, err := carKey(transactionContext, "car1")
+	require.NoError(t, err)
+	history := map[string][]*queryresult.KeyModification{
+		typedKey: {
+			keyModification(t, "tx2", testTime.Add(2*time.Hour), migratedJSON),
+			keyModification(t, "tx3", testTime.Add(3*time.Hour), transferredJSON),
+			keyModification(t, "tx4", testTime.Add(4*time.Hour), repairedJSON),
+			keyModification(t, "tx5", testTime.Add(5*time.Hour), nil),
+		},
+		// The bare key was written by a chaincode version with float prices and
+		// unstructured malfunctions, and later held a person.
+		"car1": {
+			keyModification(t, "tx1", testTime.Add(time.Hour), []byte(`{"ID":"car1","Brand":"Opel","Model":"Cascada","Year":2013,"Color":"blue",`+
+				`"OwnerID":"person1","Price":2500,"MalfunctionList":[{"Description":"Oil leaking","RepairPrice":75}]}`)),
+			keyModification(t, "tx6", testTime.Add(6*time.Hour), []byte(`{"ID":"car1","FirstName":"Car","AmountOfMoneyOwned":1}`)),
+		},
+	}
+	chaincodeStub.GetHistoryForKeyStub = func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		return historyIterator(history[key]), nil
+	}
+
+	records, err := carsAndPersons.GetCarHistory(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+
+	var txIDs []string
+	for _, record := range records {
+		txIDs = append(txIDs, record.TxId)
+	}
+	require.Equal(t, []string{"tx1", "tx2", "tx3", "tx4", "tx5"}, txIDs)
+
+	require.Equal(t, newMoney(2500_00), records[0].Record.Price, "legacy prices are converted")
+	require.Equal(t, &CarChanges{NewOwnerID: "person1", NewColor: "blue", AddedMalfunctions: records[0].Record.MalfunctionList}, records[0].Changes)
+	require.Equal(t, &CarChanges{}, records[1].Changes, "migrating the key changes nothing")
+	require.Equal(t, &CarChanges{OldOwnerID: "person1", NewOwnerID: "person2", OldColor: "blue", NewColor: "red"}, records[2].Changes)
+	require.Len(t, records[3].Changes.RepairedMalfunctions, 1)
+	require.Equal(t, "m1", records[3].Changes.RepairedMalfunctions[0].ID)
+	require.True(t, records[4].IsDelete)
+	require.Equal(t, "car1", records[4].Record.ID)
+	require.Nil(t, records[4].Changes)
+
+	_, err = carsAndPersons.GetCarHistory(transactionContext, "car9")
+	require.EqualError(t, err, "the car asset car9 has no history")
+
+	chaincodeStub.GetHistoryForKeyReturns(nil, fmt.Errorf("history database is disabled"))
+	_, err = carsAndPersons.GetCarHistory(transactionContext, "car1")
+	require.EqualError(t, err, "history database is disabled")
+}
+
+func TestGetCarHistoryOfTransactions(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	typedKey, err := carKey(transactionContext, "car5")
+	require.NoError(t, err)
+
+	// Every transaction on car5 adds the version it wrote to the history of its key.
+	modifications := []*queryresult.KeyModification{keyModification(t, "tx1", testTime, state[typedKey])}
+	record := func(transact func() error) {
+		require.NoError(t, transact())
+		txID := fmt.Sprintf("tx%d", len(modifications)+1)
+		modifications = append(modifications, keyModification(t, txID, testTime.Add(time.Duration(len(modifications))*time.Hour), state[typedKey]))
+	}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	record(func() error {
+		return carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Broken mirror", "20", severityMinor)
+	})
+	record(func() error {
+		_, err := carsAndPersons.ChangeCarColor(transactionContext, "car5", "white")
+		return err
+	})
+	record(func() error { return carsAndPersons.RepairCar(transactionContext, "car5") })
+	setClient(transactionContext, org1MSP, registryClientID)
+	record(func() error { return carsAndPersons.DeleteCarAsset(transactionContext, "car5") })
+
+	chaincodeStub.GetHistoryForKeyStub = func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		if key != typedKey {
+			return historyIterator(nil), nil
+		}
+		return historyIterator(modifications), nil
+	}
+
+	records, err := carsAndPersons.GetCarHistory(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	require.Equal(t, &CarChanges{NewOwnerID: "person3", NewColor: "black"}, records[0].Changes)
+
+	added := records[1].Changes.AddedMalfunctions
+	require.Len(t, added, 1)
+	require.Equal(t, "m1", added[0].ID)
+	require.Equal(t, testTime, added[0].ReportedAt)
+	require.Equal(t, &CarChanges{OldColor: "black", NewColor: "white"}, records[2].Changes)
+	require.Len(t, records[3].Changes.RepairedMalfunctions, 1)
+	require.Equal(t, "m1", records[3].Changes.RepairedMalfunctions[0].ID)
+	require.Empty(t, records[3].Changes.RemovedMalfunctions, "repairs keep the malfunction")
+
+	require.Equal(t, "tx5", records[4].TxId)
+	require.True(t, records[4].IsDelete)
+	require.Equal(t, "car5", records[4].Record.ID)
+	require.Nil(t, records[4].Changes)
+}
+
+func TestGetCarHistoryWriteOff(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	typedKey, err := carKey(transactionContext, "car4")
+	require.NoError(t, err)
+	activeJSON := state[typedKey]
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+
+	chaincodeStub.GetHistoryForKeyStub = func(key string) (shim.HistoryQueryIteratorInterface, error) {
+		if key != typedKey {
+			return historyIterator(nil), nil
+		}
+		return historyIterator([]*queryresult.KeyModification{
+			keyModification(t, "tx1", testTime, activeJSON),
+			keyModification(t, "tx2", testTime.Add(time.Hour), state[typedKey]),
+		}), nil
+	}
+
+	records, err := carsAndPersons.GetCarHistory(transactionContext, "car4")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.False(t, records[0].Changes.WrittenOff)
+	require.True(t, records[1].Changes.WrittenOff)
+	require.Len(t, records[1].Changes.AddedMalfunctions, 1)
+}
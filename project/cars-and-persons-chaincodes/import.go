@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	importTypeCar    = "car"
+	importTypePerson = "person"
+
+	// maxImportRecords bounds the size of a BulkImport transaction, so that its read
+	// and write sets stay well below the size limits of the orderer.
+	maxImportRecords = 500
+)
+
+// ImportRecord is a car or a person to import, with the arguments of CreateCarAsset or
+// CreatePersonAsset. Amounts are decimal strings such as "2500.00 EUR". The email
+// addresses and balances of the persons are passed in the "importedPersons" transient
+// data, e.g. {"person4":{"emailAddress":"ana@pdasp.rs","amountOfMoneyOwned":"100"}}.
+type ImportRecord struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+
+	Brand   string `json:"brand,omitempty"`
+	Model   string `json:"model,omitempty"`
+	Year    int    `json:"year,omitempty"`
+	Color   string `json:"color,omitempty"`
+	OwnerID string `json:"ownerID,omitempty"`
+	Price   string `json:"price,omitempty"`
+
+	VIN               string `json:"vin,omitempty"`
+	RegistrationPlate string `json:"registrationPlate,omitempty"`
+	FirstRegistration string `json:"firstRegistration,omitempty"`
+	Odometer          int    `json:"odometer,omitempty"`
+
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+}
+
+// ImportFailure tells why a record was not imported. Index is the position of the
+// record in the imported array, starting with 0.
+type ImportFailure struct {
+	Index  int    `json:"index"`
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// BulkImportResult lists the imported records and the records that failed validation.
+type BulkImportResult struct {
+	CarIDs    []string        `json:"carIDs"`
+	PersonIDs []string        `json:"personIDs"`
+	Failures  []ImportFailure `json:"failures"`
+}
+
+// BulkImport creates the cars and persons of a JSON array of ImportRecords, validating
+// each record as CreateCarAsset and CreatePersonAsset would. Persons are imported
+// before cars, so a car may be owned by a person of the same import. Records that fail
+// validation are skipped and reported; with allOrNothing the whole import fails
+// instead, and nothing is written.
+func (s *SmartContract) BulkImport(ctx contractapi.TransactionContextInterface, recordsJSON string, allOrNothing bool) (*BulkImportResult, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []ImportRecord
+	err = json.Unmarshal([]byte(recordsJSON), &records)
+	if err != nil {
+		return nil, fmt.Errorf("the import must be a JSON array of records: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the import has no records")
+	}
+	if len(records) > maxImportRecords {
+		return nil, fmt.Errorf("the import has %d records, at most %d can be imported in one transaction", len(records), maxImportRecords)
+	}
+
+	personInputs, err := readImportedPersonInputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkImportResult{CarIDs: []string{}, PersonIDs: []string{}, Failures: []ImportFailure{}}
+	// A transaction does not read its own writes, so the IDs imported so far are
+	// tracked to catch duplicates and owners imported together with their cars.
+	importedPersons := map[string]bool{}
+	importedCars := map[string]bool{}
+	importedIdentities := map[string]string{}
+	var personAssets []*PersonAsset
+	var personDetails []*PersonPrivateDetails
+	var carAssets []*CarAsset
+
+	for _, recordType := range []string{importTypePerson, importTypeCar} {
+		for i, record := range records {
+			if record.Type != recordType {
+				continue
+			}
+
+			switch recordType {
+			case importTypePerson:
+				var personAsset *PersonAsset
+				var details *PersonPrivateDetails
+				personAsset, details, err = s.validateImportedPerson(ctx, record, personInputs, importedPersons)
+				if err == nil {
+					importedPersons[personAsset.ID] = true
+					personAssets = append(personAssets, personAsset)
+					personDetails = append(personDetails, details)
+				}
+			case importTypeCar:
+				var carAsset *CarAsset
+				carAsset, err = s.validateImportedCar(ctx, record, importedCars, importedPersons, importedIdentities)
+				if err == nil {
+					importedCars[carAsset.ID] = true
+					addImportedCarIdentity(carAsset, importedIdentities)
+					carAssets = append(carAssets, carAsset)
+				}
+			}
+			if err != nil {
+				result.Failures = append(result.Failures, ImportFailure{Index: i, Type: record.Type, ID: record.ID, Reason: err.Error()})
+			}
+		}
+	}
+
+	for i, record := range records {
+		if record.Type != importTypeCar && record.Type != importTypePerson {
+			reason := fmt.Sprintf("the record type %q is not %s or %s", record.Type, importTypeCar, importTypePerson)
+			result.Failures = append(result.Failures, ImportFailure{Index: i, Type: record.Type, ID: record.ID, Reason: reason})
+		}
+	}
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return result.Failures[i].Index < result.Failures[j].Index
+	})
+
+	if allOrNothing && len(result.Failures) > 0 {
+		return nil, importFailedError(result.Failures)
+	}
+
+	for i, personAsset := range personAssets {
+		err := putPersonAsset(ctx, personAsset)
+		if err != nil {
+			return nil, err
+		}
+
+		err = putPersonDetails(ctx, personAsset, personDetails[i])
+		if err != nil {
+			return nil, err
+		}
+		result.PersonIDs = append(result.PersonIDs, personAsset.ID)
+	}
+
+	for _, carAsset := range carAssets {
+		err := putCarAsset(ctx, carAsset)
+		if err != nil {
+			return nil, err
+		}
+
+		// The persons of the same import are not linked yet, so the registry endorses
+		// for them.
+		organization := registryMSPID
+		if !importedPersons[carAsset.OwnerID] {
+			organization, err = s.ownerOrganization(ctx, carAsset.OwnerID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = setCarEndorsement(ctx, carAsset.ID, organization)
+		if err != nil {
+			return nil, err
+		}
+
+		err = putColorOwnerIndex(ctx, carAsset.Color, carAsset.OwnerID, carAsset.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = putOwnerIndex(ctx, carAsset.OwnerID, carAsset.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = putCarIdentityIndexes(ctx, carAsset)
+		if err != nil {
+			return nil, err
+		}
+		result.CarIDs = append(result.CarIDs, carAsset.ID)
+	}
+
+	if len(result.CarIDs) > 0 || len(result.PersonIDs) > 0 {
+		err = emitEvent(ctx, eventAssetsImported, AssetsImportedEvent{CarIDs: result.CarIDs, PersonIDs: result.PersonIDs})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (s *SmartContract) validateImportedPerson(ctx contractapi.TransactionContextInterface, record ImportRecord, personInputs map[string]personInput, importedPersons map[string]bool) (*PersonAsset, *PersonPrivateDetails, error) {
+	input, ok := personInputs[record.ID]
+	if !ok {
+		return nil, nil, fmt.Errorf("the email address and the amount of money owned of the person %s are missing from the %q transient data", record.ID, transientImportedPersonsKey)
+	}
+
+	details, err := parsePersonInput(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	personAsset := &PersonAsset{
+		ID:        record.ID,
+		FirstName: record.FirstName,
+		LastName:  record.LastName,
+	}
+
+	err = validatePersonAsset(personAsset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if importedPersons[record.ID] {
+		return nil, nil, fmt.Errorf("the person asset %s is imported more than once", record.ID)
+	}
+
+	exists, err := s.PersonAssetExists(ctx, record.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exists {
+		return nil, nil, fmt.Errorf("the person asset %s already exists", record.ID)
+	}
+
+	return personAsset, details, nil
+}
+
+// readImportedPersonInputs reads the private details of the imported persons from the
+// transient data. An import of cars alone does not need them.
+func readImportedPersonInputs(ctx contractapi.TransactionContextInterface) (map[string]personInput, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	personInputs := map[string]personInput{}
+	inputsJSON, ok := transientMap[transientImportedPersonsKey]
+	if !ok {
+		return personInputs, nil
+	}
+
+	err = json.Unmarshal(inputsJSON, &personInputs)
+	if err != nil {
+		return nil, fmt.Errorf("the %q transient data must be a JSON object of person details by ID: %v", transientImportedPersonsKey, err)
+	}
+
+	return personInputs, nil
+}
+
+func (s *SmartContract) validateImportedCar(ctx contractapi.TransactionContextInterface, record ImportRecord, importedCars map[string]bool, importedPersons map[string]bool, importedIdentities map[string]string) (*CarAsset, error) {
+	carPrice, err := ParseMoney(record.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsset := &CarAsset{
+		ID:              record.ID,
+		Brand:           record.Brand,
+		Model:           record.Model,
+		Year:            record.Year,
+		Color:           record.Color,
+		OwnerID:         record.OwnerID,
+		Price:           carPrice,
+		MalfunctionList: []CarMalfunction{},
+		RepairList:      []CarRepair{},
+		Status:          carStatusActive,
+
+		VIN:               record.VIN,
+		RegistrationPlate: record.RegistrationPlate,
+		FirstRegistration: record.FirstRegistration,
+		Odometer:          record.Odometer,
+	}
+	normalizeCarIdentity(carAsset)
+
+	err = validateCarAsset(carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateNewCarIdentity(carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	if importedCars[record.ID] {
+		return nil, fmt.Errorf("the car asset %s is imported more than once", record.ID)
+	}
+
+	exists, err := s.CarAssetExists(ctx, record.ID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("the car asset %s already exists", record.ID)
+	}
+
+	err = checkImportedCarIdentity(carAsset, importedIdentities)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkCarIdentityUnique(ctx, carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !importedPersons[record.OwnerID] {
+		exists, err = s.PersonAssetExists(ctx, record.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("the person %v does not exist", record.OwnerID)
+		}
+	}
+
+	return carAsset, nil
+}
+
+// importFailedError lists the failed records of an all-or-nothing import.
+func importFailedError(failures []ImportFailure) error {
+	lines := make([]string, len(failures))
+	for i, failure := range failures {
+		lines[i] = fmt.Sprintf("record %d (%s %s): %s", failure.Index, failure.Type, failure.ID, failure.Reason)
+	}
+
+	return fmt.Errorf("nothing was imported, %d of the records are not valid: %s", len(failures), strings.Join(lines, "; "))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const importedRecords = `[
+	{"type":"car","id":"car7","brand":"Fiat","model":"Punto","year":2010,"color":"red","ownerID":"person4","price":"1500.00 EUR","vin":"ZFA19900300A12345"},
+	{"type":"person","id":"person4","firstName":"Ana","lastName":"Anic"},
+	{"type":"car","id":"car1","brand":"Opel","model":"Astra","year":2012,"color":"blue","ownerID":"person1","price":"2000.00 EUR","vin":"W0LAH6EB4C1234567"},
+	{"type":"person","id":"person5","firstName":"Ivan","lastName":"Ivic"},
+	{"type":"car","id":"car8","brand":"Skoda","model":"Fabia","year":2019,"color":"red","ownerID":"person9","price":"9000.00 EUR","vin":"TMBJJ7NJ2K1234567"},
+	{"type":"truck","id":"truck1"},
+	{"type":"car","id":"car7","brand":"Fiat","model":"Panda","year":2011,"color":"white","ownerID":"person1","price":"1000.00 EUR","vin":"ZFA31200900B54321"}
+]`
+
+// importedPersons are the private details of the imported persons, passed as transient data.
+var importedPersons = map[string][]byte{transientImportedPersonsKey: []byte(`{
+	"person4":{"emailAddress":"ana@pdasp.rs","amountOfMoneyOwned":"1000.00 EUR","salt":"` + testSalt + `"},
+	"person5":{"emailAddress":"not an address","amountOfMoneyOwned":"10.00 EUR","salt":"` + testSalt + `"}
+}`)}
+
+func TestBulkImport(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	registry := ledger.as(org1MSP, registryClientID)
+
+	var result *BulkImportResult
+	ledger.stub.SetTransient(importedPersons)
+	ledger.submit(func() error {
+		var err error
+		result, err = carsAndPersons.BulkImport(registry, importedRecords, false)
+		return err
+	})
+
+	require.Equal(t, []string{"person4"}, result.PersonIDs)
+	require.Equal(t, []string{"car7"}, result.CarIDs, "a car may be owned by a person of the same import")
+	require.Equal(t, []ImportFailure{
+		{Index: 2, Type: "car", ID: "car1", Reason: "the car asset car1 already exists"},
+		{Index: 3, Type: "person", ID: "person5", Reason: "the email address not an address is not valid"},
+		{Index: 4, Type: "car", ID: "car8", Reason: "the person person9 does not exist"},
+		{Index: 5, Type: "truck", ID: "truck1", Reason: `the record type "truck" is not car or person`},
+		{Index: 6, Type: "car", ID: "car7", Reason: "the car asset car7 is imported more than once"},
+	}, result.Failures)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(registry, "car7")
+	require.NoError(t, err)
+	require.Equal(t, "person4", carAsset.OwnerID)
+	require.Equal(t, carStatusActive, carAsset.Status)
+	require.Equal(t, newMoney(1000_00), personBalance(t, registry, "person4"))
+
+	redCars, err := carsAndPersons.GetCarsByColorAndOwner(registry, "red", "person4")
+	require.NoError(t, err)
+	require.Len(t, redCars, 1)
+
+	err = ledger.stub.Transact(func() error {
+		return carsAndPersons.DeletePersonAsset(registry, "person4")
+	})
+	require.EqualError(t, err, "the person person4 still owns cars and cannot be deleted")
+
+	event := ledger.stub.LastEvent()
+	require.Equal(t, eventAssetsImported, event.EventName)
+	var imported AssetsImportedEvent
+	require.NoError(t, json.Unmarshal(event.Payload, &imported))
+	require.Equal(t, AssetsImportedEvent{CarIDs: []string{"car7"}, PersonIDs: []string{"person4"}}, imported)
+}
+
+func TestBulkImportAllOrNothing(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	registry := ledger.as(org1MSP, registryClientID)
+
+	ledger.stub.SetTransient(importedPersons)
+	err := ledger.stub.Transact(func() error {
+		_, err := carsAndPersons.BulkImport(registry, importedRecords, true)
+		return err
+	})
+	require.EqualError(t, err, "nothing was imported, 5 of the records are not valid: "+
+		"record 2 (car car1): the car asset car1 already exists; "+
+		"record 3 (person person5): the email address not an address is not valid; "+
+		"record 4 (car car8): the person person9 does not exist; "+
+		`record 5 (truck truck1): the record type "truck" is not car or person; `+
+		"record 6 (car car7): the car asset car7 is imported more than once")
+
+	exists, err := carsAndPersons.PersonAssetExists(registry, "person4")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = ledger.stub.Transact(func() error {
+		_, err := carsAndPersons.BulkImport(registry, importedRecords, true)
+		return err
+	})
+	require.Contains(t, err.Error(), `record 1 (person person4): the email address and the amount of money owned of the person person4 are missing from the "importedPersons" transient data`)
+
+	for _, recordsJSON := range []string{`{"type":"car"}`, `[]`} {
+		err = ledger.stub.Transact(func() error {
+			_, err := carsAndPersons.BulkImport(registry, recordsJSON, false)
+			return err
+		})
+		require.Error(t, err, recordsJSON)
+	}
+
+	err = ledger.stub.Transact(func() error {
+		_, err := carsAndPersons.BulkImport(ledger.as(org2MSP, person2ClientID), importedRecords, false)
+		return err
+	})
+	require.Error(t, err, "only the registry may import")
+}
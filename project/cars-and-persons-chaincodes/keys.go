@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	carObjectType    = "car"
+	personObjectType = "person"
+
+	carKeyIndex    = "car~ID"
+	personKeyIndex = "person~ID"
+)
+
+func carKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(carKeyIndex, []string{id})
+}
+
+func personKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(personKeyIndex, []string{id})
+}
+
+func putCarAsset(ctx contractapi.TransactionContextInterface, carAsset *CarAsset) error {
+	key, err := carKey(ctx, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	carAsset.ObjectType = carObjectType
+	carAssetJSON, err := json.Marshal(carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, carAssetJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put car %s to world state: %v", carAsset.ID, err)
+	}
+
+	return nil
+}
+
+func putPersonAsset(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset) error {
+	key, err := personKey(ctx, personAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	personAsset.ObjectType = personObjectType
+	personAssetJSON, err := json.Marshal(personAsset)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, personAssetJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put person %s to world state: %v", personAsset.ID, err)
+	}
+
+	return nil
+}
+
+// checkObjectType guards against records whose discriminator does not match the
+// key they were read from, e.g. data written by an older chaincode version.
+func checkObjectType(id string, actual string, expected string) error {
+	if actual != expected {
+		return fmt.Errorf("the asset %s is a %q, not a %q", id, actual, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"fakestub"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests in this file run whole scenarios on the in-memory ledger of the fakestub
+// module, where every call is a separate transaction that only sees committed state.
+
+// fakeLedger is a seeded fake ledger with the persons linked to their clients.
+type fakeLedger struct {
+	t              *testing.T
+	stub           *fakestub.Stub
+	carsAndPersons SmartContract
+}
+
+func prepFakeLedger(t *testing.T) *fakeLedger {
+	ledger := &fakeLedger{t: t, stub: fakestub.New()}
+	for _, collection := range personCollections {
+		ledger.stub.DefineCollections(collection)
+	}
+	registry := ledger.as(org1MSP, registryClientID)
+
+	ledger.stub.SetTransient(map[string][]byte{transientSaltKey: []byte(testSalt)})
+	ledger.submit(func() error { return ledger.carsAndPersons.InitLedger(registry) })
+	ledger.submit(func() error {
+		return ledger.carsAndPersons.LinkPersonIdentity(registry, "person1", person1ClientID, org1MSP)
+	})
+	ledger.submit(func() error {
+		return ledger.carsAndPersons.LinkPersonIdentity(registry, "person2", person2ClientID, org2MSP)
+	})
+	ledger.submit(func() error {
+		return ledger.carsAndPersons.LinkPersonIdentity(registry, "person3", person3ClientID, org3MSP)
+	})
+
+	return ledger
+}
+
+// as returns a transaction context for the given client.
+func (l *fakeLedger) as(mspID string, clientID string) *contractapi.TransactionContext {
+	return fakestub.NewTransactionContext(l.stub, fakestub.NewClientIdentity(mspID, clientID))
+}
+
+// submit runs fn as a transaction that must succeed.
+func (l *fakeLedger) submit(fn func() error) {
+	require.NoError(l.t, l.stub.Transact(fn))
+}
+
+func TestSaleOnFakeLedger(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	seller := ledger.as(org3MSP, person3ClientID)
+	buyer := ledger.as(org2MSP, person2ClientID)
+
+	ledger.submit(func() error {
+		_, err := carsAndPersons.CreateSaleOffer(seller, "offer1", "car5", "person2", "4000", 3600)
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.AcceptSaleOffer(buyer, "offer1", "person2", false)
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.SettleSaleOffer(seller, "offer1")
+		return err
+	})
+	settleTxID := ledger.stub.LastTxID()
+
+	carAsset, err := carsAndPersons.ReadCarAsset(buyer, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person2", carAsset.OwnerID)
+
+	require.Equal(t, newMoney(5430_22), personBalance(t, seller, "person3"))
+
+	require.Equal(t, newMoney(4200_40), personBalance(t, buyer, "person2"))
+
+	blackCars, err := carsAndPersons.GetCarsByColorAndOwner(buyer, "black", "person2")
+	require.NoError(t, err)
+	require.Len(t, blackCars, 1)
+	blackCars, err = carsAndPersons.GetCarsByColorAndOwner(buyer, "black", "person3")
+	require.NoError(t, err)
+	require.Empty(t, blackCars)
+
+	event := ledger.stub.LastEvent()
+	require.Equal(t, eventCarTransferred, event.EventName)
+	require.Equal(t, settleTxID, event.TxId)
+
+	var transferred CarTransferredEvent
+	require.NoError(t, json.Unmarshal(event.Payload, &transferred))
+	require.Equal(t, CarTransferredEvent{CarID: "car5", OldOwnerID: "person3", NewOwnerID: "person2", Price: newMoney(4000_00)}, transferred)
+
+	records, err := carsAndPersons.GetCarHistory(buyer, "car5")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, settleTxID, records[1].TxId)
+	require.True(t, records[0].Timestamp.Before(records[1].Timestamp))
+	require.Equal(t, "person3", records[1].Changes.OldOwnerID)
+	require.Equal(t, "person2", records[1].Changes.NewOwnerID)
+}
+
+func TestFailedTransactionOnFakeLedgerIsRolledBack(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	owner := ledger.as(org1MSP, person1ClientID)
+	eventCount := len(ledger.stub.Events())
+
+	// car4 is worth 200.00 and already needs 170.00 of repairs, so another 40.00 gets it written off.
+	ledger.submit(func() error {
+		return carsAndPersons.AddCarMalfunction(owner, "car4", "Broken axle", "40", "minor")
+	})
+	require.Equal(t, eventCarWrittenOff, ledger.stub.LastEvent().EventName)
+
+	err := ledger.stub.Transact(func() error {
+		_, err := carsAndPersons.ChangeCarColor(owner, "car3", "purple")
+		if err != nil {
+			return err
+		}
+		return carsAndPersons.AddCarMalfunction(owner, "car4", "Flat tyre", "5", "minor")
+	})
+	require.EqualError(t, err, "the car car4 has been written off")
+
+	carAsset, err := carsAndPersons.ReadCarAsset(owner, "car3")
+	require.NoError(t, err)
+	require.Equal(t, "green", carAsset.Color, "the recoloring must be rolled back with the failed transaction")
+	require.Len(t, ledger.stub.Events(), eventCount+1)
+
+	greenCars, err := carsAndPersons.GetCarsByColor(owner, "green")
+	require.NoError(t, err)
+	require.Len(t, greenCars, 1)
+}
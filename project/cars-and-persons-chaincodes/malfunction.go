@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	severityMinor    = "minor"
+	severityMajor    = "major"
+	severityCritical = "critical"
+
+	malfunctionStatusOpen     = "open"
+	malfunctionStatusRepaired = "repaired"
+)
+
+// CarRepair records the repair of one malfunction. Repaired malfunctions stay in the
+// car's MalfunctionList with the repaired status, so no history is lost.
+type CarRepair struct {
+	MalfunctionID string
+	Price         Money
+	PaidBy        string
+	RepairedAt    time.Time
+}
+
+// RepairMalfunction repairs a single open malfunction of a car at the owner's expense.
+func (s *SmartContract) RepairMalfunction(ctx contractapi.TransactionContextInterface, carID string, malfunctionID string) error {
+	carAsset, err := s.ReadCarAsset(ctx, carID)
+	if err != nil {
+		return err
+	}
+
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return err
+	}
+
+	personAsset, err := s.requireCarOwner(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, carMalfunction := range carAsset.MalfunctionList {
+		if carMalfunction.ID == malfunctionID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("the car %s has no malfunction %s", carID, malfunctionID)
+	}
+	if carAsset.MalfunctionList[index].Status != malfunctionStatusOpen {
+		return fmt.Errorf("the malfunction %s of the car %s is already %s", malfunctionID, carID, carAsset.MalfunctionList[index].Status)
+	}
+
+	err = repairMalfunctions(ctx, carAsset, personAsset, []int{index})
+	if err != nil {
+		return err
+	}
+
+	return putCarAsset(ctx, carAsset)
+}
+
+func (s *SmartContract) ListOpenMalfunctions(ctx contractapi.TransactionContextInterface, carID string) ([]CarMalfunction, error) {
+	carAsset, err := s.ReadCarAsset(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	openMalfunctions := make([]CarMalfunction, 0)
+	for _, carMalfunction := range carAsset.MalfunctionList {
+		if carMalfunction.Status == malfunctionStatusOpen {
+			openMalfunctions = append(openMalfunctions, carMalfunction)
+		}
+	}
+
+	return openMalfunctions, nil
+}
+
+// repairMalfunctions charges the owner for the malfunctions at the given indexes of
+// the car's MalfunctionList, marks them as repaired, adds a repair entry for each and
+// emits the CarRepaired event. The owner's balance is updated in the owner's private
+// data collection, but the caller still has to store the car.
+func repairMalfunctions(ctx contractapi.TransactionContextInterface, carAsset *CarAsset, personAsset *PersonAsset, indexes []int) error {
+	repairPriceSum := newMoney(0)
+	for _, index := range indexes {
+		var err error
+		repairPriceSum, err = repairPriceSum.Add(carAsset.MalfunctionList[index].RepairPrice)
+		if err != nil {
+			return err
+		}
+	}
+
+	details, err := readPersonDetails(ctx, personAsset)
+	if err != nil {
+		return err
+	}
+
+	cmp, err := repairPriceSum.Cmp(details.AmountOfMoneyOwned)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("The owner of the car cannot afford to pay the car repair price")
+	}
+
+	details.AmountOfMoneyOwned, err = details.AmountOfMoneyOwned.Sub(repairPriceSum)
+	if err != nil {
+		return err
+	}
+
+	err = putPersonDetails(ctx, personAsset, details)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	repairs := make([]CarRepair, 0, len(indexes))
+	for _, index := range indexes {
+		carMalfunction := &carAsset.MalfunctionList[index]
+		carMalfunction.Status = malfunctionStatusRepaired
+
+		repairs = append(repairs, CarRepair{
+			MalfunctionID: carMalfunction.ID,
+			Price:         carMalfunction.RepairPrice,
+			PaidBy:        personAsset.ID,
+			RepairedAt:    now,
+		})
+	}
+	carAsset.RepairList = append(carAsset.RepairList, repairs...)
+
+	return emitEvent(ctx, eventCarRepaired, CarRepairedEvent{
+		CarID:   carAsset.ID,
+		OwnerID: carAsset.OwnerID,
+		Repairs: repairs,
+	})
+}
+
+// openMalfunctionIndexes returns the positions of the malfunctions still to be repaired.
+func openMalfunctionIndexes(carAsset *CarAsset) []int {
+	var indexes []int
+	for i, carMalfunction := range carAsset.MalfunctionList {
+		if carMalfunction.Status == malfunctionStatusOpen {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}
+
+// normalizeMalfunctions fills in the IDs and statuses of malfunctions recorded before
+// they were structured. IDs follow the position in the list, which never shrinks.
+func normalizeMalfunctions(carAsset *CarAsset) {
+	if carAsset.MalfunctionList == nil {
+		carAsset.MalfunctionList = []CarMalfunction{}
+	}
+	if carAsset.RepairList == nil {
+		carAsset.RepairList = []CarRepair{}
+	}
+
+	for i := range carAsset.MalfunctionList {
+		if carAsset.MalfunctionList[i].ID == "" {
+			carAsset.MalfunctionList[i].ID = malfunctionID(i)
+		}
+		if carAsset.MalfunctionList[i].Status == "" {
+			carAsset.MalfunctionList[i].Status = malfunctionStatusOpen
+		}
+	}
+}
+
+func malfunctionID(index int) string {
+	return fmt.Sprintf("m%d", index+1)
+}
+
+func validateSeverity(severity string) error {
+	switch severity {
+	case severityMinor, severityMajor, severityCritical:
+		return nil
+	default:
+		return fmt.Errorf("the severity %q is not one of %s, %s or %s", severity, severityMinor, severityMajor, severityCritical)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairMalfunction(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err := carsAndPersons.RepairMalfunction(transactionContext, "car1", "m2")
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, malfunctionStatusOpen, carAsset.MalfunctionList[0].Status)
+	require.Equal(t, malfunctionStatusRepaired, carAsset.MalfunctionList[1].Status)
+	require.Equal(t, []CarRepair{{MalfunctionID: "m2", Price: newMoney(75_00), PaidBy: "person1", RepairedAt: testTime}}, carAsset.RepairList)
+
+	require.Equal(t, newMoney(5325_54), personBalance(t, transactionContext, "person1"))
+
+	eventName, _ := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarRepaired, eventName)
+
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car1", "m2")
+	require.EqualError(t, err, "the malfunction m2 of the car car1 is already repaired")
+
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car1", "m9")
+	require.EqualError(t, err, "the car car1 has no malfunction m9")
+
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car2", "m1")
+	require.EqualError(t, err, "only the owner can modify the car car2: client is not authorized to act on behalf of person2")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Broken gearbox", "1500", severityCritical)
+	require.NoError(t, err)
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car5", "m1")
+	require.EqualError(t, err, "The owner of the car cannot afford to pay the car repair price")
+}
+
+func TestListOpenMalfunctions(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	malfunctions, err := carsAndPersons.ListOpenMalfunctions(transactionContext, "car5")
+	require.NoError(t, err)
+	require.NotNil(t, malfunctions)
+	require.Empty(t, malfunctions)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car4", "m3")
+	require.NoError(t, err)
+
+	malfunctions, err = carsAndPersons.ListOpenMalfunctions(transactionContext, "car4")
+	require.NoError(t, err)
+	require.Len(t, malfunctions, 3)
+	for _, carMalfunction := range malfunctions {
+		require.NotEqual(t, "m3", carMalfunction.ID)
+	}
+
+	_, err = carsAndPersons.ListOpenMalfunctions(transactionContext, "car9")
+	require.EqualError(t, err, "the car asset car9 does not exist")
+}
+
+func TestMalfunctionLifecycle(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err := carsAndPersons.RepairMalfunction(transactionContext, "car4", "m4")
+	require.NoError(t, err)
+
+	// Without the 80.00 of the repaired overheating, 190.00 of repairs are open out of
+	// the 200.00 price of car4, so it is not written off.
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityMajor)
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car4")
+	require.NoError(t, err)
+	require.Equal(t, carStatusActive, carAsset.Status)
+	require.Len(t, carAsset.MalfunctionList, 5, "repaired malfunctions are kept")
+	require.Equal(t, malfunctionStatusRepaired, carAsset.MalfunctionList[3].Status)
+
+	reported := carAsset.MalfunctionList[4]
+	require.Equal(t, "m5", reported.ID)
+	require.Equal(t, person1ClientID, reported.ReportedBy)
+	require.Equal(t, testTime, reported.ReportedAt)
+	require.Equal(t, []CarRepair{{MalfunctionID: "m4", Price: newMoney(80_00), PaidBy: "person1", RepairedAt: testTime}}, carAsset.RepairList)
+
+	malfunctions, err := carsAndPersons.ListOpenMalfunctions(transactionContext, "car4")
+	require.NoError(t, err)
+	var openIDs []string
+	for _, carMalfunction := range malfunctions {
+		openIDs = append(openIDs, carMalfunction.ID)
+	}
+	require.Equal(t, []string{"m1", "m2", "m3", "m5"}, openIDs)
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrateMoneyToMinorUnits rewrites car and person records whose prices and balances
+// were stored as float32 numbers into Money values. The decimal literal stored on the
+// ledger is converted exactly; only drift below one minor unit, left behind by earlier
+// float arithmetic, is rounded away. Records that are already migrated are skipped, so
+// the transaction is safe to run more than once. Both bare and typed keys are scanned.
+// It returns the number of rewritten records.
+func (s *SmartContract) MigrateMoneyToMinorUnits(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+
+	legacyIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	count, err := migrateMoneyInRecords(ctx, legacyIterator)
+	if err != nil {
+		return 0, err
+	}
+	migrated += count
+
+	for _, keyIndex := range []string{carKeyIndex, personKeyIndex} {
+		typedIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(keyIndex, []string{})
+		if err != nil {
+			return 0, err
+		}
+		count, err := migrateMoneyInRecords(ctx, typedIterator)
+		if err != nil {
+			return 0, err
+		}
+		migrated += count
+	}
+
+	return migrated, nil
+}
+
+// MigrateToTypedKeys moves cars and persons stored under bare IDs, as written by
+// earlier chaincode versions, to their car~ID and person~ID keys and tags them with
+// their object type. Float money fields are converted on the way, as in
+// MigrateMoneyToMinorUnits, and the private details of persons are moved to the
+// private data collections, salted like those of InitLedger. It returns the number of
+// moved records.
+func (s *SmartContract) MigrateToTypedKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(queryResponse.Value, &fields); err != nil {
+			continue
+		}
+
+		switch legacyObjectType(fields) {
+		case personObjectType:
+			_, personAssetJSON, err := migratePersonMoney(fields)
+			if err != nil {
+				return 0, fmt.Errorf("failed to migrate %s: %v", queryResponse.Key, err)
+			}
+			if personAssetJSON == nil {
+				personAssetJSON = queryResponse.Value
+			}
+
+			personAsset, details, err := splitLegacyPerson(ctx, personAssetJSON)
+			if err != nil {
+				return 0, err
+			}
+
+			exists, err := s.PersonAssetExists(ctx, personAsset.ID)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				return 0, fmt.Errorf("cannot migrate %s, the person asset %s already exists", queryResponse.Key, personAsset.ID)
+			}
+
+			err = putPersonAsset(ctx, personAsset)
+			if err != nil {
+				return 0, err
+			}
+
+			err = putPersonDetails(ctx, personAsset, details)
+			if err != nil {
+				return 0, err
+			}
+
+		case carObjectType:
+			_, carAssetJSON, err := migrateCarMoney(fields)
+			if err != nil {
+				return 0, fmt.Errorf("failed to migrate %s: %v", queryResponse.Key, err)
+			}
+			if carAssetJSON == nil {
+				carAssetJSON = queryResponse.Value
+			}
+
+			var carAsset CarAsset
+			err = json.Unmarshal(carAssetJSON, &carAsset)
+			if err != nil {
+				return 0, err
+			}
+
+			exists, err := s.CarAssetExists(ctx, carAsset.ID)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				return 0, fmt.Errorf("cannot migrate %s, the car asset %s already exists", queryResponse.Key, carAsset.ID)
+			}
+
+			err = putCarAsset(ctx, &carAsset)
+			if err != nil {
+				return 0, err
+			}
+
+		default:
+			continue
+		}
+
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigratePersonDetailsToPrivateData moves the email addresses and balances that earlier
+// chaincode versions stored in the person records of the world state to the private
+// data collections, converting float balances on the way and salting them like
+// InitLedger does. Persons that are already migrated are skipped. It returns the number
+// of migrated persons.
+func (s *SmartContract) MigratePersonDetailsToPrivateData(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(personKeyIndex, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(queryResponse.Value, &fields); err != nil {
+			continue
+		}
+		if legacyObjectType(fields) != personObjectType {
+			continue
+		}
+
+		_, personAssetJSON, err := migratePersonMoney(fields)
+		if err != nil {
+			return 0, fmt.Errorf("failed to migrate %s: %v", queryResponse.Key, err)
+		}
+		if personAssetJSON == nil {
+			personAssetJSON = queryResponse.Value
+		}
+
+		personAsset, details, err := splitLegacyPerson(ctx, personAssetJSON)
+		if err != nil {
+			return 0, err
+		}
+
+		err = putPersonAsset(ctx, personAsset)
+		if err != nil {
+			return 0, err
+		}
+
+		err = putPersonDetails(ctx, personAsset, details)
+		if err != nil {
+			return 0, fmt.Errorf("failed to migrate %s: %v", queryResponse.Key, err)
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateCarEndorsementPolicies gives the cars stored by earlier chaincode versions,
+// which have no key-level endorsement policy, one that requires the organization of
+// their owner, as if they had just been created. Cars that already have a policy are
+// skipped. It returns the number of migrated cars.
+func (s *SmartContract) MigrateCarEndorsementPolicies(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	err = forEachCar(ctx, func(carAsset *CarAsset) error {
+		key, err := carKey(ctx, carAsset.ID)
+		if err != nil {
+			return err
+		}
+
+		policy, err := ctx.GetStub().GetStateValidationParameter(key)
+		if err != nil {
+			return fmt.Errorf("failed to read the validation parameter of car %s: %v", carAsset.ID, err)
+		}
+		if len(policy) > 0 {
+			return nil
+		}
+
+		organization, err := s.ownerOrganization(ctx, carAsset.OwnerID)
+		if err != nil {
+			return fmt.Errorf("failed to migrate car %s: %v", carAsset.ID, err)
+		}
+
+		err = setCarEndorsement(ctx, carAsset.ID, organization)
+		if err != nil {
+			return err
+		}
+		migrated++
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return migrated, nil
+}
+
+// MigrateOwnerIndex adds the cars stored by earlier chaincode versions to the owner~ID
+// index, which DeletePersonAsset looks the cars of a person up in. Written-off cars and
+// cars that are already indexed are skipped. It returns the number of migrated cars.
+func (s *SmartContract) MigrateOwnerIndex(ctx contractapi.TransactionContextInterface) (int, error) {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	err = forEachCar(ctx, func(carAsset *CarAsset) error {
+		if carAsset.Status == carStatusWrittenOff {
+			return nil
+		}
+
+		key, err := ctx.GetStub().CreateCompositeKey(ownerIndex, []string{carAsset.OwnerID, carAsset.ID})
+		if err != nil {
+			return err
+		}
+
+		value, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("failed to read the owner index of car %s: %v", carAsset.ID, err)
+		}
+		if value != nil {
+			return nil
+		}
+
+		err = putOwnerIndex(ctx, carAsset.OwnerID, carAsset.ID)
+		if err != nil {
+			return err
+		}
+		migrated++
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return migrated, nil
+}
+
+func migrateMoneyInRecords(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) (int, error) {
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(queryResponse.Value, &fields); err != nil {
+			continue
+		}
+
+		var changed bool
+		var migratedJSON []byte
+		switch legacyObjectType(fields) {
+		case personObjectType:
+			changed, migratedJSON, err = migratePersonMoney(fields)
+		case carObjectType:
+			changed, migratedJSON, err = migrateCarMoney(fields)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to migrate %s: %v", queryResponse.Key, err)
+		}
+		if !changed {
+			continue
+		}
+
+		err = ctx.GetStub().PutState(queryResponse.Key, migratedJSON)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// legacyObjectType tells cars and persons apart by their fields, because records
+// written before the objectType discriminator was introduced do not carry it. Persons
+// are only recognized while they still hold their balance in the world state.
+func legacyObjectType(fields map[string]json.RawMessage) string {
+	if _, isPerson := fields["AmountOfMoneyOwned"]; isPerson {
+		return personObjectType
+	}
+	if _, isCar := fields["MalfunctionList"]; isCar {
+		return carObjectType
+	}
+
+	return ""
+}
+
+func migratePersonMoney(fields map[string]json.RawMessage) (bool, []byte, error) {
+	changed, err := migrateMoneyField(fields, "AmountOfMoneyOwned")
+	if err != nil || !changed {
+		return false, nil, err
+	}
+
+	var personAsset legacyPersonAsset
+	personAssetJSON, err := remarshal(fields, &personAsset)
+	return true, personAssetJSON, err
+}
+
+// legacyPersonAsset is a person as stored before the email address and the balance
+// moved to the private data collections.
+type legacyPersonAsset struct {
+	PersonAsset
+	EmailAddress       string
+	AmountOfMoneyOwned Money
+}
+
+// splitLegacyPerson separates the public record of a legacy person from the details
+// that are now kept in a private data collection, salted with a salt derived from the
+// "salt" transient data.
+func splitLegacyPerson(ctx contractapi.TransactionContextInterface, personAssetJSON []byte) (*PersonAsset, *PersonPrivateDetails, error) {
+	var legacyPerson legacyPersonAsset
+	err := json.Unmarshal(personAssetJSON, &legacyPerson)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	saltSeed, err := readSaltSeed(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	details := &PersonPrivateDetails{
+		EmailAddress:       legacyPerson.EmailAddress,
+		AmountOfMoneyOwned: legacyPerson.AmountOfMoneyOwned,
+		Salt:               personSalt(saltSeed, legacyPerson.ID),
+	}
+
+	return &legacyPerson.PersonAsset, details, nil
+}
+
+func migrateCarMoney(fields map[string]json.RawMessage) (bool, []byte, error) {
+	changed, err := migrateMoneyField(fields, "Price")
+	if err != nil {
+		return false, nil, err
+	}
+
+	var malfunctions []map[string]json.RawMessage
+	if raw, ok := fields["MalfunctionList"]; ok {
+		if err := json.Unmarshal(raw, &malfunctions); err != nil {
+			return false, nil, err
+		}
+	}
+	for _, malfunction := range malfunctions {
+		malfunctionChanged, err := migrateMoneyField(malfunction, "RepairPrice")
+		if err != nil {
+			return false, nil, err
+		}
+		changed = changed || malfunctionChanged
+	}
+	if !changed {
+		return false, nil, nil
+	}
+
+	fields["MalfunctionList"], err = json.Marshal(malfunctions)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var carAsset CarAsset
+	carAssetJSON, err := remarshal(fields, &carAsset)
+	return true, carAssetJSON, err
+}
+
+// migrateMoneyField replaces a legacy JSON number with a Money object. Fields that
+// already hold an object are left untouched.
+func migrateMoneyField(fields map[string]json.RawMessage, name string) (bool, error) {
+	raw, ok := fields[name]
+	if !ok {
+		return false, nil
+	}
+
+	var legacyAmount json.Number
+	if err := json.Unmarshal(raw, &legacyAmount); err != nil {
+		return false, nil
+	}
+
+	amount, err := parseMinorUnits(legacyAmount.String(), true)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %s: %v", name, legacyAmount, err)
+	}
+
+	fields[name], err = json.Marshal(newMoney(amount))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// remarshal round-trips the migrated fields through the asset type, so the stored
+// JSON keeps the same shape as records written by the other transactions.
+func remarshal(fields map[string]json.RawMessage, asset interface{}) ([]byte, error) {
+	fieldsJSON, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(fieldsJSON, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(asset)
+}
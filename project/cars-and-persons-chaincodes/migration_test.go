@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Records written by chaincode versions that stored money as float32 under bare keys.
+var (
+	legacyCarJSON = []byte(`{"ID":"car7","Brand":"Fiat","Model":"Punto","Year":2005,"Color":"grey","OwnerID":"person7","Price":999.99,` +
+		`"MalfunctionList":[{"Description":"Worn brakes","RepairPrice":49.990002}]}`)
+	legacyPersonJSON = []byte(`{"ID":"person7","FirstName":"Ana","LastName":"Anic","EmailAddress":"ana@pdasp.rs","AmountOfMoneyOwned":5400.54}`)
+)
+
+func TestMigrateMoneyToMinorUnits(t *testing.T) {
+	transactionContext, _, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	state["car7"] = legacyCarJSON
+	state["person7"] = legacyPersonJSON
+	key, err := personKey(transactionContext, "person8")
+	require.NoError(t, err)
+	state[key] = []byte(`{"objectType":"person","ID":"person8","FirstName":"Ivan","LastName":"Ivic","EmailAddress":"ivan@pdasp.rs","AmountOfMoneyOwned":0.1}`)
+
+	migrated, err := carsAndPersons.MigrateMoneyToMinorUnits(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 3, migrated)
+
+	require.JSONEq(t, `{"objectType":"","ID":"car7","Brand":"Fiat","Model":"Punto","Year":2005,"Color":"grey","OwnerID":"person7",`+
+		`"Price":{"Amount":99999,"Currency":"EUR"},"MalfunctionList":[{"ID":"","Description":"Worn brakes","RepairPrice":{"Amount":4999,"Currency":"EUR"},`+
+		`"Severity":"","Status":"","ReportedAt":"0001-01-01T00:00:00Z","ReportedBy":""}],"RepairList":null,"Status":""}`, string(state["car7"]))
+
+	require.JSONEq(t, `{"objectType":"person","ID":"person8","FirstName":"Ivan","LastName":"Ivic","ClientID":"","MSPID":"",`+
+		`"EmailAddress":"ivan@pdasp.rs","AmountOfMoneyOwned":{"Amount":10,"Currency":"EUR"}}`, string(state[key]))
+
+	migrated, err = carsAndPersons.MigrateMoneyToMinorUnits(transactionContext)
+	require.NoError(t, err)
+	require.Zero(t, migrated, "migrated records are skipped")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.MigrateMoneyToMinorUnits(transactionContext)
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestMigrateToTypedKeys(t *testing.T) {
+	transactionContext, _, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	state["car7"] = legacyCarJSON
+	state["person7"] = legacyPersonJSON
+	state["note"] = []byte("not a record")
+
+	migrated, err := carsAndPersons.MigrateToTypedKeys(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+	require.Nil(t, state["car7"])
+	require.Nil(t, state["person7"])
+	require.NotNil(t, state["note"], "unknown records are left alone")
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, newMoney(999_99), carAsset.Price)
+	require.Equal(t, newMoney(49_99), carAsset.MalfunctionList[0].RepairPrice)
+
+	require.Equal(t, newMoney(5400_54), personBalance(t, transactionContext, "person7"))
+
+	migrated, err = carsAndPersons.MigrateToTypedKeys(transactionContext)
+	require.NoError(t, err)
+	require.Zero(t, migrated)
+
+	state["car1"] = []byte(`{"ID":"car1","Brand":"Opel","Model":"Cascada","Year":2013,"Color":"blue","OwnerID":"person1","Price":2500,"MalfunctionList":[]}`)
+	_, err = carsAndPersons.MigrateToTypedKeys(transactionContext)
+	require.EqualError(t, err, "cannot migrate car1, the car asset car1 already exists")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.MigrateToTypedKeys(transactionContext)
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestMigratePersonDetailsToPrivateData(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	key, err := personKey(transactionContext, "person8")
+	require.NoError(t, err)
+	state[key] = []byte(`{"objectType":"person","ID":"person8","FirstName":"Ivan","LastName":"Ivic","EmailAddress":"ivan@pdasp.rs","AmountOfMoneyOwned":0.1}`)
+
+	chaincodeStub.GetTransientReturns(nil, nil)
+	_, err = carsAndPersons.MigratePersonDetailsToPrivateData(transactionContext)
+	require.EqualError(t, err, `a random salt must be passed in the "salt" transient data`)
+
+	setSaltSeed(chaincodeStub)
+	migrated, err := carsAndPersons.MigratePersonDetailsToPrivateData(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+	require.JSONEq(t, `{"objectType":"person","ID":"person8","FirstName":"Ivan","LastName":"Ivic","ClientID":"","MSPID":""}`, string(state[key]))
+
+	personAsset, err := carsAndPersons.ReadPersonAsset(transactionContext, "person8")
+	require.NoError(t, err)
+	details, err := getPersonDetails(transactionContext, personAsset)
+	require.NoError(t, err)
+	require.Equal(t, &PersonPrivateDetails{ID: "person8", EmailAddress: "ivan@pdasp.rs", AmountOfMoneyOwned: newMoney(10), Salt: personSalt(testSalt, "person8")}, details)
+
+	migrated, err = carsAndPersons.MigratePersonDetailsToPrivateData(transactionContext)
+	require.NoError(t, err)
+	require.Zero(t, migrated, "migrated persons are skipped")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.MigratePersonDetailsToPrivateData(transactionContext)
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestMigrateCarEndorsementPolicies(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	// Cars stored before key-level endorsement policies were introduced have none.
+	key, err := carKey(transactionContext, "car2")
+	require.NoError(t, err)
+	carAssetJSON := state[key]
+	require.NoError(t, chaincodeStub.DelState(key))
+	require.NoError(t, chaincodeStub.PutState(key, carAssetJSON))
+
+	policy, err := carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Empty(t, policy.Organizations)
+
+	migrated, err := carsAndPersons.MigrateCarEndorsementPolicies(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	policy, err = carsAndPersons.GetCarEndorsementPolicy(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, []string{org2MSP}, policy.Organizations)
+
+	migrated, err = carsAndPersons.MigrateCarEndorsementPolicies(transactionContext)
+	require.NoError(t, err)
+	require.Zero(t, migrated, "cars with a policy are skipped")
+}
+
+func TestMigrateOwnerIndex(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	// Cars stored before the owner~ID index was introduced are not in it.
+	for _, entry := range [][]string{{"person2", "car2"}, {"person3", "car5"}} {
+		key, err := chaincodeStub.CreateCompositeKey(ownerIndex, entry)
+		require.NoError(t, err)
+		require.NoError(t, chaincodeStub.DelState(key))
+	}
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err := carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.MigrateOwnerIndex(transactionContext)
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+
+	setClient(transactionContext, org1MSP, registryClientID)
+	migrated, err := carsAndPersons.MigrateOwnerIndex(transactionContext)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+	require.Contains(t, state.indexEntries(t, ownerIndex), "person2/car2")
+	require.Contains(t, state.indexEntries(t, ownerIndex), "person3/car5")
+	require.NotContains(t, state.indexEntries(t, ownerIndex), "person1/car4", "written-off cars are skipped")
+
+	migrated, err = carsAndPersons.MigrateOwnerIndex(transactionContext)
+	require.NoError(t, err)
+	require.Zero(t, migrated, "indexed cars are skipped")
+
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person3")
+	require.EqualError(t, err, "the person person3 still owns cars and cannot be deleted")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	defaultCurrency = "EUR"
+	minorUnitDigits = 2
+	minorUnitScale  = 100
+)
+
+// Money is an amount expressed in the minor units (e.g. cents) of its currency,
+// so that balances are never subject to floating point rounding.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// newMoney returns an amount of minor units in the default currency, so 2500_00 is 2500.00 EUR.
+func newMoney(amount int64) Money {
+	return Money{Amount: amount, Currency: defaultCurrency}
+}
+
+// ParseMoney parses decimal amounts such as "5400.54" or "5400.54 EUR". The currency
+// defaults to EUR and at most two fractional digits are accepted.
+func ParseMoney(value string) (Money, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Money{}, fmt.Errorf("the amount %q is not valid", value)
+	}
+
+	currency := defaultCurrency
+	if len(fields) == 2 {
+		currency = strings.ToUpper(fields[1])
+	}
+	if currency != defaultCurrency {
+		return Money{}, fmt.Errorf("the currency %s is not supported", currency)
+	}
+
+	amount, err := parseMinorUnits(fields[0], false)
+	if err != nil {
+		return Money{}, fmt.Errorf("the amount %q is not valid: %v", value, err)
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// parseMinorUnits converts a decimal string to minor units. When round is false,
+// values that do not fit in minor units exactly are rejected; otherwise they are
+// rounded half away from zero.
+func parseMinorUnits(value string, round bool) (int64, error) {
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0, fmt.Errorf("not a decimal number")
+	}
+
+	rat.Mul(rat, big.NewRat(minorUnitScale, 1))
+	if !rat.IsInt() && !round {
+		return 0, fmt.Errorf("more than %d fractional digits", minorUnitDigits)
+	}
+
+	num, denom := rat.Num(), rat.Denom()
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(denom) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("out of range")
+	}
+
+	return quo.Int64(), nil
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot subtract %s from %s", other.Currency, m.Currency)
+	}
+
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+// Cmp compares two amounts of the same currency and returns -1, 0 or +1.
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, fmt.Errorf("cannot compare %s with %s", m.Currency, other.Currency)
+	}
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/minorUnitScale, minorUnitDigits, amount%minorUnitScale, m.Currency)
+}
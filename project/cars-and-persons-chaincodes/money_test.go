@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	for value, expected := range map[string]Money{
+		"5400.54":     newMoney(5400_54),
+		"5400.54 EUR": newMoney(5400_54),
+		"5400.5 eur":  newMoney(5400_50),
+		"12":          newMoney(12_00),
+		"-0.01":       newMoney(-1),
+	} {
+		money, err := ParseMoney(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, money, value)
+	}
+
+	for _, value := range []string{"", "12.345", "12 USD", "twelve", "12 EUR extra", "99999999999999999999"} {
+		_, err := ParseMoney(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestParseMinorUnitsRounding(t *testing.T) {
+	// Drift left behind by float32 arithmetic is rounded half away from zero.
+	for value, expected := range map[string]int64{
+		"49.990002": 49_99,
+		"0.005":     1,
+		"-0.005":    -1,
+		"0.0049":    0,
+	} {
+		amount, err := parseMinorUnits(value, true)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, amount, value)
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	sum, err := newMoney(10_50).Add(newMoney(75))
+	require.NoError(t, err)
+	require.Equal(t, newMoney(11_25), sum)
+
+	difference, err := newMoney(10_50).Sub(newMoney(11_00))
+	require.NoError(t, err)
+	require.True(t, difference.IsNegative())
+	require.Equal(t, "-0.50 EUR", difference.String())
+
+	cmp, err := newMoney(1).Cmp(newMoney(2))
+	require.NoError(t, err)
+	require.Equal(t, -1, cmp)
+
+	dollars := Money{Amount: 1, Currency: "USD"}
+	_, err = newMoney(1).Add(dollars)
+	require.EqualError(t, err, "cannot add USD to EUR")
+	_, err = newMoney(1).Sub(dollars)
+	require.EqualError(t, err, "cannot subtract USD from EUR")
+	_, err = newMoney(1).Cmp(dollars)
+	require.EqualError(t, err, "cannot compare EUR with USD")
+}
+
+func TestRepairPricesAddUpExactly(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	// Ten repair prices of 0.10 added up in float32 come to 1.0000001, not 1.00.
+	setClient(transactionContext, org3MSP, person3ClientID)
+	for i := 0; i < 10; i++ {
+		err := carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Loose screw", "0.10", severityMinor)
+		require.NoError(t, err)
+	}
+	err := carsAndPersons.RepairCar(transactionContext, "car5")
+	require.NoError(t, err)
+
+	require.Equal(t, newMoney(1429_22), personBalance(t, transactionContext, "person3"))
+
+	// The repaired car is sold at its full price of 5300.00 EUR.
+	sellCar(t, transactionContext, "offer1", "car5", "person2", "5300")
+	require.Equal(t, newMoney(2900_40), personBalance(t, transactionContext, "person2"))
+}
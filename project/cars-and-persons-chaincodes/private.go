@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// transientPersonKey carries the email address, the amount of money owned and the salt
+	// of the person created or updated by the registry, so that they are not recorded in
+	// the arguments of the transaction.
+	transientPersonKey = "person"
+	// transientImportedPersonsKey carries the same details for the persons of a
+	// BulkImport, as a JSON object keyed by person ID.
+	transientImportedPersonsKey = "importedPersons"
+	// transientPersonDetailsKey carries a JSON array of the current PersonPrivateDetails
+	// of persons whose collection the endorsing peer is not a member of, as returned by
+	// ReadPersonPrivateDetails. They are checked against the hashes on the ledger.
+	transientPersonDetailsKey = "personDetails"
+	// transientSaltKey carries a random value from which InitLedger and
+	// MigratePersonDetailsToPrivateData derive the salts of the persons they store.
+	transientSaltKey = "salt"
+
+	// minSaltLength is the minimum length of a salt, e.g. 16 random bytes in hex.
+	minSaltLength = 32
+)
+
+// personCollections are the private data collections of the organizations, defined in
+// collections_config.json. The details of a person are kept in the collection of the
+// organization the person is linked to, and in the registry's until they are linked.
+var personCollections = map[string]string{
+	"Org1MSP": "Org1MSPPrivateCollection",
+	"Org2MSP": "Org2MSPPrivateCollection",
+	"Org3MSP": "Org3MSPPrivateCollection",
+}
+
+// PersonPrivateDetails are the details of a person that only the peers of the person's
+// organization store. The other peers of the channel only store their hash. The salt is
+// a random value chosen by the client of the registry, without which the hash could be
+// reversed by hashing likely email addresses and balances until one matches.
+type PersonPrivateDetails struct {
+	ID                 string
+	EmailAddress       string
+	AmountOfMoneyOwned Money
+	Salt               string
+}
+
+// personInput are the private details of a person created or updated by the registry.
+type personInput struct {
+	EmailAddress       string `json:"emailAddress"`
+	AmountOfMoneyOwned string `json:"amountOfMoneyOwned"`
+	Salt               string `json:"salt"`
+}
+
+// ReadPersonPrivateDetails returns the email address and the balance of a person. Only
+// the person and the registry may read them, and only on the peers of the person's
+// organization.
+func (s *SmartContract) ReadPersonPrivateDetails(ctx contractapi.TransactionContextInterface, id string) (*PersonPrivateDetails, error) {
+	personAsset, err := s.ReadPersonAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if requireRegistry(ctx) != nil {
+		err = requirePerson(ctx, personAsset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return getPersonDetails(ctx, personAsset)
+}
+
+func personCollection(personAsset *PersonAsset) (string, error) {
+	mspID := personOrganization(personAsset)
+	collection, ok := personCollections[mspID]
+	if !ok {
+		return "", fmt.Errorf("the organization %s has no private data collection", mspID)
+	}
+
+	return collection, nil
+}
+
+// readPersonDetails returns the private details of a person passed in the transient
+// data, once they match the hash on the ledger, and otherwise reads them from the
+// collection, which only works on the peers of the person's organization.
+func readPersonDetails(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset) (*PersonPrivateDetails, error) {
+	details, err := transientPersonDetails(ctx, personAsset.ID)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil {
+		return getPersonDetails(ctx, personAsset)
+	}
+
+	collection, err := personCollection(personAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := personKey(ctx, personAsset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := ctx.GetStub().GetPrivateDataHash(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the private details hash of person %s: %v", personAsset.ID, err)
+	}
+	if hash == nil {
+		return nil, fmt.Errorf("the private details of the person %s do not exist", personAsset.ID)
+	}
+
+	// The details are marshaled the way putPersonDetails stores them, so the hash
+	// does not depend on how the client formatted them.
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+	transientHash := sha256.Sum256(detailsJSON)
+	if !bytes.Equal(transientHash[:], hash) {
+		return nil, fmt.Errorf("the private details of the person %s passed in do not match the ledger", personAsset.ID)
+	}
+
+	return details, nil
+}
+
+func getPersonDetails(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset) (*PersonPrivateDetails, error) {
+	collection, err := personCollection(personAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := personKey(ctx, personAsset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	detailsJSON, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the private details of person %s from %s, pass them in the %q transient data instead: %v", personAsset.ID, collection, transientPersonDetailsKey, err)
+	}
+	if detailsJSON == nil {
+		return nil, fmt.Errorf("the private details of the person %s do not exist", personAsset.ID)
+	}
+
+	var details PersonPrivateDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
+
+func transientPersonDetails(ctx contractapi.TransactionContextInterface, id string) (*PersonPrivateDetails, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	detailsJSON, ok := transientMap[transientPersonDetailsKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var detailsList []*PersonPrivateDetails
+	err = json.Unmarshal(detailsJSON, &detailsList)
+	if err != nil {
+		return nil, fmt.Errorf("the %q transient data must be a JSON array of person details: %v", transientPersonDetailsKey, err)
+	}
+
+	for _, details := range detailsList {
+		if details.ID == id {
+			return details, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// putPersonDetails writes the private details to the collection of the person's
+// organization. The write does not read the collection, so any peer can endorse it.
+func putPersonDetails(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset, details *PersonPrivateDetails) error {
+	collection, err := personCollection(personAsset)
+	if err != nil {
+		return err
+	}
+
+	key, err := personKey(ctx, personAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	details.ID = personAsset.ID
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(collection, key, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the private details of person %s to %s: %v", personAsset.ID, collection, err)
+	}
+
+	return nil
+}
+
+func deletePersonDetails(ctx contractapi.TransactionContextInterface, personAsset *PersonAsset) error {
+	collection, err := personCollection(personAsset)
+	if err != nil {
+		return err
+	}
+
+	key, err := personKey(ctx, personAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelPrivateData(collection, key)
+}
+
+// readPersonInput reads the private details of a person created or updated by the
+// registry from the transient data.
+func readPersonInput(ctx contractapi.TransactionContextInterface) (*PersonPrivateDetails, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	inputJSON, ok := transientMap[transientPersonKey]
+	if !ok {
+		return nil, fmt.Errorf("the email address, the amount of money owned and a random salt must be passed in the %q transient data", transientPersonKey)
+	}
+
+	var input personInput
+	err = json.Unmarshal(inputJSON, &input)
+	if err != nil {
+		return nil, fmt.Errorf("the %q transient data is not valid: %v", transientPersonKey, err)
+	}
+
+	return parsePersonInput(input)
+}
+
+func parsePersonInput(input personInput) (*PersonPrivateDetails, error) {
+	money, err := ParseMoney(input.AmountOfMoneyOwned)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &PersonPrivateDetails{EmailAddress: input.EmailAddress, AmountOfMoneyOwned: money, Salt: input.Salt}
+
+	err = validatePersonDetails(details)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+func validatePersonDetails(details *PersonPrivateDetails) error {
+	if _, err := mail.ParseAddress(details.EmailAddress); err != nil {
+		return fmt.Errorf("the email address %s is not valid", details.EmailAddress)
+	}
+	if details.AmountOfMoneyOwned.IsNegative() {
+		return fmt.Errorf("the amount of money owned must not be negative")
+	}
+
+	return validateSalt(details.Salt)
+}
+
+func validateSalt(salt string) error {
+	if len(salt) < minSaltLength {
+		return fmt.Errorf("the salt must have at least %d characters, such as 16 random bytes in hex", minSaltLength)
+	}
+
+	return nil
+}
+
+// readSaltSeed reads the random value that the salts of the persons stored by
+// InitLedger and MigratePersonDetailsToPrivateData are derived from.
+func readSaltSeed(ctx contractapi.TransactionContextInterface) (string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	seed, ok := transientMap[transientSaltKey]
+	if !ok {
+		return "", fmt.Errorf("a random salt must be passed in the %q transient data", transientSaltKey)
+	}
+
+	err = validateSalt(string(seed))
+	if err != nil {
+		return "", err
+	}
+
+	return string(seed), nil
+}
+
+// personSalt derives the salt of a person from the seed, so that every endorsing peer
+// stores the same details, while the salts of different persons differ.
+func personSalt(seed string, personID string) string {
+	salt := sha256.Sum256([]byte(seed + "\x00" + personID))
+	return hex.EncodeToString(salt[:])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaleBetweenOrganizations(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	seller := ledger.as(org3MSP, person3ClientID)
+	buyer := ledger.as(org2MSP, person2ClientID)
+
+	buyerDetails, err := carsAndPersons.ReadPersonPrivateDetails(buyer, "person2")
+	require.NoError(t, err)
+	require.Equal(t, &PersonPrivateDetails{ID: "person2", EmailAddress: "marko@pdasp.rs", AmountOfMoneyOwned: newMoney(8200_40), Salt: personSalt(testSalt, "person2")}, buyerDetails)
+
+	_, err = carsAndPersons.ReadPersonPrivateDetails(seller, "person2")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person2")
+
+	key, err := personKey(buyer, "person2")
+	require.NoError(t, err)
+	personAssetJSON, err := ledger.stub.GetState(key)
+	require.NoError(t, err)
+	require.NotContains(t, string(personAssetJSON), "AmountOfMoneyOwned")
+
+	ledger.submit(func() error {
+		_, err := carsAndPersons.CreateSaleOffer(seller, "offer1", "car5", "person2", "5300", 3600)
+		return err
+	})
+
+	// The peers of Org3 endorse the buyer's acceptance without access to Org2's
+	// collection, so the buyer passes their own details.
+	accept := func(transient map[string][]byte) error {
+		ledger.stub.SetPeerCollections(personCollections[org3MSP])
+		ledger.stub.SetTransient(transient)
+		return ledger.stub.Transact(func() error {
+			_, err := carsAndPersons.AcceptSaleOffer(buyer, "offer1", "person2", false)
+			return err
+		})
+	}
+
+	err = accept(nil)
+	require.EqualError(t, err, `failed to read the private details of person person2 from Org2MSPPrivateCollection, pass them in the "personDetails" transient data instead: the peer is not a member of collection Org2MSPPrivateCollection`)
+
+	forgedDetails := *buyerDetails
+	forgedDetails.AmountOfMoneyOwned = newMoney(1_000_000_00)
+	forgedJSON, err := json.Marshal([]PersonPrivateDetails{forgedDetails})
+	require.NoError(t, err)
+	err = accept(map[string][]byte{transientPersonDetailsKey: forgedJSON})
+	require.EqualError(t, err, "the private details of the person person2 passed in do not match the ledger")
+
+	// Guessing the email address and the balance is not enough to match the hash.
+	guessedDetails := *buyerDetails
+	guessedDetails.Salt = ""
+	guessedJSON, err := json.Marshal([]PersonPrivateDetails{guessedDetails})
+	require.NoError(t, err)
+	err = accept(map[string][]byte{transientPersonDetailsKey: guessedJSON})
+	require.EqualError(t, err, "the private details of the person person2 passed in do not match the ledger")
+
+	// The client may format the details as it likes, the hash is taken of the stored form.
+	err = accept(map[string][]byte{transientPersonDetailsKey: []byte(`[
+		{"Salt": "` + buyerDetails.Salt + `", "AmountOfMoneyOwned": {"Currency": "EUR", "Amount": 820040}, "EmailAddress": "marko@pdasp.rs", "ID": "person2"}
+	]`)})
+	require.NoError(t, err)
+	require.Equal(t, newMoney(2900_40), personBalance(t, buyer, "person2"))
+
+	// The seller settles with their own details, which the peers of Org2 cannot read.
+	sellerDetails, err := carsAndPersons.ReadPersonPrivateDetails(seller, "person3")
+	require.NoError(t, err)
+	sellerJSON, err := json.Marshal([]PersonPrivateDetails{*sellerDetails})
+	require.NoError(t, err)
+	settle := func(transient map[string][]byte) error {
+		ledger.stub.SetPeerCollections(personCollections[org2MSP])
+		ledger.stub.SetTransient(transient)
+		return ledger.stub.Transact(func() error {
+			_, err := carsAndPersons.SettleSaleOffer(seller, "offer1")
+			return err
+		})
+	}
+
+	err = settle(nil)
+	require.EqualError(t, err, `failed to read the private details of person person3 from Org3MSPPrivateCollection, pass them in the "personDetails" transient data instead: the peer is not a member of collection Org3MSPPrivateCollection`)
+	require.NoError(t, settle(map[string][]byte{transientPersonDetailsKey: sellerJSON}))
+
+	carAsset, err := carsAndPersons.ReadCarAsset(buyer, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person2", carAsset.OwnerID)
+	require.Equal(t, newMoney(2900_40), personBalance(t, buyer, "person2"))
+	require.Equal(t, newMoney(6730_22), personBalance(t, seller, "person3"))
+}
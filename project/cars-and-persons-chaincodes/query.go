@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CarFilter selects cars in QueryCars. Empty fields do not restrict the result, prices
+// are given in the format accepted by ParseMoney and year and price ranges are inclusive.
+// HasMalfunctions looks at open malfunctions only. Status is either active or writtenOff.
+type CarFilter struct {
+	Brand           string `json:"brand,omitempty"`
+	Model           string `json:"model,omitempty"`
+	MinYear         int    `json:"minYear,omitempty"`
+	MaxYear         int    `json:"maxYear,omitempty"`
+	MinPrice        string `json:"minPrice,omitempty"`
+	MaxPrice        string `json:"maxPrice,omitempty"`
+	HasMalfunctions *bool  `json:"hasMalfunctions,omitempty"`
+	OwnerID         string `json:"ownerID,omitempty"`
+	Status          string `json:"status,omitempty"`
+}
+
+// PaginatedQueryResult is a page of cars, with the bookmark to pass in to get the next page.
+type PaginatedQueryResult struct {
+	Records             []*CarAsset `json:"records"`
+	FetchedRecordsCount int32       `json:"fetchedRecordsCount"`
+	Bookmark            string      `json:"bookmark"`
+}
+
+// QueryCars returns a page of the cars matching a JSON encoded CarFilter, e.g.
+// {"brand":"Audi","minYear":2015,"maxPrice":"6000"}. It translates the filter into a
+// CouchDB selector, so it is only available when CouchDB is the state database.
+func (s *SmartContract) QueryCars(ctx contractapi.TransactionContextInterface, filterJSON string, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("the page size must be positive")
+	}
+
+	var filter CarFilter
+	if filterJSON != "" {
+		decoder := json.NewDecoder(strings.NewReader(filterJSON))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&filter)
+		if err != nil {
+			return nil, fmt.Errorf("the car filter is not valid: %v", err)
+		}
+	}
+
+	queryString, err := carSelector(&filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, int32(pageSize), bookmark)
+	if err != nil {
+		// The selector is always valid JSON built by carSelector, so a failing query
+		// means that the state database cannot run rich queries, as LevelDB cannot.
+		return nil, fmt.Errorf("QueryCars requires CouchDB as the state database: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	carAssets := make([]*CarAsset, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var carAsset CarAsset
+		err = json.Unmarshal(queryResponse.Value, &carAsset)
+		if err != nil {
+			return nil, err
+		}
+
+		normalizeMalfunctions(&carAsset)
+		normalizeCarStatus(&carAsset)
+		carAssets = append(carAssets, &carAsset)
+	}
+
+	return &PaginatedQueryResult{
+		Records:             carAssets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// carSelector builds the Mango query for a filter. The selector is marshalled rather
+// than formatted, so that filter values cannot inject operators into the query.
+func carSelector(filter *CarFilter) (string, error) {
+	selector := map[string]interface{}{
+		"objectType": carObjectType,
+	}
+
+	if filter.Brand != "" {
+		selector["Brand"] = filter.Brand
+	}
+	if filter.Model != "" {
+		selector["Model"] = filter.Model
+	}
+	if filter.OwnerID != "" {
+		selector["OwnerID"] = filter.OwnerID
+	}
+	if filter.Status != "" {
+		if filter.Status != carStatusActive && filter.Status != carStatusWrittenOff {
+			return "", fmt.Errorf("the status %q is not one of %s or %s", filter.Status, carStatusActive, carStatusWrittenOff)
+		}
+		selector["Status"] = filter.Status
+	}
+
+	if filter.MinYear != 0 && filter.MaxYear != 0 && filter.MinYear > filter.MaxYear {
+		return "", fmt.Errorf("the minimum year is greater than the maximum year")
+	}
+	yearRange := map[string]interface{}{}
+	if filter.MinYear != 0 {
+		yearRange["$gte"] = filter.MinYear
+	}
+	if filter.MaxYear != 0 {
+		yearRange["$lte"] = filter.MaxYear
+	}
+	if len(yearRange) > 0 {
+		selector["Year"] = yearRange
+	}
+
+	priceRange := map[string]interface{}{}
+	if filter.MinPrice != "" {
+		minPrice, err := ParseMoney(filter.MinPrice)
+		if err != nil {
+			return "", err
+		}
+		priceRange["$gte"] = minPrice.Amount
+		selector["Price.Currency"] = minPrice.Currency
+	}
+	if filter.MaxPrice != "" {
+		maxPrice, err := ParseMoney(filter.MaxPrice)
+		if err != nil {
+			return "", err
+		}
+		priceRange["$lte"] = maxPrice.Amount
+		selector["Price.Currency"] = maxPrice.Currency
+	}
+	if len(priceRange) > 0 {
+		selector["Price.Amount"] = priceRange
+	}
+
+	if filter.HasMalfunctions != nil {
+		openMalfunction := map[string]interface{}{
+			"$elemMatch": map[string]interface{}{"Status": malfunctionStatusOpen},
+		}
+		if *filter.HasMalfunctions {
+			selector["MalfunctionList"] = openMalfunction
+		} else {
+			selector["MalfunctionList"] = map[string]interface{}{"$not": openMalfunction}
+		}
+	}
+
+	queryJSON, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", err
+	}
+
+	return string(queryJSON), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryCars(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	car2Key, err := carKey(transactionContext, "car2")
+	require.NoError(t, err)
+
+	var queryString string
+	chaincodeStub.GetQueryResultWithPaginationStub = func(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		queryString = query
+		return state.iterator([]string{car2Key}), &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "next"}, nil
+	}
+
+	page, err := carsAndPersons.QueryCars(transactionContext, `{"brand":"Audi","minYear":2015,"maxPrice":"6000","hasMalfunctions":false,"status":"active"}`, 5, "")
+	require.NoError(t, err)
+	require.Equal(t, `{"selector":{"Brand":"Audi","MalfunctionList":{"$not":{"$elemMatch":{"Status":"open"}}},"Price.Amount":{"$lte":600000},`+
+		`"Price.Currency":"EUR","Status":"active","Year":{"$gte":2015},"objectType":"car"}}`, queryString)
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "next", page.Bookmark)
+	require.Equal(t, "car2", page.Records[0].ID)
+
+	_, err = carsAndPersons.QueryCars(transactionContext, "", 5, "")
+	require.NoError(t, err)
+	require.Equal(t, `{"selector":{"objectType":"car"}}`, queryString)
+
+	// Filter values are marshalled, so they cannot add operators to the selector.
+	_, err = carsAndPersons.QueryCars(transactionContext, `{"ownerID":"person1\",\"$or\":[{}]"}`, 5, "")
+	require.NoError(t, err)
+	require.Equal(t, `{"selector":{"OwnerID":"person1\",\"$or\":[{}]","objectType":"car"}}`, queryString)
+
+	_, err = carsAndPersons.QueryCars(transactionContext, `{"color":"red"}`, 5, "")
+	require.EqualError(t, err, `the car filter is not valid: json: unknown field "color"`)
+
+	_, err = carsAndPersons.QueryCars(transactionContext, `{"minYear":2020,"maxYear":2010}`, 5, "")
+	require.EqualError(t, err, "the minimum year is greater than the maximum year")
+
+	_, err = carsAndPersons.QueryCars(transactionContext, `{"status":"stolen"}`, 5, "")
+	require.EqualError(t, err, `the status "stolen" is not one of active or writtenOff`)
+
+	_, err = carsAndPersons.QueryCars(transactionContext, `{"minPrice":"1.001"}`, 5, "")
+	require.Error(t, err)
+
+	_, err = carsAndPersons.QueryCars(transactionContext, "", 0, "")
+	require.EqualError(t, err, "the page size must be positive")
+
+	// Records stored before malfunctions and statuses were structured are normalized.
+	car7Key, err := carKey(transactionContext, "car7")
+	require.NoError(t, err)
+	state[car7Key] = []byte(`{"objectType":"car","ID":"car7","Brand":"Fiat","Model":"Punto","Year":2005,"Color":"grey","OwnerID":"person1",` +
+		`"Price":{"Amount":99999,"Currency":"EUR"},"MalfunctionList":[{"Description":"Worn brakes","RepairPrice":{"Amount":4999,"Currency":"EUR"}}]}`)
+	chaincodeStub.GetQueryResultWithPaginationReturns(state.iterator([]string{car7Key}), &peer.QueryResponseMetadata{FetchedRecordsCount: 1}, nil)
+	page, err = carsAndPersons.QueryCars(transactionContext, "", 5, "")
+	require.NoError(t, err)
+	require.Equal(t, carStatusActive, page.Records[0].Status)
+	require.Equal(t, "m1", page.Records[0].MalfunctionList[0].ID)
+	require.Equal(t, malfunctionStatusOpen, page.Records[0].MalfunctionList[0].Status)
+	require.Equal(t, []CarRepair{}, page.Records[0].RepairList)
+
+	chaincodeStub.GetQueryResultWithPaginationReturns(nil, nil, fmt.Errorf("ExecuteQueryWithPagination not supported for leveldb"))
+	_, err = carsAndPersons.QueryCars(transactionContext, "", 5, "")
+	require.EqualError(t, err, "QueryCars requires CouchDB as the state database: ExecuteQueryWithPagination not supported for leveldb")
+}
+
+func TestQueryCarsOnLevelDB(t *testing.T) {
+	ledger := prepFakeLedger(t)
+
+	_, err := ledger.carsAndPersons.QueryCars(ledger.as(org1MSP, registryClientID), `{"brand":"Audi"}`, 5, "")
+	require.EqualError(t, err, "QueryCars requires CouchDB as the state database: ExecuteQueryWithPagination not supported for leveldb")
+}
+
+func TestCouchDBIndexes(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	var query struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	chaincodeStub.GetQueryResultWithPaginationStub = func(queryString string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		require.NoError(t, json.Unmarshal([]byte(queryString), &query))
+		return nil, nil, fmt.Errorf("no results")
+	}
+	_, _ = carsAndPersons.QueryCars(transactionContext, `{"brand":"Audi","model":"A4","ownerID":"person1","minYear":2015,"maxPrice":"6000"}`, 5, "")
+
+	indexPaths, err := filepath.Glob("META-INF/statedb/couchdb/indexes/*.json")
+	require.NoError(t, err)
+	require.Len(t, indexPaths, 4)
+
+	// Every index starts with the object type, which all car queries select, and only
+	// indexes fields that QueryCars selects.
+	for _, indexPath := range indexPaths {
+		indexJSON, err := os.ReadFile(indexPath)
+		require.NoError(t, err)
+
+		var index struct {
+			Index struct {
+				Fields []string `json:"fields"`
+			} `json:"index"`
+			Ddoc string `json:"ddoc"`
+			Name string `json:"name"`
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(indexJSON, &index), indexPath)
+		require.Equal(t, "json", index.Type, indexPath)
+		require.Equal(t, index.Name+"Doc", index.Ddoc, indexPath)
+		require.Equal(t, "objectType", index.Index.Fields[0], indexPath)
+		for _, field := range index.Index.Fields {
+			require.Contains(t, query.Selector, field, indexPath)
+		}
+	}
+}
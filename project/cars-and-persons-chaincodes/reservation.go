@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	reservationObjectType = "reservation"
+	reservationKeyIndex   = "reservation~carID"
+
+	// maxReservationSeconds keeps a buyer from holding on to a car indefinitely; a
+	// reservation can be renewed by its holder before it expires.
+	maxReservationSeconds = 24 * 60 * 60
+	// maxReservationRenewals is how often the holder can renew a reservation.
+	maxReservationRenewals = 2
+)
+
+// CarReservation holds the car of an open sale offer for a buyer until ExpiresAt, so
+// that two buyers do not race each other for the same car. The offer is the owner's
+// consent: without one a car cannot be reserved, and a reservation never outlasts its
+// offer. While the reservation is active, the car can only be transferred to its
+// holder, whichever transaction transfers it. The reservation ends with the transfer,
+// when it is released, when its offer is cancelled or when it expires.
+type CarReservation struct {
+	ObjectType string `json:"objectType"`
+	CarID      string
+	OfferID    string
+	HolderID   string
+	Renewals   int
+	ReservedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// ReserveCar is submitted by a buyer to reserve the car of an open sale offer for the
+// given number of seconds. An offer made to a single buyer can only be reserved by
+// that buyer. The holder of an active reservation can renew it maxReservationRenewals
+// times, and every buyer can reserve the car of an offer only once, so that a released
+// or expired reservation cannot be taken up again.
+func (s *SmartContract) ReserveCar(ctx contractapi.TransactionContextInterface, offerID string, holderID string, durationSeconds int) (*CarReservation, error) {
+	if durationSeconds <= 0 || durationSeconds > maxReservationSeconds {
+		return nil, fmt.Errorf("the reservation must last between 1 and %d seconds", maxReservationSeconds)
+	}
+
+	offer, err := s.ReadSaleOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSaleOfferActive(ctx, offer, offerStatusOpen)
+	if err != nil {
+		return nil, err
+	}
+
+	if offer.BuyerID != "" && offer.BuyerID != holderID {
+		return nil, fmt.Errorf("the sale offer %s is reserved for %s", offerID, offer.BuyerID)
+	}
+	if offer.SellerID == holderID {
+		return nil, fmt.Errorf("Person %s is already the owner of the car!", holderID)
+	}
+
+	holder, err := s.ReadPersonAsset(ctx, holderID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requirePerson(ctx, holder)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.readOfferedCar(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation, err := readActiveReservation(ctx, offer.CarID)
+	if err != nil {
+		return nil, err
+	}
+
+	renewals := 0
+	if reservation != nil {
+		if reservation.HolderID != holderID {
+			return nil, reservedCarError(reservation)
+		}
+		if reservation.Renewals >= maxReservationRenewals {
+			return nil, fmt.Errorf("the reservation of the car %s cannot be renewed more than %d times", offer.CarID, maxReservationRenewals)
+		}
+		renewals = reservation.Renewals + 1
+	} else {
+		for _, reservedBy := range offer.ReservedBy {
+			if reservedBy == holderID {
+				return nil, fmt.Errorf("%s already reserved the car of the sale offer %s", holderID, offerID)
+			}
+		}
+
+		offer.ReservedBy = append(offer.ReservedBy, holderID)
+
+		err = putSaleOffer(ctx, offer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(time.Duration(durationSeconds) * time.Second)
+	if expiresAt.After(offer.ExpiresAt) {
+		expiresAt = offer.ExpiresAt
+	}
+
+	reservation = &CarReservation{
+		CarID:      offer.CarID,
+		OfferID:    offerID,
+		HolderID:   holderID,
+		Renewals:   renewals,
+		ReservedAt: now,
+		ExpiresAt:  expiresAt,
+	}
+
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventCarReserved, CarReservedEvent{
+		CarID:     offer.CarID,
+		OfferID:   offerID,
+		HolderID:  holderID,
+		ExpiresAt: reservation.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// ReleaseReservation ends an active reservation before it expires. The holder can
+// release it, and so can the owner of the car, e.g. when the sale falls through.
+func (s *SmartContract) ReleaseReservation(ctx contractapi.TransactionContextInterface, carID string) error {
+	reservation, err := s.ReadCarReservation(ctx, carID)
+	if err != nil {
+		return err
+	}
+
+	carAsset, err := s.ReadCarAsset(ctx, carID)
+	if err != nil {
+		return err
+	}
+
+	holder, err := s.ReadPersonAsset(ctx, reservation.HolderID)
+	if err != nil {
+		return err
+	}
+
+	owner, err := s.ReadPersonAsset(ctx, carAsset.OwnerID)
+	if err != nil {
+		return err
+	}
+
+	err = requireEitherPerson(ctx, holder, owner)
+	if err != nil {
+		return err
+	}
+
+	err = deleteReservation(ctx, carID)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventReservationReleased, ReservationReleasedEvent{
+		CarID:    carID,
+		HolderID: reservation.HolderID,
+	})
+}
+
+// ReadCarReservation returns the active reservation of a car.
+func (s *SmartContract) ReadCarReservation(ctx contractapi.TransactionContextInterface, carID string) (*CarReservation, error) {
+	reservation, err := readActiveReservation(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		return nil, fmt.Errorf("the car %s is not reserved", carID)
+	}
+
+	return reservation, nil
+}
+
+// readActiveReservation returns the reservation of a car, or nil if the car has none
+// or it has expired. Expired reservations stay on the ledger until the car is reserved
+// again or transferred.
+func readActiveReservation(ctx contractapi.TransactionContextInterface, carID string) (*CarReservation, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(reservationKeyIndex, []string{carID})
+	if err != nil {
+		return nil, err
+	}
+
+	reservationJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reservation from world state: %v", err)
+	}
+	if reservationJSON == nil {
+		return nil, nil
+	}
+
+	var reservation CarReservation
+	err = json.Unmarshal(reservationJSON, &reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkObjectType(carID, reservation.ObjectType, reservationObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now.After(reservation.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &reservation, nil
+}
+
+// releaseOfferReservation ends the active reservation made against a sale offer that
+// is cancelled.
+func releaseOfferReservation(ctx contractapi.TransactionContextInterface, offer *SaleOffer) error {
+	reservation, err := readActiveReservation(ctx, offer.CarID)
+	if err != nil {
+		return err
+	}
+	if reservation == nil || reservation.OfferID != offer.ID {
+		return nil
+	}
+
+	return deleteReservation(ctx, offer.CarID)
+}
+
+// checkReservation only lets a reserved car go to the holder of the reservation.
+func checkReservation(ctx contractapi.TransactionContextInterface, carID string, buyerID string) error {
+	reservation, err := readActiveReservation(ctx, carID)
+	if err != nil {
+		return err
+	}
+	if reservation != nil && reservation.HolderID != buyerID {
+		return reservedCarError(reservation)
+	}
+
+	return nil
+}
+
+// reservedCarError tells the client who holds the car and when it can try again.
+func reservedCarError(reservation *CarReservation) error {
+	return fmt.Errorf("the car %s is reserved for %s until %s", reservation.CarID, reservation.HolderID, reservation.ExpiresAt.Format(time.RFC3339))
+}
+
+func putReservation(ctx contractapi.TransactionContextInterface, reservation *CarReservation) error {
+	key, err := ctx.GetStub().CreateCompositeKey(reservationKeyIndex, []string{reservation.CarID})
+	if err != nil {
+		return err
+	}
+
+	reservation.ObjectType = reservationObjectType
+	reservationJSON, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, reservationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put reservation of car %s to world state: %v", reservation.CarID, err)
+	}
+
+	return nil
+}
+
+func deleteReservation(ctx contractapi.TransactionContextInterface, carID string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(reservationKeyIndex, []string{carID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
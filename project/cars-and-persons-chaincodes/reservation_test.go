@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveCar(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err := carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.EqualError(t, err, "the sale offer offer1 does not exist", "a car cannot be reserved without the owner's offer")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car5", "person1", "5300", 3600)
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person3", 600)
+	require.EqualError(t, err, "Person person3 is already the owner of the car!")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	reservation, err := carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.NoError(t, err)
+	require.Equal(t, &CarReservation{
+		ObjectType: reservationObjectType,
+		CarID:      "car5",
+		OfferID:    "offer1",
+		HolderID:   "person2",
+		ReservedAt: testTime,
+		ExpiresAt:  testTime.Add(10 * time.Minute),
+	}, reservation)
+
+	storedReservation, err := carsAndPersons.ReadCarReservation(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, reservation, storedReservation)
+
+	offer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"person2"}, offer.ReservedBy)
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarReserved, eventName)
+	var reserved CarReservedEvent
+	require.NoError(t, json.Unmarshal(payload, &reserved))
+	require.Equal(t, CarReservedEvent{CarID: "car5", OfferID: "offer1", HolderID: "person2", ExpiresAt: testTime.Add(10 * time.Minute)}, reserved)
+
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 0)
+	require.EqualError(t, err, "the reservation must last between 1 and 86400 seconds")
+
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person1", 600)
+	require.EqualError(t, err, "client is not authorized to act on behalf of person1")
+
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer2", "person2", 600)
+	require.EqualError(t, err, "the sale offer offer2 is reserved for person1")
+
+	setTxTime(t, chaincodeStub, testTime.Add(5*time.Minute))
+	reservation, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.NoError(t, err, "the holder can renew the reservation")
+	require.Equal(t, 1, reservation.Renewals)
+	require.Equal(t, testTime.Add(15*time.Minute), reservation.ExpiresAt)
+
+	reservation, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", maxReservationSeconds)
+	require.NoError(t, err)
+	require.Equal(t, 2, reservation.Renewals)
+	require.Equal(t, testTime.Add(time.Hour), reservation.ExpiresAt, "the reservation must not outlast the offer")
+
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.EqualError(t, err, "the reservation of the car car5 cannot be renewed more than 2 times")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer2", "person1", 600)
+	require.EqualError(t, err, "the car car5 is reserved for person2 until 2022-01-15T13:00:00Z")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.ReleaseReservation(transactionContext, "car5")
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.EqualError(t, err, "person2 already reserved the car of the sale offer offer1")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer2", "person1", 600)
+	require.NoError(t, err, "a released reservation does not hold the car")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer2")
+	require.NoError(t, err)
+	_, err = carsAndPersons.ReadCarReservation(transactionContext, "car5")
+	require.EqualError(t, err, "the car car5 is not reserved", "the reservation ends with its offer")
+
+	setTxTime(t, chaincodeStub, testTime.Add(2*time.Hour))
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person1", 600)
+	require.EqualError(t, err, "the sale offer offer1 expired at 2022-01-15T13:00:00Z")
+}
+
+func TestTransferReservedCar(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car5", "person1", "4000", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer2", "person1", false)
+	require.EqualError(t, err, "the car car5 is reserved for person2 until 2022-01-15T12:10:00Z")
+
+	_, err = carsAndPersons.LockEscrow(transactionContext, "escrow1", "offer2", "person1", false, 3600)
+	require.EqualError(t, err, "the car car5 is reserved for person2 until 2022-01-15T12:10:00Z")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person2", false)
+	require.NoError(t, err)
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.ReadCarReservation(transactionContext, "car5")
+	require.EqualError(t, err, "the car car5 is not reserved", "the reservation ends with the transfer")
+}
+
+func TestReleaseReservation(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person2", 600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.ReleaseReservation(transactionContext, "car5")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person2 or person3")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.ReleaseReservation(transactionContext, "car5")
+	require.NoError(t, err, "the owner can release the reservation")
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventReservationReleased, eventName)
+	var released ReservationReleasedEvent
+	require.NoError(t, json.Unmarshal(payload, &released))
+	require.Equal(t, ReservationReleasedEvent{CarID: "car5", HolderID: "person2"}, released)
+
+	err = carsAndPersons.ReleaseReservation(transactionContext, "car5")
+	require.EqualError(t, err, "the car car5 is not reserved")
+
+	sellCar(t, transactionContext, "offer2", "car5", "person1", "5300")
+}
+
+func TestReservationExpiresOnFakeLedger(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	buyer := ledger.as(org2MSP, person2ClientID)
+	otherBuyer := ledger.as(org1MSP, person1ClientID)
+	seller := ledger.as(org3MSP, person3ClientID)
+
+	ledger.submit(func() error {
+		_, err := carsAndPersons.CreateSaleOffer(seller, "offer1", "car5", "", "5300", 3600)
+		return err
+	})
+	ledger.submit(func() error {
+		_, err := carsAndPersons.ReserveCar(buyer, "offer1", "person2", 60)
+		return err
+	})
+	reservation, err := carsAndPersons.ReadCarReservation(buyer, "car5")
+	require.NoError(t, err)
+
+	accept := func() error {
+		return ledger.stub.Transact(func() error {
+			_, err := carsAndPersons.AcceptSaleOffer(otherBuyer, "offer1", "person1", false)
+			return err
+		})
+	}
+	require.EqualError(t, accept(), "the car car5 is reserved for person2 until "+reservation.ExpiresAt.Format(time.RFC3339))
+
+	ledger.stub.SetTime(reservation.ExpiresAt.Add(time.Second))
+	require.NoError(t, accept())
+	ledger.submit(func() error {
+		_, err := carsAndPersons.SettleSaleOffer(seller, "offer1")
+		return err
+	})
+
+	carAsset, err := carsAndPersons.ReadCarAsset(seller, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person1", carAsset.OwnerID)
+}
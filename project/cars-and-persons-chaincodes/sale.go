@@ -0,0 +1,616 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	offerObjectType = "offer"
+	offerKeyIndex   = "offer~ID"
+	// carOfferIndex lists the offers of every car that are not settled or cancelled yet,
+	// so that TransferCarAsset finds them without reading every offer.
+	carOfferIndex = "car~offerID"
+
+	offerStatusOpen      = "open"
+	offerStatusAccepted  = "accepted"
+	offerStatusSettled   = "settled"
+	offerStatusCancelled = "cancelled"
+)
+
+// SaleOffer is a seller's offer to sell a car. It goes through three steps: the owner
+// of the car creates it, a buyer accepts it and pays the price into the offer, and the
+// seller settles it, which hands the car over and pays the price to the seller. Open
+// offers can be cancelled by the seller, and accepted ones by the buyer, who gets the
+// price back. Offers can no longer be accepted or settled once ExpiresAt has passed.
+//
+// Like with an escrow, every step changes the balance of one party at most, and that
+// party submits it. The peers of the other party's organization, which cannot read the
+// party's collection, are passed the party's own details in the transient data.
+type SaleOffer struct {
+	ObjectType          string `json:"objectType"`
+	ID                  string
+	CarID               string
+	SellerID            string
+	BuyerID             string
+	AskingPrice         Money
+	MalfunctionDiscount Money
+	Price               Money
+	Status              string
+	CreatedAt           time.Time
+	ExpiresAt           time.Time
+	// ReservedBy are the buyers who reserved the car of the offer with ReserveCar.
+	ReservedBy []string `json:",omitempty"`
+	// EscrowID is the escrow the offer was accepted into with LockEscrow. Such an offer
+	// is settled or cancelled by the escrow and not by SettleSaleOffer or CancelSaleOffer.
+	EscrowID string `json:",omitempty"`
+}
+
+// CreateSaleOffer offers a car for sale. An empty buyerID leaves the offer open to any
+// buyer. The cost of repairing the car's malfunctions is deducted from the asking price.
+func (s *SmartContract) CreateSaleOffer(ctx contractapi.TransactionContextInterface, offerID string, carID string, buyerID string, askingPrice string, validForSeconds int) (*SaleOffer, error) {
+	if offerID == "" {
+		return nil, fmt.Errorf("the offer ID must not be empty")
+	}
+	if validForSeconds <= 0 {
+		return nil, fmt.Errorf("the offer must be valid for a positive number of seconds")
+	}
+
+	exists, err := s.SaleOfferExists(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("the sale offer %s already exists", offerID)
+	}
+
+	carAsset, err := s.ReadCarAsset(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.requireCarOwner(ctx, carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	if buyerID != "" {
+		if buyerID == carAsset.OwnerID {
+			return nil, fmt.Errorf("Person %s is already the owner of the car!", buyerID)
+		}
+
+		exists, err := s.PersonAssetExists(ctx, buyerID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("the person %v does not exist", buyerID)
+		}
+	}
+
+	asking, err := ParseMoney(askingPrice)
+	if err != nil {
+		return nil, err
+	}
+	if asking.IsNegative() {
+		return nil, fmt.Errorf("the asking price must not be negative")
+	}
+
+	price, err := discountedCarPrice(carAsset, asking, true)
+	if err != nil {
+		return nil, err
+	}
+	discount, err := asking.Sub(price)
+	if err != nil {
+		return nil, err
+	}
+	if price.IsNegative() {
+		return nil, fmt.Errorf("the asking price does not cover the repair price of the car's malfunctions")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	offer := SaleOffer{
+		ID:                  offerID,
+		CarID:               carID,
+		SellerID:            carAsset.OwnerID,
+		BuyerID:             buyerID,
+		AskingPrice:         asking,
+		MalfunctionDiscount: discount,
+		Price:               price,
+		Status:              offerStatusOpen,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(time.Duration(validForSeconds) * time.Second),
+	}
+
+	err = putSaleOffer(ctx, &offer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putCarOfferIndex(ctx, carID, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &offer, nil
+}
+
+// AcceptSaleOffer is submitted by the buyer to agree to the offer. The price leaves the
+// buyer's balance and is held by the offer until it is settled or cancelled. Buyers who
+// do not accept a malfunctioned car cannot accept an offer that carries a malfunction
+// discount.
+func (s *SmartContract) AcceptSaleOffer(ctx contractapi.TransactionContextInterface, offerID string, buyerID string, acceptMalfunction bool) (*SaleOffer, error) {
+	offer, err := s.ReadSaleOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.acceptSaleOffer(ctx, offer, buyerID, acceptMalfunction)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, nil
+}
+
+// acceptSaleOffer moves the price of an open offer from the buyer's balance into the
+// offer. The submitting client has to be linked to the buyer.
+func (s *SmartContract) acceptSaleOffer(ctx contractapi.TransactionContextInterface, offer *SaleOffer, buyerID string, acceptMalfunction bool) error {
+	err := checkSaleOfferActive(ctx, offer, offerStatusOpen)
+	if err != nil {
+		return err
+	}
+
+	if offer.BuyerID != "" && offer.BuyerID != buyerID {
+		return fmt.Errorf("the sale offer %s is reserved for %s", offer.ID, offer.BuyerID)
+	}
+	if offer.SellerID == buyerID {
+		return fmt.Errorf("Person %s is already the owner of the car!", buyerID)
+	}
+	if offer.MalfunctionDiscount.Amount != 0 && !acceptMalfunction {
+		return fmt.Errorf("the buyer will not accept a malfunctioned car")
+	}
+
+	// The buyer would pay for a car that cannot be handed over.
+	err = checkReservation(ctx, offer.CarID, buyerID)
+	if err != nil {
+		return err
+	}
+
+	buyer, err := s.ReadPersonAsset(ctx, buyerID)
+	if err != nil {
+		return err
+	}
+
+	err = requirePerson(ctx, buyer)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.readOfferedCar(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	buyerDetails, err := readPersonDetails(ctx, buyer)
+	if err != nil {
+		return err
+	}
+
+	cmp, err := buyerDetails.AmountOfMoneyOwned.Cmp(offer.Price)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return fmt.Errorf("the buyer does not own enough money to purchase the car")
+	}
+
+	buyerDetails.AmountOfMoneyOwned, err = buyerDetails.AmountOfMoneyOwned.Sub(offer.Price)
+	if err != nil {
+		return err
+	}
+
+	err = putPersonDetails(ctx, buyer, buyerDetails)
+	if err != nil {
+		return err
+	}
+
+	offer.BuyerID = buyerID
+	offer.Status = offerStatusAccepted
+
+	return putSaleOffer(ctx, offer)
+}
+
+// SettleSaleOffer is submitted by the seller to complete an accepted offer. The car is
+// handed over to the buyer and the price the buyer paid is added to the seller's balance.
+func (s *SmartContract) SettleSaleOffer(ctx contractapi.TransactionContextInterface, offerID string) (*SaleOffer, error) {
+	offer, err := s.ReadSaleOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSaleOfferActive(ctx, offer, offerStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNotEscrowed(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := s.ReadPersonAsset(ctx, offer.SellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requirePerson(ctx, seller)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.settleSaleOffer(ctx, offer, seller)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, nil
+}
+
+// TransferCarAsset is submitted by the new owner to buy the car through an open sale
+// offer of its owner, accepting and settling the offer in a single transaction. The
+// owner consents to the sale by making the offer, and the new owner by submitting the
+// transaction. The offer the new owner reserved with ReserveCar is taken if there is
+// one, and otherwise the cheapest offer the new owner may accept. Unlike the separate
+// steps, the transaction changes the balances of both persons, so across organizations
+// the endorsing peers need the details of both in the "personDetails" transient data.
+func (s *SmartContract) TransferCarAsset(ctx contractapi.TransactionContextInterface, id string, newOwnerID string, acceptMalfunction bool) (bool, error) {
+	carAsset, err := s.ReadCarAsset(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return false, err
+	}
+
+	if carAsset.OwnerID == newOwnerID {
+		return false, fmt.Errorf("Person %s is already the owner of the car!", newOwnerID)
+	}
+
+	offer, err := s.findSaleOffer(ctx, carAsset, newOwnerID)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.acceptSaleOffer(ctx, offer, newOwnerID, acceptMalfunction)
+	if err != nil {
+		return false, err
+	}
+
+	seller, err := s.ReadPersonAsset(ctx, offer.SellerID)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.settleSaleOffer(ctx, offer, seller)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// settleSaleOffer hands the car of an accepted offer over to the buyer and pays the
+// price that the buyer paid into the offer to the seller.
+func (s *SmartContract) settleSaleOffer(ctx contractapi.TransactionContextInterface, offer *SaleOffer, seller *PersonAsset) error {
+	buyer, err := s.ReadPersonAsset(ctx, offer.BuyerID)
+	if err != nil {
+		return err
+	}
+
+	carAsset, err := s.readOfferedCar(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	err = handOverCar(ctx, carAsset, seller, buyer, offer.Price)
+	if err != nil {
+		return err
+	}
+
+	err = creditPerson(ctx, seller, offer.Price)
+	if err != nil {
+		return err
+	}
+
+	offer.Status = offerStatusSettled
+
+	err = putSaleOffer(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	return deleteCarOfferIndex(ctx, offer.CarID, offer.ID)
+}
+
+// findSaleOffer returns the offer that TransferCarAsset settles. Offers made by an
+// earlier owner of the car can no longer be settled and are skipped.
+func (s *SmartContract) findSaleOffer(ctx contractapi.TransactionContextInterface, carAsset *CarAsset, newOwnerID string) (*SaleOffer, error) {
+	reservation, err := readActiveReservation(ctx, carAsset.ID)
+	if err != nil {
+		return nil, err
+	}
+	if reservation != nil {
+		if reservation.HolderID != newOwnerID {
+			return nil, reservedCarError(reservation)
+		}
+		return s.ReadSaleOffer(ctx, reservation.OfferID)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	offerIter, err := ctx.GetStub().GetStateByPartialCompositeKey(carOfferIndex, []string{carAsset.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer offerIter.Close()
+
+	var cheapestOffer *SaleOffer
+	for offerIter.HasNext() {
+		queryResponse, err := offerIter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		offer, err := s.ReadSaleOffer(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		if offer.Status != offerStatusOpen || now.After(offer.ExpiresAt) || offer.SellerID != carAsset.OwnerID {
+			continue
+		}
+		if offer.BuyerID != "" && offer.BuyerID != newOwnerID {
+			continue
+		}
+
+		if cheapestOffer != nil {
+			cmp, err := offer.Price.Cmp(cheapestOffer.Price)
+			if err != nil {
+				return nil, err
+			}
+			if cmp >= 0 {
+				continue
+			}
+		}
+		cheapestOffer = offer
+	}
+
+	if cheapestOffer == nil {
+		return nil, fmt.Errorf("the car %s has no open sale offer for %s", carAsset.ID, newOwnerID)
+	}
+
+	return cheapestOffer, nil
+}
+
+// CancelSaleOffer withdraws an offer that has not been settled yet. The seller can
+// cancel an open offer. Once it is accepted, only the buyer can cancel it, which pays
+// the price back to the buyer; the seller declines an accepted offer by letting it
+// expire.
+func (s *SmartContract) CancelSaleOffer(ctx contractapi.TransactionContextInterface, offerID string) (*SaleOffer, error) {
+	offer, err := s.ReadSaleOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if offer.Status != offerStatusOpen && offer.Status != offerStatusAccepted {
+		return nil, fmt.Errorf("the sale offer %s is %s and cannot be cancelled", offerID, offer.Status)
+	}
+
+	err = checkNotEscrowed(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	if offer.Status == offerStatusAccepted {
+		buyer, err := s.ReadPersonAsset(ctx, offer.BuyerID)
+		if err != nil {
+			return nil, err
+		}
+		err = requirePerson(ctx, buyer)
+		if err != nil {
+			return nil, err
+		}
+		err = creditPerson(ctx, buyer, offer.Price)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		seller, err := s.ReadPersonAsset(ctx, offer.SellerID)
+		if err != nil {
+			return nil, err
+		}
+		err = requirePerson(ctx, seller)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = releaseOfferReservation(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	offer.Status = offerStatusCancelled
+
+	err = putSaleOffer(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deleteCarOfferIndex(ctx, offer.CarID, offer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, nil
+}
+
+func (s *SmartContract) ReadSaleOffer(ctx contractapi.TransactionContextInterface, offerID string) (*SaleOffer, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(offerKeyIndex, []string{offerID})
+	if err != nil {
+		return nil, err
+	}
+
+	offerJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sale offer from world state: %v", err)
+	}
+	if offerJSON == nil {
+		return nil, fmt.Errorf("the sale offer %s does not exist", offerID)
+	}
+
+	var offer SaleOffer
+	err = json.Unmarshal(offerJSON, &offer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkObjectType(offerID, offer.ObjectType, offerObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &offer, nil
+}
+
+func (s *SmartContract) SaleOfferExists(ctx contractapi.TransactionContextInterface, offerID string) (bool, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(offerKeyIndex, []string{offerID})
+	if err != nil {
+		return false, err
+	}
+
+	offerJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read sale offer from world state: %v", err)
+	}
+
+	return offerJSON != nil, nil
+}
+
+// readOfferedCar makes sure that the car is still owned by the seller and has the
+// same malfunctions as when the offer was made, so the offered price still holds.
+func (s *SmartContract) readOfferedCar(ctx contractapi.TransactionContextInterface, offer *SaleOffer) (*CarAsset, error) {
+	carAsset, err := s.ReadCarAsset(ctx, offer.CarID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	if carAsset.OwnerID != offer.SellerID {
+		return nil, fmt.Errorf("the car %s is no longer owned by %s", carAsset.ID, offer.SellerID)
+	}
+
+	price, err := discountedCarPrice(carAsset, offer.AskingPrice, true)
+	if err != nil {
+		return nil, err
+	}
+	cmp, err := price.Cmp(offer.Price)
+	if err != nil {
+		return nil, err
+	}
+	if cmp != 0 {
+		return nil, fmt.Errorf("the malfunctions of the car %s changed since the offer was made", carAsset.ID)
+	}
+
+	return carAsset, nil
+}
+
+func checkSaleOfferActive(ctx contractapi.TransactionContextInterface, offer *SaleOffer, expectedStatus string) error {
+	if offer.Status != expectedStatus {
+		return fmt.Errorf("the sale offer %s is %s, not %s", offer.ID, offer.Status, expectedStatus)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if now.After(offer.ExpiresAt) {
+		return fmt.Errorf("the sale offer %s expired at %s", offer.ID, offer.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// checkNotEscrowed keeps the parties of an escrow from bypassing its timeouts.
+func checkNotEscrowed(offer *SaleOffer) error {
+	if offer.EscrowID != "" {
+		return fmt.Errorf("the sale offer %s is held in the escrow %s", offer.ID, offer.EscrowID)
+	}
+
+	return nil
+}
+
+func putSaleOffer(ctx contractapi.TransactionContextInterface, offer *SaleOffer) error {
+	key, err := ctx.GetStub().CreateCompositeKey(offerKeyIndex, []string{offer.ID})
+	if err != nil {
+		return err
+	}
+
+	offer.ObjectType = offerObjectType
+	offerJSON, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, offerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put sale offer %s to world state: %v", offer.ID, err)
+	}
+
+	return nil
+}
+
+func putCarOfferIndex(ctx contractapi.TransactionContextInterface, carID string, offerID string) error {
+	carOfferKey, err := ctx.GetStub().CreateCompositeKey(carOfferIndex, []string{carID, offerID})
+	if err != nil {
+		return err
+	}
+
+	value := []byte{0x00}
+	return ctx.GetStub().PutState(carOfferKey, value)
+}
+
+func deleteCarOfferIndex(ctx contractapi.TransactionContextInterface, carID string, offerID string) error {
+	carOfferKey, err := ctx.GetStub().CreateCompositeKey(carOfferIndex, []string{carID, offerID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(carOfferKey)
+}
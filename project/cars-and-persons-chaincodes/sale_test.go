@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"cars-and-persons-chaincodes/mocks"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+)
+
+// personClients are the clients that prepLedger links the seeded persons to.
+var personClients = map[string]struct{ mspID, clientID string }{
+	"person1": {org1MSP, person1ClientID},
+	"person2": {org2MSP, person2ClientID},
+	"person3": {org3MSP, person3ClientID},
+}
+
+// sellCar sells a car to the buyer through a sale offer that its owner creates and the
+// buyer accepts and settles, which leaves the buyer as the submitting client.
+func sellCar(t *testing.T, transactionContext *mocks.TransactionContext, offerID string, carID string, buyerID string, askingPrice string) {
+	carsAndPersons := SmartContract{}
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, carID)
+	require.NoError(t, err)
+	seller, buyer := personClients[carAsset.OwnerID], personClients[buyerID]
+
+	setClient(transactionContext, seller.mspID, seller.clientID)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, offerID, carID, buyerID, askingPrice, 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, buyer.mspID, buyer.clientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, offerID, buyerID, true)
+	require.NoError(t, err)
+
+	setClient(transactionContext, seller.mspID, seller.clientID)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, offerID)
+	require.NoError(t, err)
+	setClient(transactionContext, buyer.mspID, buyer.clientID)
+}
+
+func TestCreateSaleOffer(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	offer, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "person3", "200", 3600)
+	require.NoError(t, err)
+	require.Equal(t, &SaleOffer{
+		ObjectType:          offerObjectType,
+		ID:                  "offer1",
+		CarID:               "car4",
+		SellerID:            "person1",
+		BuyerID:             "person3",
+		AskingPrice:         newMoney(200_00),
+		MalfunctionDiscount: newMoney(170_00),
+		Price:               newMoney(30_00),
+		Status:              offerStatusOpen,
+		CreatedAt:           testTime,
+		ExpiresAt:           testTime.Add(time.Hour),
+	}, offer)
+
+	storedOffer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offer, storedOffer)
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "person3", "200", 3600)
+	require.EqualError(t, err, "the sale offer offer1 already exists")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "", "car4", "person3", "200", 3600)
+	require.EqualError(t, err, "the offer ID must not be empty")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "person3", "200", 0)
+	require.EqualError(t, err, "the offer must be valid for a positive number of seconds")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "person1", "200", 3600)
+	require.EqualError(t, err, "Person person1 is already the owner of the car!")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "person9", "200", 3600)
+	require.EqualError(t, err, "the person person9 does not exist")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "", "-1", 3600)
+	require.EqualError(t, err, "the asking price must not be negative")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "", "100", 3600)
+	require.EqualError(t, err, "the asking price does not cover the repair price of the car's malfunctions")
+
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car2", "", "5000", 3600)
+	require.EqualError(t, err, "only the owner can modify the car car2: client is not authorized to act on behalf of person2")
+
+	offer, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car3", "", "3500", 60)
+	require.NoError(t, err, "offers without a buyer are open to anyone")
+	require.Empty(t, offer.BuyerID)
+}
+
+func TestAcceptSaleOffer(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "person3", "200", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car3", "", "3400", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person2", true)
+	require.EqualError(t, err, "the sale offer offer1 is reserved for person3")
+
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", true)
+	require.EqualError(t, err, "client is not authorized to act on behalf of person3")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", false)
+	require.EqualError(t, err, "the buyer will not accept a malfunctioned car")
+
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer2", "person3", true)
+	require.EqualError(t, err, "the buyer does not own enough money to purchase the car")
+
+	offer, err := carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", true)
+	require.NoError(t, err)
+	require.Equal(t, offerStatusAccepted, offer.Status)
+	require.Equal(t, newMoney(1400_22), personBalance(t, transactionContext, "person3"), "the buyer pays the price on accepting")
+
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", true)
+	require.EqualError(t, err, "the sale offer offer1 is accepted, not open")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer2", "person1", true)
+	require.EqualError(t, err, "Person person1 is already the owner of the car!")
+
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer9", "person1", true)
+	require.EqualError(t, err, "the sale offer offer9 does not exist")
+}
+
+func TestSettleSaleOffer(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "person3", "200", 3600)
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 is open, not accepted")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", true)
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person1", "the buyer cannot settle with the seller's details")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person1")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	offer, err := carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusSettled, offer.Status)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car4")
+	require.NoError(t, err)
+	require.Equal(t, "person3", carAsset.OwnerID)
+	require.Equal(t, newMoney(1400_22), personBalance(t, transactionContext, "person3"))
+	require.Equal(t, newMoney(5430_54), personBalance(t, transactionContext, "person1"))
+	require.Contains(t, state.indexEntries(t, colorOwnerIndex), "yellow/person3/car4")
+	require.NotContains(t, state.indexEntries(t, colorOwnerIndex), "yellow/person1/car4")
+	require.Contains(t, state.indexEntries(t, ownerIndex), "person3/car4")
+	require.NotContains(t, state.indexEntries(t, ownerIndex), "person1/car4")
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarTransferred, eventName)
+	var event CarTransferredEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, CarTransferredEvent{CarID: "car4", OldOwnerID: "person1", NewOwnerID: "person3", Price: newMoney(30_00)}, event)
+
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 is settled, not accepted")
+}
+
+func TestSettleSaleOfferChecksTheCar(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car1", "person3", "300", 3600)
+	require.NoError(t, err)
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", true)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car1", "m1")
+	require.NoError(t, err)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the malfunctions of the car car1 changed since the offer was made")
+
+	sellCar(t, transactionContext, "offer2", "car1", "person2", "2500")
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the car car1 is no longer owned by person1")
+
+	expired, err := ptypes.TimestampProto(testTime.Add(2 * time.Hour))
+	require.NoError(t, err)
+	chaincodeStub.GetTxTimestampReturns(expired, nil)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 expired at 2022-01-15T13:00:00Z")
+}
+
+func TestTransferCarAsset(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "", "5300", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car5", "", "4000", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer3", "car5", "person1", "3000", 3600)
+	require.NoError(t, err)
+
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car5", "person3", false)
+	require.EqualError(t, err, "Person person3 is already the owner of the car!")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car4", "person2", true)
+	require.EqualError(t, err, "the car car4 has no open sale offer for person2")
+
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car5", "person1", false)
+	require.EqualError(t, err, "client is not authorized to act on behalf of person1", "only the buyer can take up an offer")
+
+	transferred, err := carsAndPersons.TransferCarAsset(transactionContext, "car5", "person2", false)
+	require.NoError(t, err)
+	require.True(t, transferred)
+
+	offer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer2")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusSettled, offer.Status, "the cheapest offer open to the buyer is taken")
+	require.Equal(t, "person2", offer.BuyerID)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person2", carAsset.OwnerID)
+	require.Equal(t, newMoney(4200_40), personBalance(t, transactionContext, "person2"))
+	require.Equal(t, newMoney(5430_22), personBalance(t, transactionContext, "person3"))
+	require.Contains(t, state.indexEntries(t, ownerIndex), "person2/car5")
+	require.NotContains(t, state.indexEntries(t, ownerIndex), "person3/car5")
+	require.ElementsMatch(t, []string{"car5/offer1", "car5/offer3"}, state.indexEntries(t, carOfferIndex))
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarTransferred, eventName)
+	var event CarTransferredEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, CarTransferredEvent{CarID: "car5", OldOwnerID: "person3", NewOwnerID: "person2", Price: newMoney(4000_00)}, event)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car5", "person1", false)
+	require.EqualError(t, err, "the car car5 has no open sale offer for person1", "the offers of the previous owner are void")
+}
+
+func TestTransferCarAssetTakesTheReservedOffer(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "", "300", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "", "250", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.ReserveCar(transactionContext, "offer1", "person3", 600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car4", "person2", true)
+	require.EqualError(t, err, "the car car4 is reserved for person3 until 2022-01-15T12:10:00Z")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car4", "person3", false)
+	require.EqualError(t, err, "the buyer will not accept a malfunctioned car")
+
+	_, err = carsAndPersons.TransferCarAsset(transactionContext, "car4", "person3", true)
+	require.NoError(t, err)
+
+	offer, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusSettled, offer.Status, "the reserved offer is taken over a cheaper one")
+	require.Equal(t, newMoney(1300_22), personBalance(t, transactionContext, "person3"))
+
+	_, err = carsAndPersons.ReadCarReservation(transactionContext, "car4")
+	require.EqualError(t, err, "the car car4 is not reserved")
+}
+
+func TestCancelSaleOffer(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "person3", "200", 3600)
+	require.NoError(t, err)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car3", "person3", "3400", 3600)
+	require.NoError(t, err)
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person1")
+
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person3", true)
+	require.NoError(t, err)
+	require.Equal(t, newMoney(1400_22), personBalance(t, transactionContext, "person3"))
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "client is not authorized to act on behalf of person3", "the seller cannot refund the buyer")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	offer, err := carsAndPersons.CancelSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err, "the buyer can back out of an accepted offer")
+	require.Equal(t, offerStatusCancelled, offer.Status)
+	require.Equal(t, newMoney(1430_22), personBalance(t, transactionContext, "person3"), "the buyer gets the price back")
+
+	_, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 is cancelled and cannot be cancelled")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	offer, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer2")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusCancelled, offer.Status)
+}
+
+func TestReadSaleOffer(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	_, err := carsAndPersons.ReadSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 does not exist")
+
+	exists, err := carsAndPersons.SaleOfferExists(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "", "200", 3600)
+	require.NoError(t, err)
+
+	exists, err = carsAndPersons.SaleOfferExists(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestExpiredSaleOffer(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	offer, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car5", "person2", "4000", 3600)
+	require.NoError(t, err)
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person2", false)
+	require.NoError(t, err)
+
+	// The seller declines the accepted offer by letting it expire.
+	expired, err := ptypes.TimestampProto(offer.ExpiresAt.Add(time.Second))
+	require.NoError(t, err)
+	chaincodeStub.GetTxTimestampReturns(expired, nil)
+	setClient(transactionContext, org3MSP, person3ClientID)
+	_, err = carsAndPersons.SettleSaleOffer(transactionContext, "offer1")
+	require.EqualError(t, err, "the sale offer offer1 expired at "+offer.ExpiresAt.Format(time.RFC3339))
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	offer, err = carsAndPersons.CancelSaleOffer(transactionContext, "offer1")
+	require.NoError(t, err)
+	require.Equal(t, offerStatusCancelled, offer.Status)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, "person3", carAsset.OwnerID)
+	require.Equal(t, newMoney(8200_40), personBalance(t, transactionContext, "person2"))
+}
@@ -4,111 +4,186 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
+const (
+	colorOwnerIndex = "color~owner~ID"
+	minCarYear      = 1886
+
+	// ownerIndex lists the cars of every owner that are not written off, so that they
+	// can be found without scanning the color~owner~ID index.
+	ownerIndex = "owner~ID"
+)
+
 type SmartContract struct {
 	contractapi.Contract
 }
 
 type CarMalfunction struct {
+	ID          string
 	Description string
-	RepairPrice float32
+	RepairPrice Money
+	Severity    string
+	Status      string
+	ReportedAt  time.Time
+	ReportedBy  string
 }
 
 type CarAsset struct {
+	ObjectType      string `json:"objectType"`
 	ID              string
 	Brand           string
 	Model           string
 	Year            int
 	Color           string
 	OwnerID         string
-	Price           float32
+	Price           Money
 	MalfunctionList []CarMalfunction
+	RepairList      []CarRepair
+	Status          string
+	WriteOff        *CarWriteOff `json:",omitempty"`
+	// VIN is the vehicle identification number. Cars stored by earlier chaincode
+	// versions have none.
+	VIN               string `json:",omitempty"`
+	RegistrationPlate string `json:",omitempty"`
+	// FirstRegistration is the date the car was first registered, such as 2018-04-11.
+	FirstRegistration string `json:",omitempty"`
+	// Odometer is the last odometer reading in kilometers. It can only go up.
+	Odometer int `json:",omitempty"`
 }
 
+// PersonAsset is the public record of a person. The email address and the amount of
+// money owned are kept apart in PersonPrivateDetails.
 type PersonAsset struct {
-	ID                 string
-	FirstName          string
-	LastName           string
-	EmailAddress       string
-	AmountOfMoneyOwned float32
+	ObjectType string `json:"objectType"`
+	ID         string
+	FirstName  string
+	LastName   string
+	ClientID   string
+	MSPID      string
 }
 
+// InitLedger seeds the ledger with sample cars and persons. The salts of the persons'
+// private details are derived from the random value in the "salt" transient data.
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
 
 	carAssets := []CarAsset{
-		{ID: "car1", Brand: "Opel", Model: "Cascada", Year: 2013, Color: "blue", OwnerID: "person1", Price: 2500, MalfunctionList: []CarMalfunction{
-			{Description: "Shakey steering wheel", RepairPrice: 50},
-			{Description: "Oil leaking", RepairPrice: 75},
+		{ID: "car1", Brand: "Opel", Model: "Cascada", Year: 2013, Color: "blue", OwnerID: "person1", Price: newMoney(2500_00), VIN: "W0LPD7EC8D1012345", RegistrationPlate: "BG-123-AB", FirstRegistration: "2013-05-14", Odometer: 145000, MalfunctionList: []CarMalfunction{
+			{Description: "Shakey steering wheel", RepairPrice: newMoney(50_00), Severity: severityMajor},
+			{Description: "Oil leaking", RepairPrice: newMoney(75_00), Severity: severityMajor},
 		}},
-		{ID: "car2", Brand: "Audi", Model: "A4", Year: 2016, Color: "red", OwnerID: "person2", Price: 5000, MalfunctionList: []CarMalfunction{
-			{Description: "Flat fron left tyre", RepairPrice: 15},
+		{ID: "car2", Brand: "Audi", Model: "A4", Year: 2016, Color: "red", OwnerID: "person2", Price: newMoney(5000_00), VIN: "WAUZZZ8K3GA012345", RegistrationPlate: "NS-456-CD", FirstRegistration: "2016-03-02", Odometer: 89000, MalfunctionList: []CarMalfunction{
+			{Description: "Flat fron left tyre", RepairPrice: newMoney(15_00), Severity: severityMinor},
 		}},
-		{ID: "car3", Brand: "Volvo", Model: "V60", Year: 2014, Color: "green", OwnerID: "person1", Price: 3400, MalfunctionList: []CarMalfunction{
-			{Description: "Cracked windscreen", RepairPrice: 100},
-			{Description: "Loose back wiper", RepairPrice: 5},
+		{ID: "car3", Brand: "Volvo", Model: "V60", Year: 2014, Color: "green", OwnerID: "person1", Price: newMoney(3400_00), VIN: "YV1FW70CXE1123456", RegistrationPlate: "BG-789-EF", FirstRegistration: "2014-09-21", Odometer: 120500, MalfunctionList: []CarMalfunction{
+			{Description: "Cracked windscreen", RepairPrice: newMoney(100_00), Severity: severityMajor},
+			{Description: "Loose back wiper", RepairPrice: newMoney(5_00), Severity: severityMinor},
 		}},
-		{ID: "car4", Brand: "Zastava", Model: "Yugo 45", Year: 1985, Color: "yellow", OwnerID: "person1", Price: 200, MalfunctionList: []CarMalfunction{
-			{Description: "Broken alternator", RepairPrice: 50},
-			{Description: "Broken spark plug", RepairPrice: 30},
-			{Description: "Loose exhaust pipe", RepairPrice: 10},
-			{Description: "Overheating", RepairPrice: 80},
+		{ID: "car4", Brand: "Zastava", Model: "Yugo 45", Year: 1985, Color: "yellow", OwnerID: "person1", Price: newMoney(200_00), VIN: "VX1AB45A2F0012345", RegistrationPlate: "KG-012-GH", FirstRegistration: "1985-06-30", Odometer: 230000, MalfunctionList: []CarMalfunction{
+			{Description: "Broken alternator", RepairPrice: newMoney(50_00), Severity: severityCritical},
+			{Description: "Broken spark plug", RepairPrice: newMoney(30_00), Severity: severityMajor},
+			{Description: "Loose exhaust pipe", RepairPrice: newMoney(10_00), Severity: severityMinor},
+			{Description: "Overheating", RepairPrice: newMoney(80_00), Severity: severityCritical},
 		}},
-		{ID: "car5", Brand: "Mercedes-Benz", Model: "A-class", Year: 2018, Color: "black", OwnerID: "person3", Price: 5300, MalfunctionList: []CarMalfunction{}},
-		{ID: "car6", Brand: "BMW", Model: "X5", Year: 2018, Color: "white", OwnerID: "person2", Price: 6000, MalfunctionList: []CarMalfunction{
-			{Description: "Cracked headlight", RepairPrice: 30},
+		{ID: "car5", Brand: "Mercedes-Benz", Model: "A-class", Year: 2018, Color: "black", OwnerID: "person3", Price: newMoney(5300_00), VIN: "WDD1760410J123456", RegistrationPlate: "NI-345-JK", FirstRegistration: "2018-04-11", Odometer: 35000, MalfunctionList: []CarMalfunction{}},
+		{ID: "car6", Brand: "BMW", Model: "X5", Year: 2018, Color: "white", OwnerID: "person2", Price: newMoney(6000_00), VIN: "5UXKR0C51J0X12345", RegistrationPlate: "BG-678-LM", FirstRegistration: "2018-11-05", Odometer: 61000, MalfunctionList: []CarMalfunction{
+			{Description: "Cracked headlight", RepairPrice: newMoney(30_00), Severity: severityMinor},
 		}},
 	}
 
 	personAssets := []PersonAsset{
-		{ID: "person1", FirstName: "Petar", LastName: "Trifunovic", EmailAddress: "petar@pdasp.rs", AmountOfMoneyOwned: 5400.54},
-		{ID: "person2", FirstName: "Marko", LastName: "Markovic", EmailAddress: "marko@pdasp.rs", AmountOfMoneyOwned: 8200.4},
-		{ID: "person3", FirstName: "Jovana", LastName: "Jovanovic", EmailAddress: "jovana@pdasp.rs", AmountOfMoneyOwned: 1430.22},
+		{ID: "person1", FirstName: "Petar", LastName: "Trifunovic"},
+		{ID: "person2", FirstName: "Marko", LastName: "Markovic"},
+		{ID: "person3", FirstName: "Jovana", LastName: "Jovanovic"},
+	}
+	personDetails := []PersonPrivateDetails{
+		{EmailAddress: "petar@pdasp.rs", AmountOfMoneyOwned: newMoney(5400_54)},
+		{EmailAddress: "marko@pdasp.rs", AmountOfMoneyOwned: newMoney(8200_40)},
+		{EmailAddress: "jovana@pdasp.rs", AmountOfMoneyOwned: newMoney(1430_22)},
+	}
+
+	saltSeed, err := readSaltSeed(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
 	}
 
 	for _, carAsset := range carAssets {
-		carAssetJSON, err := json.Marshal(carAsset)
+		carAsset.Status = carStatusActive
+		normalizeMalfunctions(&carAsset)
+		for i := range carAsset.MalfunctionList {
+			carAsset.MalfunctionList[i].ReportedAt = now
+		}
+
+		err := putCarAsset(ctx, &carAsset)
+		if err != nil {
+			return err
+		}
+
+		// The seeded persons are not linked yet, so the registry endorses for them.
+		err = setCarEndorsement(ctx, carAsset.ID, registryMSPID)
 		if err != nil {
 			return err
 		}
 
-		err = ctx.GetStub().PutState(carAsset.ID, carAssetJSON)
+		err = putColorOwnerIndex(ctx, carAsset.Color, carAsset.OwnerID, carAsset.ID)
 		if err != nil {
-			return fmt.Errorf("failed to put cars to world state. %v", err)
+			return err
 		}
 
-		indexName := "color~owner~ID"
-		colorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{carAsset.Color, carAsset.OwnerID, carAsset.ID})
+		err = putOwnerIndex(ctx, carAsset.OwnerID, carAsset.ID)
 		if err != nil {
 			return err
 		}
 
-		value := []byte{0x00}
-		err = ctx.GetStub().PutState(colorOwnerIndexKey, value)
+		err = putCarIdentityIndexes(ctx, &carAsset)
 		if err != nil {
 			return err
 		}
 	}
 
-	for _, personAsset := range personAssets {
-		personAssetJSON, err := json.Marshal(personAsset)
+	initializedEvent := LedgerInitializedEvent{}
+	for _, carAsset := range carAssets {
+		initializedEvent.CarIDs = append(initializedEvent.CarIDs, carAsset.ID)
+	}
+
+	for i, personAsset := range personAssets {
+		err := putPersonAsset(ctx, &personAsset)
 		if err != nil {
 			return err
 		}
 
-		err = ctx.GetStub().PutState(personAsset.ID, personAssetJSON)
+		personDetails[i].Salt = personSalt(saltSeed, personAsset.ID)
+		err = putPersonDetails(ctx, &personAsset, &personDetails[i])
 		if err != nil {
-			return fmt.Errorf("failed to put persons to world state. %v", err)
+			return err
 		}
+		initializedEvent.PersonIDs = append(initializedEvent.PersonIDs, personAsset.ID)
 	}
 
-	return nil
+	return emitEvent(ctx, eventLedgerInitialized, initializedEvent)
 }
 
 func (s *SmartContract) ReadPersonAsset(ctx contractapi.TransactionContextInterface, id string) (*PersonAsset, error) {
-	personAssetJSON, err := ctx.GetStub().GetState(id)
+	key, err := personKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	personAssetJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read person from world state: %v", err)
 	}
@@ -122,11 +197,21 @@ func (s *SmartContract) ReadPersonAsset(ctx contractapi.TransactionContextInterf
 		return nil, err
 	}
 
+	err = checkObjectType(id, personAsset.ObjectType, personObjectType)
+	if err != nil {
+		return nil, err
+	}
+
 	return &personAsset, nil
 }
 
 func (s *SmartContract) ReadCarAsset(ctx contractapi.TransactionContextInterface, id string) (*CarAsset, error) {
-	carAssetJSON, err := ctx.GetStub().GetState(id)
+	key, err := carKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	carAssetJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read car from world state: %v", err)
 	}
@@ -140,41 +225,318 @@ func (s *SmartContract) ReadCarAsset(ctx contractapi.TransactionContextInterface
 		return nil, err
 	}
 
+	err = checkObjectType(id, carAsset.ObjectType, carObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeMalfunctions(&carAsset)
+	normalizeCarStatus(&carAsset)
+
 	return &carAsset, nil
 }
 
-func (s *SmartContract) GetCarsByColor(ctx contractapi.TransactionContextInterface, color string) ([]*CarAsset, error) {
-	coloredCarIter, err := ctx.GetStub().GetStateByPartialCompositeKey("color~owner~ID", []string{color})
+// CreatePersonAsset registers a person. The email address and the amount of money owned
+// are passed in the "person" transient data, e.g. {"emailAddress":"ana@pdasp.rs",
+// "amountOfMoneyOwned":"100.00 EUR"}, and stored in the registry's collection.
+func (s *SmartContract) CreatePersonAsset(ctx contractapi.TransactionContextInterface, id string, firstName string, lastName string) error {
+	err := requireRegistry(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	defer coloredCarIter.Close()
+	exists, err := s.PersonAssetExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the person asset %s already exists", id)
+	}
 
-	retList := make([]*CarAsset, 0)
+	details, err := readPersonInput(ctx)
+	if err != nil {
+		return err
+	}
 
-	for i := 0; coloredCarIter.HasNext(); i++ {
-		responseRange, err := coloredCarIter.Next()
-		if err != nil {
-			return nil, err
-		}
+	personAsset := PersonAsset{
+		ID:        id,
+		FirstName: firstName,
+		LastName:  lastName,
+	}
 
-		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			return nil, err
-		}
+	err = validatePersonAsset(&personAsset)
+	if err != nil {
+		return err
+	}
+
+	err = putPersonAsset(ctx, &personAsset)
+	if err != nil {
+		return err
+	}
 
-		retCarID := compositeKeyParts[2]
+	return putPersonDetails(ctx, &personAsset, details)
+}
 
-		carAsset, err := s.ReadCarAsset(ctx, retCarID)
-		if err != nil {
-			return nil, err
-		}
+// UpdatePersonAsset replaces the details of a person, taking the private ones from the
+// "person" transient data like CreatePersonAsset.
+func (s *SmartContract) UpdatePersonAsset(ctx contractapi.TransactionContextInterface, id string, firstName string, lastName string) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
 
-		retList = append(retList, carAsset)
+	personAsset, err := s.ReadPersonAsset(ctx, id)
+	if err != nil {
+		return err
 	}
 
-	return retList, nil
+	details, err := readPersonInput(ctx)
+	if err != nil {
+		return err
+	}
+
+	personAsset.FirstName = firstName
+	personAsset.LastName = lastName
+
+	err = validatePersonAsset(personAsset)
+	if err != nil {
+		return err
+	}
+
+	err = putPersonAsset(ctx, personAsset)
+	if err != nil {
+		return err
+	}
+
+	return putPersonDetails(ctx, personAsset, details)
+}
+
+func (s *SmartContract) DeletePersonAsset(ctx contractapi.TransactionContextInterface, id string) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
+
+	personAsset, err := s.ReadPersonAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ownsCars, err := s.personOwnsCars(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ownsCars {
+		return fmt.Errorf("the person %s still owns cars and cannot be deleted", id)
+	}
+
+	key, err := personKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return err
+	}
+
+	return deletePersonDetails(ctx, personAsset)
+}
+
+// CreateCarAsset registers a new car. The VIN is required and has to pass the ISO 3779
+// check digit, while the registration plate and the first registration date may be
+// left empty, e.g. for a car that is not registered yet.
+func (s *SmartContract) CreateCarAsset(ctx contractapi.TransactionContextInterface, id string, brand string, model string, year int, color string, ownerID string, price string, vin string, registrationPlate string, firstRegistration string, odometer int) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
+
+	exists, err := s.CarAssetExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the car asset %s already exists", id)
+	}
+
+	carPrice, err := ParseMoney(price)
+	if err != nil {
+		return err
+	}
+
+	carAsset := CarAsset{
+		ID:              id,
+		Brand:           brand,
+		Model:           model,
+		Year:            year,
+		Color:           color,
+		OwnerID:         ownerID,
+		Price:           carPrice,
+		MalfunctionList: []CarMalfunction{},
+		RepairList:      []CarRepair{},
+		Status:          carStatusActive,
+
+		VIN:               vin,
+		RegistrationPlate: registrationPlate,
+		FirstRegistration: firstRegistration,
+		Odometer:          odometer,
+	}
+	normalizeCarIdentity(&carAsset)
+
+	err = validateCarAsset(&carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = validateNewCarIdentity(&carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = checkCarIdentityUnique(ctx, &carAsset)
+	if err != nil {
+		return err
+	}
+
+	exists, err = s.PersonAssetExists(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("the person %v does not exist", ownerID)
+	}
+
+	organization, err := s.ownerOrganization(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	err = putCarAsset(ctx, &carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = setCarEndorsement(ctx, carAsset.ID, organization)
+	if err != nil {
+		return err
+	}
+
+	err = putColorOwnerIndex(ctx, carAsset.Color, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	err = putOwnerIndex(ctx, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	return putCarIdentityIndexes(ctx, &carAsset)
+}
+
+func (s *SmartContract) UpdateCarAsset(ctx contractapi.TransactionContextInterface, id string, brand string, model string, year int, color string, price string) error {
+	carAsset, err := s.ReadCarAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.requireCarOwner(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	carPrice, err := ParseMoney(price)
+	if err != nil {
+		return err
+	}
+
+	oldColor := carAsset.Color
+	carAsset.Brand = brand
+	carAsset.Model = model
+	carAsset.Year = year
+	carAsset.Color = color
+	carAsset.Price = carPrice
+
+	err = validateCarAsset(carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = validateCarIdentity(carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = putCarAsset(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	if oldColor == color {
+		return nil
+	}
+
+	return recolorCar(ctx, carAsset, oldColor)
+}
+
+func (s *SmartContract) DeleteCarAsset(ctx contractapi.TransactionContextInterface, id string) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
+
+	carAsset, err := s.ReadCarAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	key, err := carKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return err
+	}
+
+	err = deleteReservation(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = deleteCarIdentityIndexes(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	if carAsset.Status == carStatusWrittenOff {
+		return deleteWrittenOffIndex(ctx, carAsset.OwnerID, carAsset.ID)
+	}
+
+	err = deleteColorOwnerIndex(ctx, carAsset.Color, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	return deleteOwnerIndex(ctx, carAsset.OwnerID, carAsset.ID)
+}
+
+func (s *SmartContract) GetCarsByColor(ctx contractapi.TransactionContextInterface, color string) ([]*CarAsset, error) {
+	coloredCarIter, err := ctx.GetStub().GetStateByPartialCompositeKey(colorOwnerIndex, []string{color})
+	if err != nil {
+		return nil, err
+	}
+
+	defer coloredCarIter.Close()
+
+	return s.readIndexedCars(ctx, coloredCarIter)
 }
 
 func (s *SmartContract) GetCarsByColorAndOwner(ctx contractapi.TransactionContextInterface, color string, ownerID string) ([]*CarAsset, error) {
@@ -186,17 +548,67 @@ func (s *SmartContract) GetCarsByColorAndOwner(ctx contractapi.TransactionContex
 		return nil, fmt.Errorf("the person %v does not exist", ownerID)
 	}
 
-	coloredCarByOwnerIter, err := ctx.GetStub().GetStateByPartialCompositeKey("color~owner~ID", []string{color, ownerID})
+	coloredCarByOwnerIter, err := ctx.GetStub().GetStateByPartialCompositeKey(colorOwnerIndex, []string{color, ownerID})
 	if err != nil {
 		return nil, err
 	}
 
 	defer coloredCarByOwnerIter.Close()
 
+	return s.readIndexedCars(ctx, coloredCarByOwnerIter)
+}
+
+// GetCarsByColorWithPagination returns a page of at most pageSize cars with the given
+// color. Paginated queries are only valid for read only transactions.
+func (s *SmartContract) GetCarsByColorWithPagination(ctx contractapi.TransactionContextInterface, color string, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	return s.getIndexedCarsWithPagination(ctx, []string{color}, pageSize, bookmark)
+}
+
+// GetCarsByColorAndOwnerWithPagination returns a page of at most pageSize cars with the
+// given color and owner. Paginated queries are only valid for read only transactions.
+func (s *SmartContract) GetCarsByColorAndOwnerWithPagination(ctx contractapi.TransactionContextInterface, color string, ownerID string, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	exists, err := s.PersonAssetExists(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("the person %v does not exist", ownerID)
+	}
+
+	return s.getIndexedCarsWithPagination(ctx, []string{color, ownerID}, pageSize, bookmark)
+}
+
+func (s *SmartContract) getIndexedCarsWithPagination(ctx contractapi.TransactionContextInterface, attributes []string, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("the page size must be positive")
+	}
+
+	carIter, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(colorOwnerIndex, attributes, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	defer carIter.Close()
+
+	carAssets, err := s.readIndexedCars(ctx, carIter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:             carAssets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// readIndexedCars reads the cars referenced by the entries of a color~owner~ID or
+// owner~writtenOffID iterator. The car ID is the last attribute of both indexes.
+func (s *SmartContract) readIndexedCars(ctx contractapi.TransactionContextInterface, carIter shim.StateQueryIteratorInterface) ([]*CarAsset, error) {
 	retList := make([]*CarAsset, 0)
 
-	for i := 0; coloredCarByOwnerIter.HasNext(); i++ {
-		responseRange, err := coloredCarByOwnerIter.Next()
+	for carIter.HasNext() {
+		responseRange, err := carIter.Next()
 		if err != nil {
 			return nil, err
 		}
@@ -206,7 +618,7 @@ func (s *SmartContract) GetCarsByColorAndOwner(ctx contractapi.TransactionContex
 			return nil, err
 		}
 
-		retCarID := compositeKeyParts[2]
+		retCarID := compositeKeyParts[len(compositeKeyParts)-1]
 
 		carAsset, err := s.ReadCarAsset(ctx, retCarID)
 		if err != nil {
@@ -219,236 +631,410 @@ func (s *SmartContract) GetCarsByColorAndOwner(ctx contractapi.TransactionContex
 	return retList, nil
 }
 
-func (s *SmartContract) TransferCarAsset(ctx contractapi.TransactionContextInterface, id string, newOwnerID string, acceptMalfunction bool) (bool, error) {
+func (s *SmartContract) AddCarMalfunction(ctx contractapi.TransactionContextInterface, id string, description string, repairPrice string, severity string) error {
 	carAsset, err := s.ReadCarAsset(ctx, id)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	if carAsset.OwnerID == newOwnerID {
-		return false, fmt.Errorf("Person %s is already the owner of the car!", newOwnerID)
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return err
 	}
 
-	buyer, err := s.ReadPersonAsset(ctx, newOwnerID)
+	_, err = s.requireCarOwner(ctx, carAsset)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	seller, err := s.ReadPersonAsset(ctx, carAsset.OwnerID)
+	malfunctionPrice, err := ParseMoney(repairPrice)
 	if err != nil {
-		return false, err
+		return err
 	}
-
-	carPrice := float32(0)
-
-	if carAsset.MalfunctionList == nil || len(carAsset.MalfunctionList) == 0 {
-		carPrice = carAsset.Price
-	} else if acceptMalfunction {
-		malfuctionPrice := float32(0)
-		for _, carMalfunction := range carAsset.MalfunctionList {
-			malfuctionPrice += carMalfunction.RepairPrice
-		}
-		carPrice = carAsset.Price - malfuctionPrice
-	} else {
-		return false, fmt.Errorf("the buyer will not accept a malfunctioned car")
+	if malfunctionPrice.IsNegative() {
+		return fmt.Errorf("the repair price must not be negative")
 	}
 
-	oldOwnerID := carAsset.OwnerID
-	carAsset.OwnerID = newOwnerID
-
-	if buyer.AmountOfMoneyOwned >= carPrice {
-		buyer.AmountOfMoneyOwned -= carPrice
-		seller.AmountOfMoneyOwned += carPrice
-	} else {
-		return false, fmt.Errorf("the buyer does not own enough money to purchase the car")
+	err = validateSeverity(severity)
+	if err != nil {
+		return err
 	}
 
-	carAssetJSON, err := json.Marshal(carAsset)
+	now, err := txTimestamp(ctx)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	buyerJSON, err := json.Marshal(buyer)
+	reporterID, _, err := getSubmittingClient(ctx)
 	if err != nil {
-		return false, err
+		return err
+	}
+
+	newMalfunction := CarMalfunction{
+		ID:          malfunctionID(len(carAsset.MalfunctionList)),
+		Description: description,
+		RepairPrice: malfunctionPrice,
+		Severity:    severity,
+		Status:      malfunctionStatusOpen,
+		ReportedAt:  now,
+		ReportedBy:  reporterID,
 	}
 
-	sellerJSON, err := json.Marshal(seller)
+	carAsset.MalfunctionList = append(carAsset.MalfunctionList, newMalfunction)
+
+	repairPriceSum, err := totalRepairPrice(carAsset)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	err = ctx.GetStub().PutState(id, carAssetJSON)
+	cmp, err := repairPriceSum.Cmp(carAsset.Price)
 	if err != nil {
-		return false, err
+		return err
+	}
+	if cmp > 0 {
+		return writeOffCar(ctx, carAsset, repairPriceSum, now)
 	}
 
-	err = ctx.GetStub().PutState(buyer.ID, buyerJSON)
+	err = putCarAsset(ctx, carAsset)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	err = ctx.GetStub().PutState(seller.ID, sellerJSON)
+	return emitEvent(ctx, eventMalfunctionReported, MalfunctionReportedEvent{
+		CarID:           carAsset.ID,
+		OwnerID:         carAsset.OwnerID,
+		Malfunction:     newMalfunction,
+		OpenRepairPrice: repairPriceSum,
+	})
+}
+
+func (s *SmartContract) ChangeCarColor(ctx contractapi.TransactionContextInterface, id string, newColor string) (string, error) {
+	carAsset, err := s.ReadCarAsset(ctx, id)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	indexName := "color~owner~ID"
-	colorNewOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{carAsset.Color, newOwnerID, carAsset.ID})
+	err = requireActiveCar(carAsset)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	value := []byte{0x00}
-	err = ctx.GetStub().PutState(colorNewOwnerIndexKey, value)
+	_, err = s.requireCarOwner(ctx, carAsset)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	colorOldOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{carAsset.Color, oldOwnerID, carAsset.ID})
+	oldColor := carAsset.Color
+	if oldColor == newColor {
+		return "", fmt.Errorf("the car %s is already %s", id, newColor)
+	}
+	carAsset.Color = newColor
+
+	err = putCarAsset(ctx, carAsset)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	err = ctx.GetStub().DelState(colorOldOwnerIndexKey)
+	err = recolorCar(ctx, carAsset, oldColor)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	return true, nil
+	return oldColor, nil
 }
 
-func (s *SmartContract) AddCarMalfunction(ctx contractapi.TransactionContextInterface, id string, description string, repairPrice float32) error {
+func (s *SmartContract) RepairCar(ctx contractapi.TransactionContextInterface, id string) error {
 	carAsset, err := s.ReadCarAsset(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	newMalfunction := CarMalfunction{
-		Description: description,
-		RepairPrice: repairPrice,
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return err
 	}
 
-	carAsset.MalfunctionList = append(carAsset.MalfunctionList, newMalfunction)
-
-	totalRepairPrice := float32(0)
-	for _, carMalfunction := range carAsset.MalfunctionList {
-		totalRepairPrice += carMalfunction.RepairPrice
+	personAsset, err := s.requireCarOwner(ctx, carAsset)
+	if err != nil {
+		return err
 	}
 
-	if totalRepairPrice > carAsset.Price {
-		return ctx.GetStub().DelState(id)
+	indexes := openMalfunctionIndexes(carAsset)
+	if len(indexes) == 0 {
+		return fmt.Errorf("the car %s has no open malfunctions", id)
 	}
 
-	carAssetJSON, err := json.Marshal(carAsset)
+	err = repairMalfunctions(ctx, carAsset, personAsset, indexes)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(id, carAssetJSON)
+	return putCarAsset(ctx, carAsset)
+}
+
+func (s *SmartContract) PersonAssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	key, err := personKey(ctx, id)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	personAssetJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read person asset from world state: %v", err)
+	}
+
+	return personAssetJSON != nil, nil
 }
 
-func (s *SmartContract) ChangeCarColor(ctx contractapi.TransactionContextInterface, id string, newColor string) (string, error) {
-	carAsset, err := s.ReadCarAsset(ctx, id)
+func (s *SmartContract) CarAssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	key, err := carKey(ctx, id)
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	oldColor := carAsset.Color
-	carAsset.Color = newColor
+	carAssetJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read car asset from world state: %v", err)
+	}
 
-	carAssetJSON, err := json.Marshal(carAsset)
+	return carAssetJSON != nil, nil
+}
+
+// personOwnsCars looks the owner up in the owner~ID index of active cars and the
+// owner~writtenOffID index of written-off cars.
+func (s *SmartContract) personOwnsCars(ctx contractapi.TransactionContextInterface, ownerID string) (bool, error) {
+	for _, index := range []string{ownerIndex, writtenOffIndex} {
+		carIter, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{ownerID})
+		if err != nil {
+			return false, err
+		}
+		ownsCars := carIter.HasNext()
+		carIter.Close()
+		if ownsCars {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ownedCarIDs returns the IDs of the cars of the owner, written-off cars included, from
+// the owner~ID and owner~writtenOffID indexes.
+func ownedCarIDs(ctx contractapi.TransactionContextInterface, ownerID string) ([]string, error) {
+	var carIDs []string
+	for _, index := range []string{ownerIndex, writtenOffIndex} {
+		carIter, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{ownerID})
+		if err != nil {
+			return nil, err
+		}
+
+		for carIter.HasNext() {
+			queryResponse, err := carIter.Next()
+			if err != nil {
+				carIter.Close()
+				return nil, err
+			}
+
+			_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				carIter.Close()
+				return nil, err
+			}
+			carIDs = append(carIDs, compositeKeyParts[1])
+		}
+		carIter.Close()
+	}
+
+	return carIDs, nil
+}
+
+// totalRepairPrice sums up the repair prices of the car's open malfunctions.
+func totalRepairPrice(carAsset *CarAsset) (Money, error) {
+	total := newMoney(0)
+	for _, index := range openMalfunctionIndexes(carAsset) {
+		var err error
+		total, err = total.Add(carAsset.MalfunctionList[index].RepairPrice)
+		if err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// discountedCarPrice reduces the price of a malfunctioned car by the cost of its
+// repairs, provided that the buyer accepts the car in that state.
+func discountedCarPrice(carAsset *CarAsset, price Money, acceptMalfunction bool) (Money, error) {
+	if len(openMalfunctionIndexes(carAsset)) == 0 {
+		return price, nil
+	}
+	if !acceptMalfunction {
+		return Money{}, fmt.Errorf("the buyer will not accept a malfunctioned car")
+	}
+
+	malfuctionPrice, err := totalRepairPrice(carAsset)
 	if err != nil {
-		return "", err
+		return Money{}, err
+	}
+
+	return price.Sub(malfuctionPrice)
+}
+
+// handOverCar makes the buyer the owner of the car, keeping the color~owner~ID and
+// owner~ID indexes in step, and emits the CarTransferred event with the price that was paid. From then
+// on, changes of the car have to be endorsed by the organizations of both the seller
+// and the buyer. A reserved car can only be handed over to the holder of the
+// reservation, which ends with the transfer.
+func handOverCar(ctx contractapi.TransactionContextInterface, carAsset *CarAsset, seller *PersonAsset, buyer *PersonAsset, price Money) error {
+	buyerID := buyer.ID
+	err := checkReservation(ctx, carAsset.ID, buyerID)
+	if err != nil {
+		return err
 	}
 
-	err = ctx.GetStub().PutState(id, carAssetJSON)
+	oldOwnerID := carAsset.OwnerID
+	carAsset.OwnerID = buyerID
+
+	err = putCarAsset(ctx, carAsset)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	indexName := "color~owner~ID"
-	newColorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{newColor, carAsset.OwnerID, carAsset.ID})
+	err = deleteReservation(ctx, carAsset.ID)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	value := []byte{0x00}
-	err = ctx.GetStub().PutState(newColorOwnerIndexKey, value)
+	err = setCarEndorsement(ctx, carAsset.ID, personOrganization(seller), personOrganization(buyer))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	oldColorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{oldColor, carAsset.OwnerID, carAsset.ID})
+	err = putColorOwnerIndex(ctx, carAsset.Color, buyerID, carAsset.ID)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	err = ctx.GetStub().DelState(oldColorOwnerIndexKey)
+	err = deleteColorOwnerIndex(ctx, carAsset.Color, oldOwnerID, carAsset.ID)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return oldColor, nil
-}
+	err = putOwnerIndex(ctx, buyerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
 
-func (s *SmartContract) RepairCar(ctx contractapi.TransactionContextInterface, id string) error {
-	carAsset, err := s.ReadCarAsset(ctx, id)
+	err = deleteOwnerIndex(ctx, oldOwnerID, carAsset.ID)
 	if err != nil {
 		return err
 	}
 
-	personAsset, err := s.ReadPersonAsset(ctx, carAsset.OwnerID)
+	return emitEvent(ctx, eventCarTransferred, CarTransferredEvent{
+		CarID:      carAsset.ID,
+		OldOwnerID: oldOwnerID,
+		NewOwnerID: buyerID,
+		Price:      price,
+	})
+}
+
+// recolorCar moves a stored car from its old color to its new color in the
+// color~owner~ID index.
+func recolorCar(ctx contractapi.TransactionContextInterface, carAsset *CarAsset, oldColor string) error {
+	err := putColorOwnerIndex(ctx, carAsset.Color, carAsset.OwnerID, carAsset.ID)
 	if err != nil {
 		return err
 	}
 
-	repairPriceSum := float32(0)
-	for _, carMalfunction := range carAsset.MalfunctionList {
-		repairPriceSum += carMalfunction.RepairPrice
-		if repairPriceSum > personAsset.AmountOfMoneyOwned {
-			return fmt.Errorf("The owner of the car cannot afford to pay the car repair price")
-		}
+	err = deleteColorOwnerIndex(ctx, oldColor, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
 	}
 
-	carAsset.MalfunctionList = []CarMalfunction{}
-	personAsset.AmountOfMoneyOwned -= repairPriceSum
+	return emitEvent(ctx, eventCarRecolored, CarRecoloredEvent{
+		CarID:    carAsset.ID,
+		OwnerID:  carAsset.OwnerID,
+		OldColor: oldColor,
+		NewColor: carAsset.Color,
+	})
+}
 
-	carAssetJSON, err := json.Marshal(carAsset)
+func putColorOwnerIndex(ctx contractapi.TransactionContextInterface, color string, ownerID string, carID string) error {
+	colorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(colorOwnerIndex, []string{color, ownerID, carID})
 	if err != nil {
 		return err
 	}
 
-	personAssetJSON, err := json.Marshal(personAsset)
+	value := []byte{0x00}
+	return ctx.GetStub().PutState(colorOwnerIndexKey, value)
+}
+
+func deleteColorOwnerIndex(ctx contractapi.TransactionContextInterface, color string, ownerID string, carID string) error {
+	colorOwnerIndexKey, err := ctx.GetStub().CreateCompositeKey(colorOwnerIndex, []string{color, ownerID, carID})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(id, carAssetJSON)
+	return ctx.GetStub().DelState(colorOwnerIndexKey)
+}
+
+func putOwnerIndex(ctx contractapi.TransactionContextInterface, ownerID string, carID string) error {
+	ownerIndexKey, err := ctx.GetStub().CreateCompositeKey(ownerIndex, []string{ownerID, carID})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(personAsset.ID, personAssetJSON)
+	value := []byte{0x00}
+	return ctx.GetStub().PutState(ownerIndexKey, value)
+}
+
+func deleteOwnerIndex(ctx contractapi.TransactionContextInterface, ownerID string, carID string) error {
+	ownerIndexKey, err := ctx.GetStub().CreateCompositeKey(ownerIndex, []string{ownerID, carID})
 	if err != nil {
 		return err
 	}
 
+	return ctx.GetStub().DelState(ownerIndexKey)
+}
+
+func validateCarAsset(carAsset *CarAsset) error {
+	if strings.TrimSpace(carAsset.ID) == "" {
+		return fmt.Errorf("the car ID must not be empty")
+	}
+	if strings.TrimSpace(carAsset.Brand) == "" {
+		return fmt.Errorf("the car brand must not be empty")
+	}
+	if strings.TrimSpace(carAsset.Model) == "" {
+		return fmt.Errorf("the car model must not be empty")
+	}
+	if strings.TrimSpace(carAsset.Color) == "" {
+		return fmt.Errorf("the car color must not be empty")
+	}
+	if strings.TrimSpace(carAsset.OwnerID) == "" {
+		return fmt.Errorf("the car owner ID must not be empty")
+	}
+	if carAsset.Year < minCarYear {
+		return fmt.Errorf("the car year %d is not valid", carAsset.Year)
+	}
+	if carAsset.Price.IsNegative() {
+		return fmt.Errorf("the car price must not be negative")
+	}
+
 	return nil
 }
 
-func (s *SmartContract) PersonAssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
-	personAssetJSON, err := ctx.GetStub().GetState(id)
-	if err != nil {
-		return false, fmt.Errorf("failed to read person asset from world state: %v", err)
+func validatePersonAsset(personAsset *PersonAsset) error {
+	if strings.TrimSpace(personAsset.ID) == "" {
+		return fmt.Errorf("the person ID must not be empty")
+	}
+	if strings.TrimSpace(personAsset.FirstName) == "" {
+		return fmt.Errorf("the person first name must not be empty")
+	}
+	if strings.TrimSpace(personAsset.LastName) == "" {
+		return fmt.Errorf("the person last name must not be empty")
 	}
 
-	return personAssetJSON != nil, nil
+	return nil
 }
 
 func main() {
@@ -0,0 +1,918 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cars-and-persons-chaincodes/mocks"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+These unit tests use mocks to simulate chaincode-api & fabric interactions
+The mocks are generated using counterfeiter directives in the comments (starting with "go:generate counterfeiter")
+All files in mocks/* are generated by running following, in the directory with your directive:
+	`go generate`
+*/
+
+//go:generate counterfeiter -o mocks/transaction.go -fake-name TransactionContext . transactionContext
+type transactionContext interface {
+	contractapi.TransactionContextInterface
+}
+
+//go:generate counterfeiter -o mocks/chaincodestub.go -fake-name ChaincodeStub . chaincodeStub
+type chaincodeStub interface {
+	shim.ChaincodeStubInterface
+}
+
+//go:generate counterfeiter -o mocks/statequeryiterator.go -fake-name StateQueryIterator . stateQueryIterator
+type stateQueryIterator interface {
+	shim.StateQueryIteratorInterface
+}
+
+//go:generate counterfeiter -o mocks/historyqueryiterator.go -fake-name HistoryQueryIterator . historyQueryIterator
+type historyQueryIterator interface {
+	shim.HistoryQueryIteratorInterface
+}
+
+//go:generate counterfeiter -o mocks/clientidentity.go -fake-name ClientIdentity . clientIdentity
+type clientIdentity interface {
+	cid.ClientIdentity
+}
+
+const (
+	org1MSP = "Org1MSP"
+	org2MSP = "Org2MSP"
+	org3MSP = "Org3MSP"
+
+	registryClientID = "x509::CN=registrar,OU=client::CN=ca.org1.example.com"
+	person1ClientID  = "x509::CN=petar,OU=client::CN=ca.org1.example.com"
+	person2ClientID  = "x509::CN=marko,OU=client::CN=ca.org2.example.com"
+	person3ClientID  = "x509::CN=jovana,OU=client::CN=ca.org3.example.com"
+	insurerClientID  = "x509::CN=insurer,OU=client::CN=ca.org3.example.com"
+)
+
+var testTime = time.Date(2022, time.January, 15, 12, 0, 0, 0, time.UTC)
+
+// testSalt is the random value that the tests pass to InitLedger and the migrations in
+// the "salt" transient data, and the salt of the persons they create.
+const testSalt = "6b1d2a9fce30487f9d0e5c2b7a41e3d8"
+
+// worldState backs the ChaincodeStub mock with a map, so that transactions which read
+// back what they wrote, and the indexes they maintain, can be checked end to end.
+type worldState map[string][]byte
+
+func prepMocks(mspID string, clientID string) (*mocks.TransactionContext, *mocks.ChaincodeStub, worldState) {
+	state := worldState{}
+
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	// Key-level endorsement policies are kept with the key, like on the peer.
+	validationParameters := worldState{}
+	chaincodeStub.SetStateValidationParameterStub = func(key string, ep []byte) error {
+		validationParameters[key] = ep
+		return nil
+	}
+	chaincodeStub.GetStateValidationParameterStub = func(key string) ([]byte, error) {
+		return validationParameters[key], nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		delete(state, key)
+		delete(validationParameters, key)
+		return nil
+	}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.SplitCompositeKeyStub = (&shim.ChaincodeStub{}).SplitCompositeKey
+	chaincodeStub.GetStateByRangeStub = func(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+		// Like the peer, range queries only see simple keys.
+		return state.iterator(state.keys(func(key string) bool {
+			return !strings.HasPrefix(key, "\x00")
+		})), nil
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		return state.iterator(state.keys(func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		})), nil
+	}
+
+	timestamp, _ := ptypes.TimestampProto(testTime)
+	chaincodeStub.GetTxTimestampReturns(timestamp, nil)
+
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationStub = func(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys := state.keys(func(key string) bool {
+			return strings.HasPrefix(key, prefix) && key >= bookmark
+		})
+
+		nextBookmark := ""
+		if len(keys) > int(pageSize) {
+			nextBookmark = keys[pageSize]
+			keys = keys[:pageSize]
+		}
+
+		return state.iterator(keys), &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(keys)), Bookmark: nextBookmark}, nil
+	}
+
+	// Private data is kept apart from the world state, by collection.
+	privateData := map[string]worldState{}
+	chaincodeStub.GetPrivateDataStub = func(collection string, key string) ([]byte, error) {
+		return privateData[collection][key], nil
+	}
+	chaincodeStub.GetPrivateDataHashStub = func(collection string, key string) ([]byte, error) {
+		value := privateData[collection][key]
+		if value == nil {
+			return nil, nil
+		}
+		hash := sha256.Sum256(value)
+		return hash[:], nil
+	}
+	chaincodeStub.PutPrivateDataStub = func(collection string, key string, value []byte) error {
+		if privateData[collection] == nil {
+			privateData[collection] = worldState{}
+		}
+		privateData[collection][key] = value
+		return nil
+	}
+	chaincodeStub.DelPrivateDataStub = func(collection string, key string) error {
+		delete(privateData[collection], key)
+		return nil
+	}
+
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+	setClient(transactionContext, mspID, clientID)
+
+	return transactionContext, chaincodeStub, state
+}
+
+// setClient makes the following transactions look as if they were submitted by the given client.
+func setClient(transactionContext *mocks.TransactionContext, mspID string, clientID string) {
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetIDReturns(base64.StdEncoding.EncodeToString([]byte(clientID)), nil)
+	clientIdentity.GetMSPIDReturns(mspID, nil)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+}
+
+// prepLedger initializes the ledger as the registry and links the seeded persons to
+// their clients, leaving the registry as the submitting client.
+func prepLedger(t *testing.T) (*mocks.TransactionContext, *mocks.ChaincodeStub, worldState) {
+	transactionContext, chaincodeStub, state := prepMocks(org1MSP, registryClientID)
+	carsAndPersons := SmartContract{}
+
+	setSaltSeed(chaincodeStub)
+	err := carsAndPersons.InitLedger(transactionContext)
+	require.NoError(t, err)
+
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person1", person1ClientID, org1MSP))
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person2", person2ClientID, org2MSP))
+	require.NoError(t, carsAndPersons.LinkPersonIdentity(transactionContext, "person3", person3ClientID, org3MSP))
+
+	return transactionContext, chaincodeStub, state
+}
+
+func (w worldState) keys(match func(key string) bool) []string {
+	var keys []string
+	for key := range w {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (w worldState) iterator(keys []string) *mocks.StateQueryIterator {
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextStub = func() bool {
+		return len(keys) > 0
+	}
+	iterator.NextStub = func() (*queryresult.KV, error) {
+		key := keys[0]
+		keys = keys[1:]
+		return &queryresult.KV{Key: key, Value: w[key]}, nil
+	}
+
+	return iterator
+}
+
+// indexEntries returns the attributes of every entry of a composite key index,
+// joined with slashes, e.g. "blue/person1/car1".
+func (w worldState) indexEntries(t *testing.T, objectType string) []string {
+	prefix, err := shim.CreateCompositeKey(objectType, []string{})
+	require.NoError(t, err)
+
+	var entries []string
+	for _, key := range w.keys(func(key string) bool { return strings.HasPrefix(key, prefix) }) {
+		_, attributes, err := (&shim.ChaincodeStub{}).SplitCompositeKey(key)
+		require.NoError(t, err)
+		entries = append(entries, strings.Join(attributes, "/"))
+	}
+
+	return entries
+}
+
+// lastEvent returns the name and payload of the last event set by the chaincode.
+func lastEvent(t *testing.T, chaincodeStub *mocks.ChaincodeStub) (string, []byte) {
+	require.NotZero(t, chaincodeStub.SetEventCallCount(), "no event was set")
+	return chaincodeStub.SetEventArgsForCall(chaincodeStub.SetEventCallCount() - 1)
+}
+
+// setSaltSeed passes testSalt in the transient data of the following transactions, as
+// InitLedger and the migrations of persons need it.
+func setSaltSeed(chaincodeStub *mocks.ChaincodeStub) {
+	chaincodeStub.GetTransientReturns(map[string][]byte{transientSaltKey: []byte(testSalt)}, nil)
+}
+
+// setPersonInput passes the private details of the person to create or update in the
+// transient data of the following transactions.
+func setPersonInput(t *testing.T, chaincodeStub *mocks.ChaincodeStub, emailAddress string, amountOfMoneyOwned string) {
+	inputJSON, err := json.Marshal(personInput{EmailAddress: emailAddress, AmountOfMoneyOwned: amountOfMoneyOwned, Salt: testSalt})
+	require.NoError(t, err)
+	chaincodeStub.GetTransientReturns(map[string][]byte{transientPersonKey: inputJSON}, nil)
+}
+
+// personBalance reads the amount of money owned by a person from the collection of
+// their organization.
+func personBalance(t *testing.T, transactionContext contractapi.TransactionContextInterface, id string) Money {
+	personAsset, err := (&SmartContract{}).ReadPersonAsset(transactionContext, id)
+	require.NoError(t, err)
+
+	details, err := getPersonDetails(transactionContext, personAsset)
+	require.NoError(t, err)
+
+	return details.AmountOfMoneyOwned
+}
+
+func TestInitLedger(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepMocks(org1MSP, registryClientID)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.InitLedger(transactionContext)
+	require.EqualError(t, err, `a random salt must be passed in the "salt" transient data`)
+
+	chaincodeStub.GetTransientReturns(map[string][]byte{transientSaltKey: []byte("1234")}, nil)
+	err = carsAndPersons.InitLedger(transactionContext)
+	require.EqualError(t, err, "the salt must have at least 32 characters, such as 16 random bytes in hex")
+
+	setSaltSeed(chaincodeStub)
+	err = carsAndPersons.InitLedger(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, state.indexEntries(t, colorOwnerIndex), 6)
+	require.Len(t, state.indexEntries(t, carKeyIndex), 6)
+	require.Len(t, state.indexEntries(t, personKeyIndex), 3)
+	require.Contains(t, state.indexEntries(t, colorOwnerIndex), "blue/person1/car1")
+	require.Len(t, state.indexEntries(t, ownerIndex), 6)
+	require.Contains(t, state.indexEntries(t, ownerIndex), "person1/car1")
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventLedgerInitialized, eventName)
+	var event LedgerInitializedEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, []string{"car1", "car2", "car3", "car4", "car5", "car6"}, event.CarIDs)
+	require.Equal(t, []string{"person1", "person2", "person3"}, event.PersonIDs)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, carStatusActive, carAsset.Status)
+	require.Equal(t, "m2", carAsset.MalfunctionList[1].ID)
+	require.Equal(t, testTime, carAsset.MalfunctionList[1].ReportedAt)
+
+	// Every person gets a salt of their own, derived from the one passed in.
+	for _, id := range []string{"person1", "person2"} {
+		personAsset, err := carsAndPersons.ReadPersonAsset(transactionContext, id)
+		require.NoError(t, err)
+		details, err := getPersonDetails(transactionContext, personAsset)
+		require.NoError(t, err)
+		require.Equal(t, personSalt(testSalt, id), details.Salt)
+	}
+	require.NotEqual(t, personSalt(testSalt, "person1"), personSalt(testSalt, "person2"))
+
+	chaincodeStub.PutStateReturns(fmt.Errorf("failed inserting key"))
+	err = carsAndPersons.InitLedger(transactionContext)
+	require.EqualError(t, err, "failed to put car car1 to world state: failed inserting key")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	err = carsAndPersons.InitLedger(transactionContext)
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestReadPersonAsset(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	personAsset, err := carsAndPersons.ReadPersonAsset(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, "Petar", personAsset.FirstName)
+	require.Equal(t, newMoney(5400_54), personBalance(t, transactionContext, "person1"))
+	require.Equal(t, person1ClientID, personAsset.ClientID)
+
+	_, err = carsAndPersons.ReadPersonAsset(transactionContext, "person9")
+	require.EqualError(t, err, "the person asset person9 does not exist")
+
+	carAssetJSON, err := json.Marshal(CarAsset{ObjectType: carObjectType, ID: "person1"})
+	require.NoError(t, err)
+	chaincodeStub.GetStateReturns(carAssetJSON, nil)
+	_, err = carsAndPersons.ReadPersonAsset(transactionContext, "person1")
+	require.EqualError(t, err, `the asset person1 is a "car", not a "person"`)
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve person"))
+	_, err = carsAndPersons.ReadPersonAsset(transactionContext, "person1")
+	require.EqualError(t, err, "failed to read person from world state: unable to retrieve person")
+}
+
+func TestReadCarAsset(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car2")
+	require.NoError(t, err)
+	require.Equal(t, "Audi", carAsset.Brand)
+	require.Equal(t, "person2", carAsset.OwnerID)
+	require.Equal(t, newMoney(5000_00), carAsset.Price)
+
+	_, err = carsAndPersons.ReadCarAsset(transactionContext, "car9")
+	require.EqualError(t, err, "the car asset car9 does not exist")
+
+	// Cars written before malfunctions were structured and the status was introduced.
+	legacyCarJSON := []byte(`{"objectType":"car","ID":"car7","Brand":"Fiat","Model":"Punto","Year":2005,"Color":"grey","OwnerID":"person1",` +
+		`"Price":{"Amount":100000,"Currency":"EUR"},"MalfunctionList":[{"Description":"Worn brakes","RepairPrice":{"Amount":12000,"Currency":"EUR"}}]}`)
+	chaincodeStub.GetStateReturns(legacyCarJSON, nil)
+	carAsset, err = carsAndPersons.ReadCarAsset(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, carStatusActive, carAsset.Status)
+	require.Equal(t, "m1", carAsset.MalfunctionList[0].ID)
+	require.Equal(t, malfunctionStatusOpen, carAsset.MalfunctionList[0].Status)
+	require.NotNil(t, carAsset.RepairList)
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve car"))
+	_, err = carsAndPersons.ReadCarAsset(transactionContext, "car1")
+	require.EqualError(t, err, "failed to read car from world state: unable to retrieve car")
+}
+
+func TestCreatePersonAsset(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setPersonInput(t, chaincodeStub, "ana@pdasp.rs", "100.50")
+	err := carsAndPersons.CreatePersonAsset(transactionContext, "person4", "Ana", "Anic")
+	require.NoError(t, err)
+	require.Equal(t, newMoney(100_50), personBalance(t, transactionContext, "person4"))
+
+	key, err := personKey(transactionContext, "person4")
+	require.NoError(t, err)
+	personAssetJSON, err := chaincodeStub.GetState(key)
+	require.NoError(t, err)
+	require.NotContains(t, string(personAssetJSON), "ana@pdasp.rs", "the private details must not be in the world state")
+
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person1", "Ana", "Anic")
+	require.EqualError(t, err, "the person asset person1 already exists")
+
+	setPersonInput(t, chaincodeStub, "not an email", "100")
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person5", "Ana", "Anic")
+	require.EqualError(t, err, "the email address not an email is not valid")
+
+	setPersonInput(t, chaincodeStub, "ana@pdasp.rs", "-1")
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person5", "Ana", "Anic")
+	require.EqualError(t, err, "the amount of money owned must not be negative")
+
+	setPersonInput(t, chaincodeStub, "ana@pdasp.rs", "1.005")
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person5", "Ana", "Anic")
+	require.Error(t, err)
+
+	chaincodeStub.GetTransientReturns(map[string][]byte{transientPersonKey: []byte(`{"emailAddress":"ana@pdasp.rs","amountOfMoneyOwned":"100"}`)}, nil)
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person5", "Ana", "Anic")
+	require.EqualError(t, err, "the salt must have at least 32 characters, such as 16 random bytes in hex")
+
+	chaincodeStub.GetTransientReturns(nil, nil)
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person5", "Ana", "Anic")
+	require.EqualError(t, err, `the email address, the amount of money owned and a random salt must be passed in the "person" transient data`)
+
+	setPersonInput(t, chaincodeStub, "ana@pdasp.rs", "100")
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person5", "Ana", "Anic")
+	require.NoError(t, err, "any Org1 client acts for the registry")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	err = carsAndPersons.CreatePersonAsset(transactionContext, "person6", "Ana", "Anic")
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestUpdatePersonAsset(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setPersonInput(t, chaincodeStub, "petar@pdasp.rs", "10.00 EUR")
+	err := carsAndPersons.UpdatePersonAsset(transactionContext, "person1", "Petar", "Petrovic")
+	require.NoError(t, err)
+	personAsset, err := carsAndPersons.ReadPersonAsset(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, "Petrovic", personAsset.LastName)
+	require.Equal(t, newMoney(10_00), personBalance(t, transactionContext, "person1"))
+	require.Equal(t, person1ClientID, personAsset.ClientID, "the linked identity must be kept")
+
+	err = carsAndPersons.UpdatePersonAsset(transactionContext, "person9", "Petar", "Petrovic")
+	require.EqualError(t, err, "the person asset person9 does not exist")
+
+	err = carsAndPersons.UpdatePersonAsset(transactionContext, "person1", "", "Petrovic")
+	require.EqualError(t, err, "the person first name must not be empty")
+
+	setPersonInput(t, chaincodeStub, "petar@pdasp.rs", "10 USD")
+	err = carsAndPersons.UpdatePersonAsset(transactionContext, "person1", "Petar", "Petrovic")
+	require.EqualError(t, err, "the currency USD is not supported")
+
+	setPersonInput(t, chaincodeStub, "jovana@pdasp.rs", "1000000")
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.UpdatePersonAsset(transactionContext, "person3", "Jovana", "Jovanovic")
+	require.EqualError(t, err, "client from Org3MSP is not authorized to perform registry operations")
+}
+
+func TestDeletePersonAsset(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.DeletePersonAsset(transactionContext, "person3")
+	require.EqualError(t, err, "the person person3 still owns cars and cannot be deleted")
+
+	// A written-off car still belongs to its owner.
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+	setClient(transactionContext, org1MSP, registryClientID)
+	for _, carID := range []string{"car1", "car3"} {
+		err = carsAndPersons.DeleteCarAsset(transactionContext, carID)
+		require.NoError(t, err)
+	}
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person1")
+	require.EqualError(t, err, "the person person1 still owns cars and cannot be deleted")
+
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "car5")
+	require.NoError(t, err)
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person3")
+	require.NoError(t, err)
+	exists, err := carsAndPersons.PersonAssetExists(transactionContext, "person3")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person3")
+	require.EqualError(t, err, "the person asset person3 does not exist")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person2")
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestCreateCarAsset(t *testing.T) {
+	transactionContext, _, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.CreateCarAsset(transactionContext, "car7", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800105123456", "BG-555-ZZ", "2005-07-01", 120000)
+	require.NoError(t, err)
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, carStatusActive, carAsset.Status)
+	require.Empty(t, carAsset.MalfunctionList)
+	require.Contains(t, state.indexEntries(t, colorOwnerIndex), "grey/person3/car7")
+	require.Contains(t, state.indexEntries(t, ownerIndex), "person3/car7")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car1", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "the car asset car1 already exists")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person9", "1000", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "the person person9 does not exist")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 1800, "grey", "person3", "1000", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "the car year 1800 is not valid")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, " ", "person3", "1000", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "the car color must not be empty")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "-1000", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "the car price must not be negative")
+	require.NotContains(t, state.indexEntries(t, colorOwnerIndex), "grey/person3/car8", "rejected cars must not be indexed")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "client from Org3MSP is not authorized to perform registry operations")
+}
+
+func TestUpdateCarAsset(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err := carsAndPersons.UpdateCarAsset(transactionContext, "car1", "Opel", "Astra", 2013, "blue", "2600")
+	require.NoError(t, err)
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Equal(t, "Astra", carAsset.Model)
+	require.Equal(t, newMoney(2600_00), carAsset.Price)
+	require.Len(t, carAsset.MalfunctionList, 2, "malfunctions are not touched by updates")
+
+	eventsBefore := chaincodeStub.SetEventCallCount()
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car1", "Opel", "Astra", 2013, "silver", "2600")
+	require.NoError(t, err)
+	entries := state.indexEntries(t, colorOwnerIndex)
+	require.Contains(t, entries, "silver/person1/car1")
+	require.NotContains(t, entries, "blue/person1/car1")
+	require.Equal(t, eventsBefore+1, chaincodeStub.SetEventCallCount())
+	eventName, _ := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarRecolored, eventName)
+
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car1", "Opel", "", 2013, "silver", "2600")
+	require.EqualError(t, err, "the car model must not be empty")
+
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car1", "Opel", "Astra", 2014, "silver", "2600")
+	require.EqualError(t, err, "the first registration date 2013-05-14 is before the car year 2014")
+
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car9", "Opel", "Astra", 2013, "silver", "2600")
+	require.EqualError(t, err, "the car asset car9 does not exist")
+
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car2", "Audi", "A4", 2016, "red", "1")
+	require.EqualError(t, err, "only the owner can modify the car car2: client is not authorized to act on behalf of person2")
+}
+
+func TestDeleteCarAsset(t *testing.T) {
+	transactionContext, _, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.DeleteCarAsset(transactionContext, "car1")
+	require.NoError(t, err)
+	exists, err := carsAndPersons.CarAssetExists(transactionContext, "car1")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NotContains(t, state.indexEntries(t, colorOwnerIndex), "blue/person1/car1")
+	require.NotContains(t, state.indexEntries(t, ownerIndex), "person1/car1")
+
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "car1")
+	require.EqualError(t, err, "the car asset car1 does not exist")
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+	require.Equal(t, []string{"person1/car4"}, state.indexEntries(t, writtenOffIndex))
+
+	setClient(transactionContext, org1MSP, registryClientID)
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "car4")
+	require.NoError(t, err)
+	require.Empty(t, state.indexEntries(t, writtenOffIndex))
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "car2")
+	require.EqualError(t, err, "client from Org2MSP is not authorized to perform registry operations")
+}
+
+func TestGetCarsByColor(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	carAssets, err := carsAndPersons.GetCarsByColor(transactionContext, "blue")
+	require.NoError(t, err)
+	require.Len(t, carAssets, 1)
+	require.Equal(t, "car1", carAssets[0].ID)
+
+	carAssets, err = carsAndPersons.GetCarsByColor(transactionContext, "purple")
+	require.NoError(t, err)
+	require.Empty(t, carAssets)
+
+	chaincodeStub.GetStateByPartialCompositeKeyReturns(nil, fmt.Errorf("failed retrieving cars"))
+	_, err = carsAndPersons.GetCarsByColor(transactionContext, "blue")
+	require.EqualError(t, err, "failed retrieving cars")
+}
+
+func TestGetCarsByColorAndOwner(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.ChangeCarColor(transactionContext, "car3", "blue")
+	require.NoError(t, err)
+
+	carAssets, err := carsAndPersons.GetCarsByColorAndOwner(transactionContext, "blue", "person1")
+	require.NoError(t, err)
+	require.Len(t, carAssets, 2)
+	require.Equal(t, "car1", carAssets[0].ID)
+	require.Equal(t, "car3", carAssets[1].ID)
+
+	carAssets, err = carsAndPersons.GetCarsByColorAndOwner(transactionContext, "blue", "person2")
+	require.NoError(t, err)
+	require.Empty(t, carAssets)
+
+	_, err = carsAndPersons.GetCarsByColorAndOwner(transactionContext, "blue", "person9")
+	require.EqualError(t, err, "the person person9 does not exist")
+}
+
+func TestGetCarsByColorWithPagination(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.ChangeCarColor(transactionContext, "car3", "blue")
+	require.NoError(t, err)
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car4", "blue")
+	require.NoError(t, err)
+
+	page, err := carsAndPersons.GetCarsByColorWithPagination(transactionContext, "blue", 2, "")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), page.FetchedRecordsCount)
+	require.Equal(t, "car1", page.Records[0].ID)
+	require.NotEmpty(t, page.Bookmark)
+
+	page, err = carsAndPersons.GetCarsByColorWithPagination(transactionContext, "blue", 2, page.Bookmark)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "car4", page.Records[0].ID)
+	require.Empty(t, page.Bookmark)
+
+	_, err = carsAndPersons.GetCarsByColorWithPagination(transactionContext, "blue", 0, "")
+	require.EqualError(t, err, "the page size must be positive")
+}
+
+func TestGetCarsByColorAndOwnerWithPagination(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	page, err := carsAndPersons.GetCarsByColorAndOwnerWithPagination(transactionContext, "red", "person2", 5, "")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.FetchedRecordsCount)
+	require.Equal(t, "car2", page.Records[0].ID)
+
+	page, err = carsAndPersons.GetCarsByColorAndOwnerWithPagination(transactionContext, "red", "person1", 5, "")
+	require.NoError(t, err)
+	require.Empty(t, page.Records)
+
+	_, err = carsAndPersons.GetCarsByColorAndOwnerWithPagination(transactionContext, "red", "person9", 5, "")
+	require.EqualError(t, err, "the person person9 does not exist")
+}
+
+func TestCarAndPersonLifecycle(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+	const person4ClientID = "x509::CN=ana,OU=client::CN=ca.org1.example.com"
+
+	carIDsByColor := func(color string) []string {
+		carAssets, err := carsAndPersons.GetCarsByColor(transactionContext, color)
+		require.NoError(t, err)
+
+		carIDs := []string{}
+		for _, carAsset := range carAssets {
+			carIDs = append(carIDs, carAsset.ID)
+		}
+		return carIDs
+	}
+
+	setPersonInput(t, chaincodeStub, "ana@pdasp.rs", "100")
+	err := carsAndPersons.CreatePersonAsset(transactionContext, "person4", "Ana", "Anic")
+	require.NoError(t, err)
+	err = carsAndPersons.LinkPersonIdentity(transactionContext, "person4", person4ClientID, org1MSP)
+	require.NoError(t, err)
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car7", "Fiat", "Punto", 2005, "grey", "person4", "1000", "ZFA18800105123456", "", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"car7"}, carIDsByColor("grey"))
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car7", "Fiat", "Panda", 2011, "orange", "person4", "800", "ZFA18800305654321", "", "", 0)
+	require.EqualError(t, err, "the car asset car7 already exists")
+	require.Empty(t, carIDsByColor("orange"), "the rejected duplicate must not be indexed")
+
+	setClient(transactionContext, org1MSP, person4ClientID)
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car7", "Fiat", "Punto", 2005, "silver", "900")
+	require.NoError(t, err)
+	require.Empty(t, carIDsByColor("grey"))
+	require.Equal(t, []string{"car7"}, carIDsByColor("silver"))
+
+	setClient(transactionContext, org1MSP, registryClientID)
+
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person4")
+	require.EqualError(t, err, "the person person4 still owns cars and cannot be deleted")
+
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Empty(t, carIDsByColor("silver"))
+
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person4")
+	require.NoError(t, err)
+	exists, err := carsAndPersons.PersonAssetExists(transactionContext, "person4")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestCarAndPersonWithTheSameID(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.CreateCarAsset(transactionContext, "person1", "Fiat", "Punto", 2005, "grey", "person2", "1000", "ZFA18800105123456", "", "", 0)
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, carObjectType, carAsset.ObjectType)
+	require.Equal(t, "person2", carAsset.OwnerID)
+
+	personAsset, err := carsAndPersons.ReadPersonAsset(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Equal(t, personObjectType, personAsset.ObjectType)
+	require.Equal(t, "Petar", personAsset.FirstName)
+
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "person1")
+	require.NoError(t, err)
+
+	exists, err := carsAndPersons.PersonAssetExists(transactionContext, "person1")
+	require.NoError(t, err)
+	require.True(t, exists, "deleting the car must leave the person alone")
+	_, err = carsAndPersons.ReadCarAsset(transactionContext, "person1")
+	require.EqualError(t, err, "the car asset person1 does not exist")
+}
+
+func TestAddCarMalfunction(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err := carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Broken mirror", "20.00", severityMinor)
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, []CarMalfunction{{
+		ID:          "m1",
+		Description: "Broken mirror",
+		RepairPrice: newMoney(20_00),
+		Severity:    severityMinor,
+		Status:      malfunctionStatusOpen,
+		ReportedAt:  testTime,
+		ReportedBy:  person3ClientID,
+	}}, carAsset.MalfunctionList)
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventMalfunctionReported, eventName)
+	var event MalfunctionReportedEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, "m1", event.Malfunction.ID)
+	require.Equal(t, newMoney(20_00), event.OpenRepairPrice)
+
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Broken mirror", "20.00", "cosmetic")
+	require.EqualError(t, err, `the severity "cosmetic" is not one of minor, major or critical`)
+
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Broken mirror", "-20.00", severityMinor)
+	require.EqualError(t, err, "the repair price must not be negative")
+
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car1", "Broken mirror", "20.00", severityMinor)
+	require.EqualError(t, err, "only the owner can modify the car car1: client is not authorized to act on behalf of person1")
+
+	// The repairs of car4 already cost 170.00 EUR out of its 200.00 EUR price.
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "30.01", severityMajor)
+	require.NoError(t, err)
+
+	carAsset, err = carsAndPersons.ReadCarAsset(transactionContext, "car4")
+	require.NoError(t, err, "written-off cars are kept on the ledger")
+	require.Equal(t, carStatusWrittenOff, carAsset.Status)
+	require.Equal(t, newMoney(200_01), carAsset.WriteOff.RepairPrice)
+	require.NotContains(t, state.indexEntries(t, colorOwnerIndex), "yellow/person1/car4")
+	require.NotContains(t, state.indexEntries(t, ownerIndex), "person1/car4")
+	require.Contains(t, state.indexEntries(t, writtenOffIndex), "person1/car4")
+
+	eventName, _ = lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarWrittenOff, eventName)
+
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Flat tyre", "10", severityMinor)
+	require.EqualError(t, err, "the car car4 has been written off")
+}
+
+func TestChangeCarColor(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	oldColor, err := carsAndPersons.ChangeCarColor(transactionContext, "car1", "black")
+	require.NoError(t, err)
+	require.Equal(t, "blue", oldColor)
+
+	entries := state.indexEntries(t, colorOwnerIndex)
+	require.Contains(t, entries, "black/person1/car1")
+	require.NotContains(t, entries, "blue/person1/car1")
+	require.Len(t, entries, 6)
+
+	blackCars, err := carsAndPersons.GetCarsByColor(transactionContext, "black")
+	require.NoError(t, err)
+	require.Len(t, blackCars, 2)
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarRecolored, eventName)
+	var event CarRecoloredEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, CarRecoloredEvent{CarID: "car1", OwnerID: "person1", OldColor: "blue", NewColor: "black"}, event)
+
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car1", "black")
+	require.EqualError(t, err, "the car car1 is already black")
+	require.Contains(t, state.indexEntries(t, colorOwnerIndex), "black/person1/car1")
+
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car2", "black")
+	require.EqualError(t, err, "only the owner can modify the car car2: client is not authorized to act on behalf of person2")
+
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car9", "black")
+	require.EqualError(t, err, "the car asset car9 does not exist")
+}
+
+func TestRepairCar(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err := carsAndPersons.RepairCar(transactionContext, "car1")
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car1")
+	require.NoError(t, err)
+	require.Len(t, carAsset.MalfunctionList, 2)
+	for _, carMalfunction := range carAsset.MalfunctionList {
+		require.Equal(t, malfunctionStatusRepaired, carMalfunction.Status)
+	}
+	require.Len(t, carAsset.RepairList, 2)
+	require.Equal(t, CarRepair{MalfunctionID: "m1", Price: newMoney(50_00), PaidBy: "person1", RepairedAt: testTime}, carAsset.RepairList[0])
+
+	require.Equal(t, newMoney(5275_54), personBalance(t, transactionContext, "person1"))
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarRepaired, eventName)
+	var event CarRepairedEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Len(t, event.Repairs, 2)
+
+	eventsBefore := chaincodeStub.SetEventCallCount()
+	putsBefore := chaincodeStub.PutStateCallCount()
+	err = carsAndPersons.RepairCar(transactionContext, "car1")
+	require.EqualError(t, err, "the car car1 has no open malfunctions")
+	require.Equal(t, eventsBefore, chaincodeStub.SetEventCallCount())
+	require.Equal(t, putsBefore, chaincodeStub.PutStateCallCount())
+	require.Equal(t, newMoney(5275_54), personBalance(t, transactionContext, "person1"))
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car5", "Broken gearbox", "1500", severityCritical)
+	require.NoError(t, err)
+	err = carsAndPersons.RepairCar(transactionContext, "car5")
+	require.EqualError(t, err, "The owner of the car cannot afford to pay the car repair price")
+
+	err = carsAndPersons.RepairCar(transactionContext, "car1")
+	require.EqualError(t, err, "only the owner can modify the car car1: client is not authorized to act on behalf of person1")
+}
+
+func TestPersonAssetExists(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	exists, err := carsAndPersons.PersonAssetExists(transactionContext, "person1")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = carsAndPersons.PersonAssetExists(transactionContext, "car1")
+	require.NoError(t, err)
+	require.False(t, exists, "persons and cars do not share keys")
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve person"))
+	_, err = carsAndPersons.PersonAssetExists(transactionContext, "person1")
+	require.EqualError(t, err, "failed to read person asset from world state: unable to retrieve person")
+}
+
+func TestCarAssetExists(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	exists, err := carsAndPersons.CarAssetExists(transactionContext, "car1")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = carsAndPersons.CarAssetExists(transactionContext, "person1")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve car"))
+	_, err = carsAndPersons.CarAssetExists(transactionContext, "car1")
+	require.EqualError(t, err, "failed to read car asset from world state: unable to retrieve car")
+}
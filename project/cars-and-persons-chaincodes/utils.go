@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// txTimestamp returns the transaction timestamp set by the client, which is the
+// same on every endorsing peer, unlike the peer's local clock.
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return ptypes.Timestamp(timestamp)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	vinIndex   = "vin~ID"
+	plateIndex = "plate~ID"
+
+	// firstRegistrationLayout is the layout of the first registration date of a car.
+	firstRegistrationLayout = "2006-01-02"
+
+	maxPlateLength = 12
+)
+
+// vinValues are the values of the characters of a VIN in the check digit calculation.
+// The letters I, O and Q are not allowed, so they cannot be confused with 1 and 0.
+var vinValues = map[rune]int{
+	'0': 0, '1': 1, '2': 2, '3': 3, '4': 4, '5': 5, '6': 6, '7': 7, '8': 8, '9': 9,
+	'A': 1, 'B': 2, 'C': 3, 'D': 4, 'E': 5, 'F': 6, 'G': 7, 'H': 8,
+	'J': 1, 'K': 2, 'L': 3, 'M': 4, 'N': 5, 'P': 7, 'R': 9,
+	'S': 2, 'T': 3, 'U': 4, 'V': 5, 'W': 6, 'X': 7, 'Y': 8, 'Z': 9,
+}
+
+// vinWeights are the weights of the positions of a VIN. The check digit itself, the 9th
+// character, has no weight.
+var vinWeights = [17]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// GetCarByVIN returns the car with the given vehicle identification number.
+func (s *SmartContract) GetCarByVIN(ctx contractapi.TransactionContextInterface, vin string) (*CarAsset, error) {
+	vin = normalizeVIN(vin)
+
+	carID, err := lookupCarIdentity(ctx, vinIndex, vin)
+	if err != nil {
+		return nil, err
+	}
+	if carID == "" {
+		return nil, fmt.Errorf("no car has the VIN %s", vin)
+	}
+
+	return s.ReadCarAsset(ctx, carID)
+}
+
+// GetCarByPlate returns the car with the given registration plate. Spaces and hyphens
+// are ignored, so "BG 123-AB" finds the car registered as "BG-123-AB".
+func (s *SmartContract) GetCarByPlate(ctx contractapi.TransactionContextInterface, plate string) (*CarAsset, error) {
+	carID, err := lookupCarIdentity(ctx, plateIndex, plateIndexValue(plate))
+	if err != nil {
+		return nil, err
+	}
+	if carID == "" {
+		return nil, fmt.Errorf("no car has the registration plate %s", plate)
+	}
+
+	return s.ReadCarAsset(ctx, carID)
+}
+
+// SetCarIdentity sets the VIN, the registration plate and the first registration date
+// of a car, e.g. of a car stored by an earlier chaincode version without them, or of a
+// car that was registered again. Only the registry organization can submit it. The VIN
+// is required, while an empty plate or date clears it. The vin~ID and plate~ID entries
+// of the old values are replaced by the new ones.
+func (s *SmartContract) SetCarIdentity(ctx contractapi.TransactionContextInterface, id string, vin string, registrationPlate string, firstRegistration string) error {
+	err := requireRegistry(ctx)
+	if err != nil {
+		return err
+	}
+
+	carAsset, err := s.ReadCarAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	oldVIN := carAsset.VIN
+	oldPlate := carAsset.RegistrationPlate
+	updatedCar := *carAsset
+	updatedCar.VIN = vin
+	updatedCar.RegistrationPlate = registrationPlate
+	updatedCar.FirstRegistration = firstRegistration
+	normalizeCarIdentity(&updatedCar)
+
+	err = validateNewCarIdentity(&updatedCar)
+	if err != nil {
+		return err
+	}
+
+	err = checkCarIdentityUnique(ctx, &updatedCar)
+	if err != nil {
+		return err
+	}
+
+	err = deleteCarIdentityIndexes(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = putCarAsset(ctx, &updatedCar)
+	if err != nil {
+		return err
+	}
+
+	err = putCarIdentityIndexes(ctx, &updatedCar)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventCarIdentitySet, CarIdentitySetEvent{
+		CarID:                id,
+		OldVIN:               oldVIN,
+		VIN:                  updatedCar.VIN,
+		OldRegistrationPlate: oldPlate,
+		RegistrationPlate:    updatedCar.RegistrationPlate,
+	})
+}
+
+// UpdateOdometer records a new odometer reading of a car, in kilometers. Readings can
+// only go up, so a reading below the current one is rejected as a rollback.
+func (s *SmartContract) UpdateOdometer(ctx contractapi.TransactionContextInterface, id string, reading int) error {
+	carAsset, err := s.ReadCarAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = requireActiveCar(carAsset)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.requireCarOwner(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	if reading < carAsset.Odometer {
+		return fmt.Errorf("the odometer reading %d of car %s is below the current reading %d", reading, id, carAsset.Odometer)
+	}
+
+	oldReading := carAsset.Odometer
+	carAsset.Odometer = reading
+
+	err = putCarAsset(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventOdometerUpdated, OdometerUpdatedEvent{
+		CarID:      id,
+		OwnerID:    carAsset.OwnerID,
+		OldReading: oldReading,
+		NewReading: reading,
+	})
+}
+
+// normalizeCarIdentity brings the VIN and the registration plate of a new car into the
+// form they are stored in.
+func normalizeCarIdentity(carAsset *CarAsset) {
+	carAsset.VIN = normalizeVIN(carAsset.VIN)
+	carAsset.RegistrationPlate = strings.ToUpper(strings.TrimSpace(carAsset.RegistrationPlate))
+}
+
+func normalizeVIN(vin string) string {
+	return strings.ToUpper(strings.TrimSpace(vin))
+}
+
+// plateIndexValue is the form of a registration plate in the plate~ID index, without
+// the spaces and hyphens that only separate its parts.
+func plateIndexValue(plate string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, plate)
+}
+
+// validateNewCarIdentity checks the identity fields of a car that is being created.
+// Cars stored by earlier chaincode versions have no VIN, but every new car needs one.
+func validateNewCarIdentity(carAsset *CarAsset) error {
+	if carAsset.VIN == "" {
+		return fmt.Errorf("the car VIN must not be empty")
+	}
+
+	return validateCarIdentity(carAsset)
+}
+
+func validateCarIdentity(carAsset *CarAsset) error {
+	if carAsset.VIN != "" {
+		err := validateVIN(carAsset.VIN)
+		if err != nil {
+			return err
+		}
+	}
+
+	if carAsset.RegistrationPlate != "" {
+		plate := plateIndexValue(carAsset.RegistrationPlate)
+		if len([]rune(plate)) < 2 || len([]rune(plate)) > maxPlateLength {
+			return fmt.Errorf("the registration plate %s must have between 2 and %d letters and digits", carAsset.RegistrationPlate, maxPlateLength)
+		}
+		for _, r := range plate {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return fmt.Errorf("the registration plate %s may only have letters, digits, spaces and hyphens", carAsset.RegistrationPlate)
+			}
+		}
+	}
+
+	if carAsset.FirstRegistration != "" {
+		firstRegistration, err := time.Parse(firstRegistrationLayout, carAsset.FirstRegistration)
+		if err != nil {
+			return fmt.Errorf("the first registration date %s is not a date such as 2018-04-11", carAsset.FirstRegistration)
+		}
+		// A car can be registered a while after it was built, but never before.
+		if firstRegistration.Year() < carAsset.Year {
+			return fmt.Errorf("the first registration date %s is before the car year %d", carAsset.FirstRegistration, carAsset.Year)
+		}
+	}
+
+	if carAsset.Odometer < 0 {
+		return fmt.Errorf("the odometer reading must not be negative")
+	}
+
+	return nil
+}
+
+// validateVIN checks the form of a vehicle identification number as defined by ISO
+// 3779 and its check digit, the 9th character, which is the weighted sum of the other
+// characters modulo 11, with X standing for 10.
+func validateVIN(vin string) error {
+	if len(vin) != 17 {
+		return fmt.Errorf("the VIN %s must have 17 characters", vin)
+	}
+
+	sum := 0
+	for i, r := range vin {
+		value, ok := vinValues[r]
+		if !ok {
+			return fmt.Errorf("the VIN %s has the invalid character %q", vin, r)
+		}
+		sum += value * vinWeights[i]
+	}
+
+	checkDigit := "X"
+	if sum%11 < 10 {
+		checkDigit = fmt.Sprint(sum % 11)
+	}
+	if vin[8:9] != checkDigit {
+		return fmt.Errorf("the check digit of the VIN %s is not valid, expected %s", vin, checkDigit)
+	}
+
+	return nil
+}
+
+// checkCarIdentityUnique makes sure that no other car has the VIN or the registration
+// plate of the car.
+func checkCarIdentityUnique(ctx contractapi.TransactionContextInterface, carAsset *CarAsset) error {
+	if carAsset.VIN != "" {
+		carID, err := lookupCarIdentity(ctx, vinIndex, carAsset.VIN)
+		if err != nil {
+			return err
+		}
+		if carID != "" && carID != carAsset.ID {
+			return fmt.Errorf("the VIN %s is already registered to car %s", carAsset.VIN, carID)
+		}
+	}
+
+	if carAsset.RegistrationPlate != "" {
+		carID, err := lookupCarIdentity(ctx, plateIndex, plateIndexValue(carAsset.RegistrationPlate))
+		if err != nil {
+			return err
+		}
+		if carID != "" && carID != carAsset.ID {
+			return fmt.Errorf("the registration plate %s is already registered to car %s", carAsset.RegistrationPlate, carID)
+		}
+	}
+
+	return nil
+}
+
+// carIdentityKeys returns the vin~ID and plate~ID index keys of a car, for the
+// identity fields it has.
+func carIdentityKeys(ctx contractapi.TransactionContextInterface, carAsset *CarAsset) ([]string, error) {
+	var keys []string
+
+	if carAsset.VIN != "" {
+		key, err := ctx.GetStub().CreateCompositeKey(vinIndex, []string{carAsset.VIN, carAsset.ID})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if carAsset.RegistrationPlate != "" {
+		key, err := ctx.GetStub().CreateCompositeKey(plateIndex, []string{plateIndexValue(carAsset.RegistrationPlate), carAsset.ID})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func putCarIdentityIndexes(ctx contractapi.TransactionContextInterface, carAsset *CarAsset) error {
+	keys, err := carIdentityKeys(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	value := []byte{0x00}
+	for _, key := range keys {
+		err = ctx.GetStub().PutState(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteCarIdentityIndexes(ctx contractapi.TransactionContextInterface, carAsset *CarAsset) error {
+	keys, err := carIdentityKeys(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookupCarIdentity returns the ID of the car with the given value in the vin~ID or
+// plate~ID index, or an empty string if there is none.
+func lookupCarIdentity(ctx contractapi.TransactionContextInterface, index string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{value})
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	if !resultsIterator.HasNext() {
+		return "", nil
+	}
+
+	queryResponse, err := resultsIterator.Next()
+	if err != nil {
+		return "", err
+	}
+
+	_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+	if err != nil {
+		return "", err
+	}
+
+	return compositeKeyParts[1], nil
+}
+
+// checkImportedCarIdentity catches a VIN or a registration plate that appears twice in
+// the same import, which the world state does not know of yet.
+func checkImportedCarIdentity(carAsset *CarAsset, importedIdentities map[string]string) error {
+	if carID, ok := importedIdentities[vinIndex+carAsset.VIN]; ok && carAsset.VIN != "" {
+		return fmt.Errorf("the VIN %s is already registered to car %s", carAsset.VIN, carID)
+	}
+
+	if carID, ok := importedIdentities[plateIndex+plateIndexValue(carAsset.RegistrationPlate)]; ok && carAsset.RegistrationPlate != "" {
+		return fmt.Errorf("the registration plate %s is already registered to car %s", carAsset.RegistrationPlate, carID)
+	}
+
+	return nil
+}
+
+func addImportedCarIdentity(carAsset *CarAsset, importedIdentities map[string]string) {
+	if carAsset.VIN != "" {
+		importedIdentities[vinIndex+carAsset.VIN] = carAsset.ID
+	}
+
+	if carAsset.RegistrationPlate != "" {
+		importedIdentities[plateIndex+plateIndexValue(carAsset.RegistrationPlate)] = carAsset.ID
+	}
+}
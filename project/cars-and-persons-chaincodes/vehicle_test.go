@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateVIN(t *testing.T) {
+	for _, vin := range []string{"1M8GDM9AXKP042788", "W0LPD7EC8D1012345", "ZFA18800105123456"} {
+		require.NoError(t, validateVIN(vin), vin)
+	}
+
+	require.EqualError(t, validateVIN("1M8GDM9AXKP04278"), "the VIN 1M8GDM9AXKP04278 must have 17 characters")
+	require.EqualError(t, validateVIN("1M8GDM9AXKP04278O"), `the VIN 1M8GDM9AXKP04278O has the invalid character 'O'`)
+	require.EqualError(t, validateVIN("1M8GDM9A1KP042788"), "the check digit of the VIN 1M8GDM9A1KP042788 is not valid, expected X")
+	require.EqualError(t, validateVIN("1M8GDM9AXKP042789"), "the check digit of the VIN 1M8GDM9AXKP042789 is not valid, expected 1")
+}
+
+func TestCreateCarAssetIdentity(t *testing.T) {
+	transactionContext, _, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	err := carsAndPersons.CreateCarAsset(transactionContext, "car7", "Fiat", "Punto", 2005, "grey", "person3", "1000", "zfa18800105123456", "bg 555-zz", "2005-07-01", 120000)
+	require.NoError(t, err)
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car7")
+	require.NoError(t, err)
+	require.Equal(t, "ZFA18800105123456", carAsset.VIN)
+	require.Equal(t, "BG 555-ZZ", carAsset.RegistrationPlate)
+	require.Equal(t, "2005-07-01", carAsset.FirstRegistration)
+	require.Equal(t, 120000, carAsset.Odometer)
+	require.Contains(t, state.indexEntries(t, vinIndex), "ZFA18800105123456/car7")
+	require.Contains(t, state.indexEntries(t, plateIndex), "BG555ZZ/car7")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "", "", "", 0)
+	require.EqualError(t, err, "the car VIN must not be empty")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800105123456", "", "", 0)
+	require.EqualError(t, err, "the VIN ZFA18800105123456 is already registered to car car7")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "BG-555-ZZ", "", 0)
+	require.EqualError(t, err, "the registration plate BG-555-ZZ is already registered to car car7", "plates differing in separators only are the same plate")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "BG/555", "", 0)
+	require.EqualError(t, err, "the registration plate BG/555 may only have letters, digits, spaces and hyphens")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "", "01.07.2005", 0)
+	require.EqualError(t, err, "the first registration date 01.07.2005 is not a date such as 2018-04-11")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "", "2004-12-31", 0)
+	require.EqualError(t, err, "the first registration date 2004-12-31 is before the car year 2005")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800305654321", "", "", -1)
+	require.EqualError(t, err, "the odometer reading must not be negative")
+
+	err = carsAndPersons.DeleteCarAsset(transactionContext, "car7")
+	require.NoError(t, err)
+	require.NotContains(t, state.indexEntries(t, vinIndex), "ZFA18800105123456/car7")
+	require.NotContains(t, state.indexEntries(t, plateIndex), "BG555ZZ/car7")
+
+	err = carsAndPersons.CreateCarAsset(transactionContext, "car8", "Fiat", "Punto", 2005, "grey", "person3", "1000", "ZFA18800105123456", "BG-555-ZZ", "", 0)
+	require.NoError(t, err, "the VIN and the plate of a deleted car are free again")
+}
+
+func TestGetCarByVINAndPlate(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	carAsset, err := carsAndPersons.GetCarByVIN(transactionContext, "wdd1760410j123456")
+	require.NoError(t, err)
+	require.Equal(t, "car5", carAsset.ID)
+
+	carAsset, err = carsAndPersons.GetCarByPlate(transactionContext, "ni 345 jk")
+	require.NoError(t, err)
+	require.Equal(t, "car5", carAsset.ID)
+
+	_, err = carsAndPersons.GetCarByVIN(transactionContext, "1M8GDM9AXKP042788")
+	require.EqualError(t, err, "no car has the VIN 1M8GDM9AXKP042788")
+
+	_, err = carsAndPersons.GetCarByPlate(transactionContext, "BG-000-AA")
+	require.EqualError(t, err, "no car has the registration plate BG-000-AA")
+}
+
+func TestSetCarIdentity(t *testing.T) {
+	transactionContext, chaincodeStub, state := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	state["car7"] = legacyCarJSON
+	_, err := carsAndPersons.MigrateToTypedKeys(transactionContext)
+	require.NoError(t, err)
+
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car7", "zfa18800105123456", "bg 555-zz", "2005-07-01")
+	require.NoError(t, err)
+	carAsset, err := carsAndPersons.GetCarByPlate(transactionContext, "BG-555-ZZ")
+	require.NoError(t, err)
+	require.Equal(t, "car7", carAsset.ID)
+	require.Equal(t, "ZFA18800105123456", carAsset.VIN)
+	require.Equal(t, "2005-07-01", carAsset.FirstRegistration)
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarIdentitySet, eventName)
+	var identitySet CarIdentitySetEvent
+	require.NoError(t, json.Unmarshal(payload, &identitySet))
+	require.Equal(t, CarIdentitySetEvent{CarID: "car7", VIN: "ZFA18800105123456", RegistrationPlate: "BG 555-ZZ"}, identitySet)
+
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car5", "WDD1760410J123456", "NI-999-ZZ", "2018-04-11")
+	require.NoError(t, err)
+	require.Contains(t, state.indexEntries(t, plateIndex), "NI999ZZ/car5")
+	require.NotContains(t, state.indexEntries(t, plateIndex), "NI345JK/car5", "the old plate is free again")
+
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car5", "WDD1760410J123456", "BG-555-ZZ", "2018-04-11")
+	require.EqualError(t, err, "the registration plate BG-555-ZZ is already registered to car car7")
+
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car5", "", "", "")
+	require.EqualError(t, err, "the car VIN must not be empty")
+
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car5", "WDD1760410J123456", "", "2017-12-01")
+	require.EqualError(t, err, "the first registration date 2017-12-01 is before the car year 2018")
+
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car9", "WDD1760410J123456", "", "")
+	require.EqualError(t, err, "the car asset car9 does not exist")
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err = carsAndPersons.SetCarIdentity(transactionContext, "car5", "WDD1760410J123456", "", "")
+	require.EqualError(t, err, "client from Org3MSP is not authorized to perform registry operations")
+}
+
+func TestUpdateOdometer(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org3MSP, person3ClientID)
+	err := carsAndPersons.UpdateOdometer(transactionContext, "car5", 36500)
+	require.NoError(t, err)
+
+	carAsset, err := carsAndPersons.ReadCarAsset(transactionContext, "car5")
+	require.NoError(t, err)
+	require.Equal(t, 36500, carAsset.Odometer)
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventOdometerUpdated, eventName)
+	var updated OdometerUpdatedEvent
+	require.NoError(t, json.Unmarshal(payload, &updated))
+	require.Equal(t, OdometerUpdatedEvent{CarID: "car5", OwnerID: "person3", OldReading: 35000, NewReading: 36500}, updated)
+
+	err = carsAndPersons.UpdateOdometer(transactionContext, "car5", 36000)
+	require.EqualError(t, err, "the odometer reading 36000 of car car5 is below the current reading 36500")
+
+	err = carsAndPersons.UpdateOdometer(transactionContext, "car5", 36500)
+	require.NoError(t, err, "the same reading is not a rollback")
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	err = carsAndPersons.UpdateOdometer(transactionContext, "car5", 40000)
+	require.Error(t, err, "only the owner can update the odometer")
+
+	err = carsAndPersons.UpdateOdometer(transactionContext, "car9", 40000)
+	require.EqualError(t, err, "the car asset car9 does not exist")
+}
+
+func TestBulkImportCarIdentities(t *testing.T) {
+	ledger := prepFakeLedger(t)
+	carsAndPersons := ledger.carsAndPersons
+	registry := ledger.as(org1MSP, registryClientID)
+
+	var result *BulkImportResult
+	ledger.submit(func() error {
+		var err error
+		result, err = carsAndPersons.BulkImport(registry, `[
+			{"type":"car","id":"car7","brand":"Fiat","model":"Punto","year":2010,"color":"red","ownerID":"person1","price":"1500","vin":"ZFA19900300A12345","registrationPlate":"BG-555-ZZ","odometer":98000},
+			{"type":"car","id":"car8","brand":"Fiat","model":"Panda","year":2011,"color":"red","ownerID":"person1","price":"1000","vin":"ZFA19900300A12345"},
+			{"type":"car","id":"car9","brand":"Fiat","model":"Panda","year":2011,"color":"red","ownerID":"person1","price":"1000","vin":"ZFA31200900B54321","registrationPlate":"BG555ZZ"},
+			{"type":"car","id":"car10","brand":"Opel","model":"Astra","year":2012,"color":"blue","ownerID":"person1","price":"2000","vin":"W0LPD7EC8D1012345"},
+			{"type":"car","id":"car11","brand":"Opel","model":"Astra","year":2012,"color":"blue","ownerID":"person1","price":"2000"}
+		]`, false)
+		return err
+	})
+
+	require.Equal(t, []string{"car7"}, result.CarIDs)
+	require.Equal(t, []ImportFailure{
+		{Index: 1, Type: "car", ID: "car8", Reason: "the VIN ZFA19900300A12345 is already registered to car car7"},
+		{Index: 2, Type: "car", ID: "car9", Reason: "the registration plate BG555ZZ is already registered to car car7"},
+		{Index: 3, Type: "car", ID: "car10", Reason: "the VIN W0LPD7EC8D1012345 is already registered to car car1"},
+		{Index: 4, Type: "car", ID: "car11", Reason: "the car VIN must not be empty"},
+	}, result.Failures)
+
+	carAsset, err := carsAndPersons.GetCarByPlate(registry, "BG-555-ZZ")
+	require.NoError(t, err)
+	require.Equal(t, "car7", carAsset.ID)
+	require.Equal(t, 98000, carAsset.Odometer)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	carStatusActive     = "active"
+	carStatusWrittenOff = "writtenOff"
+
+	writtenOffIndex = "owner~writtenOffID"
+)
+
+// CarWriteOff records why and when a car was written off, and the insurance payout
+// once an insurer has settled it.
+type CarWriteOff struct {
+	RepairPrice     Money
+	WrittenOffAt    time.Time
+	PayoutSettled   bool
+	Payout          Money
+	PayoutSettledAt time.Time
+	SettledBy       string
+}
+
+// GetWrittenOffCars returns the cars of the given owner that have been written off.
+func (s *SmartContract) GetWrittenOffCars(ctx contractapi.TransactionContextInterface, ownerID string) ([]*CarAsset, error) {
+	exists, err := s.PersonAssetExists(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("the person %v does not exist", ownerID)
+	}
+
+	writtenOffIter, err := ctx.GetStub().GetStateByPartialCompositeKey(writtenOffIndex, []string{ownerID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer writtenOffIter.Close()
+
+	return s.readIndexedCars(ctx, writtenOffIter)
+}
+
+// SettleWriteOffPayout is submitted by the insurer organization to record the payout it
+// made to the owner of a written-off car. The insurer has no balance on the ledger, so
+// the payout is settled outside of it and no balance changes here. The payout can be
+// settled only once and cannot exceed the car's price.
+func (s *SmartContract) SettleWriteOffPayout(ctx contractapi.TransactionContextInterface, carID string, payout string) (*CarAsset, error) {
+	err := requireInsurer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsset, err := s.ReadCarAsset(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	if carAsset.Status != carStatusWrittenOff {
+		return nil, fmt.Errorf("the car %s is not written off", carID)
+	}
+	if carAsset.WriteOff.PayoutSettled {
+		return nil, fmt.Errorf("the payout for the car %s has already been settled", carID)
+	}
+
+	payoutAmount, err := ParseMoney(payout)
+	if err != nil {
+		return nil, err
+	}
+	if payoutAmount.IsNegative() || payoutAmount.Amount == 0 {
+		return nil, fmt.Errorf("the payout must be positive")
+	}
+	cmp, err := payoutAmount.Cmp(carAsset.Price)
+	if err != nil {
+		return nil, err
+	}
+	if cmp > 0 {
+		return nil, fmt.Errorf("the payout must not exceed the price of the car")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	insurerID, _, err := getSubmittingClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsset.WriteOff.PayoutSettled = true
+	carAsset.WriteOff.Payout = payoutAmount
+	carAsset.WriteOff.PayoutSettledAt = now
+	carAsset.WriteOff.SettledBy = insurerID
+
+	err = putCarAsset(ctx, carAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventWriteOffPayoutSettled, WriteOffPayoutSettledEvent{
+		CarID:     carAsset.ID,
+		OwnerID:   carAsset.OwnerID,
+		Payout:    payoutAmount,
+		InsurerID: insurerID,
+		SettledAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return carAsset, nil
+}
+
+// writeOffCar marks a car as written off instead of deleting it, so that the owner
+// keeps a record of it. The car leaves the color~owner~ID and owner~ID indexes and can
+// only be found through GetWrittenOffCars from then on.
+func writeOffCar(ctx contractapi.TransactionContextInterface, carAsset *CarAsset, repairPrice Money, now time.Time) error {
+	carAsset.Status = carStatusWrittenOff
+	carAsset.WriteOff = &CarWriteOff{
+		RepairPrice:  repairPrice,
+		WrittenOffAt: now,
+	}
+
+	err := putCarAsset(ctx, carAsset)
+	if err != nil {
+		return err
+	}
+
+	err = deleteColorOwnerIndex(ctx, carAsset.Color, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	err = deleteOwnerIndex(ctx, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	err = putWrittenOffIndex(ctx, carAsset.OwnerID, carAsset.ID)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventCarWrittenOff, CarWrittenOffEvent{
+		CarID:        carAsset.ID,
+		OwnerID:      carAsset.OwnerID,
+		Price:        carAsset.Price,
+		RepairPrice:  repairPrice,
+		WrittenOffAt: now,
+	})
+}
+
+// requireActiveCar rejects changes to written-off cars.
+func requireActiveCar(carAsset *CarAsset) error {
+	if carAsset.Status == carStatusWrittenOff {
+		return fmt.Errorf("the car %s has been written off", carAsset.ID)
+	}
+
+	return nil
+}
+
+// normalizeCarStatus marks cars stored before the status was introduced as active.
+func normalizeCarStatus(carAsset *CarAsset) {
+	if carAsset.Status == "" {
+		carAsset.Status = carStatusActive
+	}
+}
+
+func putWrittenOffIndex(ctx contractapi.TransactionContextInterface, ownerID string, carID string) error {
+	writtenOffKey, err := ctx.GetStub().CreateCompositeKey(writtenOffIndex, []string{ownerID, carID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(writtenOffKey, []byte{0x00})
+}
+
+func deleteWrittenOffIndex(ctx contractapi.TransactionContextInterface, ownerID string, carID string) error {
+	writtenOffKey, err := ctx.GetStub().CreateCompositeKey(writtenOffIndex, []string{ownerID, carID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(writtenOffKey)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrittenOffCarsCannotBeChanged(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	_, err := carsAndPersons.CreateSaleOffer(transactionContext, "offer1", "car4", "", "200", 3600)
+	require.NoError(t, err)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+
+	writtenOff := "the car car4 has been written off"
+
+	err = carsAndPersons.UpdateCarAsset(transactionContext, "car4", "Zastava", "Yugo 45", 1985, "yellow", "200")
+	require.EqualError(t, err, writtenOff)
+	_, err = carsAndPersons.ChangeCarColor(transactionContext, "car4", "red")
+	require.EqualError(t, err, writtenOff)
+	err = carsAndPersons.RepairCar(transactionContext, "car4")
+	require.EqualError(t, err, writtenOff)
+	err = carsAndPersons.RepairMalfunction(transactionContext, "car4", "m1")
+	require.EqualError(t, err, writtenOff)
+	_, err = carsAndPersons.CreateSaleOffer(transactionContext, "offer2", "car4", "", "200", 3600)
+	require.EqualError(t, err, writtenOff)
+
+	setClient(transactionContext, org2MSP, person2ClientID)
+	_, err = carsAndPersons.AcceptSaleOffer(transactionContext, "offer1", "person2", true)
+	require.EqualError(t, err, writtenOff)
+}
+
+func TestGetWrittenOffCars(t *testing.T) {
+	transactionContext, _, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	carAssets, err := carsAndPersons.GetWrittenOffCars(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Empty(t, carAssets)
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err = carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+
+	carAssets, err = carsAndPersons.GetWrittenOffCars(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Len(t, carAssets, 1)
+	require.Equal(t, "car4", carAssets[0].ID)
+	require.Equal(t, testTime, carAssets[0].WriteOff.WrittenOffAt)
+
+	yellowCars, err := carsAndPersons.GetCarsByColor(transactionContext, "yellow")
+	require.NoError(t, err)
+	require.Empty(t, yellowCars)
+
+	carAssets, err = carsAndPersons.GetWrittenOffCars(transactionContext, "person2")
+	require.NoError(t, err)
+	require.Empty(t, carAssets)
+
+	_, err = carsAndPersons.GetWrittenOffCars(transactionContext, "person9")
+	require.EqualError(t, err, "the person person9 does not exist")
+
+	setClient(transactionContext, org1MSP, registryClientID)
+	err = carsAndPersons.DeletePersonAsset(transactionContext, "person1")
+	require.EqualError(t, err, "the person person1 still owns cars and cannot be deleted")
+}
+
+func TestSettleWriteOffPayout(t *testing.T) {
+	transactionContext, chaincodeStub, _ := prepLedger(t)
+	carsAndPersons := SmartContract{}
+
+	setClient(transactionContext, org1MSP, person1ClientID)
+	err := carsAndPersons.AddCarMalfunction(transactionContext, "car4", "Rusty body", "100", severityCritical)
+	require.NoError(t, err)
+	eventName, _ := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventCarWrittenOff, eventName)
+
+	_, err = carsAndPersons.SettleWriteOffPayout(transactionContext, "car4", "150")
+	require.EqualError(t, err, "client from Org1MSP is not authorized to perform insurer operations")
+
+	setClient(transactionContext, org3MSP, insurerClientID)
+	_, err = carsAndPersons.SettleWriteOffPayout(transactionContext, "car1", "150")
+	require.EqualError(t, err, "the car car1 is not written off")
+
+	_, err = carsAndPersons.SettleWriteOffPayout(transactionContext, "car4", "200.01")
+	require.EqualError(t, err, "the payout must not exceed the price of the car")
+
+	_, err = carsAndPersons.SettleWriteOffPayout(transactionContext, "car4", "0")
+	require.EqualError(t, err, "the payout must be positive")
+
+	carAsset, err := carsAndPersons.SettleWriteOffPayout(transactionContext, "car4", "150")
+	require.NoError(t, err)
+	require.Equal(t, &CarWriteOff{
+		RepairPrice:     newMoney(270_00),
+		WrittenOffAt:    testTime,
+		PayoutSettled:   true,
+		Payout:          newMoney(150_00),
+		PayoutSettledAt: testTime,
+		SettledBy:       insurerClientID,
+	}, carAsset.WriteOff)
+
+	require.Equal(t, newMoney(5400_54), personBalance(t, transactionContext, "person1"), "the payout is settled outside of the ledger")
+
+	carAssets, err := carsAndPersons.GetWrittenOffCars(transactionContext, "person1")
+	require.NoError(t, err)
+	require.Len(t, carAssets, 1)
+	require.True(t, carAssets[0].WriteOff.PayoutSettled)
+
+	eventName, payload := lastEvent(t, chaincodeStub)
+	require.Equal(t, eventWriteOffPayoutSettled, eventName)
+	var event WriteOffPayoutSettledEvent
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, newMoney(150_00), event.Payout)
+
+	_, err = carsAndPersons.SettleWriteOffPayout(transactionContext, "car4", "10")
+	require.EqualError(t, err, "the payout for the car car4 has already been settled")
+}
@@ -0,0 +1,49 @@
+package samples_test
+
+import (
+	"testing"
+
+	"fakestub"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssetLifecycleOnFakeLedger(t *testing.T) {
+	stub := fakestub.New()
+	transactionContext := fakestub.NewTransactionContext(stub, fakestub.NewClientIdentity("Org1MSP", "x509::CN=user1,OU=client::CN=ca.org1.example.com"))
+	assetTransfer := chaincode.SmartContract{}
+
+	require.NoError(t, stub.Transact(func() error { return assetTransfer.InitLedger(transactionContext) }))
+	require.NoError(t, stub.Transact(func() error {
+		return assetTransfer.CreateAsset(transactionContext, "asset7", "purple", 20, "Ana", 900)
+	}))
+
+	err := stub.Transact(func() error {
+		return assetTransfer.CreateAsset(transactionContext, "asset7", "purple", 20, "Ana", 900)
+	})
+	require.EqualError(t, err, "the asset asset7 already exists")
+
+	var oldOwner string
+	require.NoError(t, stub.Transact(func() error {
+		var err error
+		oldOwner, err = assetTransfer.TransferAsset(transactionContext, "asset1", "Ana")
+		return err
+	}))
+	require.Equal(t, "Tomoko", oldOwner)
+
+	require.NoError(t, stub.Transact(func() error { return assetTransfer.DeleteAsset(transactionContext, "asset2") }))
+
+	assets, err := assetTransfer.GetAllAssets(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, assets, 6)
+	require.Equal(t, "asset1", assets[0].ID)
+	require.Equal(t, "Ana", assets[0].Owner)
+	require.Equal(t, "asset3", assets[1].ID)
+
+	history, err := stub.GetHistoryForKey("asset2")
+	require.NoError(t, err)
+	deletion, err := history.Next()
+	require.NoError(t, err)
+	require.True(t, deletion.IsDelete)
+}
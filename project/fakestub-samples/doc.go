@@ -0,0 +1,4 @@
+// Package samples runs the sample chaincodes on the in-memory ledger of fakestub. The
+// tests are a module of their own, so that the go.mod files of the deployable
+// chaincodes do not refer to fakestub.
+package samples
@@ -0,0 +1,79 @@
+package samples_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"fakestub"
+
+	"github.com/hyperledger/fabric-samples/token-erc-20/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMintAndTransfer(t *testing.T) {
+	stub := fakestub.New()
+	minter := fakestub.NewTransactionContext(stub, fakestub.NewClientIdentity("Org1MSP", "x509::CN=minter,OU=client::CN=ca.org1.example.com"))
+	recipient := fakestub.NewTransactionContext(stub, fakestub.NewClientIdentity("Org2MSP", "x509::CN=recipient,OU=client::CN=ca.org2.example.com"))
+	token := chaincode.SmartContract{}
+
+	err := stub.Transact(func() error { return token.Mint(recipient, 1000) })
+	require.EqualError(t, err, "client is not authorized to mint new tokens")
+
+	require.NoError(t, stub.Transact(func() error { return token.Mint(minter, 1000) }))
+
+	recipientID, err := token.ClientAccountID(recipient)
+	require.NoError(t, err)
+	require.NoError(t, stub.Transact(func() error { return token.Transfer(minter, recipientID, 300) }))
+
+	err = stub.Transact(func() error { return token.Transfer(recipient, recipientID, 1) })
+	require.EqualError(t, err, "failed to transfer: cannot transfer to and from same client account")
+
+	minterBalance, err := token.ClientAccountBalance(minter)
+	require.NoError(t, err)
+	require.Equal(t, 700, minterBalance)
+
+	recipientBalance, err := token.BalanceOf(minter, recipientID)
+	require.NoError(t, err)
+	require.Equal(t, 300, recipientBalance)
+
+	totalSupply, err := token.TotalSupply(minter)
+	require.NoError(t, err)
+	require.Equal(t, 1000, totalSupply)
+
+	require.Len(t, stub.Events(), 2)
+	transferEvent := stub.LastEvent()
+	require.Equal(t, "Transfer", transferEvent.EventName)
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(transferEvent.Payload, &payload))
+	require.Equal(t, recipientID, payload["to"])
+	require.Equal(t, float64(300), payload["value"])
+}
+
+func TestTransferFromAllowance(t *testing.T) {
+	stub := fakestub.New()
+	owner := fakestub.NewTransactionContext(stub, fakestub.NewClientIdentity("Org1MSP", "x509::CN=owner,OU=client::CN=ca.org1.example.com"))
+	spender := fakestub.NewTransactionContext(stub, fakestub.NewClientIdentity("Org2MSP", "x509::CN=spender,OU=client::CN=ca.org2.example.com"))
+	token := chaincode.SmartContract{}
+
+	ownerID, err := token.ClientAccountID(owner)
+	require.NoError(t, err)
+	spenderID, err := token.ClientAccountID(spender)
+	require.NoError(t, err)
+
+	require.NoError(t, stub.Transact(func() error { return token.Mint(owner, 500) }))
+	require.NoError(t, stub.Transact(func() error { return token.Approve(owner, spenderID, 200) }))
+
+	err = stub.Transact(func() error { return token.TransferFrom(spender, ownerID, spenderID, 250) })
+	require.Error(t, err, "the spender must not exceed the allowance")
+
+	require.NoError(t, stub.Transact(func() error { return token.TransferFrom(spender, ownerID, spenderID, 150) }))
+
+	allowance, err := token.Allowance(owner, ownerID, spenderID)
+	require.NoError(t, err)
+	require.Equal(t, 50, allowance)
+
+	ownerBalance, err := token.BalanceOf(owner, ownerID)
+	require.NoError(t, err)
+	require.Equal(t, 350, ownerBalance)
+}
@@ -0,0 +1,67 @@
+package fakestub
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+var _ cid.ClientIdentity = (*ClientIdentity)(nil)
+
+// ClientIdentity is a client identity with a fixed ID, MSP ID and attributes.
+// It implements cid.ClientIdentity.
+type ClientIdentity struct {
+	// ID is the decoded X.509 identity, e.g. "x509::CN=user1,OU=client::CN=ca.org1.example.com".
+	ID         string
+	MSPID      string
+	Attributes map[string]string
+	Cert       *x509.Certificate
+}
+
+// NewClientIdentity returns an identity with the given decoded ID from the given organization.
+func NewClientIdentity(mspID string, id string) *ClientIdentity {
+	return &ClientIdentity{ID: id, MSPID: mspID, Attributes: map[string]string{}}
+}
+
+// GetID returns the base64 encoded ID, as the cid package does.
+func (c *ClientIdentity) GetID() (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte(c.ID)), nil
+}
+
+func (c *ClientIdentity) GetMSPID() (string, error) {
+	return c.MSPID, nil
+}
+
+func (c *ClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := c.Attributes[attrName]
+	return value, found, nil
+}
+
+func (c *ClientIdentity) AssertAttributeValue(attrName string, attrValue string) error {
+	value, found := c.Attributes[attrName]
+	if !found {
+		return fmt.Errorf("attribute '%s' was not found", attrName)
+	}
+	if value != attrValue {
+		return fmt.Errorf("attribute '%s' equals '%s', not '%s'", attrName, value, attrValue)
+	}
+
+	return nil
+}
+
+func (c *ClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return c.Cert, nil
+}
+
+// NewTransactionContext returns a contract API transaction context that submits to the
+// stub as the given client.
+func NewTransactionContext(stub *Stub, identity *ClientIdentity) *contractapi.TransactionContext {
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(identity)
+
+	return ctx
+}
@@ -0,0 +1,68 @@
+package fakestub
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// stateIterator iterates over a snapshot of keys and values taken when the query ran,
+// so that later commits do not change its results.
+type stateIterator struct {
+	results []*queryresult.KV
+	closed  bool
+}
+
+func newStateIterator(data map[string][]byte, keys []string) *stateIterator {
+	results := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Key: key, Value: data[key]})
+	}
+
+	return &stateIterator{results: results}
+}
+
+func (it *stateIterator) HasNext() bool {
+	return !it.closed && len(it.results) > 0
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+
+	result := it.results[0]
+	it.results = it.results[1:]
+
+	return result, nil
+}
+
+func (it *stateIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+	closed        bool
+}
+
+func (it *historyIterator) HasNext() bool {
+	return !it.closed && len(it.modifications) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+
+	return modification, nil
+}
+
+func (it *historyIterator) Close() error {
+	it.closed = true
+	return nil
+}
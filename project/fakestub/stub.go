@@ -0,0 +1,704 @@
+// Package fakestub provides an in-memory implementation of shim.ChaincodeStubInterface,
+// so that chaincode can be run through multi-transaction scenarios inside go test.
+//
+// Every change is made inside a transaction started with Stub.Transact. Like on a peer,
+// a transaction reads the state committed before it started, never its own writes, and
+// its writes, private data and event are only committed when it succeeds. Rich queries
+// are not supported, as on a peer that uses LevelDB as the state database.
+package fakestub
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// DefaultChannelID is the channel reported by stubs created with New.
+	DefaultChannelID = "mychannel"
+
+	emptyKeySubstitute  = "\x01"
+	compositeKeyPrefix  = "\x00"
+	maxUnicodeRuneValue = utf8.MaxRune
+)
+
+// StartTime is the timestamp of the first transaction of a new stub. Every following
+// transaction is one second later, so that tests are deterministic.
+var StartTime = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var _ shim.ChaincodeStubInterface = (*Stub)(nil)
+
+// Stub is an in-memory ledger that implements shim.ChaincodeStubInterface.
+type Stub struct {
+	channelID   string
+	state       map[string][]byte
+	validation  map[string][]byte
+	private     map[string]map[string][]byte
+	collections map[string]bool
+	history     map[string][]*queryresult.KeyModification
+	events      []*peer.ChaincodeEvent
+	clock       time.Time
+	txCount     int
+	tx          *transaction
+	transient   map[string][]byte
+	memberOf    map[string]bool
+}
+
+type transaction struct {
+	id                string
+	timestamp         time.Time
+	writes            map[string]*write
+	validationWrites  map[string][]byte
+	privateWrites     map[string]map[string]*write
+	event             *peer.ChaincodeEvent
+	paginatedQueries  bool
+	transient         map[string][]byte
+	memberOf          map[string]bool
+	args              [][]byte
+	hasPendingChanges bool
+}
+
+type write struct {
+	value    []byte
+	isDelete bool
+}
+
+// New returns an empty ledger on the default channel.
+func New() *Stub {
+	return &Stub{
+		channelID:   DefaultChannelID,
+		state:       map[string][]byte{},
+		validation:  map[string][]byte{},
+		private:     map[string]map[string][]byte{},
+		collections: map[string]bool{},
+		history:     map[string][]*queryresult.KeyModification{},
+		clock:       StartTime,
+	}
+}
+
+// DefineCollections restricts the private data collections that can be used to the
+// given ones. Without it, any collection name is accepted.
+func (s *Stub) DefineCollections(names ...string) {
+	for _, name := range names {
+		s.collections[name] = true
+	}
+}
+
+// SetTime sets the timestamp of the next transaction.
+func (s *Stub) SetTime(t time.Time) {
+	s.clock = t
+}
+
+// SetTransient sets the transient data passed to the next transaction.
+func (s *Stub) SetTransient(transient map[string][]byte) {
+	s.transient = transient
+}
+
+// SetPeerCollections makes the next transaction run on a peer that is only a member of
+// the given collections. Like on a peer, it cannot read the private data of the other
+// collections, but it can read their hashes and write to them. Without it, the peer is
+// a member of every collection.
+func (s *Stub) SetPeerCollections(names ...string) {
+	s.memberOf = map[string]bool{}
+	for _, name := range names {
+		s.memberOf[name] = true
+	}
+}
+
+// Transact runs fn as a single transaction. The changes fn makes are committed when it
+// returns nil and discarded when it returns an error or panics. The error is returned
+// as is, and panics are propagated after the rollback.
+func (s *Stub) Transact(fn func() error, args ...string) error {
+	if s.tx != nil {
+		return fmt.Errorf("transaction %s is already in progress", s.tx.id)
+	}
+
+	s.txCount++
+	s.tx = &transaction{
+		id:               fmt.Sprintf("tx%d", s.txCount),
+		timestamp:        s.clock,
+		writes:           map[string]*write{},
+		validationWrites: map[string][]byte{},
+		privateWrites:    map[string]map[string]*write{},
+		transient:        s.transient,
+		memberOf:         s.memberOf,
+	}
+	for _, arg := range args {
+		s.tx.args = append(s.tx.args, []byte(arg))
+	}
+	s.clock = s.clock.Add(time.Second)
+	s.transient = nil
+	s.memberOf = nil
+
+	committed := false
+	defer func() {
+		if !committed {
+			s.tx = nil
+		}
+	}()
+
+	err := fn()
+	if err != nil {
+		return err
+	}
+
+	s.commit()
+	committed = true
+
+	return nil
+}
+
+// LastTxID returns the ID of the last transaction that was started.
+func (s *Stub) LastTxID() string {
+	return fmt.Sprintf("tx%d", s.txCount)
+}
+
+// Events returns the events of the committed transactions in commit order.
+func (s *Stub) Events() []*peer.ChaincodeEvent {
+	return s.events
+}
+
+// LastEvent returns the event of the last committed transaction that set one, or nil.
+func (s *Stub) LastEvent() *peer.ChaincodeEvent {
+	if len(s.events) == 0 {
+		return nil
+	}
+
+	return s.events[len(s.events)-1]
+}
+
+// Keys returns the committed keys of the world state, including composite keys, in order.
+func (s *Stub) Keys() []string {
+	return sortedKeys(s.state, func(string) bool { return true })
+}
+
+func (s *Stub) commit() {
+	txTimestamp, _ := ptypes.TimestampProto(s.tx.timestamp)
+
+	for _, key := range sortedWriteKeys(s.tx.writes) {
+		w := s.tx.writes[key]
+		if w.isDelete {
+			delete(s.state, key)
+			delete(s.validation, key)
+		} else {
+			s.state[key] = w.value
+		}
+		s.history[key] = append(s.history[key], &queryresult.KeyModification{
+			TxId:      s.tx.id,
+			Value:     w.value,
+			Timestamp: txTimestamp,
+			IsDelete:  w.isDelete,
+		})
+	}
+
+	for key, ep := range s.tx.validationWrites {
+		s.validation[key] = ep
+	}
+
+	for collection, writes := range s.tx.privateWrites {
+		if s.private[collection] == nil {
+			s.private[collection] = map[string][]byte{}
+		}
+		for key, w := range writes {
+			if w.isDelete {
+				delete(s.private[collection], key)
+			} else {
+				s.private[collection][key] = w.value
+			}
+		}
+	}
+
+	if s.tx.event != nil {
+		s.events = append(s.events, s.tx.event)
+	}
+
+	s.tx = nil
+}
+
+// requireTransaction guards the operations that only make sense inside a transaction.
+func (s *Stub) requireTransaction() error {
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress, use Transact")
+	}
+
+	return nil
+}
+
+// requireWrite rejects writes after paginated queries, which peers only allow in
+// read only transactions.
+func (s *Stub) requireWrite(key string) error {
+	err := s.requireTransaction()
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if s.tx.paginatedQueries {
+		return fmt.Errorf("txid [%s]: transaction has already performed a paginated query. Writes are not allowed", s.tx.id)
+	}
+
+	s.tx.hasPendingChanges = true
+
+	return nil
+}
+
+func (s *Stub) requirePaginatedQuery() error {
+	if s.tx == nil {
+		return nil
+	}
+	if s.tx.hasPendingChanges {
+		return fmt.Errorf("txid [%s]: paginated queries are only valid for read only transactions", s.tx.id)
+	}
+
+	s.tx.paginatedQueries = true
+
+	return nil
+}
+
+func (s *Stub) requireCollection(collection string) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	if len(s.collections) > 0 && !s.collections[collection] {
+		return fmt.Errorf("collection %s could not be found", collection)
+	}
+
+	return nil
+}
+
+// requireMember fails the reads of private data on a peer that is not a member of the
+// collection, as set with SetPeerCollections.
+func (s *Stub) requireMember(collection string) error {
+	err := s.requireCollection(collection)
+	if err != nil {
+		return err
+	}
+	if s.tx != nil && s.tx.memberOf != nil && !s.tx.memberOf[collection] {
+		return fmt.Errorf("the peer is not a member of collection %s", collection)
+	}
+
+	return nil
+}
+
+func (s *Stub) GetArgs() [][]byte {
+	if s.tx == nil {
+		return nil
+	}
+
+	return s.tx.args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	var args []string
+	for _, arg := range s.GetArgs() {
+		args = append(args, string(arg))
+	}
+
+	return args
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+
+	return args[0], args[1:]
+}
+
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	var argsSlice []byte
+	for _, arg := range s.GetArgs() {
+		argsSlice = append(argsSlice, arg...)
+	}
+
+	return argsSlice, nil
+}
+
+func (s *Stub) GetTxID() string {
+	if s.tx == nil {
+		return ""
+	}
+
+	return s.tx.id
+}
+
+func (s *Stub) GetChannelID() string {
+	return s.channelID
+}
+
+// InvokeChaincode is not supported, the fake holds the state of a single chaincode.
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	return shim.Error(fmt.Sprintf("invoking chaincode %s is not supported by the fake stub", chaincodeName))
+}
+
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	err := s.requireWrite(key)
+	if err != nil {
+		return err
+	}
+
+	s.tx.writes[key] = &write{value: value}
+
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	err := s.requireWrite(key)
+	if err != nil {
+		return err
+	}
+
+	s.tx.writes[key] = &write{isDelete: true}
+
+	return nil
+}
+
+func (s *Stub) SetStateValidationParameter(key string, ep []byte) error {
+	err := s.requireWrite(key)
+	if err != nil {
+		return err
+	}
+
+	s.tx.validationWrites[key] = ep
+
+	return nil
+}
+
+func (s *Stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return s.validation[key], nil
+}
+
+func (s *Stub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	err := validateSimpleKeys(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStateIterator(s.state, rangeKeys(s.state, startKey, endKey)), nil
+}
+
+func (s *Stub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	err := validateSimpleKeys(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.requirePaginatedQuery()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, metadata := paginate(rangeKeys(s.state, startKey, endKey), pageSize, bookmark)
+
+	return newStateIterator(s.state, keys), metadata, nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStateIterator(s.state, rangeKeys(s.state, startKey, endKey)), nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.requirePaginatedQuery()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pageKeys, metadata := paginate(rangeKeys(s.state, startKey, endKey), pageSize, bookmark)
+
+	return newStateIterator(s.state, pageKeys), metadata, nil
+}
+
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	return (&shim.ChaincodeStub{}).SplitCompositeKey(compositeKey)
+}
+
+// GetQueryResult fails like it does on peers that use LevelDB.
+func (s *Stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("ExecuteQuery not supported for leveldb")
+}
+
+// GetQueryResultWithPagination fails like it does on peers that use LevelDB.
+func (s *Stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return nil, nil, fmt.Errorf("ExecuteQueryWithPagination not supported for leveldb")
+}
+
+// GetHistoryForKey returns the committed modifications of the key, newest first,
+// as Fabric 2.x peers do.
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	modifications := s.history[key]
+
+	newestFirst := make([]*queryresult.KeyModification, len(modifications))
+	for i, modification := range modifications {
+		newestFirst[len(modifications)-1-i] = modification
+	}
+
+	return &historyIterator{modifications: newestFirst}, nil
+}
+
+func (s *Stub) GetPrivateData(collection string, key string) ([]byte, error) {
+	err := s.requireMember(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.private[collection][key], nil
+}
+
+func (s *Stub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	err := s.requireCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	value := s.private[collection][key]
+	if value == nil {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256(value)
+
+	return hash[:], nil
+}
+
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	err := s.requireCollection(collection)
+	if err != nil {
+		return err
+	}
+	err = s.requireWrite(key)
+	if err != nil {
+		return err
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("value must not be empty")
+	}
+
+	s.privateWrites(collection)[key] = &write{value: value}
+
+	return nil
+}
+
+func (s *Stub) DelPrivateData(collection string, key string) error {
+	err := s.requireCollection(collection)
+	if err != nil {
+		return err
+	}
+	err = s.requireWrite(key)
+	if err != nil {
+		return err
+	}
+
+	s.privateWrites(collection)[key] = &write{isDelete: true}
+
+	return nil
+}
+
+func (s *Stub) privateWrites(collection string) map[string]*write {
+	if s.tx.privateWrites[collection] == nil {
+		s.tx.privateWrites[collection] = map[string]*write{}
+	}
+
+	return s.tx.privateWrites[collection]
+}
+
+// SetPrivateDataValidationParameter is accepted but not enforced, the fake does not
+// validate endorsements.
+func (s *Stub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+	err := s.requireCollection(collection)
+	if err != nil {
+		return err
+	}
+
+	return s.requireWrite(key)
+}
+
+func (s *Stub) GetPrivateDataValidationParameter(collection string, key string) ([]byte, error) {
+	return nil, s.requireCollection(collection)
+}
+
+func (s *Stub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	err := s.requireMember(collection)
+	if err != nil {
+		return nil, err
+	}
+	err = validateSimpleKeys(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data := s.private[collection]
+
+	return newStateIterator(data, rangeKeys(data, startKey, endKey)), nil
+}
+
+func (s *Stub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	err := s.requireMember(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	data := s.private[collection]
+
+	return newStateIterator(data, rangeKeys(data, startKey, endKey)), nil
+}
+
+// GetPrivateDataQueryResult fails like it does on peers that use LevelDB.
+func (s *Stub) GetPrivateDataQueryResult(collection string, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("ExecuteQuery not supported for leveldb")
+}
+
+func (s *Stub) GetCreator() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	if s.tx == nil || s.tx.transient == nil {
+		return map[string][]byte{}, nil
+	}
+
+	return s.tx.transient, nil
+}
+
+func (s *Stub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Stub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+func (s *Stub) GetSignedProposal() (*peer.SignedProposal, error) {
+	return nil, nil
+}
+
+// GetTxTimestamp returns the timestamp of the current transaction, or the timestamp
+// the next transaction will get when called outside of one.
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	if s.tx == nil {
+		return ptypes.TimestampProto(s.clock)
+	}
+
+	return ptypes.TimestampProto(s.tx.timestamp)
+}
+
+// SetEvent sets the event of the current transaction. As on a peer, a transaction has
+// at most one event, so a later call replaces the earlier one.
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	err := s.requireTransaction()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+
+	s.tx.event = &peer.ChaincodeEvent{
+		TxId:      s.tx.id,
+		EventName: name,
+		Payload:   payload,
+	}
+
+	return nil
+}
+
+func validateSimpleKeys(keys ...string) error {
+	for _, key := range keys {
+		if strings.HasPrefix(key, compositeKeyPrefix) {
+			return fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+
+	return nil
+}
+
+func partialCompositeKeyRange(objectType string, attributes []string) (string, string, error) {
+	startKey, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return startKey, startKey + string(maxUnicodeRuneValue), nil
+}
+
+// rangeKeys returns the keys in [startKey, endKey) in order. As with the shim, an empty
+// start key skips composite keys and an empty end key leaves the range open.
+func rangeKeys(data map[string][]byte, startKey string, endKey string) []string {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+
+	return sortedKeys(data, func(key string) bool {
+		return key >= startKey && (endKey == "" || key < endKey)
+	})
+}
+
+// paginate returns the page of keys starting at the bookmark, which is the first key
+// of the page, and the metadata with the bookmark of the next page.
+func paginate(keys []string, pageSize int32, bookmark string) ([]string, *peer.QueryResponseMetadata) {
+	start := sort.SearchStrings(keys, bookmark)
+	keys = keys[start:]
+
+	nextBookmark := ""
+	if pageSize > 0 && len(keys) > int(pageSize) {
+		nextBookmark = keys[pageSize]
+		keys = keys[:pageSize]
+	}
+
+	return keys, &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(keys)), Bookmark: nextBookmark}
+}
+
+func sortedKeys(data map[string][]byte, match func(key string) bool) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedWriteKeys(writes map[string]*write) []string {
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,280 @@
+package fakestub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactCommitsAndRollsBack(t *testing.T) {
+	stub := New()
+
+	require.Error(t, stub.PutState("key", []byte("value")), "writes outside a transaction must fail")
+
+	err := stub.Transact(func() error {
+		require.NoError(t, stub.PutState("key", []byte("value")))
+
+		value, err := stub.GetState("key")
+		require.NoError(t, err)
+		require.Nil(t, value, "a transaction must not read its own writes")
+
+		return stub.SetEvent("Created", []byte("key"))
+	})
+	require.NoError(t, err)
+
+	value, err := stub.GetState("key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value)
+	require.Equal(t, "Created", stub.LastEvent().EventName)
+	require.Equal(t, "tx1", stub.LastEvent().TxId)
+
+	err = stub.Transact(func() error {
+		require.NoError(t, stub.DelState("key"))
+		require.NoError(t, stub.SetEvent("Deleted", []byte("key")))
+		return errors.New("endorsement failed")
+	})
+	require.EqualError(t, err, "endorsement failed")
+
+	value, err = stub.GetState("key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value, "a failed transaction must not change the state")
+	require.Len(t, stub.Events(), 1, "a failed transaction must not emit its event")
+
+	require.Panics(t, func() {
+		_ = stub.Transact(func() error {
+			require.NoError(t, stub.PutState("other", []byte("value")))
+			panic("chaincode bug")
+		})
+	})
+	require.Equal(t, []string{"key"}, stub.Keys())
+	require.NoError(t, stub.Transact(func() error { return nil }), "a panic must not leave the transaction open")
+}
+
+func TestTxTimestampAdvancesPerTransaction(t *testing.T) {
+	stub := New()
+
+	var first, second time.Time
+	require.NoError(t, stub.Transact(func() error {
+		timestamp, err := stub.GetTxTimestamp()
+		require.NoError(t, err)
+		first, err = ptypes.Timestamp(timestamp)
+		return err
+	}))
+	require.NoError(t, stub.Transact(func() error {
+		timestamp, err := stub.GetTxTimestamp()
+		require.NoError(t, err)
+		second, err = ptypes.Timestamp(timestamp)
+		return err
+	}))
+
+	require.Equal(t, StartTime, first)
+	require.Equal(t, StartTime.Add(time.Second), second)
+
+	later := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	stub.SetTime(later)
+	require.NoError(t, stub.Transact(func() error {
+		timestamp, err := stub.GetTxTimestamp()
+		require.NoError(t, err)
+		require.Equal(t, later.Unix(), timestamp.Seconds)
+		return nil
+	}))
+}
+
+func TestRangeAndCompositeKeyQueries(t *testing.T) {
+	stub := New()
+
+	require.NoError(t, stub.Transact(func() error {
+		for _, key := range []string{"car1", "car2", "car3", "person1"} {
+			require.NoError(t, stub.PutState(key, []byte(key)))
+		}
+		for _, attributes := range [][]string{{"blue", "person1", "car1"}, {"blue", "person2", "car2"}, {"red", "person1", "car3"}} {
+			key, err := stub.CreateCompositeKey("color~owner~ID", attributes)
+			require.NoError(t, err)
+			require.NoError(t, stub.PutState(key, []byte{0x00}))
+		}
+		return nil
+	}))
+
+	iterator, err := stub.GetStateByRange("", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3", "person1"}, collectKeys(t, iterator), "range queries must skip composite keys")
+
+	iterator, err = stub.GetStateByRange("car2", "person1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car2", "car3"}, collectKeys(t, iterator))
+
+	iterator, err = stub.GetStateByPartialCompositeKey("color~owner~ID", []string{"blue"})
+	require.NoError(t, err)
+	keys := collectKeys(t, iterator)
+	require.Len(t, keys, 2)
+
+	_, attributes, err := stub.SplitCompositeKey(keys[1])
+	require.NoError(t, err)
+	require.Equal(t, []string{"blue", "person2", "car2"}, attributes)
+
+	iterator, metadata, err := stub.GetStateByRangeWithPagination("", "", 3, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"car1", "car2", "car3"}, collectKeys(t, iterator))
+	require.Equal(t, "person1", metadata.Bookmark)
+
+	iterator, metadata, err = stub.GetStateByRangeWithPagination("", "", 3, metadata.Bookmark)
+	require.NoError(t, err)
+	require.Equal(t, []string{"person1"}, collectKeys(t, iterator))
+	require.Empty(t, metadata.Bookmark)
+
+	_, err = stub.GetQueryResult(`{"selector":{}}`)
+	require.Error(t, err, "rich queries need CouchDB")
+}
+
+func TestPaginatedQueriesAreReadOnly(t *testing.T) {
+	stub := New()
+
+	err := stub.Transact(func() error {
+		_, _, err := stub.GetStateByRangeWithPagination("", "", 10, "")
+		require.NoError(t, err)
+		return stub.PutState("key", []byte("value"))
+	})
+	require.Error(t, err)
+
+	err = stub.Transact(func() error {
+		require.NoError(t, stub.PutState("key", []byte("value")))
+		_, _, err := stub.GetStateByRangeWithPagination("", "", 10, "")
+		return err
+	})
+	require.Error(t, err)
+}
+
+func TestGetHistoryForKey(t *testing.T) {
+	stub := New()
+
+	for _, value := range []string{"v1", "v2"} {
+		require.NoError(t, stub.Transact(func() error {
+			return stub.PutState("key", []byte(value))
+		}))
+	}
+	require.NoError(t, stub.Transact(func() error {
+		return stub.DelState("key")
+	}))
+
+	iterator, err := stub.GetHistoryForKey("key")
+	require.NoError(t, err)
+
+	var txIDs []string
+	var deletes []bool
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		require.NoError(t, err)
+		txIDs = append(txIDs, modification.TxId)
+		deletes = append(deletes, modification.IsDelete)
+	}
+	require.Equal(t, []string{"tx3", "tx2", "tx1"}, txIDs, "history must be returned newest first")
+	require.Equal(t, []bool{true, false, false}, deletes)
+}
+
+func TestPrivateData(t *testing.T) {
+	stub := New()
+	stub.DefineCollections("balances")
+
+	stub.SetTransient(map[string][]byte{"balance": []byte("100")})
+	require.NoError(t, stub.Transact(func() error {
+		transient, err := stub.GetTransient()
+		require.NoError(t, err)
+		return stub.PutPrivateData("balances", "person1", transient["balance"])
+	}))
+
+	value, err := stub.GetPrivateData("balances", "person1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("100"), value)
+
+	hash, err := stub.GetPrivateDataHash("balances", "person1")
+	require.NoError(t, err)
+	require.Len(t, hash, 32)
+
+	_, err = stub.GetPrivateData("emails", "person1")
+	require.Error(t, err, "undefined collections must be rejected")
+
+	err = stub.Transact(func() error {
+		require.NoError(t, stub.DelPrivateData("balances", "person1"))
+		return errors.New("rejected")
+	})
+	require.Error(t, err)
+
+	value, err = stub.GetPrivateData("balances", "person1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("100"), value, "a failed transaction must not change private data")
+}
+
+func TestPeerCollections(t *testing.T) {
+	stub := New()
+	stub.DefineCollections("balances", "emails")
+	require.NoError(t, stub.Transact(func() error {
+		return stub.PutPrivateData("balances", "person1", []byte("100"))
+	}))
+
+	stub.SetPeerCollections("emails")
+	require.NoError(t, stub.Transact(func() error {
+		_, err := stub.GetPrivateData("balances", "person1")
+		require.EqualError(t, err, "the peer is not a member of collection balances")
+		_, err = stub.GetPrivateDataByRange("balances", "", "")
+		require.Error(t, err)
+
+		hash, err := stub.GetPrivateDataHash("balances", "person1")
+		require.NoError(t, err)
+		require.Len(t, hash, 32)
+
+		return stub.PutPrivateData("balances", "person1", []byte("50"))
+	}))
+
+	require.NoError(t, stub.Transact(func() error {
+		value, err := stub.GetPrivateData("balances", "person1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("50"), value, "the peer collections must only apply to the next transaction")
+		return nil
+	}))
+}
+
+func TestStateValidationParameter(t *testing.T) {
+	stub := New()
+
+	require.NoError(t, stub.Transact(func() error {
+		require.NoError(t, stub.PutState("key", []byte("value")))
+		return stub.SetStateValidationParameter("key", []byte("policy"))
+	}))
+
+	ep, err := stub.GetStateValidationParameter("key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("policy"), ep)
+}
+
+func TestTransactionContext(t *testing.T) {
+	stub := New()
+	identity := NewClientIdentity("Org1MSP", "x509::CN=user1,OU=client::CN=ca.org1.example.com")
+	identity.Attributes["role"] = "registrar"
+
+	ctx := NewTransactionContext(stub, identity)
+
+	id, err := ctx.GetClientIdentity().GetID()
+	require.NoError(t, err)
+	require.Equal(t, "eDUwOTo6Q049dXNlcjEsT1U9Y2xpZW50OjpDTj1jYS5vcmcxLmV4YW1wbGUuY29t", id)
+
+	require.NoError(t, ctx.GetClientIdentity().AssertAttributeValue("role", "registrar"))
+	require.Error(t, ctx.GetClientIdentity().AssertAttributeValue("role", "admin"))
+	require.Equal(t, shim.ChaincodeStubInterface(stub), ctx.GetStub())
+}
+
+func collectKeys(t *testing.T, iterator shim.StateQueryIteratorInterface) []string {
+	defer iterator.Close()
+
+	var keys []string
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		require.NoError(t, err)
+		keys = append(keys, result.Key)
+	}
+
+	return keys
+}